@@ -0,0 +1,246 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+var logger = log.ZoneLogger("crud/audit")
+
+// Action is the kind of mutation.
+type Action string
+
+// Audited actions.
+const (
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionRestore Action = "restore" // restore a previous version or from trash
+	ActionPurge   Action = "purge"   // delete permanently
+	ActionLink    Action = "link"    // add a child into parent.field
+	ActionUnlink  Action = "unlink"  // remove a child from parent.field
+)
+
+// Entry is a record in the audit log.
+type Entry struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	Actor     string    `json:"actor" gorm:"index"`
+	RequestID string    `json:"request_id" gorm:"index"`
+	ClientIP  string    `json:"client_ip"`
+	Action    Action    `json:"action" gorm:"index"`
+	Model     string    `json:"model" gorm:"index:idx_audit_model"`
+	ModelID   string    `json:"model_id" gorm:"index:idx_audit_model"`
+	Field     string    `json:"field,omitempty"` // the association field for link and unlink
+	Changes   Changes   `json:"changes" gorm:"serializer:json"`
+}
+
+// TableName of Entry: audit_entries
+func (Entry) TableName() string {
+	return "audit_entries"
+}
+
+// Change of a field: from Before to After.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Changes maps field names (as in JSON) to their changes.
+type Changes map[string]Change
+
+var enabled int32 // 1 if enabled, accessed atomically
+
+// Enable migrates the audit table and starts recording.
+// It should be called after orm.ConnectDB.
+func Enable() error {
+	if err := orm.RegisterModel(Entry{}); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&enabled, 1)
+	return nil
+}
+
+// Enabled reports whether the audit log is recording.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Source is where the mutations made with a context come from, the
+// request, see WithSource.
+type Source struct {
+	Actor     string // the authenticated user
+	RequestID string
+	ClientIP  string
+	Before    any // the version of the model before an update, to diff with
+}
+
+type sourceKey struct{}
+
+// WithSource returns a copy of ctx carrying the source, so that the
+// mutations made with it are audited, see Write.
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// WithBefore returns a copy of ctx with the Before of its source replaced,
+// or ctx itself if it carries no source.
+func WithBefore(ctx context.Context, before any) context.Context {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	if !ok {
+		return ctx
+	}
+	source.Before = before
+	return WithSource(ctx, source)
+}
+
+// Write writes the entry of the action on model (a struct or a pointer to
+// it) into the audit log with tx, the transaction of the mutation. So the
+// entry is kept if and only if the mutation is committed.
+//
+// model is the version after the mutation, diffed with the Before of the
+// source (nothing for a create), except for delete and purge, where model
+// is the one removed.
+//
+// It does nothing if the audit log is not Enabled, or ctx carries no
+// Source (the mutation is not made by a request), see WithSource.
+func Write(ctx context.Context, tx *gorm.DB, action Action, model any) error {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	if !Enabled() || !ok {
+		return nil
+	}
+
+	before, after := source.Before, model
+	switch action {
+	case ActionCreate:
+		before = nil
+	case ActionDelete, ActionPurge:
+		before, after = model, nil
+	}
+	changes, err := Diff(before, after)
+	if err != nil {
+		return err
+	}
+	return record(ctx, tx, source, &Entry{
+		Action:  action,
+		Model:   modelName(model),
+		ModelID: modelID(model),
+		Changes: changes,
+	})
+}
+
+// WriteNested writes the entry of linking (or unlinking) the child (by id)
+// into parent.field, see Write.
+func WriteNested(ctx context.Context, tx *gorm.DB, action Action, parent any, field string, childID string) error {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	if !Enabled() || !ok {
+		return nil
+	}
+
+	change := Change{After: childID}
+	if action == ActionUnlink {
+		change = Change{Before: childID}
+	}
+	return record(ctx, tx, source, &Entry{
+		Action:  action,
+		Model:   modelName(parent),
+		ModelID: modelID(parent),
+		Field:   field,
+		Changes: Changes{field: change},
+	})
+}
+
+// record writes the entry, made by the source, with tx.
+func record(ctx context.Context, tx *gorm.DB, source Source, entry *Entry) error {
+	entry.Actor = source.Actor
+	entry.RequestID = source.RequestID
+	entry.ClientIP = source.ClientIP
+
+	logger.WithContext(ctx).
+		WithField("action", entry.Action).
+		WithField("model", entry.Model).
+		WithField("modelID", entry.ModelID).
+		Trace("Record audit entry")
+
+	err := tx.Create(entry).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("record: failed to write audit entry")
+	}
+	return err
+}
+
+// modelName: *Model => "Model"
+func modelName(model any) string {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// modelID returns the identity value of an orm.Model (or a pointer to it)
+// as string, or "" if model is not an orm.Model.
+func modelID(model any) string {
+	if m, ok := model.(orm.Model); ok {
+		_, id := m.Identity()
+		return fmt.Sprint(id)
+	}
+	if v := reflect.ValueOf(model); v.Kind() == reflect.Ptr && !v.IsNil() {
+		return modelID(v.Elem().Interface())
+	}
+	return ""
+}
+
+// Diff compares the JSON representations of two versions of a model,
+// returning the changed fields. A nil before (or after) diffs against
+// nothing: all fields of the other are changes.
+//
+// Associations are compared as a whole, nested fields are not diffed.
+func Diff(before, after any) (Changes, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := Changes{}
+	for k, bv := range b {
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(av, bv) {
+			changes[k] = Change{Before: bv, After: a[k]}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = Change{After: av}
+		}
+	}
+	return changes, nil
+}
+
+// toMap converts model to a map via JSON.
+func toMap(model any) (map[string]any, error) {
+	m := map[string]any{}
+	if model == nil || (reflect.ValueOf(model).Kind() == reflect.Ptr && reflect.ValueOf(model).IsNil()) {
+		return m, nil
+	}
+	j, err := json.Marshal(model)
+	if err != nil {
+		return nil, fmt.Errorf("audit: marshal %T: %w", model, err)
+	}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return nil, fmt.Errorf("audit: %T is not an object: %w", model, err)
+	}
+	return m, nil
+}
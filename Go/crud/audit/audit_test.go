@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+func TestDiff(t *testing.T) {
+	type todo struct {
+		ID    uint   `json:"id"`
+		Title string `json:"title"`
+		Done  bool   `json:"done"`
+	}
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   Changes
+	}{
+		{"create",
+			nil,
+			&todo{ID: 1, Title: "a"},
+			Changes{"id": {After: 1.0}, "title": {After: "a"}, "done": {After: false}},
+		},
+		{"update",
+			&todo{ID: 1, Title: "a"},
+			&todo{ID: 1, Title: "a", Done: true},
+			Changes{"done": {Before: false, After: true}},
+		},
+		{"delete",
+			todo{ID: 1, Title: "a"},
+			(*todo)(nil),
+			Changes{"id": {Before: 1.0}, "title": {Before: "a"}, "done": {Before: false}},
+		},
+		{"unchanged",
+			todo{ID: 1},
+			todo{ID: 1},
+			Changes{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+
+	type todo struct {
+		ID    uint   `json:"id"`
+		Title string `json:"title"`
+	}
+	ctx := WithSource(context.Background(), Source{Actor: "a@example.com", RequestID: "r1"})
+	ctx = WithBefore(ctx, &todo{ID: 1, Title: "a"})
+	errRollback := errors.New("rollback")
+
+	// rolled back with the mutation
+	err := orm.DB.Transaction(func(tx *gorm.DB) error {
+		if err := Write(ctx, tx, ActionUpdate, &todo{ID: 1, Title: "b"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	// not made by a request
+	err = orm.DB.Transaction(func(tx *gorm.DB) error {
+		return Write(context.Background(), tx, ActionUpdate, &todo{ID: 1, Title: "b"})
+	})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// committed
+	err = orm.DB.Transaction(func(tx *gorm.DB) error {
+		return Write(ctx, tx, ActionUpdate, &todo{ID: 1, Title: "c"})
+	})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var entries []Entry
+	if err := orm.DB.Find(&entries).Error; err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want the committed one: %+v", len(entries), entries)
+	}
+	got := entries[0]
+	want := Changes{"title": {Before: "a", After: "c"}}
+	if got.Actor != "a@example.com" || got.RequestID != "r1" || got.Model != "todo" || !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("entry = %+v, want an update of title by a@example.com", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	defer func(limit, max int) { QueryDefaultLimit, QueryMaxLimit = limit, max }(QueryDefaultLimit, QueryMaxLimit)
+	QueryDefaultLimit, QueryMaxLimit = 2, 3
+
+	for i := 1; i <= 5; i++ {
+		entry := Entry{Actor: "a@example.com", Action: ActionCreate, Model: "todo", ModelID: fmt.Sprint(i)}
+		if err := orm.DB.Create(&entry).Error; err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	orm.DB.Create(&Entry{Actor: "b@example.com", Action: ActionCreate, Model: "todo", ModelID: "6"})
+
+	tests := []struct {
+		name          string
+		limit, offset int
+		want          []string // model ids
+	}{
+		{"default limit", 0, 0, []string{"5", "4"}},
+		{"limit", 1, 1, []string{"4"}},
+		{"max limit", 100, 0, []string{"5", "4", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total, err := Query(context.Background(), Filter{Actor: "a@example.com"}, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.ModelID)
+			}
+			if total != 5 || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() = %v of %d, want %v of 5", got, total, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+// Package audit keeps an audit log of mutations made through the crud
+// controllers: who (actor) changed which record (model, id), when, from
+// where (client ip, request id), and how (before/after diff).
+//
+// Call Enable() after orm.ConnectDB to migrate the audit table and start
+// recording. The controllers pass the request (see WithSource) to the
+// services, which Write an entry for each create, update and delete in
+// the transaction of the mutation, while Query reads the log back.
+package audit
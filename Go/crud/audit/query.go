@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+)
+
+// Filter selects audit entries. Zero fields match anything.
+type Filter struct {
+	Actor     string    `form:"actor"`
+	RequestID string    `form:"request_id"`
+	Action    Action    `form:"action"`
+	Model     string    `form:"model"`
+	ModelID   string    `form:"model_id"`
+	Since     time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until     time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// Page sizes of Query: QueryDefaultLimit if the limit is not given
+// (<= 0), and at most QueryMaxLimit.
+var (
+	QueryDefaultLimit = 100
+	QueryMaxLimit     = 1000
+)
+
+// Query returns the entries matched by filter, newest first,
+// paginated by limit (QueryDefaultLimit if <= 0, capped by QueryMaxLimit)
+// and offset.
+// total is the number of all matched entries, ignoring pagination.
+func Query(ctx context.Context, filter Filter, limit, offset int) (entries []Entry, total int64, err error) {
+	query := orm.DB.WithContext(ctx).Model(&Entry{})
+
+	for column, value := range map[string]string{
+		"actor":      filter.Actor,
+		"request_id": filter.RequestID,
+		"action":     string(filter.Action),
+		"model":      filter.Model,
+		"model_id":   filter.ModelID,
+	} {
+		if value != "" {
+			query = query.Where(map[string]any{column: value})
+		}
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	if err = query.Count(&total).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).Warn("Query: count failed")
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = QueryDefaultLimit
+	}
+	if limit > QueryMaxLimit {
+		limit = QueryMaxLimit
+	}
+	query = query.Order("id desc").Limit(limit).Offset(offset)
+	if err = query.Find(&entries).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).Warn("Query: find failed")
+	}
+	return entries, total, err
+}
@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/crud/log"
+)
+
+var logger = log.ZoneLogger("crud/broker")
+
+// EventType is the kind of change.
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+
+	// EventReset tells a resuming subscriber that some events it asked
+	// for are no longer kept: it should reload the models.
+	EventReset EventType = "reset"
+)
+
+// Event is a change of a model record.
+type Event struct {
+	ID      uint64    `json:"id"` // increasing, assigned by the Broker
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	Model   string    `json:"model"`           // type name of the model, e.g. "Todo"
+	ModelID string    `json:"model_id"`        // primary key of the record
+	Actor   string    `json:"actor,omitempty"` // who made the change
+	Data    any       `json:"data,omitempty"`  // the record after the change (before for deletes)
+}
+
+const (
+	// DefaultHistorySize is the number of latest events kept by the
+	// Default broker for resuming subscribers.
+	DefaultHistorySize = 1024
+
+	// subscriptionBuffer is the number of events buffered for each
+	// subscriber. Subscribers falling behind further are dropped.
+	subscriptionBuffer = 64
+)
+
+// Broker dispatches published events to subscribers.
+type Broker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event // latest events, oldest first
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// New creates a Broker keeping the latest historySize events.
+//
+// Event IDs start from the creation time in microseconds, so that they
+// keep increasing after a restart of the process. Subscribers resuming
+// from an ID of the previous process get an EventReset.
+func New(historySize int) *Broker {
+	return &Broker{
+		nextID:      uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		historySize: historySize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Default is the broker used by the service layer.
+var Default = New(DefaultHistorySize)
+
+// Publish publishes the event to the Default broker, see Broker.Publish.
+func Publish(event Event) Event {
+	return Default.Publish(event)
+}
+
+// Subscribe subscribes to the Default broker, see Broker.Subscribe.
+func Subscribe(model string, lastEventID uint64) *Subscription {
+	return Default.Subscribe(model, lastEventID)
+}
+
+// Publish assigns an ID (and the Time if not set) to the event and
+// dispatches it to the subscribers. The event with ID is returned.
+//
+// Publish never blocks: subscribers that do not keep up are closed.
+func (b *Broker) Publish(event Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if b.historySize > 0 {
+		if len(b.history) >= b.historySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, event)
+	}
+
+	for s := range b.subscribers {
+		if !s.accepts(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			logger.WithField("model", s.model).
+				Warn("Publish: subscriber is too slow, dropped")
+			b.unsubscribe(s)
+		}
+	}
+	return event
+}
+
+// Subscribe subscribes to the events of the model (by type name, or ""
+// for all models).
+//
+// If lastEventID is not 0, the kept events after it are replayed first,
+// or a single EventReset is delivered if some of them are no longer kept.
+func (b *Broker) Subscribe(model string, lastEventID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastEventID != 0 {
+		oldest := b.nextID
+		if len(b.history) > 0 {
+			oldest = b.history[0].ID
+		}
+		if lastEventID+1 < oldest {
+			replay = append(replay, Event{
+				ID:    oldest - 1,
+				Time:  time.Now(),
+				Type:  EventReset,
+				Model: model,
+			})
+		} else {
+			for _, event := range b.history {
+				if event.ID > lastEventID && (model == "" || event.Model == model) {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	s := &Subscription{
+		broker: b,
+		model:  model,
+		events: make(chan Event, len(replay)+subscriptionBuffer),
+	}
+	for _, event := range replay {
+		s.events <- event
+	}
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes s and closes its channel, if not yet.
+// b.mu must be held.
+func (b *Broker) unsubscribe(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Subscription receives the events of a model from a Broker.
+type Subscription struct {
+	broker *Broker
+	model  string
+	events chan Event
+}
+
+// Events returns the channel of events. It is closed when the
+// subscription is closed, by Close or by the broker for being too slow.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+func (s *Subscription) accepts(event Event) bool {
+	return s.model == "" || s.model == event.Model
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestBrokerResume(t *testing.T) {
+	b := New(3)
+
+	live := b.Subscribe("Todo", 0)
+	defer live.Close()
+
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		model := "Todo"
+		if i == 1 {
+			model = "Project"
+		}
+		ids = append(ids, b.Publish(Event{Type: EventCreated, Model: model}).ID)
+	}
+
+	if got := len(live.Events()); got != 4 {
+		t.Errorf("live subscriber got %v events, want 4", got)
+	}
+
+	// kept: ids[2], ids[3], ids[4]
+	resumed := b.Subscribe("Todo", ids[2])
+	defer resumed.Close()
+	if got := len(resumed.Events()); got != 2 {
+		t.Fatalf("resumed subscriber got %v events, want 2", got)
+	}
+	if e := <-resumed.Events(); e.ID != ids[3] {
+		t.Errorf("first replayed event = %v, want %v", e.ID, ids[3])
+	}
+
+	missed := b.Subscribe("Todo", ids[0])
+	defer missed.Close()
+	if got := len(missed.Events()); got != 1 {
+		t.Fatalf("missed subscriber got %v events, want 1", got)
+	}
+	if e := <-missed.Events(); e.Type != EventReset {
+		t.Errorf("missed subscriber got %v, want %v", e.Type, EventReset)
+	}
+}
+
+func TestBrokerDropSlowSubscriber(t *testing.T) {
+	b := New(0)
+	slow := b.Subscribe("", 0)
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		b.Publish(Event{Type: EventUpdated, Model: "Todo"})
+	}
+
+	n := 0
+	for range slow.Events() { // closed after the buffered ones
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Errorf("slow subscriber got %v events, want %v", n, subscriptionBuffer)
+	}
+	slow.Close() // no panic on closed subscription
+}
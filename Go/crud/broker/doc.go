@@ -0,0 +1,15 @@
+// Package broker is an in-process publish/subscribe broker of change
+// events of models.
+//
+// The service layer publishes an Event for each create, update and delete
+// made through it: relayed from the outbox once it is enabled (see
+// outbox.BrokerPublisher), or directly otherwise. The subscribers (e.g. the Server-Sent Events and
+// WebSocket handlers in controller) receive the events of the model they
+// subscribe to.
+//
+// The broker keeps the latest events in memory, so that a subscriber
+// reconnecting with the ID of the last event it received (the
+// Last-Event-ID) gets the events it missed replayed. If some of them have
+// been dropped, an EventReset is delivered instead, telling the subscriber
+// to reload.
+package broker
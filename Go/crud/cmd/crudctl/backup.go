@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func init() {
+	commands["backup"] = command{
+		usage: "backup [-format sqlite|sql|ndjson] [-o file]",
+		help:  "write a consistent online backup of the database",
+		run:   backup,
+	}
+	commands["restore"] = command{
+		usage: "restore file",
+		help:  "import a backup in NDJSON into the database",
+		run:   restore,
+	}
+}
+
+// backup writes a backup of the database to the file (backup-<time>.<format>
+// by default, "-" for stdout, except sqlite):
+//
+//	sqlite   a copy of the SQLite database, see orm.Backup (default for sqlite)
+//	sql      a SQL dump, see orm.DumpSQL (default for others)
+//	ndjson   rows of all models, for restore into any database, see orm.ExportData
+func backup(conf *config.BaseConfig, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	format := flags.String("format", "", "sqlite, sql or ndjson (default sqlite for a SQLite database, sql for others)")
+	output := flags.String("o", "", "output file")
+	_ = flags.Parse(args)
+
+	if err := connectData(conf); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "sql"
+		if orm.DB.Dialector.Name() == orm.DBDriverSqlite {
+			*format = "sqlite"
+		}
+	}
+	if *output == "" {
+		ext := *format
+		if ext == "sqlite" {
+			ext = "db"
+		}
+		*output = fmt.Sprintf("backup-%s.%s", time.Now().UTC().Format("20060102150405"), ext)
+	}
+	ctx := context.Background()
+
+	var err error
+	switch *format {
+	case "sqlite":
+		if orm.DB.Dialector.Name() != orm.DBDriverSqlite {
+			return fmt.Errorf("format sqlite of a %s database", orm.DB.Dialector.Name())
+		}
+		err = orm.Backup(ctx, *output)
+	case "sql":
+		err = writeOutput(*output, func(w io.Writer) error { return orm.DumpSQL(ctx, w) })
+	case "ndjson":
+		err = writeOutput(*output, func(w io.Writer) error { return orm.ExportData(ctx, w) })
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err == nil {
+		logger.WithField("output", *output).Info("backup: done")
+	}
+	return err
+}
+
+// writeOutput writes to the new file, or stdout for "-".
+func writeOutput(output string, write func(w io.Writer) error) error {
+	if output == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
+	}
+	return f.Close()
+}
+
+// restore imports a backup in NDJSON (see backup -format ndjson) into the
+// database, whose tables are created for the models if not exist, and
+// should be empty. It moves data between databases, e.g. from SQLite to
+// Postgres. Restore other formats with the tools of the database:
+//
+//	sqlite   cp backup.db todolist.db
+//	sql      psql -d dbname -f backup.sql
+func restore(conf *config.BaseConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing the backup file")
+	}
+	if err := connectData(conf); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	err := orm.ImportData(context.Background(), r)
+	if err == nil {
+		logger.WithField("file", args[0]).Info("restore: done")
+	}
+	return err
+}
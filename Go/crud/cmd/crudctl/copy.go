@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func init() {
+	commands["copy"] = command{
+		usage: "copy [-batch n] -to-config file | -to-driver d -to-dsn dsn",
+		help:  "copy all data into another database, e.g. from sqlite to postgres",
+		run:   copyData,
+	}
+}
+
+// copyData copies all rows of the models from the database of the config
+// (the source) into the target database, see orm.CopyData. The target is
+// the DB of the -to-config file, or given by -to-driver and -to-dsn:
+//
+//	crudctl copy -to-driver postgres -to-dsn "host=localhost user=crud dbname=crud"
+func copyData(conf *config.BaseConfig, args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "rows per insert")
+	toConfig := flags.String("to-config", "", "config file of the target database")
+	toDriver := flags.String("to-driver", "", "driver of the target database")
+	toDSN := flags.String("to-dsn", "", "dsn of the target database")
+	_ = flags.Parse(args)
+
+	var target config.BaseConfig
+	if *toConfig != "" {
+		if err := config.Init(&target, config.FromFile(*toConfig)); err != nil {
+			return err
+		}
+	}
+	if *toDriver != "" {
+		target.DB.Driver = *toDriver
+	}
+	if *toDSN != "" {
+		target.DB.DSN = *toDSN
+	}
+	if target.DB.Driver == "" || target.DB.DSN == "" {
+		return errors.New("missing the target database: -to-config, or -to-driver and -to-dsn")
+	}
+
+	if err := connectData(conf); err != nil {
+		return err
+	}
+	targetDB, err := orm.OpenDBConfig(target.DB)
+	if err != nil {
+		return err
+	}
+
+	copied, err := orm.CopyData(context.Background(), orm.DB, targetDB, *batch)
+	for _, table := range copied {
+		logger.WithField("table", table.Table).
+			WithField("source", table.Source).
+			WithField("target", table.Target).
+			Info("copy: rows")
+	}
+	if err == nil {
+		logger.Info("copy: done, row counts verified")
+	}
+	return err
+}
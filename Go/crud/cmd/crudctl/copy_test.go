@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func TestCopyData(t *testing.T) {
+	defer orm.SetRegisteredModels(orm.RegisteredModels())
+	dir := t.TempDir()
+	conf := &config.BaseConfig{DB: config.DBConfig{Driver: orm.DBDriverSqlite, DSN: filepath.Join(dir, "source.db")}}
+
+	orm.SetRegisteredModels(nil)
+	if err := connectData(conf); err != nil {
+		t.Fatalf("connectData() error = %v", err)
+	}
+	todo := model.Todo{Title: "buy milk"}
+	orm.DB.Create(&todo)
+	todo.Done = true
+	orm.DB.Save(&todo)
+
+	// search indexes are not copied, history is
+	target := filepath.Join(dir, "target.db")
+	if err := copyData(conf, []string{"-to-driver", orm.DBDriverSqlite, "-to-dsn", target}); err != nil {
+		t.Fatalf("copyData() error = %v", err)
+	}
+	db, err := orm.OpenDBConfig(config.DBConfig{Driver: orm.DBDriverSqlite, DSN: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var todos, versions int64
+	db.Model(&model.Todo{}).Count(&todos)
+	db.Table(orm.VersionTable("todos")).Count(&versions)
+	if todos != 1 || versions != 1 {
+		t.Errorf("copied %d todos, %d versions, want 1 and 1", todos, versions)
+	}
+}
@@ -0,0 +1,130 @@
+// Command crudctl runs maintenance tasks against the app database.
+//
+// Usage:
+//
+//	crudctl [-config config.yaml] <command> [arguments]
+//
+// The config is read from the (optional) file and then the CRUD_* environment
+// variables, the same way as the server does, e.g. CRUD_DB_DRIVER,
+// CRUD_DB_DSN, CRUD_CRYPTO_PRIMARYKEYID and CRUD_CRYPTO_KEYS.
+//
+// Run `crudctl help` to list the commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+	"github.com/cdfmlr/crud/webhook"
+)
+
+var logger = log.ZoneLogger("crudctl")
+
+// command is a crudctl sub-command.
+type command struct {
+	usage string // one line usage: "name [flags] args"
+	help  string // short description
+	run   func(conf *config.BaseConfig, args []string) error
+}
+
+// commands are all available sub-commands by name.
+var commands = map[string]command{}
+
+func main() {
+	configFile := flag.String("config", "", "path to the config file (optional)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.Arg(0) == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "crudctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	conf, err := readConfig(*configFile)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to read config")
+	}
+
+	if err := cmd.run(conf, flag.Args()[1:]); err != nil {
+		logger.WithError(err).Fatalf("%s failed", flag.Arg(0))
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: crudctl [-config file] <command> [arguments]\n\nCommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// readConfig reads the config from file (if given) and env (CRUD_*).
+func readConfig(file string) (*config.BaseConfig, error) {
+	conf := config.BaseConfig{
+		DB: config.DBConfig{Driver: orm.DBDriverSqlite, DSN: "todolist.db"},
+	}
+	options := []config.Option{config.FromEnv("CRUD")}
+	if file != "" {
+		options = append([]config.Option{config.FromFile(file)}, options...)
+	}
+	err := config.Init(&conf, options...)
+	return &conf, err
+}
+
+// connect connects to the database in conf, installs the encryption keys
+// and registers all models of the app, and those of the audit log, the
+// outbox and the webhooks, as the server does. So they are generated into
+// migrations, too.
+func connect(conf *config.BaseConfig) error {
+	if err := orm.UseEncryptionConfig(conf.Crypto); err != nil {
+		return err
+	}
+	if _, err := orm.ConnectDBConfig(conf.DB); err != nil {
+		return err
+	}
+	if err := orm.RegisterModel(model.Models...); err != nil {
+		return err
+	}
+	if err := audit.Enable(); err != nil {
+		return err
+	}
+	if err := outbox.Enable(); err != nil {
+		return err
+	}
+	return webhook.Enable()
+}
+
+// connectData connects as connect does, and enables the history and the
+// search of the models, as the server does after the migrations. So the
+// shadow tables of their history are backed up and copied, too, and the
+// search indexes are known, see orm.CopyData.
+func connectData(conf *config.BaseConfig) error {
+	if err := connect(conf); err != nil {
+		return err
+	}
+	if err := orm.EnableHistory(model.HistoryModels...); err != nil {
+		return err
+	}
+	return orm.EnableSearch(model.SearchModels...)
+}
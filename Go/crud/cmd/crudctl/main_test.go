@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+	"github.com/cdfmlr/crud/service"
+	"github.com/cdfmlr/crud/webhook"
+)
+
+func TestConnectMigrations(t *testing.T) {
+	defer func(autoMigrate bool) { orm.AutoMigrate = autoMigrate }(orm.AutoMigrate)
+	defer orm.SetRegisteredModels(orm.RegisteredModels())
+	dir := t.TempDir()
+	conf := &config.BaseConfig{DB: config.DBConfig{
+		Driver:     orm.DBDriverSqlite,
+		DSN:        filepath.Join(dir, "test.db"),
+		Migrations: filepath.Join(dir, "migrations"),
+	}}
+
+	orm.SetRegisteredModels(nil)
+	if err := migrate(conf, []string{"create", "init"}); err != nil {
+		t.Fatalf("migrate create error = %v", err)
+	}
+	orm.SetRegisteredModels(nil)
+	if err := migrate(conf, []string{"up"}); err != nil {
+		t.Fatalf("migrate up error = %v", err)
+	}
+
+	for _, m := range []any{&audit.Entry{}, &outbox.Message{}, &webhook.Subscription{}, &webhook.Delivery{}} {
+		if !orm.DB.Migrator().HasTable(m) {
+			t.Errorf("migrate up: no table of %T", m)
+		}
+	}
+
+	// boot with the migrations only, as the server does
+	orm.SetRegisteredModels(nil)
+	if err := connect(conf); err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	ctx := audit.WithSource(context.Background(), audit.Source{Actor: "test"})
+	todo := model.Todo{Title: "buy milk"}
+	if err := service.Create(ctx, &todo, service.IfNotExist()); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	var entries, messages int64
+	orm.DB.Model(&audit.Entry{}).Count(&entries)
+	orm.DB.Model(&outbox.Message{}).Count(&messages)
+	if entries != 1 || messages != 1 {
+		t.Errorf("Create() wrote %d audit entries, %d outbox messages, want 1 and 1", entries, messages)
+	}
+}
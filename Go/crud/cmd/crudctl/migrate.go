@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func init() {
+	commands["migrate"] = command{
+		usage: "migrate [-dir d] up [version]|down [n]|status|create name|unlock",
+		help:  "apply, roll back, list or generate versioned schema migrations",
+		run:   migrate,
+	}
+}
+
+// migrate manages the SQL migrations in the dir (CRUD_DB_MIGRATIONS by
+// default), see orm.Migrate:
+//
+//	migrate up [version]   applies pending migrations (up to the version)
+//	migrate down [n]       rolls back the last n (1 by default) migrations
+//	migrate status         lists migrations and whether they are applied
+//	migrate create name    generates a migration from the models and the live schema
+//	migrate unlock         releases the lock left by a crashed migration now, not
+//	                       waiting for it to expire
+func migrate(conf *config.BaseConfig, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := flags.String("dir", conf.DB.Migrations, "dir of the SQL migrations")
+	_ = flags.Parse(args)
+	if *dir == "" {
+		*dir = "migrations"
+	}
+	if flags.NArg() < 1 {
+		return errors.New("missing subcommand: up, down, status, create or unlock")
+	}
+	sub, arg := flags.Arg(0), flags.Arg(1)
+
+	orm.AutoMigrate = false // the schema is up to the migrations
+	if err := connect(conf); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*dir); err == nil || sub != "create" {
+		if err := orm.LoadMigrations(os.DirFS(*dir), "."); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+
+	switch sub {
+	case "up":
+		applied, err := orm.Migrate(ctx, arg)
+		logger.WithField("applied", applied).Info("migrate up: done")
+		return err
+	case "down":
+		steps := 1
+		if arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return err
+			}
+			steps = n
+		}
+		reverted, err := orm.Rollback(ctx, steps)
+		logger.WithField("reverted", reverted).Info("migrate down: done")
+		return err
+	case "status":
+		statuses, err := orm.MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.Missing:
+				state = "applied (missing)"
+			case s.Modified:
+				state = "applied (modified)"
+			case s.AppliedAt != nil:
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	case "create":
+		if arg == "" {
+			return errors.New("missing migration name")
+		}
+		m, err := orm.GenerateMigration(ctx, arg)
+		if err != nil {
+			return err
+		}
+		files, err := orm.WriteMigration(*dir, m)
+		for _, file := range files {
+			fmt.Println(file)
+		}
+		return err
+	case "unlock":
+		return orm.UnlockMigrations(ctx)
+	}
+	return fmt.Errorf("unknown subcommand %q", sub)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func init() {
+	commands["reencrypt"] = command{
+		usage: "reencrypt [-batch n]",
+		help:  "re-encrypt all encrypted fields with the primary key",
+		run:   reencrypt,
+	}
+}
+
+// reencrypt rewrites encrypted fields of all models with the primary key,
+// after a key rotation or encrypting a field that used to be plaintext.
+func reencrypt(conf *config.BaseConfig, args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	batch := flags.Int("batch", 100, "records per transaction")
+	_ = flags.Parse(args)
+
+	if err := connect(conf); err != nil {
+		return err
+	}
+
+	rows, err := orm.ReEncrypt(context.Background(), *batch, orm.RegisteredModels()...)
+	logger.WithField("rowsAffected", rows).Info("reencrypt: done")
+	return err
+}
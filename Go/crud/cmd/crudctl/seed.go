@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/orm"
+)
+
+func init() {
+	commands["seed"] = command{
+		usage: "seed [-dir d]",
+		help:  "load the fixture files into the database, see orm.LoadFixtures",
+		run:   seed,
+	}
+}
+
+// seed loads the fixtures in the dir (./fixtures by default) into the
+// database. It's idempotent: seeding again updates the records.
+func seed(conf *config.BaseConfig, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	dir := flags.String("dir", "fixtures", "dir of the fixture files")
+	_ = flags.Parse(args)
+
+	if err := connect(conf); err != nil {
+		return err
+	}
+
+	err := orm.LoadFixtures(context.Background(), os.DirFS(*dir), ".")
+	if err == nil {
+		logger.WithField("dir", *dir).Info("seed: done")
+	}
+	return err
+}
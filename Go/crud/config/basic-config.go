@@ -1,9 +1,28 @@
 package config
 
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
 // DBConfig is the configurations for connecting database
 type DBConfig struct {
-	Driver string // db driver name: sqlite, mysql, postgres
-	DSN    string // db connection string
+	Driver     string // db driver name: sqlite, mysql, postgres
+	DSN        string // db connection string
+	Migrations string // dir of SQL migrations to apply on boot, instead of AutoMigrate (optional)
+
+	Replicas []string // DSNs of read replicas (same driver), reads outside of transactions go to them (optional)
+
+	MaxOpenConns     int           // max open connections, 0 for unlimited
+	MaxIdleConns     int           // max idle connections in the pool, 0 for the default (2)
+	ConnMaxLifetime  time.Duration // close connections reused longer than this: "1h", 0 to reuse forever
+	ConnMaxIdleTime  time.Duration // close connections idle longer than this: "10m", 0 to keep them
+	StatementTimeout time.Duration // cancel statements running longer than this: "30s", 0 for no timeout
+	ConnectRetries   int           // retries to connect on startup, 0 to fail at once
+	ConnectBackoff   time.Duration // wait before the first retry, doubled for the next ones: "1s"
+	PrepareStmt      bool          // cache prepared statements
 }
 
 // HTTPConfig is the configurations for HTTP server
@@ -14,9 +33,49 @@ type HTTPConfig struct {
 	//TLSKeyPath  string `json:"tls_key_path"`  // path to tls key file
 }
 
+// CryptoConfig is the configurations for field-level encryption at rest
+type CryptoConfig struct {
+	PrimaryKeyID string   // id of the key to encrypt new values with
+	Keys         []string // "keyID:base64Key" entries, keep retired keys here to decrypt old values
+}
+
+// ParseKeys decodes Keys into a keyID => key map.
+func (c CryptoConfig) ParseKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte, len(c.Keys))
+	for i, entry := range c.Keys {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			// not the entry itself: it may be a bare key
+			return nil, fmt.Errorf("crypto key entry #%d: want keyID:base64Key", i)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("crypto key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// TrashConfig is the configurations for purging soft deleted records
+type TrashConfig struct {
+	RetentionDays int           // purge records trashed longer than this, 0 to keep them forever
+	PurgeInterval time.Duration // how often to purge: "1h"
+}
+
+// OutboxConfig is the configurations for relaying domain events from the outbox
+type OutboxConfig struct {
+	File          string        // file to publish events to (see outbox.FilePublisher), empty not to
+	Subject       string        // subject prefix of events: "crud"
+	RelayInterval time.Duration // how often to relay: "1s"
+}
+
 // BaseConfig includes common config for services
 type BaseConfig struct {
-	DB       DBConfig   // database config
-	HTTP     HTTPConfig // http listen config
-	LogLevel string     // log level
+	DB       DBConfig     // database config
+	HTTP     HTTPConfig   // http listen config
+	Crypto   CryptoConfig // field-level encryption config
+	Trash    TrashConfig  // trash bin config
+	Outbox   OutboxConfig // domain events config
+	LogLevel string       // log level
 }
@@ -250,3 +250,17 @@ func ExampleInit() {
 		logger.WithError(err).Fatal("failed to read config.")
 	}
 }
+
+func TestCryptoConfig_ParseKeys(t *testing.T) {
+	const secret = "c2VjcmV0LWtleS1tYXRlcmlhbA=="
+	keys, err := CryptoConfig{Keys: []string{"k1:" + secret}}.ParseKeys()
+	if err != nil || string(keys["k1"]) != "secret-key-material" {
+		t.Errorf("ParseKeys() = %v, %v", keys, err)
+	}
+
+	// a bare key is not printed in the error
+	_, err = CryptoConfig{Keys: []string{"k1:" + secret, secret}}.ParseKeys()
+	if err == nil || strings.Contains(err.Error(), secret) || !strings.Contains(err.Error(), "#1") {
+		t.Errorf("ParseKeys(bare key) error = %v", err)
+	}
+}
@@ -14,7 +14,8 @@ var OAuth2Config *oauth2.Config
 func init() {
 	err := godotenv.Load() // This will load the .env file
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		// variables may as well be set in the environment directly
+		log.Println("Error loading .env file:", err)
 	}
 
 	OAuth2Config = &oauth2.Config{
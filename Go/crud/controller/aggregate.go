@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+)
+
+// AggregateRequestOptions is the query options (?opt=val) for
+// AggregateHandler:
+//
+//     group_by=done&group_by=project_id&  # columns to group by
+//     agg=count&agg=sum:price&            # aggregations: func[:column]
+//     filter_by=name&filter_value=John&   # filtering, as GetListHandler
+//     q=buy+milk                          # full-text search, as GetListHandler
+//
+// Aggregate functions are count, sum, avg, min and max. A count without
+// column counts the rows. If no agg is given, it defaults to count.
+type AggregateRequestOptions struct {
+	GroupBy     []string `form:"group_by"`
+	Aggregate   []string `form:"agg"`
+	FilterBy    string   `form:"filter_by"`
+	FilterValue string   `form:"filter_value"`
+	Q           string   `form:"q"`
+}
+
+var ErrColumnNotAllowed = errors.New("column is not allowed")
+
+// AggregateHandler handles
+//    GET /T/_aggregate
+// It returns the aggregations of models grouped by columns, as a table.
+//
+// QueryOptions (See AggregateRequestOptions for more details):
+//    group_by, agg, filter_by, filter_value, q.
+//
+// Only the given columns are allowed in group_by and agg, it's an error
+// to use others. Columns of many2many associations are grouped by as
+// "<association>.<column>", e.g. group_by=todos.done, see service.Aggregate.
+//
+// Response:
+//  - 200 OK: { columns: [{name: "done", type: "bool"}, {name: "count", type: "int"}],
+//              rows: [[false, 3], [true, 5]] }
+//  - 400 Bad Request: { error: "request band failed or column is not allowed" }
+//  - 422 Unprocessable Entity: { error: "aggregate process failed" }
+func AggregateHandler[T any](columns ...string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, column := range columns {
+		allowed[column] = true
+	}
+
+	return func(c *gin.Context) {
+		var request AggregateRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("AggregateHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		for _, column := range request.GroupBy {
+			if !allowed[column] {
+				ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrColumnNotAllowed, column))
+				return
+			}
+		}
+		if len(request.Aggregate) == 0 {
+			request.Aggregate = []string{string(service.AggregateCount)}
+		}
+		var aggregations []service.Aggregation
+		for _, agg := range request.Aggregate {
+			fn, column, _ := strings.Cut(agg, ":")
+			if column != "" && !allowed[column] {
+				ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrColumnNotAllowed, column))
+				return
+			}
+			aggregations = append(aggregations, service.Aggregation{
+				Func:   service.AggregateFunc(strings.ToLower(fn)),
+				Column: column,
+			})
+		}
+
+		options := filterOptions[T](request.FilterBy, request.FilterValue, request.Q)
+		table, err := service.Aggregate[T](c, request.GroupBy, aggregations, options...)
+		if errors.Is(err, service.ErrUnknownColumn) || errors.Is(err, service.ErrInvalidAggregation) {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("AggregateHandler: Aggregate failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"columns": table.Columns, "rows": table.Rows})
+	}
+}
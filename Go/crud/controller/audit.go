@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// auditSource returns a context of the request c carrying the source of
+// its mutations, to be passed to the services, which write the audit
+// entries in their transactions, see audit.WithSource.
+//
+// before is the version of the model before an update, nil if unknown
+// (the services provide it where they read it).
+func auditSource(c *gin.Context, before any) context.Context {
+	return audit.WithSource(c, audit.Source{
+		Actor:     c.GetString(middleware.EmailKey),
+		RequestID: c.GetString("request_id"),
+		ClientIP:  c.ClientIP(),
+		Before:    before,
+	})
+}
+
+// AuditListHandler handles
+//    GET /audit
+// to read the audit log, newest first.
+//
+// QueryOptions (see audit.Filter): actor, request_id, action, model,
+// model_id, since, until (RFC 3339), and limit, offset for pagination.
+// The page size defaults to audit.QueryDefaultLimit and is capped by
+// audit.QueryMaxLimit.
+//
+// Response:
+//  - 200 OK: { entries: [{...}, ...], total: 123 }
+//  - 400 Bad Request: { error: "request band failed" }
+//  - 422 Unprocessable Entity: { error: "query process failed" }
+func AuditListHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			audit.Filter
+			Limit  int `form:"limit"`
+			Offset int `form:"offset"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("AuditListHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		entries, total, err := audit.Query(c, request.Filter, request.Limit, request.Offset)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("AuditListHandler: Query failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"entries": entries, "total": total})
+	}
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+)
+
+// formats of BackupHandler
+const (
+	BackupFormatNDJSON = "ndjson" // rows of all models, see orm.ExportData
+	BackupFormatSQL    = "sql"    // a SQL dump, see orm.DumpSQL
+	BackupFormatSQLite = "sqlite" // a copy of the SQLite database, see orm.Backup
+)
+
+var ErrUnknownBackupFormat = errors.New("unknown backup format")
+
+// BackupHandler handles
+//    GET /backup?format=ndjson|sql|sqlite
+// to download a consistent online backup of the database: the rows of all
+// registered models as NDJSON (by default, restore it into any database
+// with orm.ImportData), a SQL dump (restore it with psql, mysql or
+// sqlite3), or a copy of the database file (SQLite only).
+//
+// Response:
+//  - 200 OK: the backup, as an attachment
+//  - 400 Bad Request: { error: "unknown backup format" }
+//  - 422 Unprocessable Entity: { error: "backup failed" }
+//
+// Errors after the backup started to be written are only logged, the
+// response is cut off.
+func BackupHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", BackupFormatNDJSON)
+		filename := fmt.Sprintf("backup-%s.%s", time.Now().UTC().Format("20060102150405"), format)
+		ctx := c.Request.Context()
+
+		var err error
+		switch format {
+		case BackupFormatNDJSON:
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			c.Header("Content-Type", "application/x-ndjson")
+			err = orm.ExportData(ctx, c.Writer)
+		case BackupFormatSQL:
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			c.Header("Content-Type", "application/sql")
+			err = orm.DumpSQL(ctx, c.Writer)
+		case BackupFormatSQLite:
+			if orm.DB.Dialector.Name() != orm.DBDriverSqlite {
+				ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s of %s database", ErrUnknownBackupFormat, format, orm.DB.Dialector.Name()))
+				return
+			}
+			err = backupFile(c, filename)
+		default:
+			ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrUnknownBackupFormat, format))
+			return
+		}
+
+		switch {
+		case err != nil && !c.Writer.Written():
+			logger.WithContext(c).WithError(err).
+				Warn("BackupHandler: backup failed")
+			c.Writer.Header().Del("Content-Disposition")
+			ResponseError(c, CodeProcessFailed, err)
+		case err != nil:
+			logger.WithContext(c).WithError(err).
+				Warn("BackupHandler: backup interrupted")
+		}
+	}
+}
+
+// backupFile backs up the database into a temporary file, and responds it.
+func backupFile(c *gin.Context, filename string) error {
+	dir, err := os.MkdirTemp("", "crud-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, filename)
+	if err := orm.Backup(c.Request.Context(), path); err != nil {
+		return err
+	}
+	c.FileAttachment(path, filename)
+	return nil
+}
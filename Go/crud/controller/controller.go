@@ -14,6 +14,9 @@
 //   - POST   /models/:id/field => CreateNestedHandler[Model] : to create a nested model (association)
 //   - DELETE /models/:id/field => DeleteNestedHandler[Model] : to delete an association record
 //
+//   - GET    /models/_events    => EventsHandler[Model]         : to stream changes as Server-Sent Events
+//   - GET    /models/_events/ws => WebSocketEventsHandler[Model]: to stream changes over WebSocket
+//
 // The controller are all generic functions, which is available in Go 1.18 and
 // later, see [Go generics tutorial] for help if you are not familiar with this
 // feature. What you need to notice is that you HAVE TO pass handles the
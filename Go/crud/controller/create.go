@@ -1,25 +1,53 @@
 package controller
 
 import (
+	"errors"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
-	"reflect"
 )
 
+// CreateRequestOptions is the query options (?opt=val) for CreateHandler:
+//
+//     on_conflict=update&           # or ignore, by default fails on conflicts
+//     conflict_columns=title&       # the unique columns, primary keys by default
+//     restore=true                  # restore a (soft) deleted model conflicting
+type CreateRequestOptions struct {
+	OnConflict      string   `form:"on_conflict"`
+	ConflictColumns []string `form:"conflict_columns"`
+	Restore         bool     `form:"restore"`
+}
+
 // CreateHandler handles
 //    POST /T
 // creates a new model T, responds with the created model T if successful.
 //
+// With the on_conflict option, it's an upsert: the existing model with
+// the same conflict columns (a unique index) is replaced (update) or kept
+// (ignore), see service.Upsert. And it responds with that model. A deleted
+// model conflicting is a 409 Conflict, unless restore=true.
+//
 // Request body:
 //  - {...}  // fields of the model T
 //
+// QueryOptions (See CreateRequestOptions for more details):
+//    on_conflict, conflict_columns, restore.
+//
 // Response:
 //  - 200 OK: { T: {...} }
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 409 Conflict: { error: "conflicts with a deleted model, restore it first" }
 //  - 422 Unprocessable Entity: { error: "create process failed" }
 func CreateHandler[T any]() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var request CreateRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("CreateHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
 		var model T
 		if err := c.ShouldBindJSON(&model); err != nil {
 			logger.WithContext(c).WithError(err).
@@ -28,15 +56,48 @@ func CreateHandler[T any]() gin.HandlerFunc {
 			return
 		}
 		logger.WithContext(c).Tracef("CreateHandler: Create %#v", model)
-		err := service.Create(c, &model, service.IfNotExist())
+
+		if request.OnConflict != "" {
+			upsertHandler(c, &model, service.OnConflict{
+				Columns: request.ConflictColumns,
+				Action:  service.ConflictAction(request.OnConflict),
+				Restore: request.Restore,
+			})
+			return
+		}
+
+		err := service.Create(auditSource(c, nil), &model, service.IfNotExist())
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateHandler: Create failed")
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
-		c.JSON(200, SuccessResponseBody(model))
+		c.Header("ETag", ETag(&model))
+		ResponseSuccess(c, &model)
+	}
+}
+
+// upsertHandler upserts the model for CreateHandler.
+func upsertHandler[T any](c *gin.Context, model *T, onConflict service.OnConflict) {
+	_, err := service.Upsert(auditSource(c, nil), model, onConflict)
+	if errors.Is(err, service.ErrUnknownConflictAction) || errors.Is(err, service.ErrUnknownField) {
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	if errors.Is(err, service.ErrConflictDeleted) {
+		ResponseError(c, CodeConflict, err)
+		return
 	}
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("CreateHandler: Upsert failed")
+		ResponseError(c, CodeProcessFailed, err)
+		return
+	}
+
+	c.Header("ETag", ETag(model))
+	ResponseSuccess(c, model)
 }
 
 // CreateNestedHandler handles
@@ -70,7 +131,7 @@ func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, fi
 			return
 		}
 
-		if _, childID := child.Identity(); !reflect.ValueOf(childID).IsZero() {
+		if _, childID := child.Identity(); !orm.IsZeroID(childID) {
 			// child id exists: add to join table, but do not update child's fields
 			logger.WithField("childID", childID).Debug("CreateNestedHandler: child model has ID, add to join table, but do not update child's fields")
 			if err := service.GetByID[T](c, childID, &child); err != nil {
@@ -97,7 +158,7 @@ func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, fi
 		//field := strings.ToUpper(field)[:1] + field[1:]
 		field := nameToField(field, parent)
 
-		err := service.Create(c, &child, service.NestInto(&parent, field))
+		err := service.Create(auditSource(c, nil), &child, service.NestInto(&parent, field))
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateNestedHandler: CreateNest failed")
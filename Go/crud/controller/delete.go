@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
@@ -10,11 +11,21 @@ import (
 //    DELETE /T/:idParam
 // Deletes the model T with the given id.
 //
+// Models with a gorm.DeletedAt field (e.g. orm.BasicModel) are soft deleted.
+// They can be deleted permanently by
+//    DELETE /T/:idParam?hard=true
+// if it is allowed (HardDeleteKey) for the request, see router.Trash.
+//
+// With an If-Match header, the model is deleted only if it matches the
+// ETag of the current version, see ETag.
+//
 // Request body: none
 //
 // Response:
 //  - 200 OK: { deleted: true }
 //  - 400 Bad Request: { error: "missing id" }
+//  - 403 Forbidden: { error: "hard delete is not allowed" }
+//  - 412 Precondition Failed: { error: "precondition failed" }
 //  - 422 Unprocessable Entity: { error: "delete process failed" }
 func DeleteHandler[T orm.Model](idParam string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -26,10 +37,27 @@ func DeleteHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			ResponseError(c, CodeBadRequest, ErrMissingID)
 			return
 		}
+		if c.Query("hard") == "true" {
+			hardDelete[T](c, id)
+			return
+		}
+
 		logger.WithContext(c).
 			Tracef("DeleteHandler: Delete %T, id=%v", *new(T), id)
 
-		_, err := service.DeleteByID[T](c, id)
+		ctx := auditSource(c, nil)
+		var err error
+		if c.GetHeader("If-Match") != "" {
+			_, err = service.DeleteByIDIf(ctx, id, func(current *T) bool {
+				return ifMatch(c, ETag(current))
+			})
+		} else {
+			_, err = service.DeleteByID[T](ctx, id)
+		}
+		if errors.Is(err, service.ErrPreconditionFailed) {
+			ResponseError(c, CodePreconditionFailed, err)
+			return
+		}
 		if err != nil {
 			ResponseError(c, CodeProcessFailed, err)
 			return
@@ -76,7 +104,7 @@ func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field s
 		logger.WithContext(c).
 			Tracef("DeleteNestedHandler: Delete %v of %v, parentId=%v, field=%v, childId=%v", *new(T), *new(P), parentId, field, childId)
 
-		err := service.DeleteNestedByID[P, T](c, parentId, field, childId)
+		err := service.DeleteNestedByID[P, T](auditSource(c, nil), parentId, field, childId)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("DeleteNestedHandler: Delete failed")
@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ETag returns the entity tag of a version of a model (or a pointer to
+// it) for optimistic concurrency control: "<id>-<hash>", where the hash
+// is of the values of its columns, associations excluded. Other values
+// (not a struct) are tagged by a hash of their JSON.
+//
+// Time values are truncated to milliseconds (the precision of MySQL's
+// datetime(3)) in UTC, so that the tag of a model just saved equals the
+// tag of the model read back, whichever the database is.
+//
+// The tags in responses of GET are of the representation, see
+// representationETag.
+func ETag(model any) string {
+	v := reflect.ValueOf(model)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		if s, err := schema.Parse(reflect.New(v.Type()).Interface(), &etagSchemas, schema.NamingStrategy{}); err == nil {
+			id := ""
+			if m, ok := v.Interface().(orm.Model); ok {
+				_, value := m.Identity()
+				id = fmt.Sprint(value)
+			}
+			return fmt.Sprintf(`"%s-%s"`, id, columnsHash(s, v))
+		}
+	}
+
+	j, _ := json.Marshal(model)
+	sum := sha1.Sum(j)
+	return `"` + hex.EncodeToString(sum[:10]) + `"`
+}
+
+// etagSchemas caches the schemas parsed by ETag.
+var etagSchemas sync.Map
+
+// columnsHash hashes the values of the columns of the struct v.
+func columnsHash(s *schema.Schema, v reflect.Value) string {
+	values := make([]any, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), v)
+		values = append(values, etagValue(value))
+	}
+	j, _ := json.Marshal(values)
+	sum := sha1.Sum(j)
+	return hex.EncodeToString(sum[:10])
+}
+
+// etagValue normalizes a column value for ETag: times in milliseconds.
+func etagValue(value any) any {
+	switch t := value.(type) {
+	case time.Time:
+		return t.UnixNano() / int64(time.Millisecond)
+	case *time.Time:
+		if t != nil {
+			return etagValue(*t)
+		}
+	case gorm.DeletedAt:
+		if t.Valid {
+			return etagValue(t.Time)
+		}
+		return nil
+	}
+	return value
+}
+
+// representationETag returns the tag of the representation of a version
+// (etag) in the response to c: the shape (fields, preload) and the format
+// of a response make a distinct representation, "<etag>.<hash>", while
+// the default one (all fields, JSON) is tagged etag itself.
+//
+// etag is of the columns only, so with preload, the associations loaded
+// into dest (the model or the list in the response) are hashed, too.
+func representationETag(c *gin.Context, etag string, dest any) string {
+	var variant []string
+	for key, values := range c.Request.URL.Query() {
+		if key == "preload" || key == "fields" || strings.HasPrefix(key, "fields[") {
+			variant = append(variant, key+"="+strings.Join(values, ","))
+		}
+		if key == "preload" {
+			j, _ := json.Marshal(dest)
+			sum := sha1.Sum(j)
+			variant = append(variant, "preloaded="+hex.EncodeToString(sum[:10]))
+		}
+	}
+	if format := responseFormat(c); format != FormatJSON {
+		variant = append(variant, "format="+format)
+	}
+	if len(variant) == 0 {
+		return etag
+	}
+	sort.Strings(variant)
+	sum := sha1.Sum([]byte(strings.Join(variant, "&")))
+	return strings.TrimSuffix(etag, `"`) + "." + hex.EncodeToString(sum[:4]) + `"`
+}
+
+// listETag returns the entity tag of a list of models (and the addition
+// fields in response, e.g. total).
+func listETag(models any, addition ...gin.H) string {
+	h := sha1.New()
+
+	v := reflect.ValueOf(models)
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			h.Write([]byte(ETag(v.Index(i).Interface())))
+		}
+	}
+	for _, a := range addition {
+		j, _ := json.Marshal(a)
+		h.Write(j)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:10]) + `"`
+}
+
+// notModified sets the ETag header to the tag of the representation of
+// the version etag of dest (see representationETag), and if it matches
+// the If-None-Match header of the request, responds 304 Not Modified and
+// returns true.
+func notModified(c *gin.Context, etag string, dest any) bool {
+	etag = representationETag(c, etag, dest)
+	c.Header("ETag", etag)
+	varyAccept(c)
+	if inm := c.GetHeader("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// ifMatch checks the If-Match header of the request against the etag,
+// true if there is no If-Match header.
+//
+// Only the tags of the default representation (see representationETag)
+// match: the tag of a shaped one is of the fields in it, not the version.
+func ifMatch(c *gin.Context, etag string) bool {
+	im := c.GetHeader("If-Match")
+	return im == "" || etagMatches(im, etag, false)
+}
+
+// etagMatches reports whether the header (a list of entity tags or "*")
+// matches etag. weak for the weak comparison (If-None-Match),
+// or the strong comparison (If-Match) is used.
+//
+// See RFC 9110, section 8.8.3.2.
+func etagMatches(header string, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
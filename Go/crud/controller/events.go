@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// EventFilter authorizes a change event for the request c (i.e. the
+// subscriber): returns false to hide the event from it. The event can be
+// modified (e.g. to redact event.Data) as well.
+type EventFilter func(c *gin.Context, event *broker.Event) bool
+
+// EventsHeartbeat is the interval to keep idle event streams alive.
+var EventsHeartbeat = 30 * time.Second
+
+// EventsUpgrader upgrades WebSocket requests in WebSocketEventsHandler.
+// Set its CheckOrigin to allow cross-origin clients, which are refused
+// by default.
+var EventsUpgrader = websocket.Upgrader{}
+
+// EventsHandler handles
+//    GET /T/_events
+// It streams the change events (broker.Event) of model T as Server-Sent
+// Events, named after the event types (created, updated, deleted, reset),
+// with the event ids and the JSON encoded events as data.
+//
+// To resume after a reconnection, a Last-Event-ID header (sent by the
+// EventSource of browsers automatically) or a last_event_id query is
+// accepted. A reset event means that some changes are missed: reload.
+//
+// Events are sent only if authorize returns true, or authorize is nil.
+func EventsHandler[T any](authorize EventFilter) gin.HandlerFunc {
+	model := eventModelName[T]()
+	return func(c *gin.Context) {
+		sub := broker.Subscribe(model, lastEventID(c))
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // no proxy buffering (nginx)
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(EventsHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-heartbeat.C:
+				_, _ = c.Writer.WriteString(": heartbeat\n\n")
+			case event, ok := <-sub.Events():
+				if !ok { // dropped by the broker: let the client reconnect
+					return
+				}
+				if !authorizeEvent(c, authorize, &event) {
+					continue
+				}
+				c.Render(-1, sse.Event{
+					Id:    strconv.FormatUint(event.ID, 10),
+					Event: string(event.Type),
+					Data:  event,
+				})
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// WebSocketEventsHandler handles
+//    GET /T/_events/ws
+// It upgrades the request to a WebSocket (with EventsUpgrader) and sends
+// the change events (broker.Event) of model T as JSON text messages.
+// Messages from the client are ignored.
+//
+// Resuming and authorization are the same as EventsHandler. Since
+// browsers can not set headers for WebSocket, use the last_event_id query
+// to resume.
+func WebSocketEventsHandler[T any](authorize EventFilter) gin.HandlerFunc {
+	model := eventModelName[T]()
+	return func(c *gin.Context) {
+		conn, err := EventsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil { // Upgrade has responded the error
+			logger.WithContext(c).WithError(err).
+				Warn("WebSocketEventsHandler: upgrade failed")
+			return
+		}
+		defer conn.Close()
+
+		sub := broker.Subscribe(model, lastEventID(c))
+		defer sub.Close()
+
+		// read (and discard) messages to process control frames,
+		// until the connection is closed.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		heartbeat := time.NewTicker(EventsHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-heartbeat.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(EventsHeartbeat))
+			case event, ok := <-sub.Events():
+				if !ok {
+					_ = conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+						time.Now().Add(time.Second))
+					return
+				}
+				if !authorizeEvent(c, authorize, &event) {
+					continue
+				}
+				err = conn.WriteJSON(event)
+			}
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Debug("WebSocketEventsHandler: write failed, closing")
+				return
+			}
+		}
+	}
+}
+
+// authorizeEvent applies authorize to the event. Reset events carry no
+// record, so they are always allowed.
+func authorizeEvent(c *gin.Context, authorize EventFilter, event *broker.Event) bool {
+	return authorize == nil || event.Type == broker.EventReset || authorize(c, event)
+}
+
+// lastEventID reads the Last-Event-ID header or the last_event_id query,
+// 0 if none or invalid.
+func lastEventID(c *gin.Context) uint64 {
+	s := c.GetHeader("Last-Event-ID")
+	if s == "" {
+		s = c.Query("last_event_id")
+	}
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return id
+}
+
+// eventModelName: T => "T", as the broker.Event.Model published by service.
+func eventModelName[T any]() string {
+	return reflect.TypeOf(*new(T)).Name()
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportBatchSize is the number of models queried and written at a time
+// by ExportHandler.
+var ExportBatchSize = 500
+
+// ExportHandler handles
+//    GET /T/_export
+// It streams all the (filtered) models, in the order of the primary key,
+// as NDJSON (by default) or CSV. The models are queried and written batch
+// by batch (see ExportBatchSize), never all loaded in memory. It stops
+// when the client disconnects.
+//
+// QueryOptions (See GetRequestOptions for more details):
+//    filter_by, filter_value, q, preload, fields, and format=csv|ndjson
+//    (or by the Accept header, see responseFormat).
+//
+// Response:
+//  - 200 OK: a JSON line per model, or a CSV header line and a line per model
+//  - 400 Bad Request: { error: "request band failed or bad fields" }
+//
+// Errors after the first line are only logged, the response is cut off.
+func ExportHandler[T any]() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request GetRequestOptions
+		if err := c.ShouldBind(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ExportHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		options := filterOptions[T](request.FilterBy, request.FilterValue, request.Q)
+		for _, field := range request.Preload {
+			options = append(options, service.Preload(field))
+		}
+		fieldOptions, shape, err := sparseFields(c, new(T), "", request.Preload)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ExportHandler: bad fields")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		options = append(options, fieldOptions...)
+
+		format := responseFormat(c)
+		varyAccept(c)
+		if format != FormatCSV {
+			format = FormatNDJSON
+		}
+		t := reflect.TypeOf(*new(T))
+
+		var w *recordWriter // started with the first batch
+		start := func() *recordWriter {
+			c.Header("Content-Disposition",
+				fmt.Sprintf(`attachment; filename="%ss.%s"`, strings.ToLower(t.Name()), format))
+			return newRecordWriter(c, format, t, shape)
+		}
+
+		exported := 0
+		// the request context is canceled when the client disconnects
+		err = service.FindInBatches[T](c.Request.Context(), ExportBatchSize, func(batch []*T) error {
+			if w == nil {
+				w = start()
+			}
+			records := make([]reflect.Value, 0, len(batch))
+			for _, model := range batch {
+				records = append(records, reflect.ValueOf(model).Elem())
+			}
+			exported += len(records)
+			return w.write(records)
+		}, options...)
+
+		switch {
+		case err != nil && w == nil:
+			ResponseError(c, CodeProcessFailed, err)
+		case err != nil:
+			logger.WithContext(c).WithError(err).
+				WithField("exported", exported).
+				Warn("ExportHandler: export interrupted")
+		case w == nil: // nothing found
+			_ = start().write(nil)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+)
+
+// sparseFields parses the sparse fieldsets in the query of the request:
+//
+//     fields=title,done&            # fields of the model
+//     fields[todos]=title,done&     # fields of a (preloaded) association
+//     fields[todos.tags]=name       # fields of a nested association
+//
+// into query options selecting only the columns needed, and a
+// responseShape to trim the serialized output. The fields are validated
+// against the model (see service.ResolveFields).
+//
+// model is the (zero value of) model queried, or the parent of the
+// association queried at the base path (e.g. in GetFieldHandler). Options
+// of associations are added for the preloads only. If there is no fields
+// in the query, it returns nil, nil, nil.
+func sparseFields(c *gin.Context, model any, base string, preloads []string) ([]service.QueryOption, *responseShape, error) {
+	fields := c.QueryMap("fields")
+	if top, ok := c.GetQuery("fields"); ok {
+		fields[""] = top
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+
+	var options []service.QueryOption
+	shape := &responseShape{}
+	for path, names := range fields {
+		fieldset, err := resolveFields(model, base, path, splitFields(names))
+		if err != nil {
+			return nil, nil, err
+		}
+		shape.at(fieldset.JSONPath).keep(fieldset.Keys)
+
+		if fieldset.Path == "" {
+			options = append(options, service.Select(fieldset.Columns...))
+			continue
+		}
+		for _, preload := range preloads {
+			if preload == fieldset.Path {
+				// overrides the Preload without conditions
+				options = append(options, service.Preload(preload, service.Select(fieldset.Columns...)))
+			}
+		}
+	}
+	// preloaded associations are kept in the output
+	for _, preload := range preloads {
+		fieldset, err := resolveFields(model, base, preload, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		shape.at(fieldset.JSONPath)
+	}
+	return options, shape, nil
+}
+
+// resolveFields resolves the fields at the path relative to the base
+// association of model, see service.ResolveFields.
+func resolveFields(model any, base string, path string, names []string) (*service.Fieldset, error) {
+	fieldset, err := service.ResolveFields(model, strings.Trim(base+"."+path, "."), names)
+	if err != nil || base == "" {
+		return fieldset, err
+	}
+	fieldset.Path = strings.TrimPrefix(strings.TrimPrefix(fieldset.Path, base), ".")
+	fieldset.JSONPath = fieldset.JSONPath[strings.Count(base, ".")+1:]
+	return fieldset, nil
+}
+
+// responseSuccessShaped is ResponseSuccess with the model trimmed to the
+// shape (if not nil).
+func responseSuccessShaped(c *gin.Context, shape *responseShape, model any, addition ...gin.H) {
+	writeSuccess(c, model, shape, addition...)
+}
+
+func splitFields(names string) []string {
+	var fields []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// responseShape trims the serialized models (in JSON) to the sparse
+// fieldsets, see sparseFields.
+type responseShape struct {
+	keys     map[string]bool           // the keys to keep, nil to keep all
+	children map[string]*responseShape // shapes of associations by their keys
+}
+
+// at returns the shape of the association at the JSON path, adding it
+// (and the ones along the path) if absent.
+func (s *responseShape) at(path []string) *responseShape {
+	if len(path) == 0 {
+		return s
+	}
+	if s.children == nil {
+		s.children = map[string]*responseShape{}
+	}
+	child, ok := s.children[path[0]]
+	if !ok {
+		child = &responseShape{}
+		s.children[path[0]] = child
+	}
+	return child.at(path[1:])
+}
+
+func (s *responseShape) keep(keys []string) {
+	if s.keys == nil {
+		s.keys = map[string]bool{}
+	}
+	for _, key := range keys {
+		s.keys[key] = true
+	}
+}
+
+// apply trims the model (or a list of models) to the shape. The result is
+// the model in generic JSON values (map[string]any, []any, ...).
+// A nil shape returns the model as is.
+func (s *responseShape) apply(model any) (any, error) {
+	if s == nil {
+		return model, nil
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return s.trim(v), nil
+}
+
+func (s *responseShape) trim(v any) any {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			v[i] = s.trim(v[i])
+		}
+	case map[string]any:
+		for key, value := range v {
+			if child, ok := s.children[key]; ok {
+				v[key] = child.trim(value)
+			} else if s.keys != nil && !s.keys[key] {
+				delete(v, key)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,410 @@
+package controller
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Response formats of success responses, negotiated by the format query
+// parameter (e.g. ?format=csv) or else the Accept header of the request.
+const (
+	FormatJSON    = "json"
+	FormatXML     = "xml"
+	FormatMsgPack = "msgpack"
+	FormatCSV     = "csv"    // models only: a header line of columns and a line per model
+	FormatNDJSON  = "ndjson" // models only: a JSON line per model
+)
+
+// formatMIMEs are the MIME types of formats.
+var formatMIMEs = []struct {
+	format string
+	mime   string
+}{
+	{FormatJSON, "application/json"},
+	{FormatCSV, "text/csv"},
+	{FormatNDJSON, "application/x-ndjson"},
+	{FormatMsgPack, "application/msgpack"},
+	{FormatMsgPack, "application/x-msgpack"},
+	{FormatXML, "application/xml"},
+	{FormatXML, "text/xml"},
+}
+
+// responseFormat negotiates the format of the success response.
+//
+// JSON is the default: another format is responded only if it's asked by
+// the format query parameter, or it's the most preferred one (by the
+// q-values) in the Accept header, and JSON is not (by application/json or
+// wildcards). So browsers, preferring text/html to application/xml to
+// */*, get JSON.
+func responseFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		for _, f := range formatMIMEs {
+			if f.format == format {
+				return format
+			}
+		}
+		return FormatJSON
+	}
+
+	preferred := FormatJSON
+	best := 0.0
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		mime, q := parseAccept(accepted)
+		if mime == "" || q <= 0 || q < best {
+			continue
+		}
+		format := "" // not offered
+		if mime == "*/*" || mime == "application/*" {
+			format = FormatJSON
+		}
+		for _, f := range formatMIMEs {
+			if f.mime == mime {
+				format = f.format
+			}
+		}
+		if q > best {
+			best, preferred = q, format
+		} else if format == FormatJSON { // ties are JSON
+			preferred = FormatJSON
+		}
+	}
+	if preferred == "" { // preferred something else
+		return FormatJSON
+	}
+	return preferred
+}
+
+// parseAccept parses a media range of the Accept header, e.g.
+// "application/xml;q=0.9", into the lower-cased MIME type and the q-value.
+func parseAccept(accepted string) (mime string, q float64) {
+	params := strings.Split(accepted, ";")
+	mime = strings.ToLower(strings.TrimSpace(params[0]))
+	q = 1
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(strings.TrimSpace(key), "q") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return mime, 0
+			}
+			q = f
+		}
+	}
+	return mime, q
+}
+
+// varyAccept adds Accept to the Vary header of the response, which is
+// negotiated by it.
+func varyAccept(c *gin.Context) {
+	for _, vary := range c.Writer.Header().Values("Vary") {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), "Accept") {
+				return
+			}
+		}
+	}
+	c.Writer.Header().Add("Vary", "Accept")
+}
+
+// writeSuccess writes a success response of the model (trimmed to the
+// shape, if not nil) in the negotiated format.
+//
+// CSV and NDJSON are written for models (a struct, or a slice of them)
+// only, with the total (if any in addition) in the X-Total-Count header.
+// Other responses (e.g. the model is nil) fall back to JSON.
+func writeSuccess(c *gin.Context, model any, shape *responseShape, addition ...gin.H) {
+	format := responseFormat(c)
+	varyAccept(c)
+
+	if format == FormatCSV || format == FormatNDJSON {
+		if t, records, ok := modelRecords(model); ok {
+			for _, a := range addition {
+				if total, ok := a["total"]; ok {
+					c.Header("X-Total-Count", fmt.Sprint(total))
+				}
+			}
+			if err := newRecordWriter(c, format, t, shape).write(records); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("writeSuccess: write records failed")
+			}
+			return
+		}
+	}
+
+	body := SuccessResponseBody(model, addition...)
+	if shape != nil {
+		shaped, err := shape.apply(model)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("writeSuccess: apply shape failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		body = SuccessResponseBody(nil, append(addition, gin.H{getResponseModelName(model): shaped})...)
+	}
+
+	switch format {
+	case FormatXML:
+		c.XML(http.StatusOK, xmlValue(body))
+	case FormatMsgPack:
+		c.Render(http.StatusOK, render.MsgPack{Data: body})
+	default:
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// modelRecords returns the struct values of model: the model itself if
+// it's a struct (or a pointer to it), or the elements of a slice of them
+// (nil elements are skipped), and the struct type.
+func modelRecords(model any) (t reflect.Type, records []reflect.Value, ok bool) {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() == reflect.Struct {
+		return v.Type(), []reflect.Value{v}, true
+	}
+	if v.Kind() != reflect.Slice || recordType(v.Type().Elem()) == nil {
+		return nil, nil, false
+	}
+	for i := 0; i < v.Len(); i++ {
+		if record := reflect.Indirect(v.Index(i)); record.IsValid() {
+			records = append(records, record)
+		}
+	}
+	return recordType(v.Type().Elem()), records, true
+}
+
+// recordType returns the struct type of t (or *t), nil if not a struct.
+func recordType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// recordColumn is a column of models in CSV: a field of scalar value,
+// named by its JSON key.
+type recordColumn struct {
+	name  string
+	index []int
+}
+
+// recordColumns derives the columns from the fields with columns of the
+// model of struct type t, including the fields of
+// embedded structs, named by their JSON keys (see orm.JSONKey). Fields not
+// in the JSON and not of scalar values (e.g. serialized maps) are not
+// columns.
+func recordColumns(t reflect.Type) []recordColumn {
+	s, err := orm.ParseSchema(reflect.New(t).Interface())
+	if err != nil {
+		return nil
+	}
+	var columns []recordColumn
+	for _, field := range s.Fields {
+		name := orm.JSONKey(field)
+		if field.DBName == "" || name == "" || !isScalar(field.FieldType) {
+			continue
+		}
+		var index []int
+		for ft, i := t, 0; i < len(field.BindNames); i++ {
+			sf, _ := ft.FieldByName(field.BindNames[i])
+			index = append(index, sf.Index...)
+			ft = recordType(sf.Type)
+		}
+		columns = append(columns, recordColumn{name: name, index: index})
+	}
+	return columns
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+func isScalar(t reflect.Type) bool {
+	if t.Implements(valuerType) || t == timeType {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		return isScalar(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Array, reflect.Chan, reflect.Func, reflect.Interface:
+		return false
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8 // []byte
+	}
+	return true
+}
+
+// fieldString formats a field value for CSV.
+func fieldString(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	value := v.Interface()
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		if value, err = valuer.Value(); err != nil || value == nil {
+			return ""
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// recordWriter writes models as CSV or NDJSON records, see
+// newRecordWriter.
+type recordWriter struct {
+	c       *gin.Context
+	shape   *responseShape
+	csv     *csv.Writer    // for FormatCSV
+	columns []recordColumn // for FormatCSV
+	json    *json.Encoder  // for FormatNDJSON
+}
+
+// newRecordWriter writes the response header for the models of struct
+// type t in format (FormatCSV or FormatNDJSON), and returns a writer of
+// the models, trimmed to the shape (if not nil).
+//
+// The CSV columns are derived from t (see recordColumns), and the header
+// line of them is written with the first records.
+func newRecordWriter(c *gin.Context, format string, t reflect.Type, shape *responseShape) *recordWriter {
+	w := &recordWriter{c: c, shape: shape}
+	if format == FormatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w.csv = csv.NewWriter(c.Writer)
+
+		var header []string
+		for _, column := range recordColumns(t) {
+			if shape == nil || shape.keys == nil || shape.keys[column.name] {
+				w.columns = append(w.columns, column)
+				header = append(header, column.name)
+			}
+		}
+		_ = w.csv.Write(header) // buffered
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		w.json = json.NewEncoder(c.Writer)
+	}
+	c.Status(http.StatusOK)
+	return w
+}
+
+// write writes the records and flushes them to the client.
+func (w *recordWriter) write(records []reflect.Value) error {
+	for _, record := range records {
+		if w.csv != nil {
+			if err := w.csv.Write(w.row(record)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var line any = record.Interface()
+		if w.shape != nil {
+			shaped, err := w.shape.apply(line)
+			if err != nil {
+				return err
+			}
+			line = shaped
+		}
+		if err := w.json.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// row formats the record as a CSV line.
+func (w *recordWriter) row(record reflect.Value) []string {
+	row := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		if field, err := record.FieldByIndexErr(column.index); err == nil { // nil embedded pointers
+			row[i] = fieldString(field)
+		}
+	}
+	return row
+}
+
+// xmlValue converts the generic maps in v (e.g. the models trimmed by a
+// responseShape) to xmlMap, which can be marshaled to XML.
+// v itself is expected to be the gin.H response body.
+func xmlValue(v any) any {
+	switch v := v.(type) {
+	case gin.H:
+		for key, value := range v {
+			v[key] = xmlValue(value)
+		}
+		return v
+	case map[string]any:
+		m := xmlMap{}
+		for key, value := range v {
+			m[key] = xmlValue(value)
+		}
+		return m
+	case []any:
+		for i := range v {
+			v[i] = xmlValue(v[i])
+		}
+	}
+	return v
+}
+
+// xmlMap is marshaled to an XML element (named by its parent) of
+// elements of the entries, in order of the keys.
+// Unlike gin.H, which is always named "map".
+type xmlMap map[string]any
+
+// MarshalXML implements xml.Marshaler.
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := e.EncodeElement(m[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -14,10 +14,17 @@ import (
 //     order_by=id&desc=true&             # ordering
 //     filter_by=name&filter_value=John&  # filtering
 //     total=true&                        # return total count (all available records under the filter, ignoring pagination)
+//     q=buy+milk&                        # full-text search (only for GetListHandler, see orm.EnableSearch)
+//     fields=title,done&fields[todos]=title&  # sparse fieldsets: select and return only these fields
 //     preload=Product&preload=Product.Manufacturer  # preloading: loads nested models as well
 //
 // It is used in GetListHandler, GetByIDHandler and GetFieldHandler, to bind
 // the query parameters in the GET request url.
+//
+// The fields parameters are not bound into GetRequestOptions (for the
+// fields[association] form), they are parsed by the handlers. Only the
+// primary keys and the given fields of models are returned. Unknown fields
+// are responded 400 Bad Request.
 type GetRequestOptions struct {
 	Limit       int      `form:"limit"`
 	Offset      int      `form:"offset"`
@@ -27,6 +34,7 @@ type GetRequestOptions struct {
 	FilterValue string   `form:"filter_value"`
 	Preload     []string `form:"preload"` // fields to preload
 	Total       bool     `form:"total"`   // return total count ?
+	Q           string   `form:"q"`       // full-text search
 }
 
 // GetListHandler handles
@@ -34,10 +42,20 @@ type GetRequestOptions struct {
 // It returns a list of models.
 //
 // QueryOptions (See GetRequestOptions for more details):
-//    limit, offset, order_by, desc, filter_by, filter_value, preload, total.
+//    limit, offset, order_by, desc, filter_by, filter_value, preload, total, q,
+//    fields.
+//
+// With q, only the models matching the full-text search are returned,
+// most relevant first (unless order_by is given), with highlighted
+// snippets of the matches keyed by ids (HTML escaped, but for the <mark>
+// tags). Search must be enabled for T (see orm.EnableSearch).
+//
+// The response has a weak ETag header. Requests with a matched
+// If-None-Match header are responded 304 Not Modified.
 //
 // Response:
-//  - 200 OK: { Ts: [{...}, ...] }
+//  - 200 OK: { Ts: [{...}, ...], snippets: { id: "...<mark>word</mark>..." } }
+//  - 304 Not Modified
 //  - 400 Bad Request: { error: "request band failed" }
 //  - 422 Unprocessable Entity: { error: "get process failed" }
 func GetListHandler[T any]() gin.HandlerFunc {
@@ -51,9 +69,20 @@ func GetListHandler[T any]() gin.HandlerFunc {
 		}
 
 		options := buildQueryOptions(request)
+		if request.Q != "" {
+			options = append(options, service.Search[T](request.Q))
+		}
+		fieldOptions, shape, err := sparseFields(c, new(T), "", request.Preload)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetListHandler: bad fields")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		options = append(options, fieldOptions...)
 
 		var dest []*T
-		err := service.GetMany[T](c, &dest, options...)
+		err = service.GetMany[T](c, &dest, options...)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("GetListHandler: GetMany failed")
@@ -63,7 +92,7 @@ func GetListHandler[T any]() gin.HandlerFunc {
 
 		var addition []gin.H
 		if request.Total {
-			total, err := getCount[T](c, request.FilterBy, request.FilterValue)
+			total, err := getCount[T](c, request.FilterBy, request.FilterValue, request.Q)
 			if err != nil {
 				logger.WithContext(c).WithError(err).
 					Warn("GetListHandler: getCount failed")
@@ -72,17 +101,36 @@ func GetListHandler[T any]() gin.HandlerFunc {
 				addition = append(addition, gin.H{"total": total})
 			}
 		}
-		ResponseSuccess(c, dest, addition...)
+		if request.Q != "" {
+			ids := make([]any, 0, len(dest))
+			for _, model := range dest {
+				ids = append(ids, modelID(model))
+			}
+			snippets, err := service.SearchSnippets[T](c, request.Q, ids)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("GetListHandler: SearchSnippets failed")
+			}
+			addition = append(addition, gin.H{"snippets": snippets})
+		}
+		if notModified(c, listETag(dest, addition...), dest) {
+			return
+		}
+		responseSuccessShaped(c, shape, dest, addition...)
 	}
 }
 
 // GetByIDHandler handles
 //    GET /T/:idParam
 //
-// QueryOptions (See GetRequestOptions for more details): preload
+// QueryOptions (See GetRequestOptions for more details): preload, fields
+//
+// The response has an ETag header (see ETag). Requests with a matched
+// If-None-Match header are responded 304 Not Modified.
 //
 // Response:
 //  - 200 OK: { T: {...} }
+//  - 304 Not Modified
 //  - 400 Bad Request: { error: "request band failed" }
 //  - 422 Unprocessable Entity: { error: "get process failed" }
 func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
@@ -96,6 +144,14 @@ func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
 		}
 
 		options := buildQueryOptions(request)
+		fieldOptions, shape, err := sparseFields(c, new(T), "", request.Preload)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetByIDHandler: bad fields")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		options = append(options, fieldOptions...)
 
 		dest, err := getModelByID[T](c, idParam, options...)
 		if err != nil {
@@ -104,7 +160,10 @@ func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
-		ResponseSuccess(c, dest)
+		if notModified(c, ETag(dest), dest) {
+			return
+		}
+		responseSuccessShaped(c, shape, dest)
 	}
 }
 
@@ -112,7 +171,8 @@ func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
 //    GET /T/:idParam/field
 //
 // QueryOptions (See GetRequestOptions for more details):
-//    limit, offset, order_by, desc, filter_by, filter_value, preload, total.
+//    limit, offset, order_by, desc, filter_by, filter_value, preload, total,
+//    fields.
 // Notice, all GetRequestOptions will be conditions for the field, for example:
 //    GET /user/123/order?preload=Product
 // Preloads User.Order.Product instead of User.Product.
@@ -133,6 +193,14 @@ func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc
 			return
 		}
 		options := buildQueryOptions(request)
+		fieldOptions, shape, err := sparseFields(c, new(T), field, request.Preload)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetFieldHandler: bad fields")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		options = append(options, fieldOptions...)
 
 		model, err := getModelByID[T](c, idParam, service.Preload(field, options...))
 		if err != nil {
@@ -158,7 +226,7 @@ func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc
 			}
 		}
 
-		ResponseSuccess(c, fieldValue.Interface(), addition...)
+		responseSuccessShaped(c, shape, fieldValue.Interface(), addition...)
 	}
 }
 
@@ -195,13 +263,22 @@ func getModelByID[T orm.Model](c *gin.Context, idParam string, options ...servic
 	return &model, err
 }
 
-func getCount[T any](ctx context.Context, filterBy string, filterValue any) (total int64, err error) {
-	var option []service.QueryOption
+func getCount[T any](ctx context.Context, filterBy string, filterValue any, q string) (total int64, err error) {
+	total, err = service.Count[T](ctx, filterOptions[T](filterBy, filterValue, q)...)
+	return total, err
+}
+
+// filterOptions builds the options of the conditions of GetListHandler,
+// without pagination or ordering.
+func filterOptions[T any](filterBy string, filterValue any, q string) []service.QueryOption {
+	var options []service.QueryOption
 	if filterBy != "" && filterValue != "" {
-		option = append(option, service.FilterBy(filterBy, filterValue))
+		options = append(options, service.FilterBy(filterBy, filterValue))
 	}
-	total, err = service.Count[T](ctx, option...)
-	return total, err
+	if q != "" {
+		options = append(options, service.Search[T](q))
+	}
+	return options
 }
 
 func getAssociationCount(ctx context.Context, model any, field string, filterBy string, filterValue any) (total int64, err error) {
@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
+
+	"github.com/cdfmlr/crud/orm"
 )
 
 // nameToField converts name to the right field name in the structure.
@@ -38,3 +41,16 @@ func nameToField(name string, structure any) string {
 
 	return name
 }
+
+// modelID returns the identity value of an orm.Model (or a pointer to it)
+// as string, or "" if model is not an orm.Model.
+func modelID(model any) string {
+	if m, ok := model.(orm.Model); ok {
+		_, id := m.Identity()
+		return fmt.Sprint(id)
+	}
+	if v := reflect.ValueOf(model); v.Kind() == reflect.Ptr && !v.IsNil() {
+		return modelID(v.Elem().Interface())
+	}
+	return ""
+}
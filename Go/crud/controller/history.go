@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetVersionsHandler handles
+//    GET /T/:idParam/versions
+// It returns the previous versions of the model T, oldest first.
+//
+// QueryOptions: limit, offset.
+//
+// Response:
+//  - 200 OK: { Versions: [{version: 1, action: "update", data: {...}, ...}, ...] }
+//  - 400 Bad Request: { error: "missing id" }
+//  - 422 Unprocessable Entity: { error: "get process failed" }
+func GetVersionsHandler[T orm.Model](idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(idParam)
+		if id == "" {
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+
+		var request GetRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetVersionsHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		var options []service.QueryOption
+		if request.Limit > 0 {
+			options = append(options, service.WithPage(request.Limit, request.Offset))
+		}
+
+		versions, err := service.GetVersions[T](c, id, options...)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetVersionsHandler: GetVersions failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, versions)
+	}
+}
+
+// GetVersionHandler handles
+//    GET /T/:idParam/versions/:versionParam
+// It returns the n-th version of the model T.
+//
+// Response:
+//  - 200 OK: { Version: {version: n, action: "update", data: {...}, ...} }
+//  - 400 Bad Request: { error: "missing id or bad version" }
+//  - 404 Not Found: { error: "record not found" }
+//  - 422 Unprocessable Entity: { error: "get process failed" }
+func GetVersionHandler[T orm.Model](idParam string, versionParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, n, err := versionParams(c, idParam, versionParam)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		version, err := service.GetVersion[T](c, id, n)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetVersionHandler: GetVersion failed")
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ResponseError(c, CodeNotFound, err)
+			} else {
+				ResponseError(c, CodeProcessFailed, err)
+			}
+			return
+		}
+		ResponseSuccess(c, version)
+	}
+}
+
+// RestoreVersionHandler handles
+//    POST /T/:idParam/versions/:versionParam/restore
+// It restores the model T to its n-th version, responds with the restored
+// model. The replaced version is kept as a new version.
+//
+// Response:
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id or bad version" }
+//  - 422 Unprocessable Entity: { error: "restore process failed" }
+func RestoreVersionHandler[T orm.Model](idParam string, versionParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, n, err := versionParams(c, idParam, versionParam)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		var model T
+		if err := service.RestoreVersion[T](auditSource(c, nil), id, n, &model); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("RestoreVersionHandler: RestoreVersion failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, &model)
+	}
+}
+
+// versionParams reads the id and version number from url.
+func versionParams(c *gin.Context, idParam string, versionParam string) (id string, n int, err error) {
+	id = c.Param(idParam)
+	if id == "" {
+		return "", 0, ErrMissingID
+	}
+	n, err = strconv.Atoi(c.Param(versionParam))
+	if err != nil || n < 1 {
+		return "", 0, ErrBadVersion
+	}
+	return id, n, nil
+}
@@ -0,0 +1,318 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// ImportRequestOptions is the query options (?opt=val) for ImportHandler:
+//
+//     format=csv&                     # csv or ndjson, by default from the Content-Type or file name
+//     map[Task]=title&map[Notes]=-&   # header mapping: column "Task" to field title, ignore column "Notes"
+//     key=title&                      # upsert: update the existing model with the same title
+//     dry_run=true                    # validate and try, but do not commit
+type ImportRequestOptions struct {
+	Format string `form:"format"`
+	Key    string `form:"key"`
+	DryRun bool   `form:"dry_run"`
+}
+
+var (
+	ErrUnknownImportFormat = errors.New("unknown import format, use format=csv or format=ndjson")
+	ErrImportTooLarge      = errors.New("import file too large")
+	ErrImportTooManyRows   = errors.New("too many rows to import")
+	ErrImportLineTooLong   = errors.New("line too long")
+)
+
+// Limits of the files imported by ImportHandler.
+var (
+	ImportMaxBytes     int64 = 32 << 20 // of the request body
+	ImportMaxRows            = 10000    // rows of a file, not counting the CSV header
+	ImportMaxLineBytes       = 1 << 20  // of an NDJSON line
+)
+
+// ImportHandler handles
+//    POST /T/_import
+// It imports models from the uploaded CSV or NDJSON file (the request
+// body, or the "file" of a multipart form), in a transaction: either all
+// rows are imported, or none.
+//
+// CSV files must have a header line. Columns (and NDJSON keys) are fields
+// of T by field names, JSON keys or column names, unless mapped by the
+// map[column]=field options. Empty cells are not imported (to keep the
+// existing values on updates).
+//
+// Every row is decoded, validated (by the binding tags of T) and tried,
+// the errors are reported with their line numbers.
+//
+// Files are limited to ImportMaxBytes, ImportMaxRows rows, and NDJSON
+// lines to ImportMaxLineBytes. Larger ones are refused as a whole.
+//
+// QueryOptions (See ImportRequestOptions for more details):
+//    format, map, key, dry_run.
+//
+// Response:
+//  - 200 OK: { report: { dry_run: false, created: 10, updated: 2, errors: [] } }
+//  - 400 Bad Request: { error: "bad file, format or unknown columns" }
+//  - 413 Request Entity Too Large: { error: "import file too large, too many rows or line too long" }
+//  - 422 Unprocessable Entity: { error: "import failed, nothing imported",
+//                                report: { ..., errors: [{ line: 3, field: "done", error: "..." }, ...] } }
+func ImportHandler[T any]() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ImportRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, ImportMaxBytes)
+		file, format, err := importFile(c, request.Format)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: open file failed")
+			responseImportError(c, err)
+			return
+		}
+		defer file.Close()
+
+		var records []importRecord
+		if format == FormatCSV {
+			var header []string
+			header, records, err = readCSVRecords(file, c.QueryMap("map"))
+			if err == nil {
+				_, err = service.ResolveFields(new(T), "", header)
+			}
+		} else {
+			records, err = readNDJSONRecords(file, c.QueryMap("map"))
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: read file failed")
+			responseImportError(c, err)
+			return
+		}
+
+		var rows []service.ImportRow[T]
+		var rowErrors []service.ImportError
+		for _, record := range records {
+			row, err := decodeImportRecord[T](c, record)
+			if err != nil {
+				rowErrors = append(rowErrors, *err)
+				continue
+			}
+			rows = append(rows, row)
+		}
+
+		// try the valid rows anyway, for a complete report
+		dryRun := request.DryRun || len(rowErrors) > 0
+		report, _, err := service.Import[T](auditSource(c, nil), rows, request.Key, dryRun)
+		if errors.Is(err, service.ErrUnknownField) {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if report != nil {
+			report.DryRun = request.DryRun
+			report.Errors = mergeImportErrors(rowErrors, report.Errors)
+		}
+		if err == nil && len(rowErrors) > 0 {
+			err = service.ErrImportFailed
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: Import failed")
+			c.JSON(CodeProcessFailed, gin.H{"error": err.Error(), "report": report})
+			return
+		}
+
+		ResponseSuccess(c, nil, gin.H{"report": report})
+	}
+}
+
+// responseImportError responds the error of reading the imported file:
+// 413 if it's beyond the limits, 400 otherwise.
+func responseImportError(c *gin.Context, err error) {
+	// http.MaxBytesError is not available before go 1.19
+	if strings.Contains(err.Error(), "http: request body too large") {
+		err = fmt.Errorf("%w: more than %d bytes", ErrImportTooLarge, ImportMaxBytes)
+	}
+	if errors.Is(err, ErrImportTooLarge) || errors.Is(err, ErrImportTooManyRows) || errors.Is(err, ErrImportLineTooLong) {
+		ResponseError(c, CodeTooLarge, err)
+		return
+	}
+	ResponseError(c, CodeBadRequest, err)
+}
+
+// importFile opens the uploaded file, and determines its format by the
+// format option, the Content-Type, or the file name.
+func importFile(c *gin.Context, format string) (file io.ReadCloser, _ string, err error) {
+	contentType, filename := c.ContentType(), ""
+	file = c.Request.Body
+	if contentType == binding.MIMEMultipartPOSTForm {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		if file, err = header.Open(); err != nil {
+			return nil, "", err
+		}
+		contentType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+		filename = header.Filename
+	}
+
+	if format == "" {
+		switch {
+		case contentType == "text/csv" || strings.EqualFold(path.Ext(filename), ".csv"):
+			format = FormatCSV
+		case contentType == "application/x-ndjson" || contentType == "application/json" ||
+			strings.EqualFold(path.Ext(filename), ".ndjson") || strings.EqualFold(path.Ext(filename), ".jsonl"):
+			format = FormatNDJSON
+		}
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		file.Close()
+		return nil, "", ErrUnknownImportFormat
+	}
+	return file, format, nil
+}
+
+// importRecord is a row read from the imported file.
+type importRecord struct {
+	line   int
+	values map[string]any // mapped field name => value
+	err    error          // the line is not readable
+}
+
+// mapColumn maps a column of the file to a field name by mapping,
+// "" to ignore the column.
+func mapColumn(mapping map[string]string, column string) string {
+	if name, ok := mapping[column]; ok {
+		column = name
+	}
+	if column == "-" {
+		return ""
+	}
+	return strings.TrimSpace(column)
+}
+
+// readCSVRecords reads the CSV with a header line, returns the mapped
+// field names of the header, and the records. Empty cells are skipped.
+func readCSVRecords(r io.Reader, mapping map[string]string) (header []string, records []importRecord, err error) {
+	reader := csv.NewReader(r)
+
+	columns, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns[0] = strings.TrimPrefix(columns[0], "\uFEFF") // BOM of Excel
+	for i, column := range columns {
+		columns[i] = mapColumn(mapping, column)
+		if columns[i] != "" {
+			header = append(header, columns[i])
+		}
+	}
+
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if errors.Is(err, csv.ErrFieldCount) && len(records) < ImportMaxRows {
+			records = append(records, importRecord{line: line, err: err})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) >= ImportMaxRows {
+			return nil, nil, fmt.Errorf("%w: more than %d", ErrImportTooManyRows, ImportMaxRows)
+		}
+		record := importRecord{line: line, values: map[string]any{}}
+		for i, cell := range cells {
+			if columns[i] != "" && cell != "" {
+				record.values[columns[i]] = cell
+			}
+		}
+		records = append(records, record)
+	}
+	return header, records, nil
+}
+
+// readNDJSONRecords reads a JSON object per line. Blank lines are skipped.
+func readNDJSONRecords(r io.Reader, mapping map[string]string) (records []importRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, ImportMaxLineBytes)
+
+	line := 1
+	for ; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		if len(records) >= ImportMaxRows {
+			return nil, fmt.Errorf("%w: more than %d", ErrImportTooManyRows, ImportMaxRows)
+		}
+		var object map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			records = append(records, importRecord{line: line, err: err})
+			continue
+		}
+		record := importRecord{line: line, values: map[string]any{}}
+		for key, value := range object {
+			if name := mapColumn(mapping, key); name != "" {
+				record.values[name] = value
+			}
+		}
+		records = append(records, record)
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return nil, fmt.Errorf("%w: line %d is more than %d bytes", ErrImportLineTooLong, line, ImportMaxLineBytes)
+	}
+	return records, scanner.Err()
+}
+
+// decodeImportRecord decodes and validates the record into a new model.
+func decodeImportRecord[T any](c *gin.Context, record importRecord) (service.ImportRow[T], *service.ImportError) {
+	row := service.ImportRow[T]{Line: record.line, Model: new(T)}
+	if record.err != nil {
+		return row, &service.ImportError{Line: record.line, Error: record.err.Error()}
+	}
+
+	fields, err := service.DecodeFields(c, row.Model, record.values)
+	var fieldError *service.FieldError
+	if errors.As(err, &fieldError) {
+		return row, &service.ImportError{Line: record.line, Field: fieldError.Field, Error: fieldError.Err.Error()}
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(row.Model)
+	}
+	if err != nil {
+		return row, &service.ImportError{Line: record.line, Error: err.Error()}
+	}
+	row.Fields = fields
+	return row, nil
+}
+
+// mergeImportErrors merges the errors of decoding and importing, in the
+// order of lines.
+func mergeImportErrors(a, b []service.ImportError) []service.ImportError {
+	errs := append(append([]service.ImportError{}, a...), b...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	return errs
+}
@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadYourWritesHeader is the request header to read from the primary
+// database instead of the replicas (see orm.UseReplicas), e.g. for a GET
+// just after the client's own writes. Any non-empty value enables it.
+const ReadYourWritesHeader = "X-Read-Your-Writes"
+
+// ReadYourWrites is a middleware routing the reads of a request to the
+// primary database, for requests other than GET and HEAD (the models they
+// read before writing must be fresh) or with the ReadYourWritesHeader.
+// Reads of other requests go to the replicas, if any.
+func ReadYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		safe := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+		if !safe || c.GetHeader(ReadYourWritesHeader) != "" {
+			c.Set(orm.ReadPrimaryKey, true)
+		}
+		c.Next()
+	}
+}
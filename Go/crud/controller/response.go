@@ -72,9 +72,12 @@ func ResponseError(c *gin.Context, code int, err error) {
 	c.JSON(code, ErrorResponseBody(err))
 }
 
-// ResponseSuccess writes a success response to client in JSON.
+// ResponseSuccess writes a success response to client, in JSON by default,
+// or in the format negotiated by the format query parameter or the Accept
+// header: XML, MessagePack, and for models, CSV or NDJSON.
+// See FormatJSON, FormatXML, ...
 func ResponseSuccess(c *gin.Context, model any, addition ...gin.H) {
-	c.JSON(http.StatusOK, SuccessResponseBody(model, addition...))
+	writeSuccess(c, model, nil, addition...)
 }
 
 const (
@@ -82,6 +85,11 @@ const (
 	CodeNotFound      = http.StatusNotFound
 	CodeBadRequest    = http.StatusBadRequest
 	CodeProcessFailed = http.StatusUnprocessableEntity
+	CodeForbidden     = http.StatusForbidden
+	CodeConflict      = http.StatusConflict
+
+	CodePreconditionFailed = http.StatusPreconditionFailed
+	CodeTooLarge           = http.StatusRequestEntityTooLarge
 )
 
 var (
@@ -89,4 +97,7 @@ var (
 	ErrMissingID       = errors.New("missing id")
 	ErrMissingParentID = errors.New("missing parent id")
 	ErrUpdateID        = errors.New("id can not be updated")
+	ErrBadVersion      = errors.New("version must be a positive integer")
+
+	ErrHardDeleteForbidden = errors.New("hard delete is not allowed")
 )
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+)
+
+// HardDeleteKey is the gin context key to allow permanent deletes by
+//    DELETE /T/:idParam?hard=true
+// in DeleteHandler. It should be set to true by an authorization middleware,
+// e.g. the router.Trash option.
+const HardDeleteKey = "crud_hard_delete"
+
+// GetTrashHandler handles
+//    GET /T/_trash
+// It returns a list of soft deleted models.
+//
+// QueryOptions (See GetRequestOptions for more details):
+//    limit, offset, order_by, desc, filter_by, filter_value, preload, total.
+//
+// Response:
+//  - 200 OK: { Ts: [{...}, ...] }
+//  - 400 Bad Request: { error: "request band failed" }
+//  - 422 Unprocessable Entity: { error: "get process failed" }
+func GetTrashHandler[T any]() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request GetRequestOptions
+		if err := c.ShouldBind(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetTrashHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		var dest []*T
+		err := service.GetTrashed[T](c, &dest, buildQueryOptions(request)...)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetTrashHandler: GetTrashed failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+
+		var addition []gin.H
+		if request.Total {
+			var options []service.QueryOption
+			if request.FilterBy != "" && request.FilterValue != "" {
+				options = append(options, service.FilterBy(request.FilterBy, request.FilterValue))
+			}
+			total, err := service.CountTrashed[T](c, options...)
+			if err != nil {
+				addition = append(addition, gin.H{"totalError": err.Error()})
+			} else {
+				addition = append(addition, gin.H{"total": total})
+			}
+		}
+		ResponseSuccess(c, dest, addition...)
+	}
+}
+
+// RestoreHandler handles
+//    POST /T/:idParam/restore
+// Restores the soft deleted model T with the given id.
+//
+// Response:
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id" }
+//  - 422 Unprocessable Entity: { error: "restore process failed" }
+func RestoreHandler[T orm.Model](idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(idParam)
+		if id == "" {
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+
+		if _, err := service.RestoreByID[T](auditSource(c, nil), id); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("RestoreHandler: RestoreByID failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+
+		var model T
+		if err := service.GetByID[T](c, id, &model); err != nil {
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, &model)
+	}
+}
+
+// hardDelete handles DELETE /T/:idParam?hard=true for DeleteHandler:
+// deletes the model T permanently if allowed by HardDeleteKey.
+func hardDelete[T orm.Model](c *gin.Context, id string) {
+	if !c.GetBool(HardDeleteKey) {
+		logger.WithContext(c).
+			Warn("DeleteHandler: hard delete is not allowed")
+		ResponseError(c, CodeForbidden, ErrHardDeleteForbidden)
+		return
+	}
+
+	var model T
+	if err := service.GetByID[T](c, id, &model, service.Unscoped()); err != nil {
+		ResponseError(c, CodeNotFound, err)
+		return
+	}
+
+	if _, err := service.PurgeByID[T](auditSource(c, nil), id); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("DeleteHandler: PurgeByID failed")
+		ResponseError(c, CodeProcessFailed, err)
+		return
+	}
+	ResponseSuccess(c, nil, gin.H{"deleted": true, "hard": true})
+}
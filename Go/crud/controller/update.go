@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"github.com/cdfmlr/crud/log"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
@@ -8,16 +10,22 @@ import (
 )
 
 // UpdateHandler handles
-//    PUT /T/:idParam
-// Updates the model T with the given id.
+//    PATCH /T/:idParam
+// Updates the model T with the given id: fields given in the request body
+// are updated, others are kept.
+//
+// With an If-Match header, the update is done only if it matches the
+// ETag of the current version (i.e. no one else has updated it since the
+// client got it), see ETag.
 //
 // Request body:
 //  - {"field": "new_value", ...}   // fields to update
 //
 // Response:
-//  - 200 OK: { updated: true }
+//  - 200 OK: { T: {...} }
 //  - 400 Bad Request: { error: "missing id or bind fields failed" }
 //  - 404 Not Found: { error: "record with id not found" }
+//  - 412 Precondition Failed: { error: "precondition failed" }
 //  - 422 Unprocessable Entity: { error: "update process failed" }
 func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -38,6 +46,13 @@ func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			return
 		}
 
+		if !ifMatch(c, ETag(&model)) {
+			logger.WithContext(c).WithField("ETag", ETag(&model)).
+				Warn("UpdateHandler: If-Match mismatched")
+			ResponseError(c, CodePreconditionFailed, service.ErrPreconditionFailed)
+			return
+		}
+
 		var updatedModel = model
 		if err := c.ShouldBindJSON(&updatedModel); err != nil {
 			logger.WithContext(c).WithError(err).
@@ -50,7 +65,7 @@ func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 
 		_, oldID := model.Identity()
 		_, newID := updatedModel.Identity()
-		if oldID != newID {
+		if fmt.Sprint(oldID) != fmt.Sprint(newID) { // CompositeIDs are not comparable
 			logger.WithContext(c).WithField("idParam", idParam).
 				WithField("oldID", oldID).
 				WithField("newID", newID).
@@ -59,13 +74,99 @@ func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			return
 		}
 
-		_, err := service.Update(c, &updatedModel)
+		ctx := auditSource(c, &model)
+		var err error
+		if c.GetHeader("If-Match") != "" {
+			_, err = service.UpdateIf(ctx, &updatedModel, func(current *T) bool {
+				return ifMatch(c, ETag(current))
+			})
+		} else {
+			_, err = service.Update(ctx, &updatedModel)
+		}
+		if errors.Is(err, service.ErrPreconditionFailed) {
+			ResponseError(c, CodePreconditionFailed, err)
+			return
+		}
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("UpdateHandler: Update failed")
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
+		c.Header("ETag", ETag(&updatedModel))
 		ResponseSuccess(c, &updatedModel)
 	}
 }
+
+// ReplaceHandler handles
+//    PUT /T/:idParam
+// Creates or replaces the model T with the given id: the model in the
+// request body is saved as a whole (fields not given are zeroed), in one
+// upsert, see service.Replace.
+//
+// With an If-Match header, the model must exist, and it's replaced only
+// if it matches the ETag of the current version, see UpdateHandler.
+// A (soft) deleted model is not replaced, it should be restored first.
+//
+// Request body:
+//  - {...}   // fields of the model T, the id is optional
+//
+// Response:
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id, id mismatch or bind failed" }
+//  - 409 Conflict: { error: "conflicts with a deleted model, restore it first" }
+//  - 412 Precondition Failed: { error: "precondition failed" }
+//  - 422 Unprocessable Entity: { error: "replace process failed" }
+func ReplaceHandler[T orm.Model](idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(idParam) // NOTICE: id is a string
+		if id == "" {
+			logger.WithContext(c).WithField("idParam", idParam).
+				Warn("ReplaceHandler: Missing id")
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+
+		var model T
+		if err := c.ShouldBindJSON(&model); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ReplaceHandler: Bind failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if _, bodyID := model.Identity(); !orm.IsZeroID(bodyID) && fmt.Sprint(bodyID) != id {
+			logger.WithContext(c).WithField("id", id).
+				WithField("bodyID", bodyID).
+				Warn("ReplaceHandler: id mismatch: cannot update id")
+			ResponseError(c, CodeBadRequest, ErrUpdateID)
+			return
+		}
+
+		log.Logger.Tracef("ReplaceHandler: Replace %#v, id=%v", model, id)
+
+		var precondition func(current *T) bool
+		if c.GetHeader("If-Match") != "" {
+			precondition = func(current *T) bool {
+				return ifMatch(c, ETag(current))
+			}
+		}
+		_, err := service.Replace(auditSource(c, nil), id, &model, precondition)
+		if errors.Is(err, service.ErrPreconditionFailed) {
+			ResponseError(c, CodePreconditionFailed, err)
+			return
+		}
+		if errors.Is(err, service.ErrConflictDeleted) {
+			ResponseError(c, CodeConflict, err)
+			return
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ReplaceHandler: Replace failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+
+		c.Header("ETag", ETag(&model))
+		ResponseSuccess(c, &model)
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cdfmlr/crud/service"
+	"github.com/cdfmlr/crud/webhook"
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookCreateHandler handles
+//    POST /webhooks
+// creates a webhook subscription, responds with it and its generated
+// secret, which is never responded again.
+//
+// Request body:
+//  - {...}  // fields of the webhook.Subscription
+//
+// Response:
+//  - 200 OK: { Subscription: {...}, secret: "..." }
+//  - 400 Bad Request: { error: "request band failed" }
+//  - 422 Unprocessable Entity: { error: "create process failed" }
+func WebhookCreateHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscription webhook.Subscription
+		if err := c.ShouldBindJSON(&subscription); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("WebhookCreateHandler: Bind failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		err := service.Create(auditSource(c, nil), &subscription, service.IfNotExist())
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("WebhookCreateHandler: Create failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		c.Header("ETag", ETag(&subscription))
+		ResponseSuccess(c, &subscription, gin.H{"secret": subscription.Secret})
+	}
+}
+
+// WebhookDeliveriesHandler handles
+//    GET /webhooks/:idParam/deliveries
+// to read the delivery log of a webhook subscription, newest first.
+//
+// QueryOptions: status (pending, succeeded, failed), and limit, offset
+// for pagination.
+//
+// Response:
+//  - 200 OK: { deliveries: [{...}, ...], total: 123 }
+//  - 400 Bad Request: { error: "missing id or request band failed" }
+//  - 422 Unprocessable Entity: { error: "query process failed" }
+func WebhookDeliveriesHandler(idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param(idParam), 10, 0)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+
+		var request struct {
+			Status webhook.Status `form:"status"`
+			Limit  int            `form:"limit"`
+			Offset int            `form:"offset"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("WebhookDeliveriesHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		deliveries, total, err := webhook.Deliveries(c, uint(id), request.Status, request.Limit, request.Offset)
+		if err != nil {
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"deliveries": deliveries, "total": total})
+	}
+}
+
+// WebhookReplayHandler handles
+//    POST /webhooks/:idParam/deliveries/:deliveryParam/replay
+// to deliver the payload of a previous delivery again, as a new delivery.
+//
+// Response:
+//  - 200 OK: { Delivery: {...} }
+//  - 400 Bad Request: { error: "missing id" }
+//  - 404 Not Found: { error: "record not found" }
+func WebhookReplayHandler(idParam string, deliveryParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param(idParam), 10, 0)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+		deliveryID, err := strconv.ParseUint(c.Param(deliveryParam), 10, 0)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, ErrMissingID)
+			return
+		}
+
+		delivery, err := webhook.Replay(c, uint(id), uint(deliveryID))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("WebhookReplayHandler: Replay failed")
+			ResponseError(c, CodeNotFound, err)
+			return
+		}
+		ResponseSuccess(c, delivery)
+	}
+}
@@ -0,0 +1,264 @@
+package crudtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/middleware"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+	"github.com/cdfmlr/crud/router"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// App is a gin router on an isolated in-memory database, see New.
+type App struct {
+	tb testing.TB
+
+	// Router is the gin engine serving the requests.
+	Router *gin.Engine
+	// Routes is where to add the routes to test: the Router, or a group of
+	// it behind middleware.AuthMiddleware with WithAuth.
+	Routes gin.IRouter
+	// DB is the database of the App, which is also orm.DB while the test
+	// runs.
+	DB *gorm.DB
+}
+
+type appOptions struct {
+	driver     orm.DBDriver
+	dsn        string
+	models     []any
+	fixtures   []fixtures
+	auth       bool
+	events     bool
+	middleware []gin.HandlerFunc
+}
+
+type fixtures struct {
+	fsys fs.FS
+	dir  string
+}
+
+// Option configures the App built by New.
+type Option func(o *appOptions)
+
+// WithDB runs the App on the database of the driver and dsn, instead of an
+// in-memory sqlite one (or on the sqlite database of dsn). The database
+// must be dedicated to the tests: the tables of the models are dropped
+// before and after the test.
+func WithDB(driver orm.DBDriver, dsn string) Option {
+	return func(o *appOptions) {
+		o.driver = driver
+		o.dsn = dsn
+	}
+}
+
+// WithModels registers the models in the database of the App, see
+// orm.RegisterModel.
+func WithModels(models ...any) Option {
+	return func(o *appOptions) {
+		o.models = append(o.models, models...)
+	}
+}
+
+// WithFixtures loads the fixture files in dir of fsys into the database of
+// the App, after the models are registered. See orm.LoadFixtures.
+func WithFixtures(fsys fs.FS, dir string) Option {
+	return func(o *appOptions) {
+		o.fixtures = append(o.fixtures, fixtures{fsys, dir})
+	}
+}
+
+// FixtureID returns the primary key of the fixture record of T labeled
+// label, loaded WithFixtures without a key of its own, see orm.FixtureID.
+func FixtureID[T any](tb testing.TB, label string) any {
+	tb.Helper()
+
+	s, err := orm.ParseSchema(new(T))
+	if err != nil || s.PrioritizedPrimaryField == nil {
+		tb.Fatalf("crudtest.FixtureID: no primary key of %T: %v", *new(T), err)
+	}
+	return orm.FixtureID(s.PrioritizedPrimaryField, s.Table, label)
+}
+
+// WithAuth puts App.Routes behind middleware.AuthMiddleware, as the
+// protected routes of the app. userModel (e.g. &model.User{}, a struct with
+// an email field) is registered, users of it to authenticate as are
+// created by App.User.
+func WithAuth(userModel any) Option {
+	return func(o *appOptions) {
+		o.auth = true
+		o.models = append(o.models, userModel)
+	}
+}
+
+// WithEvents enables the audit log and the outbox, see audit.Enable and
+// outbox.Enable. They can not be disabled: their tables are created in the
+// databases of the later Apps, too.
+func WithEvents() Option {
+	return func(o *appOptions) {
+		o.events = true
+	}
+}
+
+// WithMiddleware adds middlewares to App.Routes (after AuthMiddleware).
+func WithMiddleware(middleware ...gin.HandlerFunc) Option {
+	return func(o *appOptions) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// apps numbers the databases of Apps.
+var apps uint64
+
+// New creates an App for the test tb: orm.DB is connected to a fresh
+// in-memory sqlite database (or the one WithDB), with the models and
+// fixtures of the options, until the end of the test, when the previous
+// orm.DB and registered models (see orm.RegisteredModels) are put back.
+//
+// Add the routes to test to App.Routes, e.g. by Crud. tb fails on errors.
+func New(tb testing.TB, options ...Option) *App {
+	tb.Helper()
+
+	var opts appOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	var enable []func() error
+	tables := opts.models
+	if opts.events || audit.Enabled() {
+		enable = append(enable, audit.Enable)
+		tables = append(tables, &audit.Entry{})
+	}
+	if opts.events || outbox.Enabled() {
+		enable = append(enable, outbox.Enable)
+		tables = append(tables, &outbox.Message{})
+	}
+
+	previous, registered := orm.DB, orm.RegisteredModels()
+	fresh := opts.driver == "" || (opts.driver == orm.DBDriverSqlite && opts.dsn == "")
+	if fresh {
+		opts.driver = orm.DBDriverSqlite
+		opts.dsn = fmt.Sprintf("file:crudtest%d_%s?mode=memory&cache=shared",
+			atomic.AddUint64(&apps, 1), url.PathEscape(tb.Name()))
+	}
+	db, err := orm.ConnectDB(opts.driver, opts.dsn)
+	if err != nil {
+		tb.Fatalf("crudtest.New: %v", err)
+	}
+	if !fresh {
+		if err := dropTables(db, tables); err != nil {
+			tb.Fatalf("crudtest.New: %v", err)
+		}
+	}
+	tb.Cleanup(func() {
+		if !fresh {
+			if err := dropTables(db, tables); err != nil {
+				tb.Errorf("crudtest: %v", err)
+			}
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		orm.DB = previous
+		orm.SetRegisteredModels(registered)
+	})
+
+	// migrated even if orm.AutoMigrate is off
+	if err := db.AutoMigrate(tables...); err != nil {
+		tb.Fatalf("crudtest.New: %v", err)
+	}
+	if err := orm.RegisterModel(opts.models...); err != nil {
+		tb.Fatalf("crudtest.New: %v", err)
+	}
+	for _, enable := range enable {
+		if err := enable(); err != nil {
+			tb.Fatalf("crudtest.New: %v", err)
+		}
+	}
+	for _, f := range opts.fixtures {
+		if err := orm.LoadFixtures(context.Background(), f.fsys, f.dir); err != nil {
+			tb.Fatalf("crudtest.New: %v", err)
+		}
+	}
+
+	app := &App{tb: tb, DB: db}
+	app.Router = router.NewRouter()
+	app.Routes = app.Router
+	if opts.auth {
+		app.Routes = app.Router.Group("/", middleware.AuthMiddleware())
+	}
+	app.Routes.Use(opts.middleware...)
+	return app
+}
+
+// dropTables drops the tables of the models, and the join tables of their
+// many2many relationships.
+func dropTables(db *gorm.DB, models []any) error {
+	var tables []any
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		for _, rel := range stmt.Schema.Relationships.Many2Many {
+			tables = append(tables, rel.JoinTable.Table)
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	return db.Migrator().DropTable(tables...)
+}
+
+// Do makes a request to the App, with body (nil, a string, []byte, or
+// anything else sent as JSON) and the headers.
+func (app *App) Do(method, path string, body any, header http.Header) *Response {
+	app.tb.Helper()
+
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	case []byte:
+		reader = bytes.NewBuffer(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			app.tb.Fatalf("crudtest: %s %s: %v", method, path, err)
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, req)
+	return &Response{
+		tb:      app.tb,
+		request: method + " " + path,
+		Code:    w.Code,
+		Header:  w.Header(),
+		Body:    w.Body.Bytes(),
+	}
+}
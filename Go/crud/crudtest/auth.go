@@ -0,0 +1,66 @@
+package crudtest
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// User creates the user (a pointer to the user model given WithAuth, e.g.
+// &model.User{Email: "bob@example.com", Role: model.Employee}) in the
+// database of the App, to authenticate as by Client.As or App.Header, and
+// returns it.
+func (app *App) User(user any) any {
+	app.tb.Helper()
+
+	email := app.email(user)
+	if err := app.DB.WithContext(context.Background()).Create(user).Error; err != nil {
+		app.tb.Fatalf("crudtest: create user %s: %v", email, err)
+	}
+	return user
+}
+
+// Token signs an access token of the user, as the login does, accepted by
+// middleware.AuthMiddleware.
+func (app *App) Token(user any) string {
+	app.tb.Helper()
+
+	email := app.email(user)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": email,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		app.tb.Fatalf("crudtest: sign token of %s: %v", email, err)
+	}
+	return signed
+}
+
+// Header returns the headers to authenticate requests as the user, for
+// App.Do.
+func (app *App) Header(user any) http.Header {
+	return http.Header{"Authorization": {"Bearer " + app.Token(user)}}
+}
+
+// email returns the email of the user, which the tokens are signed for.
+func (app *App) email(user any) string {
+	app.tb.Helper()
+
+	s, err := orm.ParseSchema(user)
+	if err != nil {
+		app.tb.Fatalf("crudtest: user %T: %v", user, err)
+	}
+	field := s.LookUpField("email")
+	if field == nil {
+		app.tb.Fatalf("crudtest: user %T has no email field", user)
+	}
+	value, _ := field.ValueOf(context.Background(), reflect.ValueOf(user))
+	email, _ := value.(string)
+	return email
+}
@@ -0,0 +1,163 @@
+package crudtest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+)
+
+// Client makes requests to the CRUD routes of models T on path, see
+// router.Crud. Models in the responses are decoded into T.
+type Client[T any] struct {
+	app    *App
+	path   string
+	header http.Header
+}
+
+// NewClient returns a Client of the CRUD routes of T on path of the App.
+func NewClient[T any](app *App, path string) *Client[T] {
+	return &Client[T]{app: app, path: path}
+}
+
+// Crud adds the CRUD routes of T on path to App.Routes (see router.Crud),
+// and returns a Client of them.
+func Crud[T orm.Model](app *App, path string, options ...router.CrudOption) *Client[T] {
+	router.Crud[T](app.Routes, path, options...)
+	return NewClient[T](app, path)
+}
+
+// As returns a copy of the Client making requests as the user, see App.User.
+func (c *Client[T]) As(user any) *Client[T] {
+	return c.WithHeader(c.app.Header(user))
+}
+
+// WithHeader returns a copy of the Client sending the headers (e.g.
+// If-Match) along with the ones of c.
+func (c *Client[T]) WithHeader(header http.Header) *Client[T] {
+	copied := *c
+	copied.header = c.header.Clone()
+	if copied.header == nil {
+		copied.header = http.Header{}
+	}
+	for key, values := range header {
+		copied.header[key] = values
+	}
+	return &copied
+}
+
+// Do makes a request to path relative to the one of the Client, with its
+// headers, see App.Do.
+func (c *Client[T]) Do(method, path string, body any) *Response {
+	c.app.tb.Helper()
+	return c.app.Do(method, c.path+path, body, c.header)
+}
+
+// List gets models by GET path?query, where query is e.g.
+// "limit=10&order_by=title&total=true".
+func (c *Client[T]) List(query string) ([]T, *Response) {
+	c.app.tb.Helper()
+	res := c.Do(http.MethodGet, withQuery("", query), nil)
+	return decodeModels[T](res), res
+}
+
+// Get gets a model by GET path/id?query.
+func (c *Client[T]) Get(id any, query string) (T, *Response) {
+	c.app.tb.Helper()
+	res := c.Do(http.MethodGet, withQuery(fmt.Sprintf("/%v", id), query), nil)
+	return decodeModel[T](res), res
+}
+
+// Create creates a model by POST path.
+func (c *Client[T]) Create(model *T) (T, *Response) {
+	c.app.tb.Helper()
+	res := c.Do(http.MethodPost, "", model)
+	return decodeModel[T](res), res
+}
+
+// Update updates the fields (a map, or a struct) of a model by
+// PATCH path/id.
+func (c *Client[T]) Update(id any, fields any) (T, *Response) {
+	c.app.tb.Helper()
+	res := c.Do(http.MethodPatch, fmt.Sprintf("/%v", id), fields)
+	return decodeModel[T](res), res
+}
+
+// Replace creates or replaces a model by PUT path/id.
+func (c *Client[T]) Replace(id any, model *T) (T, *Response) {
+	c.app.tb.Helper()
+	res := c.Do(http.MethodPut, fmt.Sprintf("/%v", id), model)
+	return decodeModel[T](res), res
+}
+
+// Delete deletes a model by DELETE path/id.
+func (c *Client[T]) Delete(id any) *Response {
+	c.app.tb.Helper()
+	return c.Do(http.MethodDelete, fmt.Sprintf("/%v", id), nil)
+}
+
+// NestedClient makes requests to the nested routes of the field of a parent
+// model P, whose children are models N, see router.CrudNested.
+type NestedClient[P, N any] struct {
+	parent *Client[P]
+	field  string
+}
+
+// Nested returns a NestedClient of the field of the models of the parent
+// Client, e.g. Nested[Project, Todo](projects, "todos").
+func Nested[P, N any](parent *Client[P], field string) *NestedClient[P, N] {
+	return &NestedClient[P, N]{parent: parent, field: field}
+}
+
+func (c *NestedClient[P, N]) path(parentID any) string {
+	return fmt.Sprintf("/%v/%s", parentID, c.field)
+}
+
+// List gets the children of the parent by GET path/parentID/field?query.
+func (c *NestedClient[P, N]) List(parentID any, query string) ([]N, *Response) {
+	c.parent.app.tb.Helper()
+	res := c.parent.Do(http.MethodGet, withQuery(c.path(parentID), query), nil)
+	return decodeModels[N](res), res
+}
+
+// Create creates (or associates, with the primary key of an existing one)
+// a child of the parent by POST path/parentID/field, and returns the
+// parent, as the route does.
+func (c *NestedClient[P, N]) Create(parentID any, child *N) (P, *Response) {
+	c.parent.app.tb.Helper()
+	res := c.parent.Do(http.MethodPost, c.path(parentID), child)
+	return decodeModel[P](res), res
+}
+
+// Delete removes a child from the parent by
+// DELETE path/parentID/field/childID.
+func (c *NestedClient[P, N]) Delete(parentID, childID any) *Response {
+	c.parent.app.tb.Helper()
+	return c.parent.Do(http.MethodDelete, fmt.Sprintf("%s/%v", c.path(parentID), childID), nil)
+}
+
+func withQuery(path, query string) string {
+	if query == "" {
+		return path
+	}
+	return path + "?" + query
+}
+
+// decodeModel decodes the model T of a successful response, or returns
+// the zero T.
+func decodeModel[T any](res *Response) (model T) {
+	if res.Code == http.StatusOK {
+		res.decode(&model)
+	}
+	return model
+}
+
+// decodeModels decodes the models T of a successful response, or returns
+// nil.
+func decodeModels[T any](res *Response) (models []T) {
+	if res.Code == http.StatusOK {
+		res.decode(&models)
+	}
+	return models
+}
@@ -0,0 +1,634 @@
+package crudtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Environment variables of the DSNs of the databases to run the
+// Conformance suites on, besides sqlite. See Databases.
+const (
+	PostgresDSNEnv = "CRUDTEST_POSTGRES_DSN"
+	MySQLDSNEnv    = "CRUDTEST_MYSQL_DSN"
+)
+
+// Database is a database to run tests on, see WithDB.
+type Database struct {
+	Driver orm.DBDriver
+	DSN    string
+}
+
+// Databases returns the databases to run the Conformance suites on: an
+// in-memory sqlite one, and the Postgres and MySQL ones of the DSNs in the
+// CRUDTEST_POSTGRES_DSN and CRUDTEST_MYSQL_DSN environment variables, if
+// given. They must be dedicated to the tests, see WithDB.
+func Databases() []Database {
+	databases := []Database{{Driver: orm.DBDriverSqlite}}
+	if dsn := os.Getenv(PostgresDSNEnv); dsn != "" {
+		databases = append(databases, Database{Driver: orm.DBDriverPostgres, DSN: dsn})
+	}
+	if dsn := os.Getenv(MySQLDSNEnv); dsn != "" {
+		databases = append(databases, Database{Driver: orm.DBDriverMySQL, DSN: dsn})
+	}
+	return databases
+}
+
+// conformanceSamples is the number of samples created by a Conformance
+// suite.
+const conformanceSamples = 5
+
+// Conformance is the conformance suite of the CRUD routes of models T (see
+// router.Crud): Run creates samples of T by the routes, and checks that the
+// generated endpoints behave as documented by the controllers:
+//
+//  - POST, GET, PATCH, PUT and DELETE of a model, and their responses;
+//  - lists with total, pagination, ordering and filtering;
+//  - nested routes with preloads, see NestedConformance;
+//  - error cases: missing models, ids that can't be updated, malformed
+//    requests.
+//
+// Example:
+//
+//    func TestTodoConformance(t *testing.T) {
+//        crudtest.Conformance[Todo]{}.Run(t)
+//    }
+//
+// Fields of the samples are compared as JSON after the round trip through
+// the database. Times should be in UTC and rounded to seconds, for the
+// precision of some databases.
+type Conformance[T orm.Model] struct {
+	// Sample returns the i-th sample of T (i >= 0) to create: with no
+	// primary key unless clients give it, and with field values distinct
+	// from the ones of other samples. Sample[T] by default.
+	Sample func(i int) T
+	// Models are the other models to register, e.g. of belongs to
+	// associations of T.
+	Models []any
+	// Options are the CrudOptions of the routes, besides the nested ones.
+	Options []router.CrudOption
+	// Nested are the nested routes to check, see NestedConformance.
+	Nested []ConformanceNested[T]
+	// OrderBy is the column to order lists by, the (first) primary key
+	// by default.
+	OrderBy string
+	// FilterBy is the column to filter lists by, the first string column
+	// by default (or the primary key, if there is none).
+	FilterBy string
+}
+
+// Run runs the suite on each of the Databases, as subtests.
+func (c Conformance[T]) Run(t *testing.T) {
+	for _, db := range Databases() {
+		db := db
+		t.Run(string(db.Driver), func(t *testing.T) {
+			c.run(t, db)
+		})
+	}
+}
+
+// conformanceRun is the state of a Conformance suite running on a database.
+type conformanceRun[T orm.Model] struct {
+	Conformance[T]
+	schema  *schema.Schema
+	fields  map[string]*schema.Field // compared fields, by JSON keys
+	client  *Client[T]
+	samples int      // taken
+	created []T      // and not deleted
+	deleted []string // ids
+}
+
+func (c Conformance[T]) run(t *testing.T, db Database) {
+	if c.Sample == nil {
+		c.Sample = Sample[T]
+	}
+	s, err := orm.ParseSchema(new(T))
+	if err != nil {
+		t.Fatalf("crudtest.Conformance: %v", err)
+	}
+	if c.OrderBy == "" {
+		c.OrderBy = s.PrimaryFieldDBNames[0]
+	}
+	if c.FilterBy == "" {
+		c.FilterBy = s.PrimaryFieldDBNames[0]
+		for _, field := range s.Fields {
+			if sampled(s, field) && indirectType(field.FieldType).Kind() == reflect.String {
+				c.FilterBy = field.DBName
+				break
+			}
+		}
+	}
+
+	models := append([]any{new(T)}, c.Models...)
+	options := append([]router.CrudOption(nil), c.Options...)
+	for _, nested := range c.Nested {
+		models = append(models, nested.model)
+		options = append(options, nested.route)
+	}
+	app := New(t, WithDB(db.Driver, db.DSN), WithModels(models...))
+
+	r := &conformanceRun[T]{
+		Conformance: c,
+		schema:      s,
+		fields:      jsonFields(s),
+		client:      Crud[T](app, "/"+s.Table, options...),
+	}
+	steps := []struct {
+		name string
+		step func(t *testing.T)
+	}{
+		{"Create", r.create},
+		{"Get", r.get},
+		{"List", r.list},
+		{"Filter", r.filter},
+		{"Update", r.update},
+		{"Replace", r.replace},
+		{"Nested", r.nested},
+		{"Delete", r.delete},
+		{"Errors", r.errors},
+	}
+	for _, step := range steps {
+		if !t.Run(step.name, step.step) {
+			return // later steps depend on the models of the failed one
+		}
+	}
+}
+
+func (r *conformanceRun[T]) sample() T {
+	r.samples++
+	return r.Sample(r.samples - 1)
+}
+
+func (r *conformanceRun[T]) create(t *testing.T) {
+	for i := 0; i < conformanceSamples; i++ {
+		want := r.sample()
+		got, res := r.client.Create(&want)
+		if res.AssertStatus(http.StatusOK); t.Failed() {
+			t.FailNow()
+		}
+		if id := identity(got); id == "" {
+			t.Fatalf("Create() = %+v, without a primary key", got)
+		}
+		r.assertFields(t, "Create", want, got)
+		r.created = append(r.created, got)
+	}
+}
+
+func (r *conformanceRun[T]) get(t *testing.T) {
+	for _, want := range r.created {
+		got, res := r.client.Get(identity(want), "")
+		res.AssertStatus(http.StatusOK)
+		if res.Header.Get("ETag") == "" {
+			t.Errorf("Get(%s): no ETag", identity(want))
+		}
+		if identity(got) != identity(want) {
+			t.Errorf("Get(%s) = %+v", identity(want), got)
+		}
+		r.assertFields(t, "Get", want, got)
+	}
+}
+
+func (r *conformanceRun[T]) list(t *testing.T) {
+	n := len(r.created)
+	all, res := r.client.List("total=true")
+	res.AssertStatus(http.StatusOK).AssertTotal(int64(n))
+	if len(all) != n {
+		t.Errorf("List() = %d models, want %d", len(all), n)
+	}
+
+	orderBy := "order_by=" + url.QueryEscape(r.OrderBy)
+	asc, res := r.client.List(orderBy)
+	res.AssertStatus(http.StatusOK)
+	desc, res := r.client.List(orderBy + "&desc=true")
+	res.AssertStatus(http.StatusOK)
+	if got, want := identities(desc), reversed(identities(asc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("List(%s desc) = %v, want %v", r.OrderBy, got, want)
+	}
+
+	page, res := r.client.List(orderBy + "&limit=2&offset=1&total=true")
+	res.AssertStatus(http.StatusOK).AssertTotal(int64(n))
+	if got, want := identities(page), identities(asc)[1:3]; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(limit=2, offset=1) = %v, want %v", got, want)
+	}
+	beyond, res := r.client.List(orderBy + fmt.Sprintf("&limit=2&offset=%d", n))
+	res.AssertStatus(http.StatusOK)
+	if len(beyond) != 0 {
+		t.Errorf("List(offset=%d) = %v, want none", n, identities(beyond))
+	}
+}
+
+func (r *conformanceRun[T]) filter(t *testing.T) {
+	field := r.schema.LookUpField(r.FilterBy)
+	if field == nil {
+		t.Fatalf("no column %s to filter by", r.FilterBy)
+	}
+	value := fieldString(field, r.created[len(r.created)/2])
+	var want []string
+	for _, model := range r.created {
+		if fieldString(field, model) == value {
+			want = append(want, identity(model))
+		}
+	}
+
+	query := fmt.Sprintf("filter_by=%s&filter_value=%s&total=true",
+		url.QueryEscape(r.FilterBy), url.QueryEscape(value))
+	got, res := r.client.List(query)
+	res.AssertStatus(http.StatusOK).AssertTotal(int64(len(want)))
+	for _, model := range got {
+		if v := fieldString(field, model); v != value {
+			t.Errorf("List(%s) = %s with %s=%q", query, identity(model), r.FilterBy, v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("List(%s) = %v, want %v", query, identities(got), want)
+	}
+}
+
+func (r *conformanceRun[T]) update(t *testing.T) {
+	target := r.created[0]
+	want := r.sample()
+	got, res := r.client.Update(identity(target), r.jsonOf(want))
+	res.AssertStatus(http.StatusOK)
+	r.assertFields(t, "Update", want, got)
+
+	current, res := r.client.Get(identity(target), "")
+	res.AssertStatus(http.StatusOK)
+	r.assertFields(t, "Get after Update", want, current)
+	r.created[0] = current
+}
+
+func (r *conformanceRun[T]) replace(t *testing.T) {
+	target := r.created[0]
+	want := r.sample()
+	if _, res := r.client.Replace(identity(target), &want); res.Code != http.StatusOK {
+		res.AssertStatus(http.StatusOK)
+		return
+	}
+
+	current, res := r.client.Get(identity(target), "")
+	res.AssertStatus(http.StatusOK)
+	r.assertFields(t, "Get after Replace", want, current)
+	r.created[0] = current
+}
+
+func (r *conformanceRun[T]) nested(t *testing.T) {
+	for _, nested := range r.Nested {
+		t.Run(nested.field, func(t *testing.T) {
+			nested.run(t, r.client, identity(r.created[0]))
+		})
+	}
+}
+
+func (r *conformanceRun[T]) delete(t *testing.T) {
+	target := r.created[len(r.created)-1]
+	r.client.Delete(identity(target)).AssertStatus(http.StatusOK).AssertField("deleted", true)
+	r.created = r.created[:len(r.created)-1]
+	r.deleted = append(r.deleted, identity(target))
+
+	_, res := r.client.Get(identity(target), "")
+	res.AssertError(controller.CodeProcessFailed, "")
+	_, res = r.client.List("total=true")
+	res.AssertStatus(http.StatusOK).AssertTotal(int64(len(r.created)))
+}
+
+func (r *conformanceRun[T]) errors(t *testing.T) {
+	missing := r.deleted[0]
+	_, res := r.client.Get(missing, "")
+	res.AssertError(controller.CodeProcessFailed, "")
+	_, res = r.client.Update(missing, r.jsonOf(r.sample()))
+	res.AssertError(controller.CodeNotFound, "")
+
+	// ids can't be updated
+	ids := map[string]any{}
+	other := modelJSON(r.created[1])
+	for key, field := range jsonKeys(r.schema) {
+		if field.PrimaryKey {
+			ids[key] = other[key]
+		}
+	}
+	_, res = r.client.Update(identity(r.created[0]), ids)
+	res.AssertError(controller.CodeBadRequest, controller.ErrUpdateID.Error())
+
+	// malformed requests
+	r.client.Do(http.MethodPost, "", "{").AssertError(controller.CodeBadRequest, "")
+	_, res = r.client.List("limit=many")
+	res.AssertError(controller.CodeBadRequest, "")
+	_, res = r.client.List("fields=no_such_field")
+	res.AssertError(controller.CodeBadRequest, "")
+}
+
+// assertFields compares the compared fields of got and want, as JSON.
+func (r *conformanceRun[T]) assertFields(t *testing.T, op string, want, got T) {
+	t.Helper()
+
+	wantJSON, gotJSON := r.jsonOf(want), r.jsonOf(got)
+	for key := range r.fields {
+		if !sameJSON(wantJSON[key], gotJSON[key]) {
+			t.Errorf("%s(%s): %s = %v, want %v", op, identity(got), key, gotJSON[key], wantJSON[key])
+		}
+	}
+}
+
+// jsonOf returns the compared fields of the model, as JSON values.
+func (r *conformanceRun[T]) jsonOf(model T) map[string]any {
+	values := modelJSON(model)
+	for key := range values {
+		if _, ok := r.fields[key]; !ok {
+			delete(values, key)
+		}
+	}
+	return values
+}
+
+// modelJSON returns the model as JSON values.
+func modelJSON(model any) map[string]any {
+	data, _ := json.Marshal(model)
+	var values map[string]any
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// ConformanceNested is the conformance suite of the nested routes of a
+// field of models P, see NestedConformance.
+type ConformanceNested[P orm.Model] struct {
+	field string
+	model any
+	route router.CrudOption
+	run   func(t *testing.T, parents *Client[P], parentID string)
+}
+
+// NestedConformance returns the conformance suite of the nested routes of
+// the field (a has many or many2many association) of models P, whose
+// children are models N, see router.CrudNested. The children are samples
+// of N by sample (Sample[N] by default), nested into the first sample of P.
+//
+// It checks creating children, listing them with total and pagination,
+// preloading them with their parent, and removing them.
+func NestedConformance[P orm.Model, N orm.Model](field string, sample func(i int) N) ConformanceNested[P] {
+	if sample == nil {
+		sample = Sample[N]
+	}
+	return ConformanceNested[P]{
+		field: field,
+		model: new(N),
+		route: router.CrudNested[P, N](field),
+		run: func(t *testing.T, parents *Client[P], parentID string) {
+			s, err := orm.ParseSchema(new(P))
+			if err != nil {
+				t.Fatalf("crudtest.NestedConformance: %v", err)
+			}
+			rel := orm.LookUpRelationship(s, field)
+			if rel == nil {
+				t.Fatalf("crudtest.NestedConformance: no association %s of %s", field, s.Name)
+			}
+			children := Nested[P, N](parents, field)
+
+			const n = 3
+			for i := 0; i < n; i++ {
+				child := sample(i)
+				if _, res := children.Create(parentID, &child); res.Code != http.StatusOK {
+					res.AssertStatus(http.StatusOK)
+					t.FailNow()
+				}
+			}
+
+			list, res := children.List(parentID, "total=true")
+			res.AssertStatus(http.StatusOK).AssertTotal(n)
+			if len(list) != n {
+				t.Fatalf("List(%s) = %d children, want %d", field, len(list), n)
+			}
+			childKey := rel.FieldSchema.PrimaryFieldDBNames[0]
+			page, res := children.List(parentID, "order_by="+childKey+"&limit=2&total=true")
+			res.AssertStatus(http.StatusOK).AssertTotal(n)
+			if len(page) != 2 {
+				t.Errorf("List(%s, limit=2) = %d children, want 2", field, len(page))
+			}
+
+			// preloads
+			parent, res := parents.Get(parentID, "")
+			res.AssertStatus(http.StatusOK)
+			if got := relatedCount(rel, &parent); got != 0 {
+				t.Errorf("Get() = %d %s without preload, want 0", got, rel.Name)
+			}
+			parent, res = parents.Get(parentID, "preload="+rel.Name)
+			res.AssertStatus(http.StatusOK)
+			if got := relatedCount(rel, &parent); got != n {
+				t.Errorf("Get(preload=%s) = %d %s, want %d", rel.Name, got, rel.Name, n)
+			}
+			all, res := parents.List("preload=" + rel.Name)
+			res.AssertStatus(http.StatusOK)
+			for i := range all {
+				if identity(all[i]) == parentID && relatedCount(rel, &all[i]) != n {
+					t.Errorf("List(preload=%s) = %d %s, want %d", rel.Name, relatedCount(rel, &all[i]), rel.Name, n)
+				}
+			}
+
+			// removes
+			children.Delete(parentID, identity(list[0])).
+				AssertStatus(http.StatusOK).AssertField("deleted", true)
+			_, res = children.List(parentID, "total=true")
+			res.AssertStatus(http.StatusOK).AssertTotal(n - 1)
+		},
+	}
+}
+
+// relatedCount counts the models of the relationship of model.
+func relatedCount(rel *schema.Relationship, model any) int {
+	v := reflect.Indirect(rel.Field.ReflectValueOf(context.Background(), reflect.ValueOf(model).Elem()))
+	switch v.Kind() {
+	case reflect.Slice:
+		return v.Len()
+	case reflect.Struct:
+		if v.IsZero() {
+			return 0
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// identity returns the primary key of the model, as in the routes.
+func identity[T orm.Model](model T) string {
+	_, id := model.Identity()
+	if id == nil || reflect.ValueOf(id).IsZero() {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+func identities[T orm.Model](models []T) []string {
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, identity(model))
+	}
+	return ids
+}
+
+func reversed(s []string) []string {
+	r := make([]string, len(s))
+	for i := range s {
+		r[len(s)-1-i] = s[i]
+	}
+	return r
+}
+
+// fieldString returns the value of the field of model, as a filter_value.
+func fieldString(field *schema.Field, model any) string {
+	v, _ := field.ValueOf(context.Background(), reflect.Indirect(reflect.ValueOf(model)))
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		v = rv.Elem().Interface()
+	}
+	return fmt.Sprint(v)
+}
+
+// sameJSON reports whether the JSON values are equal, with times compared
+// as instants.
+func sameJSON(a, b any) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	as, ok1 := a.(string)
+	bs, ok2 := b.(string)
+	if !ok1 || !ok2 {
+		return false
+	}
+	at, err1 := time.Parse(time.RFC3339Nano, as)
+	bt, err2 := time.Parse(time.RFC3339Nano, bs)
+	return err1 == nil && err2 == nil && at.Equal(bt)
+}
+
+// jsonFields returns the fields of s compared by Conformance: the sampled
+// fields and foreign keys, by JSON keys.
+func jsonFields(s *schema.Schema) map[string]*schema.Field {
+	fields := map[string]*schema.Field{}
+	for key, field := range jsonKeys(s) {
+		if !field.PrimaryKey && !managed(field) {
+			fields[key] = field
+		}
+	}
+	return fields
+}
+
+// jsonKeys returns the fields of s in the JSON of its models, by JSON keys.
+func jsonKeys(s *schema.Schema) map[string]*schema.Field {
+	keys := map[string]*schema.Field{}
+	for _, field := range s.Fields {
+		if field.DBName == "" || !field.Readable {
+			continue
+		}
+		if key := orm.JSONKey(field); key != "" {
+			keys[key] = field
+		}
+	}
+	return keys
+}
+
+// managed reports whether the field is managed by GORM: timestamps and
+// soft deletes.
+func managed(field *schema.Field) bool {
+	return field.AutoCreateTime != 0 || field.AutoUpdateTime != 0 ||
+		indirectType(field.FieldType) == reflect.TypeOf(gorm.DeletedAt{})
+}
+
+// sampled reports whether Sample sets the field of s.
+func sampled(s *schema.Schema, field *schema.Field) bool {
+	if field.DBName == "" || field.PrimaryKey || !field.Creatable || managed(field) {
+		return false
+	}
+	for _, rel := range s.Relationships.Relations {
+		for _, ref := range rel.References {
+			if ref.ForeignKey == field && rel.Type == schema.BelongsTo {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// sampleTime is the time of the first sample.
+var sampleTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Sample is the default sample generator of Conformance: it returns the
+// i-th sample of T, whose fields of strings, numbers, bools and times (or
+// pointers to them) are set to values derived from i, e.g. "Title 3" for
+// the field Title, except the primary keys, the foreign keys of belongs to
+// associations and the fields managed by GORM (timestamps and soft
+// deletes).
+func Sample[T any](i int) T {
+	var model T
+	s, err := orm.ParseSchema(&model)
+	if err != nil {
+		return model
+	}
+	v := reflect.ValueOf(&model).Elem()
+	for _, field := range s.Fields {
+		if !sampled(s, field) {
+			continue
+		}
+		if value, ok := sampleValue(field, field.FieldType, i); ok {
+			field.ReflectValueOf(context.Background(), v).Set(value)
+		}
+	}
+	return model
+}
+
+// sampleValue returns the value of type t of the field for the i-th
+// sample, if it's of a sampled type.
+func sampleValue(field *schema.Field, t reflect.Type, i int) (reflect.Value, bool) {
+	var value reflect.Value
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, ok := sampleValue(field, t.Elem(), i)
+		if !ok {
+			return value, false
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, true
+	case reflect.String:
+		s := fmt.Sprintf("%s %d", field.Name, i)
+		if field.Size > 0 && len(s) > field.Size {
+			s = s[len(s)-field.Size:]
+		}
+		value = reflect.ValueOf(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = reflect.ValueOf(int64(i%100 + 1))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = reflect.ValueOf(uint64(i%100 + 1))
+	case reflect.Float32, reflect.Float64:
+		value = reflect.ValueOf(float64(i) + 0.5)
+	case reflect.Bool:
+		value = reflect.ValueOf(i%2 == 1)
+	case reflect.Struct:
+		if t != reflect.TypeOf(time.Time{}) {
+			return value, false
+		}
+		value = reflect.ValueOf(sampleTime.Add(time.Duration(i) * time.Hour))
+	default:
+		return value, false
+	}
+	return value.Convert(t), true
+}
@@ -0,0 +1,32 @@
+// Package crudtest helps testing the routes added by router.Crud over HTTP,
+// without wiring gin, the database and the authentication by hand.
+//
+// New spins up an App: an isolated in-memory sqlite database (orm.DB, for
+// the duration of the test) with the chosen models and fixtures, and a gin
+// router to add routes to. Clients make requests to the routes, typed by
+// the models, and return Responses, with assertions on the response
+// envelope of the controllers ({ Model: ... }, { error: ... }, total...):
+//
+//    func TestTodos(t *testing.T) {
+//        app := crudtest.New(t, crudtest.WithModels(&Todo{}), crudtest.WithAuth(&model.User{}))
+//        bob := app.User(&model.User{Email: "bob@example.com", Role: model.Employee})
+//        todos := crudtest.Crud[Todo](app, "/todos").As(bob)
+//
+//        _, res := todos.Create(&Todo{Title: "buy milk"})
+//        res.AssertStatus(http.StatusOK)
+//
+//        list, res := todos.List("total=true")
+//        res.AssertStatus(http.StatusOK).AssertTotal(1)
+//        ...
+//    }
+//
+// Conformance is a suite checking that the routes of a model behave as
+// documented, for any model, on sqlite and on the Postgres and MySQL
+// databases of the CRUDTEST_POSTGRES_DSN and CRUDTEST_MYSQL_DSN environment
+// variables, if given:
+//
+//    crudtest.Conformance[Todo]{}.Run(t)
+//
+// The database is global (orm.DB), so tests using an App must not run in
+// parallel.
+package crudtest
@@ -0,0 +1,150 @@
+package crudtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Response is the response to a request made to an App. Its Assert methods
+// fail the test with the request and the body, and return the Response,
+// to be chained:
+//
+//    res.AssertStatus(http.StatusOK).AssertTotal(2)
+type Response struct {
+	tb      testing.TB
+	request string
+
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+// Envelope decodes the body, a JSON object, into its keys:
+//
+//    { "Todos": [...], "total": 2 }
+//    { "error": "record not found" }
+func (r *Response) Envelope() map[string]json.RawMessage {
+	r.tb.Helper()
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(r.Body, &envelope); err != nil {
+		r.tb.Fatalf("%s: body is not a JSON object: %v\n%s", r.request, err, r.Body)
+	}
+	return envelope
+}
+
+// Decode decodes the value of the key of the envelope into dest.
+// It reports whether the key is found.
+func (r *Response) Decode(key string, dest any) bool {
+	r.tb.Helper()
+
+	raw, ok := r.Envelope()[key]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(raw, dest); err != nil {
+		r.tb.Fatalf("%s: decode %q: %v\n%s", r.request, key, err, r.Body)
+	}
+	return true
+}
+
+// decode decodes the models of the envelope into dest, a pointer to a
+// model or to a slice of models, under the key named after the type of
+// the models, as the controllers do: { "Todo": ... }, { "Todos": [...] }.
+func (r *Response) decode(dest any) {
+	r.tb.Helper()
+
+	key := envelopeKey(reflect.TypeOf(dest).Elem())
+	if !r.Decode(key, dest) {
+		r.tb.Fatalf("%s: no %q in the response\n%s", r.request, key, r.Body)
+	}
+}
+
+// envelopeKey is the key of the models of type t in the envelope.
+func envelopeKey(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return t.Name()
+	}
+	t = t.Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name() + "s"
+}
+
+// AssertStatus asserts the status code of the response.
+func (r *Response) AssertStatus(code int) *Response {
+	r.tb.Helper()
+
+	if r.Code != code {
+		r.tb.Errorf("%s: status = %d, want %d\n%s", r.request, r.Code, code, r.Body)
+	}
+	return r
+}
+
+// AssertError asserts the status code of the response, and that the error
+// of the envelope contains message.
+func (r *Response) AssertError(code int, message string) *Response {
+	r.tb.Helper()
+
+	r.AssertStatus(code)
+	var got string
+	if !r.Decode("error", &got) {
+		r.tb.Errorf("%s: no error in the response\n%s", r.request, r.Body)
+	} else if !strings.Contains(got, message) {
+		r.tb.Errorf("%s: error = %q, want %q", r.request, got, message)
+	}
+	return r
+}
+
+// AssertTotal asserts the total of the envelope, of a list with total=true.
+func (r *Response) AssertTotal(total int64) *Response {
+	r.tb.Helper()
+
+	var got int64
+	if !r.Decode("total", &got) {
+		r.tb.Errorf("%s: no total in the response\n%s", r.request, r.Body)
+	} else if got != total {
+		r.tb.Errorf("%s: total = %d, want %d", r.request, got, total)
+	}
+	return r
+}
+
+// AssertField asserts the value of the key of the envelope, compared as
+// JSON, e.g. AssertField("deleted", true).
+func (r *Response) AssertField(key string, want any) *Response {
+	r.tb.Helper()
+
+	raw, ok := r.Envelope()[key]
+	if !ok {
+		r.tb.Errorf("%s: no %q in the response\n%s", r.request, key, r.Body)
+		return r
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		r.tb.Fatalf("%s: %v", r.request, err)
+	}
+	var got, wanted any
+	json.Unmarshal(raw, &got)
+	json.Unmarshal(wantJSON, &wanted)
+	if !reflect.DeepEqual(got, wanted) {
+		r.tb.Errorf("%s: %s = %s, want %s", r.request, key, raw, wantJSON)
+	}
+	return r
+}
+
+// AssertHeader asserts the value of the header of the response.
+func (r *Response) AssertHeader(key, value string) *Response {
+	r.tb.Helper()
+
+	if got := r.Header.Get(key); got != value {
+		r.tb.Errorf("%s: header %s = %q, want %q", r.request, key, got, value)
+	}
+	return r
+}
@@ -1,23 +1,114 @@
 package main
 
 import (
+	"context"
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/config"
 	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/log"
 	"github.com/cdfmlr/crud/middleware"
 	"github.com/cdfmlr/crud/model"
 	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+	gin_request_id "github.com/cdfmlr/crud/pkg/gin-request-id"
 	"github.com/cdfmlr/crud/router"
+	"github.com/cdfmlr/crud/service"
+	"github.com/cdfmlr/crud/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/cors"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 )
 
+var logger = log.ZoneLogger("main")
+
 func main() {
+	// Read config from CRUD_* environment variables, e.g. CRUD_DB_DSN
+	conf := config.BaseConfig{
+		DB:     config.DBConfig{Driver: orm.DBDriverSqlite, DSN: "todolist.db"},
+		HTTP:   config.HTTPConfig{Addr: ":8086"},
+		Trash:  config.TrashConfig{RetentionDays: 30, PurgeInterval: time.Hour},
+		Outbox: config.OutboxConfig{Subject: "crud", RelayInterval: time.Second},
+	}
+	if err := config.Init(&conf, config.FromEnv("CRUD")); err != nil {
+		logger.WithError(err).Fatal("failed to read config")
+	}
+
+	// Install the keys to encrypt secrets at rest, see orm.EncryptedSerializer
+	if err := orm.UseEncryptionConfig(conf.Crypto); err != nil {
+		logger.WithError(err).Fatal("failed to install encryption keys")
+	}
+
 	// Connect to the database and register models
-	orm.ConnectDB(orm.DBDriverSqlite, "todolist.db")
-	orm.RegisterModel(model.Todo{}, model.Project{}, model.User{}, model.AuthorizationCodeUsage{}, model.LoginHistory{})
+	if _, err := orm.ConnectDBConfig(conf.DB); err != nil {
+		logger.WithError(err).Fatal("failed to connect to the database")
+	}
+	if conf.DB.Migrations != "" { // versioned migrations instead of AutoMigrate
+		orm.AutoMigrate = false
+		if err := orm.LoadMigrations(os.DirFS(conf.DB.Migrations), "."); err != nil {
+			logger.WithError(err).Fatal("failed to load migrations")
+		}
+		// not waiting forever for another instance holding the lock
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		_, err := orm.Migrate(ctx, "")
+		cancel()
+		if err != nil {
+			logger.WithError(err).Fatal("failed to migrate")
+		}
+	}
+	if err := orm.RegisterModel(model.Models...); err != nil {
+		logger.WithError(err).Fatal("failed to register models")
+	}
+
+	// Keep previous versions of todos and projects
+	if err := orm.EnableHistory(model.HistoryModels...); err != nil {
+		logger.WithError(err).Fatal("failed to enable history")
+	}
+
+	// Search todos by words: GET /todos?q=...
+	if err := orm.EnableSearch(model.SearchModels...); err != nil {
+		logger.WithError(err).Fatal("failed to enable search")
+	}
+
+	// Record mutations made through the crud routes
+	if err := audit.Enable(); err != nil {
+		logger.WithError(err).Fatal("failed to enable audit log")
+	}
+
+	// Relay domain events written by the service layer: post them to
+	// webhook subscribers, to the outbox file if configured, and to the
+	// change feeds of the broker (which never fails, so the last)
+	if err := outbox.Enable(); err != nil {
+		logger.WithError(err).Fatal("failed to enable outbox")
+	}
+	if err := webhook.Enable(); err != nil {
+		logger.WithError(err).Fatal("failed to enable webhooks")
+	}
+	// not serving signups (TOTP secrets) nor webhooks (their secrets)
+	// that could not be saved
+	if err := orm.RequireEncryptionKeys(); err != nil {
+		logger.WithError(err).Fatal("encryption keys required, set CRUD_CRYPTO_KEYS")
+	}
+	dispatcher := webhook.NewDispatcher()
+	dispatcher.Start(context.Background())
+	publishers := outbox.Publishers{dispatcher}
+	if conf.Outbox.File != "" {
+		publisher, err := outbox.NewFilePublisher(conf.Outbox.File, conf.Outbox.Subject)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to open outbox file")
+		}
+		publishers = append(publishers, publisher)
+	}
+	publishers = append(publishers, outbox.NewBrokerPublisher(broker.Default))
+	outbox.NewRelay(publishers, outbox.WithRelayInterval(conf.Outbox.RelayInterval)).
+		Start(context.Background())
 
 	// Initialize Gin router
 	r := gin.Default()
+	r.Use(gin_request_id.RequestID())
 
 	r.Static("/qrcodes", "./static/qrcodes")
 
@@ -39,25 +130,69 @@ func main() {
 	protectedRoutes := r.Group("/")
 	protectedRoutes.Use(middleware.AuthMiddleware())
 	{
-		router.Crud[model.Todo](protectedRoutes, "/todos")
-		router.Crud[model.Project](protectedRoutes, "/projects", router.CrudNested[model.Project, model.Todo]("todos"))
+		// todos are shared by all users, so are their changes
+		router.Crud[model.Todo](protectedRoutes, "/todos",
+			router.History[model.Todo](),
+			router.Trash[model.Todo](isAdmin),
+			router.Events[model.Todo](todoEvents),
+			router.Aggregate[model.Todo]("done"),
+			router.Export[model.Todo](),
+			router.Import[model.Todo]())
+		router.Crud[model.Project](protectedRoutes, "/projects",
+			router.CrudNested[model.Project, model.Todo]("todos"),
+			router.History[model.Project](),
+			router.Trash[model.Project](isAdmin),
+			router.Aggregate[model.Project]("todos.done"))
 
 		// Add more protected routes here
 	}
 
+	// Admin routes
+	adminRoutes := protectedRoutes.Group("/admin")
+	adminRoutes.Use(middleware.RequireRole(model.Admin))
+	{
+		router.AuditLog(adminRoutes, "/audit")
+		router.Webhooks(adminRoutes, "/webhooks")
+		router.Backup(adminRoutes, "/backup")
+	}
+
+	// Purge records trashed (of the models with trash bins) after the
+	// retention period
+	if conf.Trash.RetentionDays > 0 {
+		err := service.StartTrashPurger(context.Background(), conf.Trash.PurgeInterval,
+			time.Duration(conf.Trash.RetentionDays)*24*time.Hour,
+			router.TrashModels()...)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to start trash purger")
+		}
+	}
+
 	// Configure and apply CORS settings
+	allowedOrigins := []string{"http://localhost:5173"}
+	// and the same origins for the WebSocket change feeds
+	controller.EventsUpgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, allowed := range allowedOrigins {
+			if origin == allowed {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return origin == "" || (err == nil && u.Host == r.Host) // same origin
+	}
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:5173"},
+		AllowedOrigins:   allowedOrigins,
 		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "Last-Event-ID", controller.ReadYourWritesHeader},
+		ExposedHeaders:   []string{"ETag", "X-Total-Count"},
 	})
 
 	// Wrap the router with the CORS middleware
 	handler := c.Handler(r)
 
-	// Start the HTTP server on port 8086
-	http.ListenAndServe(":8086", handler)
+	// Start the HTTP server on port 8086 (by default)
+	http.ListenAndServe(conf.HTTP.Addr, handler)
 }
 
 // setupOAuth2Routes remains unchanged
@@ -65,3 +200,17 @@ func setupOAuth2Routes(r *gin.RouterGroup) {
 	r.GET("/auth", controller.AuthHandler)             // Adjust this according to your actual AuthHandler
 	r.GET("/callback", controller.AuthCallbackHandler) // Adjust this according to your actual AuthCallbackHandler
 }
+
+// isAdmin allows admins only
+func isAdmin(c *gin.Context) bool {
+	return middleware.HasRole(c, model.Admin)
+}
+
+// todoEvents authorizes the change events of todos: todos are shared by
+// all users, but who made a change is shown to admins and the actor only.
+func todoEvents(c *gin.Context, event *broker.Event) bool {
+	if !isAdmin(c) && event.Actor != c.GetString(middleware.EmailKey) {
+		event.Actor = ""
+	}
+	return true
+}
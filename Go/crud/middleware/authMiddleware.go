@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/store"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
@@ -9,6 +11,14 @@ import (
 	"strings"
 )
 
+// EmailKey is the gin context key of the authenticated user's email,
+// set by AuthMiddleware.
+const EmailKey = "email"
+
+// RoleKey is the gin context key of the authenticated user's role,
+// set by RequireRole and HasRole.
+const RoleKey = "role"
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := getTokenFromRequest(c)
@@ -28,10 +38,59 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if email, ok := claims["email"].(string); ok {
+				c.Set(EmailKey, email)
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole only allows users with one of the given roles.
+// It must be used after AuthMiddleware.
+func RequireRole(roles ...model.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(EmailKey) == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		if !HasRole(c, roles...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+// HasRole reports whether the authenticated user (by AuthMiddleware)
+// has one of the given roles. The user's role is cached in RoleKey.
+func HasRole(c *gin.Context, roles ...model.Role) bool {
+	role, ok := c.Get(RoleKey)
+	if !ok {
+		email := c.GetString(EmailKey)
+		if email == "" {
+			return false
+		}
+		var user model.User
+		if err := orm.DB.WithContext(c).Where("email = ?", email).First(&user).Error; err != nil {
+			return false
+		}
+		role = user.Role
+		c.Set(RoleKey, role)
+	}
+
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
 func getTokenFromRequest(c *gin.Context) string {
 	bearerToken := c.GetHeader("Authorization")
 	if len(bearerToken) > 7 && strings.ToUpper(bearerToken[0:7]) == "BEARER " {
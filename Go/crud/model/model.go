@@ -0,0 +1,23 @@
+package model
+
+// Models are all the models of the app, in the order to register them
+// with orm.RegisterModel.
+var Models = []any{
+	Todo{},
+	Project{},
+	User{},
+	AuthorizationCodeUsage{},
+	LoginHistory{},
+}
+
+// SearchModels are the models to search by words, with orm.EnableSearch.
+var SearchModels = []any{
+	Todo{},
+}
+
+// HistoryModels are the models to keep previous versions of, with
+// orm.EnableHistory.
+var HistoryModels = []any{
+	Todo{},
+	Project{},
+}
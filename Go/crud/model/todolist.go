@@ -6,8 +6,8 @@ import (
 
 type Todo struct {
 	orm.BasicModel
-	Title  string `json:"title"`
-	Detail string `json:"detail"`
+	Title  string `json:"title" crud:"searchable"`
+	Detail string `json:"detail" crud:"searchable"`
 	Done   bool   `json:"done"`
 }
 
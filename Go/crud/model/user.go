@@ -23,10 +23,10 @@ type User struct {
 	Picture      string    `json:"picture"`
 	Role         Role      `json:"role"`
 	WorkHours    int       `json:"work_hours"`
-	AccessToken  string    `json:"access_token"`
-	RefreshToken string    `json:"refresh_token"`
+	AccessToken  string    `json:"access_token" gorm:"serializer:encrypted"`
+	RefreshToken string    `json:"refresh_token" gorm:"serializer:encrypted"`
 	TokenExpiry  time.Time `json:"token_expiry"`
-	TOTPSecret   string    `json:"totp_secret"` // New field to store TOTP secret
+	TOTPSecret   string    `json:"totp_secret" gorm:"serializer:encrypted"` // New field to store TOTP secret
 
 }
 
@@ -0,0 +1,490 @@
+package orm
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnknownBackupTable = errors.New("unknown table in the backup")
+
+// ImportBatchSize is the number of rows inserted at a time by ImportData.
+var ImportBatchSize = 100
+
+// backupTable is a table to back up: of a registered model, a join table
+// of their many2many relationships, a shadow table of their history (see
+// EnableHistory), or the schema migrations.
+type backupTable struct {
+	name   string
+	schema *schema.Schema
+	model  any // a pointer to the (join table) model, to create the table
+	join   bool
+	shadow bool // of Versions
+}
+
+// create creates the table on db.
+func (t *backupTable) create(db *gorm.DB) error {
+	if t.shadow {
+		if err := db.Table(t.name).Migrator().CreateTable(t.model); err != nil {
+			return err
+		}
+		return createVersionIndex(db, t.name)
+	}
+	return db.Migrator().CreateTable(t.model)
+}
+
+// migrate creates or migrates the table on db, see gorm.AutoMigrate.
+// Join tables are migrated with their models.
+func (t *backupTable) migrate(db *gorm.DB) error {
+	switch {
+	case t.join:
+		return nil
+	case t.shadow:
+		return migrateShadowTable(db, t.name)
+	}
+	return db.AutoMigrate(t.model)
+}
+
+// columns are the columns of the fields, without the ones the database
+// generates (e.g. of EnableSearch).
+func (t *backupTable) columns() []string {
+	var columns []string
+	for _, field := range t.schema.Fields {
+		if field.DBName != "" && !field.IgnoreMigration {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// backupTables returns the tables to back up, in the order to restore:
+// the ones of the registered models (the app's, and those of the audit
+// log, the outbox and the webhooks, if enabled), the shadow tables of
+// their history, and then the join tables.
+func backupTables(db *gorm.DB) ([]*backupTable, error) {
+	var tables, joinTables []*backupTable
+	seen := map[string]bool{}
+	add := func(to *[]*backupTable, name string, s *schema.Schema, model any) {
+		if !seen[name] {
+			seen[name] = true
+			*to = append(*to, &backupTable{name: name, schema: s, model: model, join: to == &joinTables})
+		}
+	}
+
+	models := registeredModels
+	if db.Migrator().HasTable(&schemaMigration{}) {
+		models = append([]any{&schemaMigration{}}, models...)
+	}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		add(&tables, stmt.Schema.Table, stmt.Schema, model)
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable != nil {
+				add(&joinTables, rel.JoinTable.Table, rel.JoinTable,
+					reflect.New(rel.JoinTable.ModelType).Interface())
+			}
+		}
+	}
+	for _, shadow := range shadowTables() {
+		if !db.Migrator().HasTable(shadow) {
+			continue
+		}
+		s, err := ParseSchema(&Version{})
+		if err != nil {
+			return nil, err
+		}
+		if !seen[shadow] {
+			seen[shadow] = true
+			tables = append(tables, &backupTable{name: shadow, schema: s, model: &Version{}, shadow: true})
+		}
+	}
+	return append(tables, joinTables...), nil
+}
+
+// snapshot runs fn in a read-only transaction, which sees a consistent
+// snapshot of the database.
+//
+// The tables are streamed for as long as they take, without the statement
+// timeout, see WithoutStatementTimeout.
+func snapshot(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	var opts *sql.TxOptions
+	if db.Dialector.Name() != DBDriverSqlite { // sqlite transactions are serializable
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	return db.WithContext(WithoutStatementTimeout(ctx)).Transaction(fn, opts)
+}
+
+// scanTable calls fn with each row of the table, in the order of the
+// primary key, values normalized by the fields (see exportValue).
+func scanTable(tx *gorm.DB, table *backupTable, fn func(columns []string, values []any) error) error {
+	columns := table.columns()
+	query := tx.Table(table.name).Select(columns)
+	for _, field := range table.schema.PrimaryFields {
+		query = query.Order(field.DBName)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			values[i] = exportValue(values[i], table.schema.LookUpField(column))
+		}
+		if err := fn(columns, values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportValue normalizes a value read from the database for the field:
+// drivers read text as bytes (MySQL) and booleans as ints (SQLite).
+func exportValue(value any, field *schema.Field) any {
+	if field == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case []byte:
+		if field.DataType != schema.Bytes {
+			return string(v)
+		}
+	case int64:
+		if field.DataType == schema.Bool {
+			return v != 0
+		}
+	}
+	return value
+}
+
+// region logical export
+
+// dataLine is a line of the NDJSON of ExportData: a row of a table.
+type dataLine struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// ExportData writes all rows of the registered models (and of the join
+// tables of their many2many relationships, and of the shadow tables of
+// their history) to w as NDJSON, a line per row:
+//    {"table": "todos", "row": {"id": 1, "title": "Buy milk", ...}}
+// from a consistent snapshot of the database. Bytes are written in base64,
+// times in RFC 3339.
+//
+// It's independent of the database driver: ImportData reads it into any
+// database, e.g. to move data from SQLite to Postgres.
+func ExportData(ctx context.Context, w io.Writer) error {
+	tables, err := backupTables(DB)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	err = snapshot(ctx, DB, func(tx *gorm.DB) error {
+		for _, table := range tables {
+			err := scanTable(tx, table, func(columns []string, values []any) error {
+				row := make(map[string]any, len(columns))
+				for i, column := range columns {
+					row[column] = values[i]
+				}
+				return encoder.Encode(dataLine{Table: table.name, Row: row})
+			})
+			if err != nil {
+				return fmt.Errorf("export %s: %w", table.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportData inserts the rows of the NDJSON written by ExportData, in a
+// transaction. The tables must exist (e.g. by AutoMigrate or Migrate) and
+// should be empty: rows are inserted as they are, primary keys included.
+//
+// Sequences of the primary keys are moved after the imported ones, for
+// Postgres.
+func ImportData(ctx context.Context, r io.Reader) error {
+	tables, err := backupTables(DB)
+	if err != nil {
+		return err
+	}
+	byName := map[string]*backupTable{}
+	for _, table := range tables {
+		byName[table.name] = table
+	}
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var table *backupTable
+		var batch []map[string]any
+		imported := map[string]bool{}
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			err := tx.Table(table.name).Create(&batch).Error
+			batch = nil
+			return err
+		}
+
+		for line := 1; ; line++ {
+			var data dataLine
+			if err := decoder.Decode(&data); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("import line %d: %w", line, err)
+			}
+
+			if table == nil || data.Table != table.name || len(batch) >= ImportBatchSize {
+				if err := flush(); err != nil {
+					return fmt.Errorf("import %s: %w", table.name, err)
+				}
+			}
+			table = byName[data.Table]
+			if table == nil {
+				return fmt.Errorf("import line %d: %w: %s", line, ErrUnknownBackupTable, data.Table)
+			}
+			imported[table.name] = true
+
+			for column, value := range data.Row {
+				v, err := importValue(value, table.schema.LookUpField(column))
+				if err != nil {
+					return fmt.Errorf("import line %d: %s: %w", line, column, err)
+				}
+				data.Row[column] = v
+			}
+			batch = append(batch, data.Row)
+		}
+		if err := flush(); err != nil {
+			return fmt.Errorf("import %s: %w", table.name, err)
+		}
+
+		if tx.Dialector.Name() == DBDriverPostgres {
+			for _, table := range tables {
+				if sql := resetSequenceSQL(tx, table); sql != "" && imported[table.name] {
+					if err := tx.Exec(sql).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// importValue converts a value of ExportData back for the field.
+func importValue(value any, field *schema.Field) (any, error) {
+	if n, ok := value.(json.Number); ok {
+		switch {
+		case field != nil && field.DataType == schema.Float:
+			return n.Float64()
+		case field != nil && field.DataType == schema.Bool:
+			i, err := n.Int64()
+			return i != 0, err
+		}
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		return n.Float64()
+	}
+	if s, ok := value.(string); ok && field != nil {
+		switch field.DataType {
+		case schema.Time:
+			return time.Parse(time.RFC3339Nano, s)
+		case schema.Bytes:
+			return base64.StdEncoding.DecodeString(s)
+		}
+	}
+	return value, nil
+}
+
+// resetSequenceSQL returns the statement to move the sequence of the
+// auto increment primary key of table (Postgres only) after its rows,
+// or "" if no such key.
+func resetSequenceSQL(db *gorm.DB, table *backupTable) string {
+	field := table.schema.PrioritizedPrimaryField
+	if field == nil || !field.AutoIncrement || (field.DataType != schema.Int && field.DataType != schema.Uint) {
+		return ""
+	}
+	return fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+		table.name, field.DBName, db.Statement.Quote(field.DBName), db.Statement.Quote(table.name))
+}
+
+// endregion logical export
+
+// region SQL dump
+
+// Backup writes a consistent online backup of the database to the file
+// path, which must not exist: a copy of the database for SQLite (by VACUUM
+// INTO), a SQL dump for other databases (see DumpSQL). Either takes as
+// long as it takes, without the statement timeout.
+func Backup(ctx context.Context, path string) error {
+	if DB.Dialector.Name() == DBDriverSqlite {
+		return DB.WithContext(WithoutStatementTimeout(ctx)).Exec("VACUUM INTO ?", path).Error
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := DumpSQL(ctx, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// DumpSQL writes a SQL script to w, which creates the tables of the
+// registered models (and the join tables of their many2many relationships,
+// as AutoMigrate does, and the shadow tables of their history) and inserts
+// their rows, from a consistent snapshot
+// of the database. For Postgres, it's in the form of pg_dump --inserts,
+// restore it into an empty database with psql:
+//    psql -d dbname -f dump.sql
+func DumpSQL(ctx context.Context, w io.Writer) error {
+	tables, err := backupTables(DB)
+	if err != nil {
+		return err
+	}
+	dialect := DB.Dialector.Name()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- SQL dump of the %s database, %s\n\n", dialect, time.Now().UTC().Format(time.RFC3339))
+	switch dialect {
+	case DBDriverPostgres:
+		fmt.Fprint(bw, "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n\n")
+	case DBDriverMySQL:
+		fmt.Fprint(bw, "SET NAMES utf8mb4;\nSET FOREIGN_KEY_CHECKS = 0;\n\n")
+	}
+
+	recorder := &sqlRecorder{}
+	dryRun := DB.Session(&gorm.Session{DryRun: true, Logger: recorder, Context: ctx})
+	for _, table := range tables {
+		statements := recorder.record(func() error {
+			return table.create(dryRun)
+		})
+		fmt.Fprintf(bw, "--\n-- Table: %s\n--\n\n%s\n", table.name, script(statements))
+	}
+	if recorder.err != nil {
+		return recorder.err
+	}
+
+	err = snapshot(ctx, DB, func(tx *gorm.DB) error {
+		for _, table := range tables {
+			fmt.Fprintf(bw, "--\n-- Data: %s\n--\n\n", table.name)
+			var quoted []string
+			for _, column := range table.columns() {
+				quoted = append(quoted, tx.Statement.Quote(column))
+			}
+			insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (",
+				tx.Statement.Quote(table.name), strings.Join(quoted, ", "))
+
+			err := scanTable(tx, table, func(_ []string, values []any) error {
+				bw.WriteString(insert)
+				for i, value := range values {
+					if i > 0 {
+						bw.WriteString(", ")
+					}
+					bw.WriteString(sqlLiteral(dialect, value))
+				}
+				_, err := bw.WriteString(");\n")
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("dump %s: %w", table.name, err)
+			}
+			bw.WriteString("\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch dialect {
+	case DBDriverPostgres:
+		for _, table := range tables {
+			if sql := resetSequenceSQL(DB, table); sql != "" {
+				fmt.Fprintf(bw, "%s;\n", sql)
+			}
+		}
+	case DBDriverMySQL:
+		fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 1;\n")
+	}
+	return bw.Flush()
+}
+
+// sqlLiteral formats a value read from the database as a SQL literal of
+// the dialect.
+func sqlLiteral(dialect string, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if dialect == DBDriverSqlite {
+			if v {
+				return "1"
+			}
+			return "0"
+		}
+		return strings.ToUpper(strconv.FormatBool(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		if dialect == DBDriverMySQL {
+			return "'" + v.Format("2006-01-02 15:04:05.999999") + "'" // in the loc of the DSN
+		}
+		return "'" + v.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+	case []byte:
+		if dialect == DBDriverPostgres {
+			return `'\x` + hex.EncodeToString(v) + "'"
+		}
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		s := strings.ReplaceAll(v, "'", "''")
+		if dialect == DBDriverMySQL {
+			s = strings.ReplaceAll(s, `\`, `\\`)
+		}
+		return "'" + s + "'"
+	default:
+		return sqlLiteral(dialect, fmt.Sprint(v))
+	}
+}
+
+// endregion SQL dump
@@ -0,0 +1,146 @@
+package orm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type backupThing struct {
+	BasicModel
+	Name  string `json:"name"`
+	Done  bool
+	Data  []byte
+	Score float64
+}
+
+func TestBackup(t *testing.T) {
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	registeredModels = nil
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	open := func(name string) {
+		t.Helper()
+		if _, err := ConnectDB(DBDriverSqlite, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("ConnectDB() error = %v", err)
+		}
+		registeredModels = nil
+		if err := RegisterModel(&fixtureOwner{}, &fixtureTodo{}, &fixtureProject{}, &backupThing{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := EnableHistory(&backupThing{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name string) {
+		t.Helper()
+		var projects []fixtureProject
+		DB.Preload("Todos.Owner").Find(&projects)
+		if len(projects) != 1 || len(projects[0].Todos) != 2 || projects[0].Todos[0].Owner == nil {
+			t.Errorf("%s: projects = %+v", name, projects)
+		}
+		var things []backupThing
+		DB.Unscoped().Find(&things)
+		if len(things) != 2 || things[0].Name != "it's" || !things[0].Done || string(things[0].Data) != "\x00bin" ||
+			things[0].Score != 1.5 || things[0].CreatedAt.IsZero() || !things[1].DeletedAt.Valid {
+			t.Errorf("%s: things = %+v", name, things)
+		}
+		var versions []Version
+		DB.Table(VersionTable("backup_things")).Find(&versions)
+		if len(versions) != 1 || versions[0].RecordID != "1" {
+			t.Errorf("%s: versions = %+v", name, versions)
+		}
+	}
+
+	open("source.db")
+	owner := &fixtureOwner{Name: "Alice"}
+	DB.Create(owner)
+	DB.Create(&fixtureProject{Title: "Home", Todos: []*fixtureTodo{
+		{Title: "Buy milk", OwnerID: owner.ID}, {Title: "Walk the dog", Done: true, OwnerID: owner.ID}}})
+	things := []backupThing{{Name: "it's", Done: true, Data: []byte("\x00bin"), Score: 1.5}, {Name: `back\slash`}}
+	DB.Create(&things)
+	DB.Delete(&backupThing{}, 2)
+	DB.Save(&things[0])
+	check("source")
+	source := DB
+
+	var data, dump bytes.Buffer
+	if err := ExportData(ctx, &data); err != nil {
+		t.Fatalf("ExportData() error = %v", err)
+	}
+	if err := DumpSQL(ctx, &dump); err != nil {
+		t.Fatalf("DumpSQL() error = %v", err)
+	}
+	if err := Backup(ctx, filepath.Join(dir, "backup.db")); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	open("imported.db")
+	if err := ImportData(ctx, &data); err != nil {
+		t.Fatalf("ImportData() error = %v", err)
+	}
+	check("imported")
+
+	open("backup.db")
+	check("backup")
+
+	if _, err := ConnectDB(DBDriverSqlite, filepath.Join(dir, "dumped.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Exec(dump.String()).Error; err != nil {
+		t.Fatalf("restore DumpSQL() error = %v\n%s", err, dump.String())
+	}
+	check("dumped")
+
+	DB = source
+	if err := Backup(ctx, filepath.Join(dir, "backup.db")); err == nil {
+		t.Errorf("Backup(existing) error = nil")
+	}
+	if !strings.Contains(dump.String(), "INSERT INTO `backup_things`") {
+		t.Errorf("DumpSQL() =\n%s", dump.String())
+	}
+}
+
+func TestBackupStatementTimeout(t *testing.T) {
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	registeredModels = nil
+	ctx := context.Background()
+
+	timeout := 20 * time.Millisecond
+	_, err := ConnectDB(DBDriverSqlite, filepath.Join(t.TempDir(), "test.db"), WithStatementTimeout(timeout))
+	if err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := RegisterModel(&backupThing{}); err != nil {
+		t.Fatal(err)
+	}
+	DB.Create(&[]backupThing{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+	tables, err := backupTables(DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows read slower than the timeout are streamed to the end
+	var names []string
+	err = snapshot(ctx, DB, func(tx *gorm.DB) error {
+		return scanTable(tx, tables[0], func(columns []string, values []any) error {
+			time.Sleep(timeout)
+			for i, column := range columns {
+				if column == "name" {
+					names = append(names, fmt.Sprint(values[i]))
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil || strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("scanTable() = %v, %v, want all rows", names, err)
+	}
+}
@@ -0,0 +1,149 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCopyCountMismatch = errors.New("row counts mismatched after copy")
+	ErrCopyUnknownTables = errors.New("tables of the source unknown to the copy")
+)
+
+// CopiedTable is the row counts of a table copied by CopyData.
+type CopiedTable struct {
+	Table  string `json:"table"`
+	Source int64  `json:"source"` // rows read from the source
+	Target int64  `json:"target"` // rows in the target, after the copy
+}
+
+// CopyData copies all rows of the registered models (and of the join tables
+// of their many2many relationships, and of the shadow tables of their
+// history) from the source database into the target one, which may use
+// another driver, e.g. from SQLite to Postgres:
+//
+//  1. the schema of the models is migrated on the target (AutoMigrate);
+//  2. rows are read from a consistent snapshot of the source, and inserted
+//     into the target batchSize rows at a time, primary keys included;
+//  3. sequences of the primary keys are moved after the copied ones
+//     (Postgres);
+//  4. the row counts of the target are verified against the source.
+//
+// The target tables should be empty. Rows are inserted in a transaction:
+// the target is left unchanged on errors, ErrCopyCountMismatch included.
+//
+// Nothing is copied, with ErrCopyUnknownTables, if the source has tables
+// other than those and the search indexes (see EnableSearch, they are
+// rebuilt on the target), e.g. of models not registered.
+func CopyData(ctx context.Context, source, target *gorm.DB, batchSize int) ([]CopiedTable, error) {
+	if batchSize <= 0 {
+		batchSize = ImportBatchSize
+	}
+	tables, err := backupTables(source)
+	if err != nil {
+		return nil, err
+	}
+	unknown, err := unknownTables(source, tables)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCopyUnknownTables, unknown)
+	}
+
+	target = target.WithContext(ctx)
+	for _, table := range tables {
+		if err := table.migrate(target); err != nil {
+			return nil, fmt.Errorf("copy: migrate %s: %w", table.name, err)
+		}
+	}
+
+	copied := make([]CopiedTable, len(tables))
+	err = target.Transaction(func(tx *gorm.DB) error {
+		err := snapshot(ctx, source, func(src *gorm.DB) error {
+			for i, table := range tables {
+				copied[i].Table = table.name
+				var batch []map[string]any
+				flush := func() error {
+					if len(batch) == 0 {
+						return nil
+					}
+					err := tx.Table(table.name).Create(&batch).Error
+					batch = nil
+					return err
+				}
+
+				err := scanTable(src, table, func(columns []string, values []any) error {
+					row := make(map[string]any, len(columns))
+					for i, column := range columns {
+						row[column] = values[i]
+					}
+					batch = append(batch, row)
+					copied[i].Source++
+					if len(batch) >= batchSize {
+						return flush()
+					}
+					return nil
+				})
+				if err == nil {
+					err = flush()
+				}
+				if err != nil {
+					return fmt.Errorf("copy %s: %w", table.name, err)
+				}
+				logger.WithField("table", table.name).WithField("rows", copied[i].Source).
+					Info("CopyData: table copied")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var mismatched []string
+		for i, table := range tables {
+			if tx.Dialector.Name() == DBDriverPostgres {
+				if sql := resetSequenceSQL(tx, table); sql != "" {
+					if err := tx.Exec(sql).Error; err != nil {
+						return err
+					}
+				}
+			}
+			if err := tx.Table(table.name).Count(&copied[i].Target).Error; err != nil {
+				return err
+			}
+			if copied[i].Target != copied[i].Source {
+				mismatched = append(mismatched, fmt.Sprintf("%s: %d of %d", table.name, copied[i].Target, copied[i].Source))
+			}
+		}
+		if len(mismatched) > 0 {
+			return fmt.Errorf("%w: %v", ErrCopyCountMismatch, mismatched)
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// unknownTables returns the tables of db other than the given ones, the
+// search indexes and the internal ones of the database.
+func unknownTables(db *gorm.DB, tables []*backupTable) ([]string, error) {
+	names, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		known[table.name] = true
+	}
+	var unknown []string
+	for _, name := range names {
+		if !known[name] && !isSearchTable(name) && !strings.HasPrefix(name, "sqlite_") {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
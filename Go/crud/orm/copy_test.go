@@ -0,0 +1,94 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdfmlr/crud/config"
+)
+
+func TestCopyData(t *testing.T) {
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	registeredModels = nil
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	source, err := ConnectDB(DBDriverSqlite, filepath.Join(dir, "source.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterModel(&fixtureOwner{}, &fixtureTodo{}, &fixtureProject{}, &backupThing{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableHistory(&backupThing{}); err != nil {
+		t.Fatal(err)
+	}
+	owner := &fixtureOwner{Name: "Alice"}
+	source.Create(owner)
+	source.Create(&fixtureProject{Title: "Home", Todos: []*fixtureTodo{
+		{Title: "Buy milk", OwnerID: owner.ID}, {Title: "Walk the dog", Done: true, OwnerID: owner.ID}}})
+	source.Delete(&fixtureTodo{}, 1)
+	thing := &backupThing{Name: "v1"}
+	source.Create(thing)
+	thing.Name = "v2"
+	source.Save(thing)
+
+	target, err := OpenDBConfig(config.DBConfig{Driver: DBDriverSqlite, DSN: filepath.Join(dir, "target.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DB != source {
+		t.Fatalf("OpenDBConfig() changed DB")
+	}
+
+	copied, err := CopyData(ctx, source, target, 1)
+	if err != nil {
+		t.Fatalf("CopyData() error = %v", err)
+	}
+	want := map[string]int64{"fixture_owners": 1, "fixture_todos": 2, "fixture_projects": 1, "fixture_project_todos": 2,
+		"backup_things": 1, "backup_things_versions": 1}
+	if len(copied) != len(want) {
+		t.Errorf("CopyData() = %+v", copied)
+	}
+	for _, table := range copied {
+		if table.Source != want[table.Table] || table.Target != table.Source {
+			t.Errorf("copied %+v, want %d rows", table, want[table.Table])
+		}
+	}
+
+	var projects []fixtureProject
+	target.Preload("Todos.Owner").Find(&projects)
+	if len(projects) != 1 || len(projects[0].Todos) != 1 || projects[0].Todos[0].Owner.Name != "Alice" {
+		t.Errorf("target projects = %+v", projects)
+	}
+	var trashed fixtureTodo
+	if err := target.Unscoped().First(&trashed, 1).Error; err != nil || !trashed.DeletedAt.Valid {
+		t.Errorf("target trashed todo = %+v, %v", trashed, err)
+	}
+
+	// again: conflicts, the target is left unchanged
+	if _, err := CopyData(ctx, source, target, 1); err == nil {
+		t.Errorf("CopyData() again error = nil, want conflicts")
+	}
+	var count int64
+	target.Table("fixture_todos").Count(&count)
+	if count != 2 {
+		t.Errorf("target todos = %d after failed copy, want 2", count)
+	}
+
+	// tables unknown to the copy: refused
+	source.Exec("CREATE TABLE strays (id integer)")
+	empty, err := OpenDBConfig(config.DBConfig{Driver: DBDriverSqlite, DSN: filepath.Join(dir, "empty.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CopyData(ctx, source, empty, 1); !errors.Is(err, ErrCopyUnknownTables) || !strings.Contains(err.Error(), "strays") {
+		t.Errorf("CopyData(unknown tables) error = %v, want ErrCopyUnknownTables", err)
+	}
+	if tables, _ := empty.Migrator().GetTables(); len(tables) != 0 {
+		t.Errorf("CopyData(unknown tables) created %v", tables)
+	}
+}
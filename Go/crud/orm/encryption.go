@@ -0,0 +1,311 @@
+package orm
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/cdfmlr/crud/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// EncryptedSerializerName is the name of the serializer that encrypts
+// fields at rest. Tag a string (or []byte) field with it to enable:
+//
+//	type User struct {
+//	    orm.BasicModel
+//	    TOTPSecret string `gorm:"serializer:encrypted"`
+//	}
+//
+// Values are sealed with AES-GCM using the primary key installed by
+// UseEncryptionKeys on write, and opened with the key recorded in the
+// ciphertext on read. So old keys keep working after a rotation, until
+// ReEncrypt rewrites all rows with the new primary key.
+//
+// Values without the encrypted prefix (i.e. plaintext rows written before
+// the field was encrypted) are read as they are. Empty values are stored
+// empty.
+const EncryptedSerializerName = "encrypted"
+
+// encryptedPrefix marks a stored value as ciphertext:
+//
+//	enc:<keyID>:<base64(nonce || sealed)>
+const encryptedPrefix = "enc:"
+
+var (
+	ErrNoEncryptionKey      = errors.New("no encryption key configured")
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key id")
+	ErrInvalidEncryptionKey = errors.New("invalid encryption key")
+	ErrMalformedCiphertext  = errors.New("malformed ciphertext")
+)
+
+// keyring holds the AEADs used by the encrypted serializer.
+type keyring struct {
+	mu      sync.RWMutex
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+var encryptionKeys = &keyring{}
+
+func init() {
+	schema.RegisterSerializer(EncryptedSerializerName, EncryptedSerializer{})
+}
+
+// UseEncryptionKeys installs the keys for the encrypted serializer.
+//
+// keys maps key ids to raw AES keys (16, 24 or 32 bytes). New values are
+// encrypted with keys[primaryKeyID], while any key in keys can decrypt.
+// To rotate, add a new key, make it the primary one, keep the old ones
+// and run ReEncrypt.
+func UseEncryptionKeys(primaryKeyID string, keys map[string][]byte) error {
+	if _, ok := keys[primaryKeyID]; !ok {
+		return fmt.Errorf("%w: primary key %q not in keys", ErrUnknownEncryptionKey, primaryKeyID)
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("%w: bad key id %q", ErrInvalidEncryptionKey, id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidEncryptionKey, id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidEncryptionKey, id, err)
+		}
+		aeads[id] = aead
+	}
+
+	encryptionKeys.mu.Lock()
+	defer encryptionKeys.mu.Unlock()
+	encryptionKeys.primary = primaryKeyID
+	encryptionKeys.aeads = aeads
+
+	logger.WithField("primaryKeyID", primaryKeyID).
+		WithField("keys", len(aeads)).
+		Info("UseEncryptionKeys: encryption keys installed")
+	return nil
+}
+
+// UseEncryptionConfig installs the keys in conf, see UseEncryptionKeys.
+// It does nothing but warns if no key is configured, in which case writes
+// to encrypted fields will fail with ErrNoEncryptionKey, see
+// RequireEncryptionKeys.
+func UseEncryptionConfig(conf config.CryptoConfig) error {
+	if len(conf.Keys) == 0 {
+		logger.Warn("UseEncryptionConfig: no encryption keys configured, encrypted fields cannot be written")
+		return nil
+	}
+	keys, err := conf.ParseKeys()
+	if err != nil {
+		return err
+	}
+	return UseEncryptionKeys(conf.PrimaryKeyID, keys)
+}
+
+// RequireEncryptionKeys fails with ErrNoEncryptionKey if a registered
+// model (see RegisterModel) has encrypted fields, but no encryption key is
+// installed: writes of them would fail. Servers should check it at start.
+func RequireEncryptionKeys() error {
+	encryptionKeys.mu.RLock()
+	_, ok := encryptionKeys.aeads[encryptionKeys.primary]
+	encryptionKeys.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	for _, model := range registeredModels {
+		s, err := ParseSchema(model)
+		if err != nil {
+			return err
+		}
+		for _, field := range s.Fields {
+			if strings.EqualFold(field.TagSettings["SERIALIZER"], EncryptedSerializerName) {
+				return fmt.Errorf("%w: for %s.%s", ErrNoEncryptionKey, s.Name, field.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypt seals plaintext with the primary key.
+// additionalData is authenticated but not encrypted, the serializer passes
+// the column name so that ciphertexts cannot be swapped between columns.
+func Encrypt(plaintext []byte, additionalData []byte) (string, error) {
+	encryptionKeys.mu.RLock()
+	defer encryptionKeys.mu.RUnlock()
+
+	aead, ok := encryptionKeys.aeads[encryptionKeys.primary]
+	if !ok {
+		return "", ErrNoEncryptionKey
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+
+	return encryptedPrefix + encryptionKeys.primary + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with the key it was
+// encrypted with.
+func Decrypt(ciphertext string, additionalData []byte) ([]byte, error) {
+	if !IsEncrypted(ciphertext) {
+		return nil, ErrMalformedCiphertext
+	}
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(ciphertext, encryptedPrefix), ":")
+	if !ok {
+		return nil, ErrMalformedCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedCiphertext, err)
+	}
+
+	encryptionKeys.mu.RLock()
+	aead, ok := encryptionKeys.aeads[keyID]
+	encryptionKeys.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, keyID)
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedCiphertext
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+// IsEncrypted reports whether the stored value s is a ciphertext.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix)
+}
+
+// EncryptedSerializer implements schema.SerializerInterface to encrypt
+// string and []byte fields. It is registered as "encrypted", see
+// EncryptedSerializerName.
+type EncryptedSerializer struct{}
+
+// Scan decrypts the dbValue into the field.
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("encrypted serializer: unsupported db value type %T", dbValue)
+	}
+
+	plaintext := []byte(stored)
+	if IsEncrypted(stored) {
+		var err error
+		plaintext, err = Decrypt(stored, []byte(field.DBName))
+		if err != nil {
+			logger.WithContext(ctx).WithError(err).
+				WithField("field", field.Name).
+				Warn("EncryptedSerializer: decrypt failed")
+			return err
+		}
+	}
+
+	fieldValue := reflect.New(field.FieldType).Elem()
+	switch field.FieldType.Kind() {
+	case reflect.String:
+		fieldValue.SetString(string(plaintext))
+	case reflect.Slice:
+		if field.FieldType.Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("encrypted serializer: unsupported field type %s", field.FieldType)
+		}
+		fieldValue.SetBytes(plaintext)
+	default:
+		return fmt.Errorf("encrypted serializer: unsupported field type %s", field.FieldType)
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue)
+	return nil
+}
+
+// Value encrypts the fieldValue to be stored.
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plaintext []byte
+	switch v := fieldValue.(type) {
+	case string:
+		plaintext = []byte(v)
+	case []byte:
+		plaintext = v
+	default:
+		return nil, fmt.Errorf("encrypted serializer: unsupported field type %T", fieldValue)
+	}
+
+	if len(plaintext) == 0 {
+		return "", nil
+	}
+	return Encrypt(plaintext, []byte(field.DBName))
+}
+
+// ReEncrypt rewrites all encrypted fields of the given models (soft deleted
+// records included) with the current primary key, batchSize records per
+// transaction. Run it after a key rotation, or after tagging an existing
+// plaintext field with the encrypted serializer.
+//
+// Models without encrypted fields are skipped.
+func ReEncrypt(ctx context.Context, batchSize int, models ...any) (rowsAffected int64, err error) {
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return rowsAffected, err
+		}
+
+		var fields []string
+		for _, field := range stmt.Schema.Fields {
+			if strings.EqualFold(field.TagSettings["SERIALIZER"], EncryptedSerializerName) {
+				fields = append(fields, field.Name)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		logger := logger.WithContext(ctx).
+			WithField("model", stmt.Schema.Name).
+			WithField("fields", fields)
+		logger.Info("ReEncrypt: re-encrypting model")
+
+		records := reflect.New(reflect.SliceOf(reflect.PtrTo(stmt.Schema.ModelType)))
+		result := DB.WithContext(ctx).Model(model).Unscoped().
+			FindInBatches(records.Interface(), batchSize, func(tx *gorm.DB, batch int) error {
+				return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					for i := 0; i < records.Elem().Len(); i++ {
+						record := records.Elem().Index(i).Interface()
+						ret := tx.Model(record).Unscoped().Select(fields).UpdateColumns(record)
+						if ret.Error != nil {
+							return ret.Error
+						}
+						rowsAffected += ret.RowsAffected
+					}
+					return nil
+				})
+			})
+		if result.Error != nil {
+			logger.WithError(result.Error).Error("ReEncrypt: failed")
+			return rowsAffected, result.Error
+		}
+	}
+	return rowsAffected, nil
+}
@@ -0,0 +1,111 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type secretHolder struct {
+	BasicModel
+	Secret string `gorm:"serializer:encrypted"`
+	Public string
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := DB.AutoMigrate(&secretHolder{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+
+	key1 := []byte("0123456789abcdef0123456789abcdef")
+	key2 := []byte("fedcba9876543210fedcba9876543210")
+	if err := UseEncryptionKeys("k1", map[string][]byte{"k1": key1}); err != nil {
+		t.Fatalf("UseEncryptionKeys() error = %v", err)
+	}
+
+	record := secretHolder{Secret: "s3cr3t", Public: "hello"}
+	if err := DB.Create(&record).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	// a legacy plaintext row
+	if err := DB.Exec("INSERT INTO secret_holders (secret, public) VALUES (?, ?)", "legacy", "old").Error; err != nil {
+		t.Fatalf("insert plaintext error = %v", err)
+	}
+
+	stored := func(id uint) string {
+		var s string
+		DB.Raw("SELECT secret FROM secret_holders WHERE id = ?", id).Scan(&s)
+		return s
+	}
+
+	t.Run("encrypted at rest", func(t *testing.T) {
+		if s := stored(record.ID); !strings.HasPrefix(s, "enc:k1:") {
+			t.Errorf("stored secret = %q, want encrypted with k1", s)
+		}
+	})
+
+	t.Run("decrypted on read", func(t *testing.T) {
+		var got secretHolder
+		if err := DB.First(&got, record.ID).Error; err != nil {
+			t.Fatalf("First() error = %v", err)
+		}
+		if got.Secret != "s3cr3t" {
+			t.Errorf("Secret = %q, want %q", got.Secret, "s3cr3t")
+		}
+	})
+
+	t.Run("rotate and re-encrypt", func(t *testing.T) {
+		if err := UseEncryptionKeys("k2", map[string][]byte{"k1": key1, "k2": key2}); err != nil {
+			t.Fatalf("UseEncryptionKeys() error = %v", err)
+		}
+		rows, err := ReEncrypt(context.Background(), 1, &secretHolder{})
+		if err != nil {
+			t.Fatalf("ReEncrypt() error = %v", err)
+		}
+		if rows != 2 {
+			t.Errorf("ReEncrypt() rowsAffected = %v, want 2", rows)
+		}
+		for _, id := range []uint{1, 2} {
+			if s := stored(id); !strings.HasPrefix(s, "enc:k2:") {
+				t.Errorf("stored secret of %v = %q, want encrypted with k2", id, s)
+			}
+		}
+
+		// k1 is retired
+		if err := UseEncryptionKeys("k2", map[string][]byte{"k2": key2}); err != nil {
+			t.Fatalf("UseEncryptionKeys() error = %v", err)
+		}
+		var got []secretHolder
+		if err := DB.Order("id").Find(&got).Error; err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		if len(got) != 2 || got[0].Secret != "s3cr3t" || got[1].Secret != "legacy" {
+			t.Errorf("Find() got = %+v", got)
+		}
+	})
+}
+
+func TestRequireEncryptionKeys(t *testing.T) {
+	defer func(keys *keyring) { encryptionKeys = keys }(encryptionKeys)
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	encryptionKeys = &keyring{}
+	registeredModels = []any{&fixtureOwner{}}
+
+	if err := RequireEncryptionKeys(); err != nil {
+		t.Errorf("RequireEncryptionKeys(no encrypted fields) error = %v", err)
+	}
+	registeredModels = append(registeredModels, &secretHolder{})
+	if err := RequireEncryptionKeys(); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("RequireEncryptionKeys(no keys) error = %v, want ErrNoEncryptionKey", err)
+	}
+	if err := UseEncryptionKeys("k1", map[string][]byte{"k1": []byte("0123456789abcdef")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequireEncryptionKeys(); err != nil {
+		t.Errorf("RequireEncryptionKeys() error = %v", err)
+	}
+}
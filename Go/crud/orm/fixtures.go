@@ -0,0 +1,298 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrUnknownFixtureTable = errors.New("no registered model for the fixtures")
+	ErrUnknownFixture      = errors.New("unknown fixture")
+)
+
+// fixtureSet is the records of a fixture file, by label, and the models
+// built from them.
+type fixtureSet struct {
+	file    string
+	schema  *schema.Schema
+	order   int // of the model in registeredModels
+	labels  []string
+	records map[string]map[string]any
+	models  map[string]reflect.Value // pointers to the models, by label
+}
+
+// LoadFixtures loads the fixture files (*.yaml, *.yml or *.json) in dir of
+// fsys into the tables of the registered models, see RegisterModel.
+//
+// A file is named after the table, and maps labels to records, whose keys
+// are field names, JSON keys or column names. Associations refer to the
+// labels of the related records, in the fixtures loaded together:
+//
+//    # todos.yaml
+//    buy_milk:
+//      title: Buy milk
+//    walk_dog:
+//      title: Walk the dog
+//      done: true
+//
+//    # projects.yaml
+//    home:
+//      title: Home
+//      todos: [buy_milk, walk_dog]
+//
+// Records without a primary key get one derived from the table and the
+// label (see FixtureID), so that loading the fixtures again updates the
+// records instead of duplicating them. Models with composite keys must
+// give their keys, as fields or belongs to associations.
+//
+// The fixtures are loaded in a transaction, in the order the models were
+// registered. Tests load them into a fresh database by crudtest.WithFixtures.
+func LoadFixtures(ctx context.Context, fsys fs.FS, dir string) error {
+	sets, err := readFixtures(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	// fields first, so that the keys to refer to are known
+	for _, set := range sets {
+		for _, label := range set.labels {
+			if err := set.build(ctx, label); err != nil {
+				return err
+			}
+		}
+	}
+	var associations []func(tx *gorm.DB) error
+	for _, set := range sets {
+		for _, label := range set.labels {
+			fns, err := set.refer(ctx, label, sets)
+			if err != nil {
+				return err
+			}
+			associations = append(associations, fns...)
+		}
+	}
+
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, set := range sets {
+			for _, label := range set.labels {
+				err := tx.Omit(clause.Associations).
+					Clauses(clause.OnConflict{UpdateAll: true}).
+					Create(set.models[label].Interface()).Error
+				if err != nil {
+					return fmt.Errorf("fixture %s of %s: %w", label, set.file, err)
+				}
+			}
+		}
+		for _, associate := range associations {
+			if err := associate(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readFixtures reads the fixture files in dir, in the order of models.
+func readFixtures(fsys fs.FS, dir string) ([]*fixtureSet, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := map[string]*fixtureSet{} // by table
+	for i, model := range registeredModels {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if _, ok := schemas[stmt.Schema.Table]; !ok {
+			schemas[stmt.Schema.Table] = &fixtureSet{schema: stmt.Schema, order: i}
+		}
+	}
+
+	var sets []*fixtureSet
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		table := strings.TrimSuffix(entry.Name(), ext)
+		registered, ok := schemas[table]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFixtureTable, entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		set := &fixtureSet{
+			file:   entry.Name(),
+			schema: registered.schema,
+			order:  registered.order,
+			models: map[string]reflect.Value{},
+		}
+		// JSON is YAML as well
+		if err := yaml.Unmarshal(data, &set.records); err != nil {
+			return nil, fmt.Errorf("fixtures %s: %w", entry.Name(), err)
+		}
+		for label := range set.records {
+			set.labels = append(set.labels, label)
+		}
+		sort.Strings(set.labels)
+		sets = append(sets, set)
+	}
+	sort.SliceStable(sets, func(i, j int) bool {
+		return sets[i].order < sets[j].order
+	})
+	return sets, nil
+}
+
+// build builds the model of the record labeled label, with its fields and
+// primary key, but not associations.
+func (set *fixtureSet) build(ctx context.Context, label string) error {
+	model := reflect.New(set.schema.ModelType)
+	for key, value := range set.records[label] {
+		if LookUpRelationship(set.schema, key) != nil {
+			continue
+		}
+		field := LookUpField(set.schema, key)
+		if field == nil {
+			return fmt.Errorf("fixture %s of %s: unknown field %s", label, set.file, key)
+		}
+		if err := field.Set(ctx, model, value); err != nil {
+			return fmt.Errorf("fixture %s of %s: %s: %w", label, set.file, key, err)
+		}
+	}
+
+	if len(set.schema.PrimaryFields) == 1 {
+		field := set.schema.PrimaryFields[0]
+		if _, zero := field.ValueOf(ctx, model); zero {
+			if id := FixtureID(field, set.schema.Table, label); id != nil {
+				if err := field.Set(ctx, model, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	set.models[label] = model
+	return nil
+}
+
+// refer sets the foreign keys of belongs to associations of the record
+// labeled label, and returns functions to replace its other associations,
+// after all the records are saved.
+func (set *fixtureSet) refer(ctx context.Context, label string, sets []*fixtureSet) (associations []func(tx *gorm.DB) error, err error) {
+	model := set.models[label]
+	for key, value := range set.records[label] {
+		rel := LookUpRelationship(set.schema, key)
+		if rel == nil {
+			continue
+		}
+		targets, err := lookUpFixtures(rel.FieldSchema, value, sets)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s of %s: %s: %w", label, set.file, key, err)
+		}
+
+		if rel.Type == schema.BelongsTo {
+			if len(targets) != 1 {
+				return nil, fmt.Errorf("fixture %s of %s: %s: want one label", label, set.file, key)
+			}
+			for _, ref := range rel.References {
+				if ref.PrimaryKey == nil || ref.ForeignKey == nil {
+					continue
+				}
+				value, _ := ref.PrimaryKey.ValueOf(ctx, targets[0].Elem())
+				if err := ref.ForeignKey.Set(ctx, model, value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		name := rel.Name
+		values := make([]any, len(targets))
+		for i, target := range targets {
+			values[i] = target.Interface()
+		}
+		associations = append(associations, func(tx *gorm.DB) error {
+			if err := tx.Model(model.Interface()).Association(name).Replace(values...); err != nil {
+				return fmt.Errorf("fixture %s of %s: %s: %w", label, set.file, name, err)
+			}
+			return nil
+		})
+	}
+	return associations, nil
+}
+
+// lookUpFixtures finds the models of the labels (a label or a list of
+// them) in the fixtures of the schema s.
+func lookUpFixtures(s *schema.Schema, labels any, sets []*fixtureSet) ([]reflect.Value, error) {
+	var names []string
+	switch labels := labels.(type) {
+	case string:
+		names = []string{labels}
+	case []any:
+		for _, label := range labels {
+			names = append(names, fmt.Sprint(label))
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("%w: %v, want labels", ErrUnknownFixture, labels)
+	}
+
+	var models []reflect.Value
+	for _, name := range names {
+		var model reflect.Value
+		for _, set := range sets {
+			if set.schema.Table == s.Table {
+				if m, ok := set.models[name]; ok {
+					model = m
+					break
+				}
+			}
+		}
+		if !model.IsValid() {
+			return nil, fmt.Errorf("%w: %s of %s", ErrUnknownFixture, name, s.Table)
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// FixtureID returns the primary key derived from the table and the label of
+// a fixture record, for the primary key field: a positive int of 30 bits for
+// integer keys, a name based UUID (version 5) for string keys (or a ULID of
+// the same bytes, for keys shorter than a UUID, e.g. of ULIDModel).
+// It's nil for other keys, left to the database.
+func FixtureID(field *schema.Field, table, label string) any {
+	name := table + "/" + label
+	switch field.DataType {
+	case schema.Int, schema.Uint:
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		return int64(h.Sum32()%(1<<30-1) + 1)
+	case schema.String:
+		id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(name))
+		if field.Size > 0 && field.Size < len(id.String()) {
+			return ulid.ULID(id).String()
+		}
+		return id.String()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/gorm"
+)
+
+type fixtureOwner struct {
+	UUIDModel
+	Name string `json:"name"`
+}
+
+type fixtureTodo struct {
+	BasicModel
+	Title   string        `json:"title"`
+	Done    bool          `json:"done"`
+	OwnerID string        `json:"owner_id"`
+	Owner   *fixtureOwner `json:"owner"`
+}
+
+type fixtureProject struct {
+	BasicModel
+	Title string         `json:"title"`
+	Todos []*fixtureTodo `json:"todos" gorm:"many2many:fixture_project_todos"`
+}
+
+var fixtures = fstest.MapFS{
+	"fixtures/fixture_owners.json": {Data: []byte(`{"alice": {"name": "Alice"}}`)},
+	"fixtures/fixture_todos.yaml": {Data: []byte(`
+buy_milk:
+  title: Buy milk
+  owner: alice
+walk_dog:
+  id: 42
+  Title: Walk the dog
+  done: true
+`)},
+	"fixtures/fixture_projects.yml": {Data: []byte(`
+home:
+  title: Home
+  todos: [buy_milk, walk_dog]
+`)},
+}
+
+func TestLoadFixtures(t *testing.T) {
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	registeredModels = nil
+	if _, err := ConnectDB(DBDriverSqlite, filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := RegisterModel(&fixtureOwner{}, &fixtureTodo{}, &fixtureProject{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFixtures(context.Background(), fixtures, "fixtures"); err != nil {
+		t.Fatalf("LoadFixtures() error = %v", err)
+	}
+
+	// again: idempotent
+	if err := LoadFixtures(context.Background(), fixtures, "fixtures"); err != nil {
+		t.Fatalf("LoadFixtures() error = %v", err)
+	}
+
+	var todos []fixtureTodo
+	DB.Preload("Owner").Order("id").Find(&todos)
+	if len(todos) != 2 || todos[0].ID != 42 || !todos[0].Done {
+		t.Fatalf("todos = %+v", todos)
+	}
+	stmt := &gorm.Statement{DB: DB}
+	if err := stmt.Parse(&fixtureTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	id := FixtureID(stmt.Schema.PrioritizedPrimaryField, "fixture_todos", "buy_milk")
+	if buyMilk := todos[1]; buyMilk.Owner == nil || buyMilk.Owner.Name != "Alice" || int64(buyMilk.ID) != id {
+		t.Errorf("buy_milk = %+v", buyMilk)
+	}
+
+	var projects []fixtureProject
+	DB.Preload("Todos").Find(&projects)
+	if len(projects) != 1 || len(projects[0].Todos) != 2 {
+		t.Errorf("projects = %+v", projects)
+	}
+
+	broken := fstest.MapFS{"fixtures/fixture_projects.yml": {Data: []byte("home:\n  todos: [nothing]\n")}}
+	if err := LoadFixtures(context.Background(), broken, "fixtures"); !errors.Is(err, ErrUnknownFixture) {
+		t.Errorf("LoadFixtures(broken) error = %v, want ErrUnknownFixture", err)
+	}
+	unknown := fstest.MapFS{"fixtures/things.yml": {Data: []byte("a: {}\n")}}
+	if err := LoadFixtures(context.Background(), unknown, "fixtures"); !errors.Is(err, ErrUnknownFixtureTable) {
+		t.Errorf("LoadFixtures(unknown) error = %v, want ErrUnknownFixtureTable", err)
+	}
+}
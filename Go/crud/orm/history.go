@@ -0,0 +1,277 @@
+package orm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Version is a previous version of a record of a model with history
+// enabled (see EnableHistory).
+//
+// Versions of a model are kept in its shadow table "<table>_versions",
+// numbered from 1 for each record, unique by (record_id, version).
+type Version struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time      `json:"created_at"`                  // when the version was replaced
+	RecordID  string         `json:"record_id"`                   // primary key of the record
+	Version   int            `json:"version"`                     // 1, 2, 3, ... for each record
+	Action    string         `json:"action"`                      // what replaced the version: "update" or "delete"
+	Data      map[string]any `json:"data" gorm:"serializer:json"` // the record as JSON
+}
+
+var (
+	ErrHistoryNotEnabled      = errors.New("history is not enabled for the model")
+	ErrHistoryEncryptedFields = errors.New("history is not allowed for models with encrypted fields")
+	ErrHistoryCompositeKey    = errors.New("history is not supported for models with composite primary keys")
+)
+
+// historyTables are the tables with history enabled: table => shadow table
+var historyTables sync.Map
+
+// EnableHistory enables the history mode for the given models (which
+// should have been registered by RegisterModel): every version of a record
+// is kept in a shadow table before the record is updated or deleted
+// through the DB. So they can be listed and restored later.
+//
+// Only updates and deletes of records with known primary keys
+// (e.g. DB.Save(&model), DB.Delete(&model)) are versioned, batch updates
+// like DB.Model(&T{}).Where(...).Update(...) are not.
+//
+// Versions are stored as JSON in plaintext, so models with encrypted
+// fields are refused with ErrHistoryEncryptedFields.
+//
+// Versions are numbered by a single primary key, so models without
+// one (e.g. with composite primary keys) are refused with
+// ErrHistoryCompositeKey.
+func EnableHistory(models ...any) error {
+	if err := registerHistoryCallbacks(DB); err != nil {
+		return err
+	}
+
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		if len(stmt.Schema.PrimaryFields) != 1 {
+			return fmt.Errorf("%w: %s has %d", ErrHistoryCompositeKey,
+				stmt.Schema.Name, len(stmt.Schema.PrimaryFields))
+		}
+		for _, field := range stmt.Schema.Fields {
+			if strings.EqualFold(field.TagSettings["SERIALIZER"], EncryptedSerializerName) {
+				return fmt.Errorf("%w: %s.%s", ErrHistoryEncryptedFields, stmt.Schema.Name, field.Name)
+			}
+		}
+
+		table := stmt.Schema.Table
+		shadow := VersionTable(table)
+		if err := migrateShadowTable(DB, shadow); err != nil {
+			logger.WithError(err).WithField("table", shadow).
+				Error("EnableHistory: migrate shadow table failed")
+			return err
+		}
+
+		historyTables.Store(table, shadow)
+		logger.WithField("model", stmt.Schema.Name).
+			WithField("shadowTable", shadow).
+			Info("EnableHistory: history enabled")
+	}
+	return nil
+}
+
+// migrateShadowTable creates or migrates the shadow table on db, and its
+// index, see migrateVersionIndex.
+func migrateShadowTable(db *gorm.DB, shadow string) error {
+	if err := db.Table(shadow).AutoMigrate(&Version{}); err != nil {
+		return err
+	}
+	return migrateVersionIndex(db, shadow)
+}
+
+// migrateVersionIndex creates the unique index on (record_id, version) of
+// the shadow table, replacing the plain one of older versions.
+func migrateVersionIndex(db *gorm.DB, shadow string) error {
+	if db.Migrator().HasIndex(shadow, versionIndex(shadow)) {
+		return nil
+	}
+	if legacy := "idx_" + shadow + "_record"; db.Migrator().HasIndex(shadow, legacy) {
+		if err := db.Migrator().DropIndex(shadow, legacy); err != nil {
+			return err
+		}
+	}
+	return createVersionIndex(db, shadow)
+}
+
+// createVersionIndex creates the unique index on (record_id, version) of
+// the shadow table.
+//
+// Index names are global in sqlite, so they are named after the shadow table.
+func createVersionIndex(db *gorm.DB, shadow string) error {
+	return db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (record_id, version)",
+		db.Statement.Quote(versionIndex(shadow)), db.Statement.Quote(shadow))).Error
+}
+
+func versionIndex(shadow string) string {
+	return "idx_" + shadow + "_record_version"
+}
+
+// shadowTables returns the shadow tables of the models with history
+// enabled, sorted.
+func shadowTables() []string {
+	var tables []string
+	historyTables.Range(func(_, shadow any) bool {
+		tables = append(tables, shadow.(string))
+		return true
+	})
+	sort.Strings(tables)
+	return tables
+}
+
+// VersionTable returns the shadow table name for the table: "<table>_versions"
+func VersionTable(table string) string {
+	return table + "_versions"
+}
+
+// HistoryEnabled reports whether the history mode is enabled for model.
+func HistoryEnabled(model any) bool {
+	stmt := &gorm.Statement{DB: DB}
+	if err := stmt.Parse(model); err != nil {
+		return false
+	}
+	_, ok := historyTables.Load(stmt.Schema.Table)
+	return ok
+}
+
+// registerHistoryCallbacks registers callbacks to save versions before
+// updates and deletes, if not yet.
+func registerHistoryCallbacks(db *gorm.DB) error {
+	if db.Callback().Update().Get("crud:history") != nil {
+		return nil
+	}
+	err := db.Callback().Update().Before("gorm:update").
+		Register("crud:history", saveVersionCallback("update"))
+	if err != nil {
+		return err
+	}
+	// upserts (INSERT ... ON CONFLICT DO UPDATE) update existing records too
+	err = db.Callback().Create().Before("gorm:create").
+		Register("crud:history", func(db *gorm.DB) {
+			if isUpsert(db.Statement) {
+				saveVersionCallback("update")(db)
+			}
+		})
+	if err != nil {
+		return err
+	}
+	return db.Callback().Delete().Before("gorm:delete").
+		Register("crud:history", saveVersionCallback("delete"))
+}
+
+// isUpsert reports whether the create statement updates on conflicts.
+func isUpsert(stmt *gorm.Statement) bool {
+	c, ok := stmt.Clauses["ON CONFLICT"]
+	if !ok {
+		return false
+	}
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	return ok && (onConflict.UpdateAll || len(onConflict.DoUpdates) > 0)
+}
+
+// saveVersionCallback returns a gorm callback that saves the current
+// version of the record(s) about to be updated or deleted.
+func saveVersionCallback(action string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.PrioritizedPrimaryField == nil {
+			return
+		}
+		shadow, ok := historyTables.Load(db.Statement.Table)
+		if !ok {
+			return
+		}
+
+		var ids []any
+		switch rv := db.Statement.ReflectValue; rv.Kind() {
+		case reflect.Struct:
+			if id, zero := db.Statement.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, rv); !zero {
+				ids = append(ids, id)
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				elem := reflect.Indirect(rv.Index(i))
+				if id, zero := db.Statement.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, elem); !zero {
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			logger.WithContext(db.Statement.Context).
+				WithField("table", db.Statement.Table).
+				Debug("saveVersion: no primary key, skipped")
+			return
+		}
+
+		for _, id := range ids {
+			if err := saveVersion(db, db.Statement.Schema, shadow.(string), action, id); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+// saveVersion saves the current version of the record (by id) into the
+// shadow table, using the connection (or transaction) of db.
+//
+// The record is locked (SELECT ... FOR UPDATE, where the database
+// supports) before numbering the version, so concurrent updates of it
+// get the next numbers in turn, and the unique index fails any that
+// could still collide rather than keeping duplicates.
+func saveVersion(db *gorm.DB, s *schema.Schema, shadow string, action string, id any) error {
+	tx := db.Session(&gorm.Session{NewDB: true})
+
+	current := reflect.New(s.ModelType).Interface()
+	err := tx.Unscoped().Table(s.Table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(map[string]any{s.PrioritizedPrimaryField.DBName: id}).
+		Take(current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // nothing to keep
+	}
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{}
+	j, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(j, &data); err != nil {
+		return err
+	}
+
+	recordID := fmt.Sprint(id)
+	var latest int
+	err = tx.Table(shadow).Where("record_id = ?", recordID).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Table(shadow).Create(&Version{
+		RecordID: recordID,
+		Version:  latest + 1,
+		Action:   action,
+		Data:     data,
+	}).Error
+}
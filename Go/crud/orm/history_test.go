@@ -0,0 +1,68 @@
+package orm
+
+import (
+	"errors"
+	"testing"
+)
+
+type historyTodo struct {
+	BasicModel
+	Title string `json:"title"`
+}
+
+func TestEnableHistory(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := DB.AutoMigrate(&historyTodo{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	if err := EnableHistory(&historyTodo{}); err != nil {
+		t.Fatalf("EnableHistory() error = %v", err)
+	}
+	shadow := VersionTable("history_todos")
+
+	todo := historyTodo{Title: "a"}
+	if err := DB.Create(&todo).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, title := range []string{"b", "c"} {
+		todo.Title = title
+		if err := DB.Save(&todo).Error; err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	if err := DB.Delete(&todo).Error; err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	var versions []Version
+	if err := DB.Table(shadow).Order("version").Find(&versions).Error; err != nil {
+		t.Fatalf("Find(versions) error = %v", err)
+	}
+	want := []struct {
+		title  string
+		action string
+	}{{"a", "update"}, {"b", "update"}, {"c", "delete"}}
+	if len(versions) != len(want) {
+		t.Fatalf("got %d versions, want %d: %+v", len(versions), len(want), versions)
+	}
+	for i, v := range versions {
+		if v.Version != i+1 || v.Data["title"] != want[i].title || v.Action != want[i].action {
+			t.Errorf("version #%d = %+v, want %+v", i+1, v, want[i])
+		}
+	}
+
+	t.Run("versions are unique", func(t *testing.T) {
+		duplicate := Version{RecordID: versions[0].RecordID, Version: 1, Action: "update"}
+		if err := DB.Table(shadow).Create(&duplicate).Error; err == nil {
+			t.Errorf("Create(duplicate version) succeeded, want a unique constraint error")
+		}
+	})
+
+	t.Run("composite keys are refused", func(t *testing.T) {
+		if err := EnableHistory(&membership{}); !errors.Is(err, ErrHistoryCompositeKey) {
+			t.Errorf("EnableHistory(membership) error = %v, want ErrHistoryCompositeKey", err)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// CompositeIDSeparator separates the values of a CompositeID in its string
+// form, e.g. in URLs: /memberships/3,7
+const CompositeIDSeparator = ","
+
+// CompositeID is the value of a composite primary key: the values of the
+// key fields, in order. See Model.
+type CompositeID []any
+
+// String joins the values with CompositeIDSeparator.
+func (id CompositeID) String() string {
+	values := make([]string, len(id))
+	for i, value := range id {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, CompositeIDSeparator)
+}
+
+// ParseCompositeID splits the string form of a CompositeID.
+func ParseCompositeID(s string) CompositeID {
+	var id CompositeID
+	for _, value := range strings.Split(s, CompositeIDSeparator) {
+		id = append(id, value)
+	}
+	return id
+}
+
+var ErrInvalidID = errors.New("invalid id")
+
+// IdentityFields returns the names of the primary key fields of model:
+// the field of Identity, or the fields of a composite key.
+func IdentityFields(model Model) []string {
+	fieldName, _ := model.Identity()
+	if fieldName == "" {
+		return nil
+	}
+	return strings.Split(fieldName, ",")
+}
+
+// IdentityValues returns the values of id for the identity fields of
+// model, see IdentityFields: id is the value of a single key field, or a
+// CompositeID (or its string form) for a composite key.
+func IdentityValues(model Model, id any) ([]any, error) {
+	fields := IdentityFields(model)
+	if len(fields) <= 1 {
+		return []any{id}, nil
+	}
+
+	values, ok := id.(CompositeID)
+	if s, isString := id.(string); isString {
+		values, ok = ParseCompositeID(s), true
+	}
+	if !ok || len(values) != len(fields) {
+		return nil, fmt.Errorf("%w: %v, want values of %s", ErrInvalidID, id, strings.Join(fields, ", "))
+	}
+	return values, nil
+}
+
+// IdentityConds returns the conditions (column => value) to query the
+// model (of the type of model) identified by id, see IdentityValues.
+func IdentityConds(model Model, id any) (map[string]any, error) {
+	fields := IdentityFields(model)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: no identity field", ErrInvalidID)
+	}
+	values, err := IdentityValues(model, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ParseSchema(model)
+	if err != nil {
+		return nil, err
+	}
+	conds := make(map[string]any, len(fields))
+	for i, name := range fields {
+		field := s.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: unknown identity field %s", ErrInvalidID, name)
+		}
+		conds[field.DBName] = values[i]
+	}
+	return conds, nil
+}
+
+// IsZeroID reports whether id is not set: a zero value, or a CompositeID
+// of zero values only.
+func IsZeroID(id any) bool {
+	if values, ok := id.(CompositeID); ok {
+		for _, value := range values {
+			if !IsZeroID(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return id == nil || reflect.ValueOf(id).IsZero()
+}
+
+// schemaCache caches the schemas parsed with no DB connected.
+var schemaCache sync.Map
+
+// ParseSchema parses the schema of the model, with the naming strategy of
+// DB, or the default one if DB is not connected (e.g. for models kept out
+// of the database, see service.MemoryRepository).
+func ParseSchema(model any) (*schema.Schema, error) {
+	if DB == nil {
+		return schema.Parse(model, &schemaCache, schema.NamingStrategy{})
+	}
+	stmt := &gorm.Statement{DB: DB}
+	err := stmt.Parse(model)
+	return stmt.Schema, err
+}
+
+// LookUpField finds a field with a column of the schema s by its name,
+// JSON key (see JSONKey) or column name.
+func LookUpField(s *schema.Schema, name string) *schema.Field {
+	field := s.LookUpField(name)
+	if field == nil {
+		for _, f := range s.Fields {
+			if JSONKey(f) == name {
+				field = f
+				break
+			}
+		}
+	}
+	if field == nil || field.DBName == "" {
+		return nil
+	}
+	return field
+}
+
+// routeNameReplacer drops the separators of the names of associations in
+// routes, e.g. "/projects/:id/todo-items" of TodoItems.
+var routeNameReplacer = strings.NewReplacer(" ", "", "-", "", "_", "", "/", "")
+
+// LookUpRelationship finds a relationship of the schema s by its field
+// name, JSON key (see JSONKey), or its name in routes: case-insensitively,
+// without spaces, "-", "_" and "/" (e.g. "todo_items" of TodoItems).
+func LookUpRelationship(s *schema.Schema, name string) *schema.Relationship {
+	if rel, ok := s.Relationships.Relations[name]; ok {
+		return rel
+	}
+	for _, rel := range s.Relationships.Relations {
+		if JSONKey(rel.Field) == name {
+			return rel
+		}
+	}
+	name = routeNameReplacer.Replace(name)
+	for _, rel := range s.Relationships.Relations {
+		if strings.EqualFold(rel.Name, name) {
+			return rel
+		}
+	}
+	return nil
+}
+
+// JSONKey returns the key of the field in the JSON of its model (by
+// encoding/json), or "" if the field is not in it: hidden by `json:"-"`,
+// or nested in the object of a (non-anonymous) embedded struct.
+func JSONKey(field *schema.Field) string {
+	t := field.Schema.ModelType
+	var key string
+	for i, name := range field.BindNames {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		sf, ok := t.FieldByName(name)
+		if !ok {
+			return ""
+		}
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if tag == "-" {
+			return ""
+		}
+		if i < len(field.BindNames)-1 {
+			if !sf.Anonymous || tag != "" {
+				return ""
+			}
+			t = sf.Type
+			continue
+		}
+		key = tag
+		if key == "" {
+			key = sf.Name
+		}
+	}
+	return key
+}
@@ -0,0 +1,125 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type uuidThing struct {
+	UUIDModel
+	Name string
+}
+
+type ulidThing struct {
+	ULIDModel
+	Name string
+}
+
+type membership struct {
+	UserID    uint `gorm:"primaryKey"`
+	ProjectID uint `gorm:"primaryKey"`
+	Role      string
+}
+
+func (m membership) Identity() (fieldName string, value any) {
+	return "UserID,ProjectID", CompositeID{m.UserID, m.ProjectID}
+}
+
+func TestGeneratedIDs(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := DB.AutoMigrate(&uuidThing{}, &ulidThing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	uuids := []*uuidThing{{Name: "a"}, {Name: "b"}, {UUIDModel: UUIDModel{ID: "given"}}}
+	if err := DB.Create(&uuids).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if len(uuids[0].ID) != 36 || uuids[0].ID == uuids[1].ID || uuids[2].ID != "given" {
+		t.Errorf("UUIDs = %q, %q, %q", uuids[0].ID, uuids[1].ID, uuids[2].ID)
+	}
+
+	first, second := &ulidThing{Name: "a"}, &ulidThing{Name: "b"}
+	DB.Create(first)
+	DB.Create(second)
+	if len(first.ID) != 26 || first.ID >= second.ID {
+		t.Errorf("ULIDs = %q, %q, want sorted", first.ID, second.ID)
+	}
+}
+
+func TestIdentityConds(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+
+	conds, err := IdentityConds(BasicModel{}, "10")
+	if err != nil || len(conds) != 1 || conds["id"] != "10" {
+		t.Errorf("IdentityConds(BasicModel) = %v, %v", conds, err)
+	}
+
+	for _, id := range []any{"3,7", CompositeID{uint(3), uint(7)}} {
+		conds, err := IdentityConds(membership{}, id)
+		if err != nil || len(conds) != 2 || conds["user_id"] == nil || conds["project_id"] == nil {
+			t.Errorf("IdentityConds(membership, %v) = %v, %v", id, conds, err)
+		}
+	}
+	if _, err := IdentityConds(membership{}, "3"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("IdentityConds(membership, 3) error = %v, want ErrInvalidID", err)
+	}
+
+	if id := fmt.Sprint(CompositeID{3, 7}); id != "3,7" {
+		t.Errorf("CompositeID.String() = %q", id)
+	}
+	if !IsZeroID(CompositeID{uint(0), uint(0)}) || IsZeroID(CompositeID{uint(0), uint(7)}) || !IsZeroID(uint(0)) {
+		t.Errorf("IsZeroID() wrong")
+	}
+}
+
+type lookUpThing struct {
+	BasicModel
+	Title    string         `json:"title,omitempty"`
+	Secret   string         `json:"-"`
+	Meta     lookUpMeta     `gorm:"embedded;embeddedPrefix:meta_"`
+	TodoList []*fixtureTodo `json:"todos" gorm:"many2many:look_up_thing_todos"`
+}
+
+type lookUpMeta struct {
+	Source string `json:"source"`
+}
+
+func TestLookUp(t *testing.T) {
+	s, err := ParseSchema(&lookUpThing{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Title", "title"} {
+		if field := LookUpField(s, name); field == nil || field.Name != "Title" {
+			t.Errorf("LookUpField(%s) = %v, want Title", name, field)
+		}
+	}
+	if field := LookUpField(s, "TodoList"); field != nil {
+		t.Errorf("LookUpField(association) = %v, want nil", field.Name)
+	}
+	if field := LookUpField(s, "meta_source"); field == nil || JSONKey(field) != "" {
+		t.Errorf("LookUpField(meta_source) = %v, want a field not in the JSON", field)
+	}
+
+	keys := map[string]string{"ID": "ID", "Title": "title", "Secret": ""}
+	for name, key := range keys {
+		if got := JSONKey(s.LookUpField(name)); got != key {
+			t.Errorf("JSONKey(%s) = %q, want %q", name, got, key)
+		}
+	}
+
+	for _, name := range []string{"TodoList", "todos", "todo_list", "todo-list"} {
+		if rel := LookUpRelationship(s, name); rel == nil || rel.Name != "TodoList" {
+			t.Errorf("LookUpRelationship(%s) = %v, want TodoList", name, rel)
+		}
+	}
+	if rel := LookUpRelationship(s, "title"); rel != nil {
+		t.Errorf("LookUpRelationship(title) = %v, want nil", rel.Name)
+	}
+}
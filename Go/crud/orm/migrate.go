@@ -0,0 +1,453 @@
+package orm
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration is a versioned schema migration, written in Go (Up and Down)
+// or SQL (UpSQL and DownSQL, see LoadMigrations).
+//
+// Migrations are applied in the order of versions, each in a transaction
+// (except DDL statements of MySQL, which commit implicitly).
+type Migration struct {
+	Version string // sortable, e.g. a timestamp "20240102150405"
+	Name    string
+
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error // nil if irreversible
+
+	UpSQL   string
+	DownSQL string // "" if irreversible
+}
+
+// checksum detects SQL migrations modified after being applied.
+func (m *Migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.Name + "\x00" + m.UpSQL + "\x00" + m.DownSQL))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Migration) up(tx *gorm.DB) error {
+	if m.Up != nil {
+		return m.Up(tx)
+	}
+	return execScript(tx, m.UpSQL)
+}
+
+func (m *Migration) down(tx *gorm.DB) error {
+	if m.Down != nil {
+		return m.Down(tx)
+	}
+	if strings.TrimSpace(m.DownSQL) == "" {
+		return ErrIrreversibleMigration
+	}
+	return execScript(tx, m.DownSQL)
+}
+
+// execScript runs the SQL statements of a migration at once.
+// MySQL requires multiStatements=true in the DSN for scripts of multiple
+// statements.
+func execScript(tx *gorm.DB, script string) error {
+	if strings.TrimSpace(script) == "" {
+		return nil
+	}
+	return tx.Exec(script).Error
+}
+
+// MigrationStatus is the status of a migration, see MigrationStatuses.
+type MigrationStatus struct {
+	Version   string     `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"` // nil if pending
+	Modified  bool       `json:"modified"`   // applied, but changed since then
+	Missing   bool       `json:"missing"`    // applied, but not registered
+}
+
+// schemaMigration is an applied migration.
+type schemaMigration struct {
+	Version   string `gorm:"primaryKey;size:64"`
+	Name      string
+	Checksum  string `gorm:"size:64"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "crud_schema_migrations"
+}
+
+// schemaMigrationLock is the lock (a single row) held by the process
+// running migrations.
+type schemaMigrationLock struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	Locked   bool
+	LockedBy string
+	LockedAt *time.Time
+}
+
+func (schemaMigrationLock) TableName() string {
+	return "crud_schema_migrations_lock"
+}
+
+var (
+	ErrDuplicateMigration    = errors.New("duplicate migration version")
+	ErrUnknownMigration      = errors.New("unknown migration version")
+	ErrIrreversibleMigration = errors.New("irreversible migration")
+	ErrMigrationModified     = errors.New("applied migration has been modified")
+	ErrMigrationLocked       = errors.New("migrations are locked by another process")
+	ErrNoSchemaChanges       = errors.New("no schema changes")
+	ErrInvalidRollbackSteps  = errors.New("rollback steps must be positive")
+)
+
+// MigrationLockPollInterval is how often a locked migration run retries
+// to acquire the lock.
+var MigrationLockPollInterval = 500 * time.Millisecond
+
+// MigrationLockTTL is how long the migrations lock is held without being
+// refreshed. The holder refreshes it every third of the TTL while running,
+// so a lock older than that is left by a crashed process, and it's taken
+// over.
+var MigrationLockTTL = time.Minute
+
+// migrations are registered migrations: version => *Migration
+var migrations sync.Map
+
+// RegisterMigrations registers the migrations to apply by Migrate.
+func RegisterMigrations(ms ...Migration) error {
+	for i := range ms {
+		m := ms[i]
+		if m.Version == "" {
+			return fmt.Errorf("%w: empty version of %q", ErrUnknownMigration, m.Name)
+		}
+		if _, loaded := migrations.LoadOrStore(m.Version, &m); loaded {
+			return fmt.Errorf("%w: %s", ErrDuplicateMigration, m.Version)
+		}
+	}
+	return nil
+}
+
+// LoadMigrations registers the SQL migrations in dir of fsys, in files
+// named "<version>_<name>.up.sql" and "<version>_<name>.down.sql" (the
+// down one is optional), as written by WriteMigration.
+func LoadMigrations(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]*Migration{}
+	var versions []string
+	for _, entry := range entries {
+		up := strings.HasSuffix(entry.Name(), ".up.sql")
+		base := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".up.sql"), ".down.sql")
+		version, name, ok := strings.Cut(base, "_")
+		if entry.IsDir() || base == entry.Name() || !ok {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		m, ok := loaded[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			loaded[version] = m
+			versions = append(versions, version)
+		}
+		if up {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	sort.Strings(versions)
+	for _, version := range versions {
+		if err := RegisterMigrations(*loaded[version]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedMigrations returns the registered migrations in order.
+func sortedMigrations() []*Migration {
+	var ms []*Migration
+	migrations.Range(func(_, m any) bool {
+		ms = append(ms, m.(*Migration))
+		return true
+	})
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms
+}
+
+// appliedMigrations returns the applied migrations by version.
+func appliedMigrations(ctx context.Context) (map[string]schemaMigration, error) {
+	if err := DB.WithContext(ctx).AutoMigrate(&schemaMigration{}, &schemaMigrationLock{}); err != nil {
+		return nil, err
+	}
+	var applied []schemaMigration
+	if err := DB.WithContext(ctx).Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	byVersion := make(map[string]schemaMigration, len(applied))
+	for _, a := range applied {
+		byVersion[a.Version] = a
+	}
+	return byVersion, nil
+}
+
+// Migrate applies the pending migrations in order, up to the version to
+// (inclusive, "" for all), returns the versions applied.
+//
+// It holds the migrations lock while running: a concurrent Migrate (e.g.
+// of another instance booting) waits for it, until ctx is done, or the
+// lock expires (see MigrationLockTTL). It fails without applying anything if an applied SQL migration has been
+// modified.
+func Migrate(ctx context.Context, to string) (applied []string, err error) {
+	err = withMigrationLock(ctx, func() error {
+		done, err := appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		ms := sortedMigrations()
+		for _, m := range ms {
+			if a, ok := done[m.Version]; ok && a.Checksum != m.checksum() {
+				return fmt.Errorf("%w: %s_%s", ErrMigrationModified, m.Version, m.Name)
+			}
+		}
+
+		for _, m := range ms {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if to != "" && m.Version > to {
+				break
+			}
+			logger.WithField("version", m.Version).WithField("name", m.Name).
+				Info("Migrate: applying migration")
+			err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := m.up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{
+					Version:   m.Version,
+					Name:      m.Name,
+					Checksum:  m.checksum(),
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m.Version)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).WithField("applied", applied).
+			Warn("Migrate: failed")
+	}
+	return applied, err
+}
+
+// Rollback reverts the last steps (at least 1) applied migrations, in
+// reverse order, returns the versions reverted.
+func Rollback(ctx context.Context, steps int) (reverted []string, err error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidRollbackSteps, steps)
+	}
+	err = withMigrationLock(ctx, func() error {
+		done, err := appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		versions := make([]string, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		for _, version := range versions {
+			m, ok := migrations.Load(version)
+			if !ok {
+				return fmt.Errorf("%w: %s_%s", ErrUnknownMigration, version, done[version].Name)
+			}
+			logger.WithField("version", version).WithField("name", done[version].Name).
+				Info("Rollback: reverting migration")
+			err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := m.(*Migration).down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{Version: version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s_%s: %w", version, done[version].Name, err)
+			}
+			reverted = append(reverted, version)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).WithField("reverted", reverted).
+			Warn("Rollback: failed")
+	}
+	return reverted, err
+}
+
+// MigrationStatuses returns the statuses of the registered, and applied,
+// migrations, in order of versions.
+func MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	done, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range sortedMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := done[m.Version]; ok {
+			status.AppliedAt = &a.AppliedAt
+			status.Modified = a.Checksum != m.checksum()
+			delete(done, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, a := range done {
+		a := a
+		statuses = append(statuses, MigrationStatus{
+			Version: a.Version, Name: a.Name, AppliedAt: &a.AppliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// withMigrationLock runs fn holding the migrations lock, waiting for it if
+// held by another process, unless the lock is stale: not refreshed in the
+// MigrationLockTTL. The lock is refreshed while fn runs.
+func withMigrationLock(ctx context.Context, fn func() error) error {
+	if _, err := appliedMigrations(ctx); err != nil { // creates the tables
+		return err
+	}
+	db := DB.WithContext(ctx)
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&schemaMigrationLock{ID: 1}).Error
+	if err != nil {
+		return err
+	}
+
+	token := lockToken()
+	for {
+		now := time.Now()
+		result := db.Model(&schemaMigrationLock{}).
+			Where("id = ? AND (locked = ? OR locked_at < ?)", 1, false, now.Add(-MigrationLockTTL)).
+			Updates(map[string]any{"locked": true, "locked_by": token, "locked_at": &now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			break
+		}
+
+		logger.Info("Migrate: waiting for the migrations lock")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrMigrationLocked, ctx.Err())
+		case <-time.After(MigrationLockPollInterval):
+		}
+	}
+
+	refreshed := make(chan struct{})
+	stop := make(chan struct{})
+	go func() { // keeps the lock from going stale
+		defer close(refreshed)
+		ticker := time.NewTicker(MigrationLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			err := DB.Model(&schemaMigrationLock{}).
+				Where("id = ? AND locked_by = ?", 1, token).
+				Update("locked_at", &now).Error
+			if err != nil {
+				logger.WithError(err).Warn("Migrate: refresh the migrations lock failed")
+			}
+		}
+	}()
+
+	defer func() {
+		close(stop)
+		<-refreshed
+		err := DB.Model(&schemaMigrationLock{}).
+			Where("id = ? AND locked_by = ?", 1, token).
+			Updates(map[string]any{"locked": false, "locked_by": "", "locked_at": nil}).Error
+		if err != nil {
+			logger.WithError(err).Error("Migrate: release the migrations lock failed")
+		}
+	}()
+	return fn()
+}
+
+// UnlockMigrations releases the migrations lock by force, left by a
+// process crashed while migrating.
+func UnlockMigrations(ctx context.Context) error {
+	if _, err := appliedMigrations(ctx); err != nil {
+		return err
+	}
+	return DB.WithContext(ctx).Model(&schemaMigrationLock{}).
+		Where("id = ?", 1).
+		Updates(map[string]any{"locked": false, "locked_by": "", "locked_at": nil}).Error
+}
+
+// lockToken identifies the lock holder: host, pid and a random suffix.
+func lockToken() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// WriteMigration writes the SQL migration m into dir, as files
+// "<version>_<name>.up.sql" and "<version>_<name>.down.sql".
+// See LoadMigrations.
+func WriteMigration(dir string, m *Migration) (files []string, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, m.Version+"_"+m.Name)
+	for suffix, content := range map[string]string{".up.sql": m.UpSQL, ".down.sql": m.DownSQL} {
+		file := base + suffix
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,164 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// GenerateMigration generates the skeleton of a SQL migration, from the
+// differences between the registered models (see RegisterModel) and the
+// live schema of the database:
+//
+//  - tables of models missing: created (dropped in the down migration);
+//  - columns of fields missing: added (dropped);
+//  - columns without fields: dropped (added back, but not the data);
+//  - indexes of models missing: created (dropped).
+//
+// Changes of column types and renames are not detected (a rename looks
+// like a drop and an add), edit the skeleton for them and data migrations.
+//
+// The version is the current UTC time. ErrNoSchemaChanges is returned if
+// nothing to migrate. Use WriteMigration to write it into files.
+func GenerateMigration(ctx context.Context, name string) (*Migration, error) {
+	live := DB.WithContext(ctx).Migrator()
+	recorder := &sqlRecorder{}
+	dryRunDB := DB.Session(&gorm.Session{DryRun: true, Logger: recorder, Context: ctx})
+	dryRun := dryRunDB.Migrator()
+
+	var up, down []string
+	change := func(upSQL, downSQL []string) {
+		up = append(up, upSQL...)
+		down = append(downSQL, down...) // reverted in reverse order
+	}
+
+	for _, model := range registeredModels {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		s := stmt.Schema
+
+		if !live.HasTable(model) {
+			change(recorder.record(func() error { return dryRun.CreateTable(model) }),
+				[]string{fmt.Sprintf("DROP TABLE %s", quote(s.Table))})
+			continue
+		}
+
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.IgnoreMigration || live.HasColumn(model, field.DBName) {
+				continue
+			}
+			change(recorder.record(func() error { return dryRun.AddColumn(model, field.Name) }),
+				[]string{dropColumnSQL(s.Table, field.DBName)})
+		}
+
+		columnTypes, err := live.ColumnTypes(model)
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columnTypes {
+			if _, ok := s.FieldsByDBName[column.Name()]; ok || column.Name() == "crud_search" { // see EnableSearch
+				continue
+			}
+			change([]string{dropColumnSQL(s.Table, column.Name())},
+				[]string{fmt.Sprintf("ALTER TABLE %s ADD %s %s",
+					quote(s.Table), quote(column.Name()), column.DatabaseTypeName())})
+		}
+
+		for _, index := range s.ParseIndexes() {
+			if live.HasIndex(model, index.Name) {
+				continue
+			}
+			change(recorder.record(func() error { return dryRun.CreateIndex(model, index.Name) }),
+				recorder.record(func() error { return dryRun.DropIndex(model, index.Name) }))
+		}
+	}
+	// join tables of many2many relationships, after the tables they reference
+	joinTables := map[string]bool{}
+	for _, model := range registeredModels {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable == nil || joinTables[rel.JoinTable.Table] || live.HasTable(rel.JoinTable.Table) {
+				continue
+			}
+			joinTables[rel.JoinTable.Table] = true
+			joinTable := reflect.New(rel.JoinTable.ModelType).Interface()
+			change(recorder.record(func() error {
+				return dryRunDB.Table(rel.JoinTable.Table).Migrator().CreateTable(joinTable)
+			}), []string{fmt.Sprintf("DROP TABLE %s", quote(rel.JoinTable.Table))})
+		}
+	}
+	if recorder.err != nil { // of the dry run
+		return nil, recorder.err
+	}
+	if len(up) == 0 {
+		return nil, ErrNoSchemaChanges
+	}
+
+	return &Migration{
+		Version: time.Now().UTC().Format("20060102150405"),
+		Name:    name,
+		UpSQL:   script(up),
+		DownSQL: script(down),
+	}, nil
+}
+
+// script joins the statements into a SQL script.
+func script(statements []string) string {
+	var sb strings.Builder
+	for _, statement := range statements {
+		sb.WriteString(strings.TrimSuffix(statement, ";"))
+		sb.WriteString(";\n")
+	}
+	return sb.String()
+}
+
+func dropColumnSQL(table, column string) string {
+	return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", quote(table), quote(column))
+}
+
+// quote quotes the name (of a table or column) for the database.
+func quote(name string) string {
+	var sb strings.Builder
+	DB.Dialector.QuoteTo(&sb, name)
+	return sb.String()
+}
+
+// sqlRecorder is a gorm logger recording the SQL statements of a dry run
+// session, instead of logging them.
+type sqlRecorder struct {
+	statements []string
+	err        error // the first error of recorded functions
+}
+
+// record runs fn with the dry run session, returns the statements of it.
+func (r *sqlRecorder) record(fn func() error) []string {
+	r.statements = nil
+	if err := fn(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.statements
+}
+
+func (r *sqlRecorder) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (sql string, rowsAffected int64), _ error) {
+	if sql, _ := fc(); sql != "" {
+		r.statements = append(r.statements, sql)
+	}
+}
@@ -0,0 +1,190 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type migratedThing struct {
+	BasicModel
+	Name  string
+	Color string `gorm:"index"`
+}
+
+func TestMigrate(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	migrations = sync.Map{}
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"migrations/20240101000000_create_things.up.sql":   {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY, name TEXT);")},
+		"migrations/20240101000000_create_things.down.sql": {Data: []byte("DROP TABLE things;")},
+		"migrations/20240102000000_add_color.up.sql":       {Data: []byte("ALTER TABLE things ADD color TEXT;")},
+		"migrations/README.md":                             {Data: []byte("not a migration")},
+	}
+	if err := LoadMigrations(fsys, "migrations"); err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	err := RegisterMigrations(Migration{
+		Version: "20240103000000",
+		Name:    "seed_things",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO things (name, color) VALUES (?, ?)", "sky", "blue").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DELETE FROM things WHERE name = ?", "sky").Error
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterMigrations() error = %v", err)
+	}
+	if err := RegisterMigrations(Migration{Version: "20240103000000"}); !errors.Is(err, ErrDuplicateMigration) {
+		t.Errorf("RegisterMigrations(duplicate) error = %v, want ErrDuplicateMigration", err)
+	}
+
+	applied, err := Migrate(ctx, "20240102000000")
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Migrate(to) = %v, %v, want 2 applied", applied, err)
+	}
+	applied, err = Migrate(ctx, "")
+	if err != nil || len(applied) != 1 || applied[0] != "20240103000000" {
+		t.Fatalf("Migrate() = %v, %v, want the last one", applied, err)
+	}
+
+	var count int64
+	DB.Table("things").Count(&count)
+	if count != 1 {
+		t.Errorf("things count = %d, want 1", count)
+	}
+
+	statuses, err := MigrationStatuses(ctx)
+	if err != nil || len(statuses) != 3 {
+		t.Fatalf("MigrationStatuses() = %v, %v", statuses, err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil || s.Modified || s.Missing {
+			t.Errorf("status %+v, want applied", s)
+		}
+	}
+
+	// irreversible: add_color has no down migration
+	reverted, err := Rollback(ctx, 2)
+	if !errors.Is(err, ErrIrreversibleMigration) || len(reverted) != 1 {
+		t.Errorf("Rollback(2) = %v, %v, want ErrIrreversibleMigration after 1", reverted, err)
+	}
+	DB.Table("things").Count(&count)
+	if count != 0 {
+		t.Errorf("things count = %d after rollback, want 0", count)
+	}
+
+	if _, err := Rollback(ctx, 0); !errors.Is(err, ErrInvalidRollbackSteps) {
+		t.Errorf("Rollback(0) error = %v, want ErrInvalidRollbackSteps", err)
+	}
+	if _, err := Rollback(ctx, -1); !errors.Is(err, ErrInvalidRollbackSteps) {
+		t.Errorf("Rollback(-1) error = %v, want ErrInvalidRollbackSteps", err)
+	}
+
+	t.Run("lock", func(t *testing.T) {
+		defer func(ttl, poll time.Duration) {
+			MigrationLockTTL, MigrationLockPollInterval = ttl, poll
+		}(MigrationLockTTL, MigrationLockPollInterval)
+		MigrationLockTTL, MigrationLockPollInterval = time.Hour, 10*time.Millisecond
+		lock := func(at time.Time) {
+			DB.Model(&schemaMigrationLock{}).Where("id = ?", 1).
+				Updates(map[string]any{"locked": true, "locked_by": "crashed:1:0", "locked_at": &at})
+		}
+
+		lock(time.Now())
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		// the deadline may cut off a query instead of the wait
+		if _, err := Migrate(timeout, ""); !errors.Is(err, ErrMigrationLocked) && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Migrate(locked) error = %v, want ErrMigrationLocked", err)
+		}
+
+		lock(time.Now().Add(-2 * time.Hour))
+		if _, err := Migrate(ctx, "20240101000000"); err != nil {
+			t.Errorf("Migrate(stale lock) error = %v, want it taken over", err)
+		}
+
+		// refreshed while running, not to be taken over
+		MigrationLockTTL = 30 * time.Millisecond
+		var lockedAt []time.Time
+		err := withMigrationLock(ctx, func() error {
+			for i := 0; i < 2; i++ {
+				time.Sleep(MigrationLockTTL)
+				var l schemaMigrationLock
+				DB.Take(&l, 1)
+				lockedAt = append(lockedAt, *l.LockedAt)
+			}
+			return nil
+		})
+		if err != nil || !lockedAt[1].After(lockedAt[0]) {
+			t.Errorf("withMigrationLock() = %v, locked at %v, want refreshed", err, lockedAt)
+		}
+	})
+
+	m, _ := migrations.Load("20240101000000")
+	m.(*Migration).UpSQL += "\n-- changed"
+	if _, err := Migrate(ctx, ""); !errors.Is(err, ErrMigrationModified) {
+		t.Errorf("Migrate() error = %v, want ErrMigrationModified", err)
+	}
+}
+
+func TestGenerateMigration(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	defer func(models []any) { registeredModels = models }(registeredModels)
+	registeredModels = []any{&migratedThing{}}
+	ctx := context.Background()
+
+	m, err := GenerateMigration(ctx, "create_migrated_things")
+	if err != nil {
+		t.Fatalf("GenerateMigration() error = %v", err)
+	}
+	if !strings.Contains(m.UpSQL, "CREATE TABLE `migrated_things`") ||
+		!strings.Contains(m.UpSQL, "CREATE INDEX `idx_migrated_things_color`") ||
+		!strings.Contains(m.DownSQL, "DROP TABLE `migrated_things`") {
+		t.Errorf("GenerateMigration() =\n%s\n%s", m.UpSQL, m.DownSQL)
+	}
+
+	// the live table: a column missing, and an extra one
+	err = DB.Exec("CREATE TABLE migrated_things (id INTEGER PRIMARY KEY, created_at DATETIME, " +
+		"updated_at DATETIME, deleted_at DATETIME, name TEXT, size INTEGER)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err = GenerateMigration(ctx, "change_migrated_things"); err != nil {
+		t.Fatalf("GenerateMigration() error = %v", err)
+	}
+	for _, want := range []string{"ALTER TABLE `migrated_things` ADD `color` text", "ALTER TABLE `migrated_things` DROP COLUMN `size`"} {
+		if !strings.Contains(m.UpSQL, want) {
+			t.Errorf("UpSQL = %s, want %s", m.UpSQL, want)
+		}
+	}
+	if !strings.Contains(m.DownSQL, "ALTER TABLE `migrated_things` ADD `size` INTEGER") {
+		t.Errorf("DownSQL = %s", m.DownSQL)
+	}
+
+	// apply it
+	migrations = sync.Map{}
+	if err := RegisterMigrations(*m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, ""); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if _, err := GenerateMigration(ctx, "nothing"); !errors.Is(err, ErrNoSchemaChanges) {
+		t.Errorf("GenerateMigration() error = %v, want ErrNoSchemaChanges", err)
+	}
+}
@@ -1,12 +1,27 @@
 package orm
 
 import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
 )
 
 // Model is the interface for all models.
 // It only requires an Identity() method to return the primary key field
 // name and value.
+//
+// Models with a composite primary key return the key fields joined by
+// commas, and a CompositeID of their values:
+//    type Membership struct {
+//      UserID    uint `gorm:"primaryKey"`
+//      ProjectID uint `gorm:"primaryKey"`
+//    }
+//
+//    func (m Membership) Identity() (fieldName string, value any) {
+//      return "UserID,ProjectID", orm.CompositeID{m.UserID, m.ProjectID}
+//    }
 type Model interface {
 	// Identity returns the primary key field of the model.
 	// A very common case is that the primary key field is ID.
@@ -28,3 +43,57 @@ type BasicModel gorm.Model
 func (m BasicModel) Identity() (fieldName string, value any) {
 	return "ID", m.ID
 }
+
+// UUIDModel implements Model interface with a random UUID (version 4)
+// primary key ID, generated on create if not set.
+//
+// Embed it as the base struct instead of BasicModel for ids that are not
+// guessable or enumerable:
+//    type User struct {
+//      orm.UUIDModel
+//    }
+// A model defining its own BeforeCreate hook must call the one of
+// UUIDModel.
+type UUIDModel struct {
+	ID        string `gorm:"primaryKey;size:36"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (m UUIDModel) Identity() (fieldName string, value any) {
+	return "ID", m.ID
+}
+
+// BeforeCreate generates the ID if not set.
+func (m *UUIDModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == "" {
+		m.ID = uuid.NewString()
+	}
+	return nil
+}
+
+// ULIDModel implements Model interface with a ULID primary key ID,
+// generated on create if not set. ULIDs are sortable by creation time,
+// so are the models by ID, which keeps the indexes compact as well.
+//
+// A model defining its own BeforeCreate hook must call the one of
+// ULIDModel.
+type ULIDModel struct {
+	ID        string `gorm:"primaryKey;size:26"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (m ULIDModel) Identity() (fieldName string, value any) {
+	return "ID", m.ID
+}
+
+// BeforeCreate generates the ID if not set.
+func (m *ULIDModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == "" {
+		m.ID = ulid.Make().String()
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package orm
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cdfmlr/crud/config"
 	"github.com/cdfmlr/crud/log"
 	"gorm.io/gorm"
 
@@ -32,17 +37,202 @@ var logger = log.ZoneLogger("crud/orm")
 //  - DBDriverPostgres: host=localhost user=gorm password=gorm dbname=gorm port=9920 sslmode=disable TimeZone=Asia/Shanghai
 // See GORM docs for more information:
 // - https://gorm.io/docs/connecting_to_the_database.html
-func ConnectDB(driver DBDriver, dsn string) (*gorm.DB, error) {
-	var err error
+//
+// Options configure the connection pool, timeouts and retries, see
+// ConnectOption. Or use ConnectDBConfig to connect with a config.DBConfig.
+//
+// The global DB is set only if connected: a failure is returned, after the
+// retries, instead of leaving a nil DB behind.
+func ConnectDB(driver DBDriver, dsn string, options ...ConnectOption) (*gorm.DB, error) {
+	db, err := connect(driver, dsn, options...)
+	if err != nil {
+		return nil, err
+	}
 
+	DB = db
+	return DB, nil
+}
+
+// connect opens the database and configures it with the options.
+func connect(driver DBDriver, dsn string, options ...ConnectOption) (*gorm.DB, error) {
 	driverOpen := getDBOpener(driver)
+	if driverOpen == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, driver)
+	}
+
+	var opts connectOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	db, err := openDB(driverOpen(dsn), &opts)
+	if err != nil {
+		logger.WithError(err).WithField("driver", driver).
+			Error("ConnectDB: failed to connect to the database")
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if opts.maxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(opts.maxOpenConns)
+	}
+	if opts.maxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(opts.maxIdleConns)
+	}
+	if opts.connMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(opts.connMaxLifetime)
+	}
+	if opts.connMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(opts.connMaxIdleTime)
+	}
+	if opts.statementTimeout > 0 {
+		if err := registerStatementTimeout(db, opts.statementTimeout); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// ConnectDBConfig connects to the database in conf, see ConnectDB,
+// and to its read replicas, if any, see UseReplicas.
+func ConnectDBConfig(conf config.DBConfig) (*gorm.DB, error) {
+	options := configOptions(conf)
+	db, err := ConnectDB(DBDriver(conf.Driver), conf.DSN, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := UseReplicas(DBDriver(conf.Driver), conf.Replicas, options...); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDBConfig opens the database in conf, as ConnectDBConfig does, but
+// leaves the global DB alone: e.g. for the target of CopyData.
+func OpenDBConfig(conf config.DBConfig) (*gorm.DB, error) {
+	return connect(DBDriver(conf.Driver), conf.DSN, configOptions(conf)...)
+}
+
+// configOptions are the ConnectOptions of conf.
+func configOptions(conf config.DBConfig) []ConnectOption {
+	return []ConnectOption{
+		WithMaxOpenConns(conf.MaxOpenConns),
+		WithMaxIdleConns(conf.MaxIdleConns),
+		WithConnMaxLifetime(conf.ConnMaxLifetime),
+		WithConnMaxIdleTime(conf.ConnMaxIdleTime),
+		WithStatementTimeout(conf.StatementTimeout),
+		WithConnectRetries(conf.ConnectRetries, conf.ConnectBackoff),
+		WithPrepareStmt(conf.PrepareStmt),
+	}
+}
+
+var ErrUnknownDriver = errors.New("unknown database driver")
+
+// openDB opens the database, retrying with exponential backoff on failure.
+func openDB(dialector gorm.Dialector, opts *connectOptions) (db *gorm.DB, err error) {
+	backoff := opts.backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		db, err = gorm.Open(dialector, &gorm.Config{
+			Logger:      log.Logger4Gorm,
+			PrepareStmt: opts.prepareStmt,
+		})
+		if err == nil || attempt >= opts.retries {
+			return db, err
+		}
 
-	DB, err = gorm.Open(driverOpen(dsn), &gorm.Config{
-		Logger: log.Logger4Gorm,
-	})
-	return DB, err
+		logger.WithError(err).WithField("attempt", attempt+1).
+			WithField("backoff", backoff).
+			Warn("ConnectDB: failed to connect, retrying")
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// maxConnectBackoff caps the backoff between connect retries.
+const maxConnectBackoff = 30 * time.Second
+
+// region connectOptions
+
+type connectOptions struct {
+	maxOpenConns     int
+	maxIdleConns     int
+	connMaxLifetime  time.Duration
+	connMaxIdleTime  time.Duration
+	statementTimeout time.Duration
+	retries          int
+	backoff          time.Duration
+	prepareStmt      bool
+}
+
+// ConnectOption configures ConnectDB. Zero values keep the defaults of
+// database/sql and gorm.
+type ConnectOption func(*connectOptions)
+
+// WithMaxOpenConns limits the open connections to the database.
+func WithMaxOpenConns(n int) ConnectOption {
+	return func(o *connectOptions) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets the max idle connections kept in the pool.
+func WithMaxIdleConns(n int) ConnectOption {
+	return func(o *connectOptions) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime closes connections reused longer than d.
+func WithConnMaxLifetime(d time.Duration) ConnectOption {
+	return func(o *connectOptions) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithConnMaxIdleTime closes connections idle longer than d.
+func WithConnMaxIdleTime(d time.Duration) ConnectOption {
+	return func(o *connectOptions) {
+		o.connMaxIdleTime = d
+	}
 }
 
+// WithStatementTimeout cancels statements running longer than d, unless
+// the context of the statement has an earlier deadline, or is
+// WithoutStatementTimeout (as those of backups).
+func WithStatementTimeout(d time.Duration) ConnectOption {
+	return func(o *connectOptions) {
+		o.statementTimeout = d
+	}
+}
+
+// WithConnectRetries retries to connect n times on failure, sleeping for
+// backoff (1s by default) before the first retry, and doubling it (up to
+// 30s) for the next ones. It helps when the database starts along with
+// the app.
+func WithConnectRetries(n int, backoff time.Duration) ConnectOption {
+	return func(o *connectOptions) {
+		o.retries = n
+		o.backoff = backoff
+	}
+}
+
+// WithPrepareStmt caches prepared statements for reuse.
+func WithPrepareStmt(enable bool) ConnectOption {
+	return func(o *connectOptions) {
+		o.prepareStmt = enable
+	}
+}
+
+// endregion connectOptions
+
 // region dbOpener
 
 // DBOpener opens a gorm Dialector.
@@ -53,7 +243,7 @@ func ConnectDB(driver DBDriver, dsn string) (*gorm.DB, error) {
 // 	- gorm.io/driver/sqlite:   https://github.com/go-gorm/sqlite/blob/1d1e7723862758a6e6a860f90f3e7a3bea9cc94a/sqlite.go#L28
 type DBOpener func(dsn string) gorm.Dialector
 
-// get DBOpener for the given driver
+// get DBOpener for the given driver, nil if unknown
 func getDBOpener(driver DBDriver) DBOpener {
 	switch driver {
 	case DBDriverMySQL:
@@ -63,25 +253,45 @@ func getDBOpener(driver DBDriver) DBOpener {
 	case DBDriverPostgres:
 		return postgres.Open
 	default:
-		//panic("unknown database driver: " + driver)
-		logger.WithField("driver", driver).
-			Fatal("unknown database driver")
+		return nil
 	}
-	return nil // unreachable, make compiler happy
 }
 
 // endregion dbOpener
 
+// registeredModels are models registered by RegisterModel, in order.
+var registeredModels []any
+
+// AutoMigrate makes RegisterModel call gorm.AutoMigrate. Turn it off to
+// manage the schema by versioned migrations only, see Migrate.
+var AutoMigrate = true
+
 // RegisterModel registers the given model to the database.
 // Arguments should be pointers to model structs.
 //
-// It calls gorm.AutoMigrate to migrate the database.
+// It calls gorm.AutoMigrate to migrate the database, if AutoMigrate.
 func RegisterModel(m ...any) error {
-	err := DB.AutoMigrate(m...)
-	if err != nil {
-		logger.WithError(err).
-			Errorf("RegisterModel: AutoMigrate failed")
-		return err
+	if AutoMigrate {
+		err := DB.AutoMigrate(m...)
+		if err != nil {
+			logger.WithError(err).
+				Errorf("RegisterModel: AutoMigrate failed")
+			return err
+		}
 	}
+	registeredModels = append(registeredModels, m...)
 	return nil
 }
+
+// RegisteredModels returns all models registered by RegisterModel,
+// in the order they were registered.
+func RegisteredModels() []any {
+	return append([]any(nil), registeredModels...)
+}
+
+// SetRegisteredModels replaces the models registered by RegisterModel,
+// without migrating them, e.g. to put back those of RegisteredModels after
+// a test registered its own.
+func SetRegisteredModels(models []any) {
+	registeredModels = append([]any(nil), models...)
+}
@@ -0,0 +1,85 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestConnectDB(t *testing.T) {
+	if _, err := ConnectDB("oracle", "dsn"); !errors.Is(err, ErrUnknownDriver) {
+		t.Errorf("ConnectDB(oracle) error = %v, want ErrUnknownDriver", err)
+	}
+
+	connected, err := ConnectDB(DBDriverSqlite, "file::memory:",
+		WithMaxOpenConns(1), WithStatementTimeout(50*time.Millisecond), WithPrepareStmt(true))
+	if err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	sqlDB, _ := connected.DB()
+	if sqlDB.Stats().MaxOpenConnections != 1 {
+		t.Errorf("MaxOpenConnections = %d, want 1", sqlDB.Stats().MaxOpenConnections)
+	}
+
+	var n int64
+	slow := "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM (SELECT x FROM c LIMIT 1000000000)"
+	start := time.Now()
+	if err := DB.Raw(slow).Scan(&n).Error; err == nil {
+		t.Errorf("slow query error = nil, want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("slow query took %v, want it canceled", elapsed)
+	}
+	if err := DB.WithContext(context.Background()).Raw("SELECT 1").Scan(&n).Error; err != nil || n != 1 {
+		t.Errorf("query after timeout = %v, %v", n, err)
+	}
+
+	// failed to connect, after retries: DB is kept
+	_, err = ConnectDB(DBDriverSqlite, "/nonexistent/dir/test.db", WithConnectRetries(2, time.Millisecond))
+	if err == nil {
+		t.Errorf("ConnectDB(bad dsn) error = nil")
+	}
+	if DB != connected {
+		t.Errorf("DB changed by a failed ConnectDB")
+	}
+}
+
+func TestReadDB(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ConnectDB(DBDriverSqlite, filepath.Join(dir, "primary.db")); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	ctx := context.Background()
+	if ReadDB(ctx).ConnPool != DB.ConnPool {
+		t.Errorf("ReadDB() without replicas, want the primary")
+	}
+
+	replicaDSNs := []string{filepath.Join(dir, "replica1.db"), filepath.Join(dir, "replica2.db")}
+	if err := UseReplicas(DBDriverSqlite, replicaDSNs); err != nil {
+		t.Fatalf("UseReplicas() error = %v", err)
+	}
+	defer UseReplicas(DBDriverSqlite, nil)
+
+	for _, db := range append([]*gorm.DB{DB}, replicas...) {
+		db.Exec("CREATE TABLE things (name TEXT)")
+	}
+	DB.Exec("INSERT INTO things VALUES ('written')")
+
+	var count int64
+	for i := 0; i < 4; i++ {
+		if err := ReadDB(ctx).Table("things").Count(&count).Error; err != nil || count != 0 {
+			t.Errorf("ReadDB() count = %v, %v, want 0 from a replica", count, err)
+		}
+	}
+	if err := ReadDB(ReadYourWrites(ctx)).Table("things").Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("ReadDB(ReadYourWrites) count = %v, %v, want 1 from the primary", count, err)
+	}
+
+	if err := UseReplicas("oracle", []string{"dsn"}); !errors.Is(err, ErrUnknownDriver) {
+		t.Errorf("UseReplicas(oracle) error = %v, want ErrUnknownDriver", err)
+	}
+}
@@ -0,0 +1,73 @@
+package orm
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// ReadPrimaryKey is the gin.Context key to read from the primary database
+// instead of the replicas: a gin.Context with c.Set(ReadPrimaryKey, true)
+// reads its own writes. It's a string for gin.Context.Value to find it,
+// other contexts use ReadYourWrites.
+const ReadPrimaryKey = "crud_read_primary"
+
+// readPrimaryKey is the context key of ReadYourWrites.
+type readPrimaryKey struct{}
+
+var (
+	replicas    []*gorm.DB
+	replicasMu  sync.RWMutex
+	replicaNext uint64
+)
+
+// UseReplicas connects to the read replicas of the DB, with the same driver
+// and options of ConnectDB. Reads outside of transactions, see ReadDB, are
+// routed to them in turn. Writes always go to the primary DB.
+//
+// Calling it with no dsns stops reading from replicas.
+func UseReplicas(driver DBDriver, dsns []string, options ...ConnectOption) error {
+	connected := make([]*gorm.DB, 0, len(dsns))
+	for _, dsn := range dsns {
+		db, err := connect(driver, dsn, options...)
+		if err != nil {
+			return err
+		}
+		connected = append(connected, db)
+	}
+
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	replicas = connected
+	return nil
+}
+
+// ReadDB returns a session to read from: one of the replicas (round robin),
+// or the primary DB if there is no replica or the ctx asks for it, see
+// ReadYourWrites and ReadPrimaryKey.
+//
+// Do not use it to read inside transactions, or before writes: replicas
+// may lag behind the primary.
+func ReadDB(ctx context.Context) *gorm.DB {
+	if ctx.Value(readPrimaryKey{}) != nil {
+		return DB.WithContext(ctx)
+	}
+	if primary, _ := ctx.Value(ReadPrimaryKey).(bool); primary {
+		return DB.WithContext(ctx)
+	}
+
+	replicasMu.RLock()
+	defer replicasMu.RUnlock()
+	if len(replicas) == 0 {
+		return DB.WithContext(ctx)
+	}
+	i := atomic.AddUint64(&replicaNext, 1)
+	return replicas[i%uint64(len(replicas))].WithContext(ctx)
+}
+
+// ReadYourWrites returns a ctx reading from the primary DB, see ReadDB.
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readPrimaryKey{}, true)
+}
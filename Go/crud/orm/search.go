@@ -0,0 +1,484 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// SearchableTag is the value of the crud struct tag to make a string field
+// full-text searchable (see EnableSearch):
+//
+//    type Todo struct {
+//        orm.BasicModel
+//        Title  string `crud:"searchable"`
+//        Detail string `crud:"searchable"`
+//    }
+const SearchableTag = "searchable"
+
+// SearchLanguage is the text search configuration of Postgres used for
+// searching, e.g. "english" to stem words. Change it before EnableSearch.
+var SearchLanguage = "simple"
+
+var (
+	ErrSearchNotEnabled         = errors.New("search is not enabled for the model")
+	ErrSearchNoSearchableFields = errors.New("no searchable fields in the model")
+	ErrSearchEncryptedFields    = errors.New("encrypted fields are not searchable")
+	ErrSearchUnsupportedDriver  = errors.New("search is not supported by the database driver")
+)
+
+// searchIndex is the full-text index of a table.
+type searchIndex struct {
+	driver  string   // DB.Dialector.Name()
+	table   string   // the model table
+	key     string   // primary key column
+	columns []string // searchable columns
+	fts     string   // sqlite: "fts5" or "fts4"
+}
+
+// searchIndexes are the tables with search enabled: table => *searchIndex
+var searchIndexes sync.Map
+
+// EnableSearch creates the full-text indexes for the searchable fields
+// (tagged with `crud:"searchable"`) of the given models (which should have
+// been registered by RegisterModel), depending on the database driver:
+//
+//  - sqlite: an external content FTS5 virtual table "<table>_search", kept
+//    in sync with the model table by triggers. FTS5 is an optional feature
+//    of go-sqlite3, build with `-tags sqlite_fts5` to enable it, otherwise
+//    FTS4 is used instead (with a simpler ranking).
+//  - postgres: a generated tsvector column "crud_search" with a GIN index.
+//  - mysql: a FULLTEXT index "idx_<table>_search".
+//
+// Indexes of sqlite are rebuilt when the searchable fields change. For
+// postgres and mysql, drop the column or index to rebuild it.
+//
+// Use Search to query and SearchSnippets to highlight the matches.
+func EnableSearch(models ...any) error {
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		index := &searchIndex{
+			driver: DB.Dialector.Name(),
+			table:  stmt.Schema.Table,
+		}
+		if stmt.Schema.PrioritizedPrimaryField != nil {
+			index.key = stmt.Schema.PrioritizedPrimaryField.DBName
+		}
+		for _, field := range stmt.Schema.Fields {
+			if !hasCrudTag(field.Tag, SearchableTag) || field.DBName == "" {
+				continue
+			}
+			if strings.EqualFold(field.TagSettings["SERIALIZER"], EncryptedSerializerName) {
+				return fmt.Errorf("%w: %s.%s", ErrSearchEncryptedFields, stmt.Schema.Name, field.Name)
+			}
+			index.columns = append(index.columns, field.DBName)
+		}
+		if len(index.columns) == 0 {
+			return fmt.Errorf("%w: %s", ErrSearchNoSearchableFields, stmt.Schema.Name)
+		}
+
+		var err error
+		switch index.driver {
+		case DBDriverSqlite:
+			err = index.createSqlite()
+		case DBDriverPostgres:
+			err = index.createPostgres()
+		case DBDriverMySQL:
+			err = index.createMySQL()
+		default:
+			err = fmt.Errorf("%w: %s", ErrSearchUnsupportedDriver, index.driver)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("table", index.table).
+				Error("EnableSearch: create index failed")
+			return err
+		}
+
+		searchIndexes.Store(index.table, index)
+		logger.WithField("table", index.table).
+			WithField("columns", index.columns).
+			Info("EnableSearch: search enabled")
+	}
+	return nil
+}
+
+// isSearchTable reports whether the table is a FTS table of a search
+// index, or one of its shadow tables (sqlite).
+func isSearchTable(table string) bool {
+	found := false
+	searchIndexes.Range(func(_, index any) bool {
+		fts := index.(*searchIndex).ftsTable()
+		found = table == fts || strings.HasPrefix(table, fts+"_")
+		return !found
+	})
+	return found
+}
+
+// SearchEnabled reports whether search is enabled for the model.
+func SearchEnabled(model any) bool {
+	_, err := getSearchIndex(DB, model)
+	return err == nil
+}
+
+// Search adds a full-text search for q to the query of model: only
+// matched records are selected, ordered by relevance (most relevant
+// first, after any order already set).
+//
+// An ErrSearchNotEnabled is added to db if search is not enabled for the
+// model, see EnableSearch.
+func Search(db *gorm.DB, model any, q string) *gorm.DB {
+	index, err := getSearchIndex(db, model)
+	if err != nil {
+		_ = db.AddError(err)
+		return db
+	}
+
+	query, args := index.matchQuery(q)
+	if query == "" {
+		return db
+	}
+	join := fmt.Sprintf("JOIN (%s) AS crud_search ON crud_search.crud_search_key = %s.%s",
+		query, db.Statement.Quote(index.table), db.Statement.Quote(index.joinKey()))
+	return db.Joins(join, args...).Order("crud_search.crud_search_score DESC")
+}
+
+// SearchSnippets returns snippets of the records (of model, by their
+// primary keys ids) matching q, with the matched words highlighted by
+// <mark></mark>. The snippets are keyed by fmt.Sprint(id).
+//
+// The snippets are HTML escaped, but for the <mark> tags, to be put into
+// HTML pages as they are.
+func SearchSnippets(ctx context.Context, model any, q string, ids []any) (map[string]string, error) {
+	index, err := getSearchIndex(DB, model)
+	if err != nil {
+		return nil, err
+	}
+	snippets := map[string]string{}
+	if len(ids) == 0 {
+		return snippets, nil
+	}
+
+	var rows []struct {
+		Key     string `gorm:"column:crud_search_key"`
+		Snippet string `gorm:"column:crud_search_snippet"`
+	}
+	switch index.driver {
+	case DBDriverSqlite:
+		terms := searchTerms(q)
+		if len(terms) == 0 {
+			return snippets, nil
+		}
+		snippet := fmt.Sprintf("snippet(%s, -1, ?, ?, '…', 16)", index.ftsTable())
+		if index.fts == "fts4" {
+			snippet = fmt.Sprintf("snippet(%s, ?, ?, '…', -1, 16)", index.ftsTable())
+		}
+		err = DB.WithContext(ctx).Raw(fmt.Sprintf(
+			"SELECT %[1]s.%[2]s AS crud_search_key, %[3]s AS crud_search_snippet FROM %[4]s JOIN %[1]s ON %[1]s.rowid = %[4]s.rowid "+
+				"WHERE %[4]s MATCH ? AND %[1]s.%[2]s IN ?",
+			index.table, index.key, snippet, index.ftsTable()),
+			markStart, markStop, sqliteMatch(terms), ids).Scan(&rows).Error
+	case DBDriverPostgres:
+		err = DB.WithContext(ctx).Raw(fmt.Sprintf(
+			"SELECT %s AS crud_search_key, ts_headline(?, %s, websearch_to_tsquery(?, ?), ?) AS crud_search_snippet "+
+				"FROM %s WHERE %s IN ?",
+			index.key, index.document(), index.table, index.key),
+			SearchLanguage, SearchLanguage, q,
+			fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=24, MinWords=8", markStart, markStop),
+			ids).Scan(&rows).Error
+	case DBDriverMySQL:
+		var records []map[string]any
+		err = DB.WithContext(ctx).Table(index.table).
+			Select(append([]string{index.key}, index.columns...)).
+			Where(fmt.Sprintf("%s IN ?", index.key), ids).
+			Find(&records).Error
+		for _, record := range records {
+			for _, column := range index.columns {
+				if snippet, ok := highlight(fmt.Sprint(record[column]), searchTerms(q), 24); ok {
+					snippets[fmt.Sprint(record[index.key])] = escapeSnippet(snippet)
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			WithField("table", index.table).
+			Warn("SearchSnippets: query failed")
+		return nil, err
+	}
+
+	for _, row := range rows {
+		snippets[row.Key] = escapeSnippet(row.Snippet)
+	}
+	return snippets, nil
+}
+
+// markStart and markStop mark the matches in the snippets from the
+// databases, to be replaced by the <mark> tags after escaping the rest,
+// see escapeSnippet. They are control characters not expected in texts.
+const (
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+// snippetReplacer replaces the marks of matches with the <mark> tags.
+var snippetReplacer = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// escapeSnippet HTML escapes the snippet, and then turns its marks of
+// matches (markStart, markStop) into <mark> tags.
+func escapeSnippet(snippet string) string {
+	return snippetReplacer.Replace(html.EscapeString(snippet))
+}
+
+func getSearchIndex(db *gorm.DB, model any) (*searchIndex, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	index, ok := searchIndexes.Load(stmt.Schema.Table)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSearchNotEnabled, stmt.Schema.Name)
+	}
+	return index.(*searchIndex), nil
+}
+
+// matchQuery returns the subquery selecting the keys (crud_search_key)
+// of matched records and their scores (crud_search_score).
+func (index *searchIndex) matchQuery(q string) (query string, args []any) {
+	switch index.driver {
+	case DBDriverSqlite:
+		terms := searchTerms(q)
+		if len(terms) == 0 {
+			return "", nil
+		}
+		// bm25 is smaller for better matches,
+		// and fts4 has no built-in ranking: count the matched terms
+		score := fmt.Sprintf("-bm25(%s)", index.ftsTable())
+		if index.fts == "fts4" {
+			score = fmt.Sprintf("length(offsets(%[1]s)) - length(replace(offsets(%[1]s), ' ', ''))", index.ftsTable())
+		}
+		return fmt.Sprintf("SELECT rowid AS crud_search_key, %s AS crud_search_score FROM %s WHERE %s MATCH ?",
+			score, index.ftsTable(), index.ftsTable()), []any{sqliteMatch(terms)}
+	case DBDriverPostgres:
+		return fmt.Sprintf("SELECT %s AS crud_search_key, ts_rank(crud_search, websearch_to_tsquery(?, ?)) AS crud_search_score "+
+				"FROM %s WHERE crud_search @@ websearch_to_tsquery(?, ?)", index.key, index.table),
+			[]any{SearchLanguage, q, SearchLanguage, q}
+	case DBDriverMySQL:
+		match := fmt.Sprintf("MATCH (%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(index.columns, ", "))
+		return fmt.Sprintf("SELECT %s AS crud_search_key, %s AS crud_search_score FROM %s WHERE %s",
+			index.key, match, index.table, match), []any{q, q}
+	}
+	return "", nil
+}
+
+// joinKey is the column of the model table to join the matched keys on.
+func (index *searchIndex) joinKey() string {
+	if index.driver == DBDriverSqlite {
+		return "rowid"
+	}
+	return index.key
+}
+
+// region sqlite
+
+func (index *searchIndex) ftsTable() string {
+	return index.table + "_search"
+}
+
+// createSqlite creates the FTS table and its triggers, following
+// https://www.sqlite.org/fts5.html#external_content_tables
+// and https://www.sqlite.org/fts3.html#_external_content_fts4_tables_
+func (index *searchIndex) createSqlite() error {
+	var fts5 bool
+	if err := DB.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		return err
+	}
+	index.fts = "fts5"
+	if !fts5 {
+		logger.WithField("table", index.table).
+			Warn("EnableSearch: sqlite is built without FTS5 (build with -tags sqlite_fts5), fallback to FTS4")
+		index.fts = "fts4"
+	}
+
+	fts := index.ftsTable()
+	create := fmt.Sprintf("CREATE VIRTUAL TABLE %s USING %s(%s, content='%s')",
+		fts, index.fts, strings.Join(index.columns, ", "), index.table)
+
+	var existing string
+	DB.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", fts).Scan(&existing)
+	if existing == create {
+		return nil
+	}
+
+	columns := strings.Join(index.columns, ", ")
+	values := func(row string) string {
+		var values []string
+		for _, column := range index.columns {
+			values = append(values, row+"."+column)
+		}
+		return strings.Join(values, ", ")
+	}
+
+	var triggers []string
+	if index.fts == "fts5" {
+		insert := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);", fts, columns, values("new"))
+		remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);", fts, fts, columns, values("old"))
+		triggers = []string{
+			fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", fts, index.table, insert),
+			fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN %s END", fts, index.table, remove),
+			fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s BEGIN %s %s END", fts, index.table, remove, insert),
+		}
+	} else {
+		insert := fmt.Sprintf("INSERT INTO %s(docid, %s) VALUES (new.rowid, %s);", fts, columns, values("new"))
+		remove := fmt.Sprintf("DELETE FROM %s WHERE docid = old.rowid;", fts)
+		triggers = []string{
+			fmt.Sprintf("CREATE TRIGGER %s_bu BEFORE UPDATE ON %s BEGIN %s END", fts, index.table, remove),
+			fmt.Sprintf("CREATE TRIGGER %s_bd BEFORE DELETE ON %s BEGIN %s END", fts, index.table, remove),
+			fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s BEGIN %s END", fts, index.table, insert),
+			fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", fts, index.table, insert),
+		}
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// the searchable fields (or the FTS version) changed: recreate all
+		statements := []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", fts)}
+		for _, suffix := range []string{"ai", "ad", "au", "bu", "bd"} {
+			statements = append(statements, fmt.Sprintf("DROP TRIGGER IF EXISTS %s_%s", fts, suffix))
+		}
+		statements = append(statements, create)
+		statements = append(statements, triggers...)
+		statements = append(statements, fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts))
+
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sqliteMatch quotes terms as strings for a FTS MATCH, which are all
+// required to match (implicit AND).
+func sqliteMatch(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// endregion sqlite
+
+// region postgres & mysql
+
+// document is the SQL expression of the searchable text in postgres.
+func (index *searchIndex) document() string {
+	var columns []string
+	for _, column := range index.columns {
+		columns = append(columns, fmt.Sprintf("coalesce(%s, '')", column))
+	}
+	return strings.Join(columns, " || ' ' || ")
+}
+
+func (index *searchIndex) createPostgres() error {
+	if DB.Migrator().HasColumn(index.table, "crud_search") {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN crud_search tsvector GENERATED ALWAYS AS (to_tsvector('%s', %s)) STORED",
+			index.table, SearchLanguage, index.document())).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec(fmt.Sprintf("CREATE INDEX idx_%s_search ON %s USING GIN (crud_search)",
+			index.table, index.table)).Error
+	})
+}
+
+func (index *searchIndex) createMySQL() error {
+	name := "idx_" + index.table + "_search"
+	if DB.Migrator().HasIndex(index.table, name) {
+		return nil
+	}
+	return DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD FULLTEXT INDEX %s (%s)",
+		index.table, name, strings.Join(index.columns, ", "))).Error
+}
+
+// highlight returns a snippet of about size words of text around the first
+// matched term, with the matched words marked by markStart and markStop.
+// ok is false if no term matches.
+func highlight(text string, terms []string, size int) (snippet string, ok bool) {
+	words := strings.Fields(text)
+	first := -1
+	marked := make([]string, len(words))
+	for i, word := range words {
+		marked[i] = word
+		lower := strings.ToLower(word)
+		for _, term := range terms {
+			if strings.Contains(lower, strings.ToLower(term)) {
+				marked[i] = markStart + word + markStop
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	start := first - size/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + size
+	if end > len(words) {
+		end = len(words)
+	}
+	snippet = strings.Join(marked[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return snippet, true
+}
+
+// endregion postgres & mysql
+
+// searchTerms splits q into words, dropping the double quotes.
+func searchTerms(q string) []string {
+	var terms []string
+	for _, term := range strings.Fields(strings.ReplaceAll(q, `"`, " ")) {
+		if utf8.ValidString(term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// hasCrudTag reports whether the crud struct tag (comma-separated)
+// contains the given value.
+func hasCrudTag(tag reflect.StructTag, value string) bool {
+	for _, v := range strings.Split(tag.Get("crud"), ",") {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
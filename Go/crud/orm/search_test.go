@@ -0,0 +1,99 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type article struct {
+	BasicModel
+	Title string `crud:"searchable"`
+	Body  string `crud:"searchable"`
+	Views int
+}
+
+func TestSearch(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := DB.AutoMigrate(&article{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	// indexed by rebuilding
+	DB.Create(&article{Title: "milk", Body: "buy milk and eggs, more milk"})
+
+	if err := EnableSearch(article{}); err != nil {
+		t.Fatalf("EnableSearch() error = %v", err)
+	}
+	if err := EnableSearch(article{}); err != nil { // enabled again on restart
+		t.Fatalf("EnableSearch() again error = %v", err)
+	}
+	if err := EnableSearch(secretHolder{}); !errors.Is(err, ErrSearchNoSearchableFields) {
+		t.Errorf("EnableSearch(no searchable fields) error = %v", err)
+	}
+
+	// indexed by triggers
+	DB.Create(&article{Title: "eggs", Body: "buy eggs"})
+	updated := article{Title: "bread", Body: "buy milk"}
+	DB.Create(&updated)
+	DB.Model(&updated).Update("body", "buy bread")
+	deleted := article{Title: "deleted", Body: "milk"}
+	DB.Create(&deleted)
+	DB.Unscoped().Delete(&deleted)
+
+	search := func(q string) (titles []string) {
+		var articles []article
+		if err := Search(DB.Model(&article{}), &article{}, q).Find(&articles).Error; err != nil {
+			t.Fatalf("Search(%q) error = %v", q, err)
+		}
+		for _, a := range articles {
+			titles = append(titles, a.Title)
+		}
+		return titles
+	}
+
+	if got := fmt.Sprint(search("milk")); got != "[milk]" {
+		t.Errorf(`Search("milk") = %v, want [milk]`, got)
+	}
+	if got := fmt.Sprint(search("eggs")); got != "[eggs milk]" { // ranked
+		t.Errorf(`Search("eggs") = %v, want [eggs milk]`, got)
+	}
+	if got := fmt.Sprint(search(`"buy" bread`)); got != "[bread]" {
+		t.Errorf(`Search("buy bread") = %v, want [bread]`, got)
+	}
+
+	snippets, err := SearchSnippets(context.Background(), &article{}, "eggs", []any{1, 2})
+	if err != nil {
+		t.Fatalf("SearchSnippets() error = %v", err)
+	}
+	if len(snippets) != 2 || !strings.Contains(snippets["1"], "<mark>eggs</mark>") {
+		t.Errorf("SearchSnippets() = %v", snippets)
+	}
+
+	script := article{Title: `<script>alert("eggs")</script>`}
+	DB.Create(&script)
+	snippets, err = SearchSnippets(context.Background(), &article{}, "alert", []any{script.ID})
+	if err != nil {
+		t.Fatalf("SearchSnippets() error = %v", err)
+	}
+	if got, want := snippets[fmt.Sprint(script.ID)], `&lt;script&gt;<mark>alert</mark>(&#34;eggs&#34;)&lt;/script&gt;`; got != want {
+		t.Errorf("SearchSnippets(<script>) = %q, want %q", got, want)
+	}
+
+	if err := Search(DB.Model(&secretHolder{}), &secretHolder{}, "x").Find(&[]secretHolder{}).Error; !errors.Is(err, ErrSearchNotEnabled) {
+		t.Errorf("Search(not enabled) error = %v", err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got, ok := highlight("Buy some milk today", []string{"milk"}, 3)
+	if !ok || escapeSnippet(got) != "…some <mark>milk</mark> today" {
+		t.Errorf("highlight() = %q, %v", got, ok)
+	}
+	if _, ok := highlight("nothing here", []string{"milk"}, 3); ok {
+		t.Errorf("highlight() matched nothing")
+	}
+}
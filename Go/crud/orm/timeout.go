@@ -0,0 +1,80 @@
+package orm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// statementCancelKey is the gorm instance key of the cancel function of
+// a statement's timeout context.
+const statementCancelKey = "crud:statement_cancel"
+
+// noStatementTimeoutKey marks the contexts of statements not to time out,
+// see WithoutStatementTimeout.
+type noStatementTimeoutKey struct{}
+
+// WithoutStatementTimeout returns a copy of ctx whose statements are not
+// canceled by the statement timeout (see WithStatementTimeout), e.g. to
+// stream the rows of Rows() for longer than that. They are still canceled
+// with ctx.
+func WithoutStatementTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noStatementTimeoutKey{}, true)
+}
+
+// registerStatementTimeout registers callbacks to run statements with a
+// timeout (if their contexts have no earlier deadline, and are not
+// WithoutStatementTimeout).
+//
+// Contexts of Row(s) queries (e.g. Raw(...).Scan) are not canceled after
+// the callbacks, as their rows are read by the caller then: they expire
+// by the timeout only, which cuts off the rows not read by then. Stream
+// long ones WithoutStatementTimeout.
+func registerStatementTimeout(db *gorm.DB, timeout time.Duration) error {
+	before := func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if ctx.Value(noStatementTimeoutKey{}) != nil {
+			return
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(statementCancelKey, cancel)
+	}
+	after := func(db *gorm.DB) {
+		if cancel, ok := db.InstanceGet(statementCancelKey); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}
+
+	callbacks := db.Callback()
+	for name, register := range map[string]func(name string, fn func(*gorm.DB)) error{
+		"before_create": callbacks.Create().Before("*").Register,
+		"after_create":  callbacks.Create().After("*").Register,
+		"before_query":  callbacks.Query().Before("*").Register,
+		"after_query":   callbacks.Query().After("*").Register,
+		"before_update": callbacks.Update().Before("*").Register,
+		"after_update":  callbacks.Update().After("*").Register,
+		"before_delete": callbacks.Delete().Before("*").Register,
+		"after_delete":  callbacks.Delete().After("*").Register,
+		"before_raw":    callbacks.Raw().Before("*").Register,
+		"after_raw":     callbacks.Raw().After("*").Register,
+		"before_row":    callbacks.Row().Before("*").Register,
+	} {
+		fn := after
+		if strings.HasPrefix(name, "before_") {
+			fn = before
+		}
+		if err := register("crud:timeout_"+name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
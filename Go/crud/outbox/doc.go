@@ -0,0 +1,24 @@
+// Package outbox implements the transactional outbox pattern for domain
+// events (e.g. TodoCreated, ProjectTodoLinked).
+//
+// The service layer writes a Message for each mutation into the
+// outbox_messages table in the same transaction as the mutation, so that
+// an event is kept if and only if the mutation is committed, even if the
+// process crashes right after. A Relay then reads the unpublished
+// messages in the order of their IDs (see Relay for what is ordered) and
+// hands them to a Publisher (the event bus), marking them published on
+// success. Delivery is at-least-once: consumers should deduplicate by
+// Message.ID.
+//
+// Publishers provided here are MemoryBus (in-process, for tests and
+// prototypes), FilePublisher (appends NATS-style subject/data lines to
+// a file, a stand-in for a real broker) and BrokerPublisher (the change
+// events of models for the live feeds, see package broker). Implement
+// Publisher to plug in others, and combine them by Publishers.
+//
+// Once enabled, the outbox is the only path of the events of the service
+// layer: they are no longer published to the broker directly.
+//
+// Call Enable() after orm.ConnectDB to migrate the table and start
+// writing, and NewRelay(publisher).Start(ctx) to start relaying.
+package outbox
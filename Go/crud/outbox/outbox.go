@@ -0,0 +1,112 @@
+package outbox
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+var logger = log.ZoneLogger("crud/outbox")
+
+// Message is a domain event in the outbox.
+type Message struct {
+	ID          uint              `json:"id" gorm:"primarykey"` // increasing, the order to publish
+	CreatedAt   time.Time         `json:"created_at"`
+	Topic       string            `json:"topic" gorm:"index"`             // name of the event, e.g. "TodoCreated"
+	Key         string            `json:"key"`                            // id of the record the event is about
+	Payload     string            `json:"payload"`                        // JSON encoded event data
+	Headers     map[string]string `json:"headers" gorm:"serializer:json"` // metadata, e.g. actor, request_id
+	PublishedAt *time.Time        `json:"published_at" gorm:"index"`
+	Attempts    int               `json:"attempts"`
+	LastError   string            `json:"last_error,omitempty"`
+}
+
+// TableName of Message: outbox_messages
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Decode decodes the Payload into v.
+func (m *Message) Decode(v any) error {
+	return json.Unmarshal([]byte(m.Payload), v)
+}
+
+// eventTypes maps the verbs of domain events to the change event types.
+var eventTypes = []struct {
+	verb      string
+	eventType broker.EventType
+}{
+	{"Created", broker.EventCreated},
+	{"Updated", broker.EventUpdated},
+	{"Restored", broker.EventUpdated},
+	{"Deleted", broker.EventDeleted},
+	{"Purged", broker.EventDeleted},
+}
+
+// Event converts the domain event "<Model><Verb>" (e.g. TodoUpdated) to
+// the change event of the model (Todo) and the type (updated), with the ID
+// of the message. Restored events are updates, purged ones are deletes.
+// ok is false for other messages (e.g. of associations).
+func (m *Message) Event() (event broker.Event, ok bool) {
+	for _, t := range eventTypes {
+		model := strings.TrimSuffix(m.Topic, t.verb)
+		if model == m.Topic || model == "" {
+			continue
+		}
+		return broker.Event{
+			ID:      uint64(m.ID),
+			Time:    m.CreatedAt,
+			Type:    t.eventType,
+			Model:   model,
+			ModelID: m.Key,
+			Actor:   m.Headers["actor"],
+			Data:    json.RawMessage(m.Payload),
+		}, true
+	}
+	return event, false
+}
+
+var enabled int32 // 1 if enabled, accessed atomically
+
+// Enable migrates the outbox table and starts writing messages.
+// It should be called after orm.ConnectDB.
+func Enable() error {
+	if err := orm.RegisterModel(Message{}); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&enabled, 1)
+	return nil
+}
+
+// Enabled reports whether messages are written into the outbox.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Write writes a message of topic about the record key, with the payload
+// encoded as JSON, into the outbox using tx, which should be the
+// transaction of the mutation the message is about.
+//
+// It does nothing if the outbox is not Enabled.
+func Write(tx *gorm.DB, topic string, key string, payload any, headers map[string]string) error {
+	if !Enabled() {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// a new statement in the same transaction, not to mess up tx's
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&Message{
+		Topic:   topic,
+		Key:     key,
+		Payload: string(data),
+		Headers: headers,
+	}).Error
+}
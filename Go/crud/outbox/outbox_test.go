@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+type todo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+}
+
+func TestOutbox(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := orm.RegisterModel(todo{}); err != nil {
+		t.Fatalf("RegisterModel() error = %v", err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	ctx := context.Background()
+
+	create := func(title string, fail bool) error {
+		return orm.DB.Transaction(func(tx *gorm.DB) error {
+			record := todo{Title: title}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			if err := Write(tx, "TodoCreated", "", record, nil); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("crash")
+			}
+			return nil
+		})
+	}
+
+	t.Run("written with the mutation", func(t *testing.T) {
+		_ = create("a", false)
+		_ = create("rolled back", true)
+		_ = create("b", false)
+
+		var count int64
+		orm.DB.Model(&Message{}).Count(&count)
+		if count != 2 {
+			t.Errorf("outbox has %v messages, want 2", count)
+		}
+	})
+
+	t.Run("relay in order with retries", func(t *testing.T) {
+		bus := NewMemoryBus()
+		fail := true
+		bus.Subscribe("TodoCreated", func(msg Message) error {
+			var record todo
+			if err := msg.Decode(&record); err != nil {
+				return err
+			}
+			if record.Title == "b" && fail {
+				fail = false
+				return errors.New("bus unavailable")
+			}
+			return nil
+		})
+		relay := NewRelay(bus)
+
+		if n, err := relay.RelayPending(ctx); n != 1 || err == nil {
+			t.Errorf("first RelayPending() = %v, %v, want 1 and an error", n, err)
+		}
+		if n, err := relay.RelayPending(ctx); n != 1 || err != nil {
+			t.Errorf("second RelayPending() = %v, %v, want 1, nil", n, err)
+		}
+		if n, _ := relay.RelayPending(ctx); n != 0 {
+			t.Errorf("third RelayPending() = %v, want 0", n)
+		}
+
+		messages := bus.Messages("TodoCreated")
+		if len(messages) != 2 || messages[0].ID > messages[1].ID {
+			t.Errorf("bus.Messages() = %+v, want 2 in order", messages)
+		}
+	})
+
+	t.Run("file publisher", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		publisher, err := NewFilePublisher(path, "crud")
+		if err != nil {
+			t.Fatalf("NewFilePublisher() error = %v", err)
+		}
+		_ = publisher.Publish(ctx, &Message{ID: 1, Topic: "TodoCreated", Payload: `{"title":"a"}`})
+		_ = publisher.Close()
+
+		subjects, messages, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if len(messages) != 1 || subjects[0] != "crud.TodoCreated" || messages[0].Payload != `{"title":"a"}` {
+			t.Errorf("ReadFile() = %v, %+v", subjects, messages)
+		}
+	})
+
+	t.Run("broker publisher", func(t *testing.T) {
+		b := broker.New(8)
+		sub := b.Subscribe("Todo", 0)
+		defer sub.Close()
+		publisher := NewBrokerPublisher(b)
+
+		_ = publisher.Publish(ctx, &Message{ID: 1, Topic: "ProjectTodoLinked", Key: "1"})
+		_ = publisher.Publish(ctx, &Message{ID: 2, Topic: "TodoRestored", Key: "7",
+			Payload: `{"title":"a"}`, Headers: map[string]string{"actor": "a@example.com"}})
+
+		event := <-sub.Events()
+		if event.Type != broker.EventUpdated || event.Model != "Todo" || event.ModelID != "7" ||
+			event.Actor != "a@example.com" || string(event.Data.(json.RawMessage)) != `{"title":"a"}` {
+			t.Errorf("event = %+v, want the update of Todo 7", event)
+		}
+		select {
+		case event := <-sub.Events():
+			t.Errorf("unexpected event %+v", event)
+		default:
+		}
+	})
+}
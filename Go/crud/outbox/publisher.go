@@ -0,0 +1,211 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cdfmlr/crud/broker"
+)
+
+// Publisher publishes messages relayed from the outbox to an event bus.
+//
+// Publish should return nil only if the message is accepted by the bus,
+// otherwise it will be retried. It may be called again with a message
+// published before (e.g. after a crash), so consumers should deduplicate
+// by Message.ID.
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// PublisherFunc adapts a function to a Publisher.
+type PublisherFunc func(ctx context.Context, msg *Message) error
+
+// Publish calls f(ctx, msg).
+func (f PublisherFunc) Publish(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Publishers is a Publisher publishing messages to all of its Publishers in
+// turn. A message failed on any of them is retried on all of them, so they
+// should deduplicate it, as any consumer should.
+type Publishers []Publisher
+
+// Publish implements Publisher.
+func (ps Publishers) Publish(ctx context.Context, msg *Message) error {
+	for _, p := range ps {
+		if err := p.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BrokerPublisher publishes the domain events of models to a broker as
+// their change events (see Message.Event), for the live feeds of them
+// (e.g. the Server-Sent Events in controller). Other messages are skipped.
+//
+// The broker never fails, so put it after the Publishers that may, not to
+// publish a message to it again when they are retried.
+type BrokerPublisher struct {
+	broker *broker.Broker
+}
+
+// NewBrokerPublisher creates a BrokerPublisher to b.
+func NewBrokerPublisher(b *broker.Broker) *BrokerPublisher {
+	return &BrokerPublisher{broker: b}
+}
+
+// Publish implements Publisher.
+func (p *BrokerPublisher) Publish(ctx context.Context, msg *Message) error {
+	if event, ok := msg.Event(); ok {
+		p.broker.Publish(event)
+	}
+	return nil
+}
+
+// MemoryBus is an in-process Publisher, dispatching messages to the
+// handlers subscribed to their topics synchronously. It keeps all the
+// published messages, see Messages.
+type MemoryBus struct {
+	mu       sync.Mutex
+	handlers map[string][]func(msg Message) error
+	messages []Message
+}
+
+// NewMemoryBus creates an empty MemoryBus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{handlers: map[string][]func(msg Message) error{}}
+}
+
+// Subscribe adds a handler of the messages of topic ("" for all topics).
+// A handler error fails the Publish, so the message is retried.
+func (b *MemoryBus) Subscribe(topic string, handler func(msg Message) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[topic] = append(b.handlers[topic], handler)
+}
+
+// Publish implements Publisher.
+func (b *MemoryBus) Publish(ctx context.Context, msg *Message) error {
+	b.mu.Lock()
+	var handlers []func(msg Message) error
+	handlers = append(handlers, b.handlers[msg.Topic]...)
+	handlers = append(handlers, b.handlers[""]...)
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		if err := handler(*msg); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	b.messages = append(b.messages, *msg)
+	b.mu.Unlock()
+	return nil
+}
+
+// Messages returns the published messages of the topics (all if none
+// given), in the published order.
+func (b *MemoryBus) Messages(topics ...string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []Message
+	for _, msg := range b.messages {
+		if len(topics) == 0 || contains(topics, msg.Topic) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func contains(s []string, e string) bool {
+	for _, x := range s {
+		if x == e {
+			return true
+		}
+	}
+	return false
+}
+
+// FilePublisher is a stand-in for a NATS-like broker: it appends each
+// message to a file as two lines, in the manner of the NATS protocol:
+//    PUB <subject> <message id>
+//    <JSON encoded Message>
+// where the subject is "<prefix>.<topic>". Use ReadFile to read them back.
+type FilePublisher struct {
+	mu     sync.Mutex
+	file   *os.File
+	prefix string
+}
+
+// NewFilePublisher opens (or creates) the file at path to append messages
+// with subjects "<subjectPrefix>.<topic>".
+func NewFilePublisher(path string, subjectPrefix string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: file, prefix: subjectPrefix}, nil
+}
+
+// Publish implements Publisher. The message is synced to disk before it
+// returns.
+func (p *FilePublisher) Publish(ctx context.Context, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = fmt.Fprintf(p.file, "PUB %s %d\n%s\n", p.subject(msg.Topic), msg.ID, data)
+	if err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) subject(topic string) string {
+	if p.prefix == "" {
+		return topic
+	}
+	return p.prefix + "." + topic
+}
+
+// Close closes the file.
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
+
+// ReadFile reads the messages written by a FilePublisher at path, with
+// their subjects.
+func ReadFile(path string) (subjects []string, messages []Message, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "PUB" || !scanner.Scan() {
+			return nil, nil, fmt.Errorf("outbox: malformed file %s", path)
+		}
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, nil, err
+		}
+		subjects = append(subjects, fields[1])
+		messages = append(messages, msg)
+	}
+	return subjects, messages, scanner.Err()
+}
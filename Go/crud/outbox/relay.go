@@ -0,0 +1,154 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+)
+
+// Relay reads unpublished messages from the outbox and publishes them.
+//
+// Messages are published one by one in the order of their IDs. A failed
+// one stops the round (and is retried in the next one), so that messages
+// after it in the round never overtake it. Run a single Relay for a
+// database.
+//
+// IDs are assigned when messages are written, not when their transactions
+// commit. So a message of a transaction committing late (e.g. on Postgres)
+// may be published after ones with greater IDs: there is no global order.
+// Messages about the same record are still in the order of its mutations,
+// as each of them locks the record until it commits.
+type Relay struct {
+	publisher Publisher
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+}
+
+// RelayOption configures a Relay.
+type RelayOption func(r *Relay)
+
+// WithRelayInterval sets how often the outbox is checked for unpublished
+// messages. By default, every second.
+func WithRelayInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.interval = interval
+	}
+}
+
+// WithBatchSize sets the max number of messages published in a round.
+// By default, 100.
+func WithBatchSize(batchSize int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = batchSize
+	}
+}
+
+// WithRetention sets how long published messages are kept in the outbox
+// before they are deleted, 0 to keep them forever. By default, 7 days.
+func WithRetention(retention time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.retention = retention
+	}
+}
+
+// NewRelay creates a Relay publishing to publisher.
+func NewRelay(publisher Publisher, options ...RelayOption) *Relay {
+	r := &Relay{
+		publisher: publisher,
+		interval:  time.Second,
+		batchSize: 100,
+		retention: 7 * 24 * time.Hour,
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Start starts a goroutine to relay messages every interval, until ctx
+// is done.
+func (r *Relay) Start(ctx context.Context) {
+	logger.WithField("interval", r.interval).
+		Info("Relay: relaying outbox messages in background")
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for {
+				n, err := r.RelayPending(ctx)
+				if err != nil || n < r.batchSize { // drained, or wait before retrying
+					break
+				}
+			}
+			if r.retention > 0 {
+				_, _ = r.Cleanup(ctx, time.Now().Add(-r.retention))
+			}
+		}
+	}()
+}
+
+// RelayPending publishes a batch of unpublished messages, in order.
+// published is the number of messages published, err is the error of the
+// first failed one, if any.
+func (r *Relay) RelayPending(ctx context.Context) (published int, err error) {
+	var messages []Message
+	err = orm.DB.WithContext(ctx).
+		Where("published_at IS NULL").
+		Order("id").Limit(r.batchSize).
+		Find(&messages).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("RelayPending: find messages failed")
+		return 0, err
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		if err := r.publisher.Publish(ctx, msg); err != nil {
+			logger.WithContext(ctx).WithError(err).
+				WithField("message", msg.ID).
+				WithField("topic", msg.Topic).
+				Warn("RelayPending: publish failed, will retry")
+			orm.DB.WithContext(ctx).Model(msg).Updates(map[string]any{
+				"attempts":   msg.Attempts + 1,
+				"last_error": err.Error(),
+			})
+			return published, err
+		}
+
+		now := time.Now()
+		err := orm.DB.WithContext(ctx).Model(msg).Updates(map[string]any{
+			"published_at": &now,
+			"attempts":     msg.Attempts + 1,
+			"last_error":   "",
+		}).Error
+		if err != nil { // it will be published again
+			logger.WithContext(ctx).WithError(err).
+				WithField("message", msg.ID).
+				Error("RelayPending: mark published failed")
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// Cleanup deletes the messages published before the given time.
+func (r *Relay) Cleanup(ctx context.Context, before time.Time) (rowsAffected int64, err error) {
+	result := orm.DB.WithContext(ctx).
+		Where("published_at < ?", before).
+		Delete(&Message{})
+	if result.Error != nil {
+		logger.WithContext(ctx).WithError(result.Error).
+			Warn("Cleanup: failed")
+	}
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,87 @@
+package router_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+)
+
+func TestAggregate(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}, &model.Project{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos",
+		router.Aggregate[model.Todo]("done", "id", "created_at"))
+	projects := crudtest.Crud[model.Project](app, "/projects",
+		router.Aggregate[model.Project]("todos", "todos.done", "id"))
+
+	for _, todo := range []model.Todo{{Title: "a", Done: true}, {Title: "b", Done: true}, {Title: "c"}} {
+		_, res := todos.Create(&todo)
+		res.AssertStatus(http.StatusOK)
+	}
+	// home: a, c; work: a, b; idle: none; trashed: c
+	for _, project := range []model.Project{
+		{Title: "home", Todos: []*model.Todo{{BasicModel: orm.BasicModel{ID: 1}}, {BasicModel: orm.BasicModel{ID: 3}}}},
+		{Title: "work", Todos: []*model.Todo{{BasicModel: orm.BasicModel{ID: 1}}, {BasicModel: orm.BasicModel{ID: 2}}}},
+		{Title: "idle"},
+		{Title: "trashed", Todos: []*model.Todo{{BasicModel: orm.BasicModel{ID: 3}}}},
+	} {
+		if err := app.DB.Omit("Todos.*").Create(&project).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	projects.Delete(4).AssertStatus(http.StatusOK)
+
+	t.Run("group by", func(t *testing.T) {
+		res := todos.Do(http.MethodGet, "/_aggregate?group_by=done&agg=count&agg=sum:id&agg=avg:id&agg=min:id&agg=max:id", nil)
+		res.AssertStatus(http.StatusOK).
+			AssertField("columns", []map[string]string{
+				{"name": "done", "type": "bool"},
+				{"name": "count", "type": "int"},
+				{"name": "sum_id", "type": "int"},
+				{"name": "avg_id", "type": "float"},
+				{"name": "min_id", "type": "int"},
+				{"name": "max_id", "type": "int"},
+			}).
+			AssertField("rows", [][]any{{false, 1, 3, 3, 3, 3}, {true, 2, 3, 1.5, 1, 2}})
+	})
+
+	t.Run("count by default", func(t *testing.T) {
+		todos.Do(http.MethodGet, "/_aggregate", nil).
+			AssertStatus(http.StatusOK).
+			AssertField("rows", [][]any{{3}})
+		todos.Do(http.MethodGet, "/_aggregate?group_by=done&filter_by=title&filter_value=c", nil).
+			AssertStatus(http.StatusOK).
+			AssertField("rows", [][]any{{false, 1}})
+	})
+
+	t.Run("allow list", func(t *testing.T) {
+		todos.Do(http.MethodGet, "/_aggregate?group_by=title", nil).
+			AssertError(http.StatusBadRequest, "column is not allowed")
+		todos.Do(http.MethodGet, "/_aggregate?agg=max:title", nil).
+			AssertError(http.StatusBadRequest, "column is not allowed")
+		todos.Do(http.MethodGet, "/_aggregate?agg=sum:created_at", nil).
+			AssertError(http.StatusBadRequest, "invalid aggregation")
+		todos.Do(http.MethodGet, "/_aggregate?agg=median:id", nil).
+			AssertError(http.StatusBadRequest, "invalid aggregation")
+	})
+
+	t.Run("associations", func(t *testing.T) {
+		projects.Do(http.MethodGet, "/_aggregate?group_by=todos.done", nil).
+			AssertStatus(http.StatusOK).
+			AssertField("columns", []map[string]string{
+				{"name": "todos.done", "type": "bool"},
+				{"name": "count", "type": "int"},
+			}).
+			AssertField("rows", [][]any{{nil, 1}, {false, 1}, {true, 2}})
+		projects.Do(http.MethodGet, "/_aggregate?group_by=todos.done&filter_by=title&filter_value=home&agg=count&agg=max:id", nil).
+			AssertStatus(http.StatusOK).
+			AssertField("rows", [][]any{{false, 1, 1}, {true, 1, 1}})
+		projects.Do(http.MethodGet, "/_aggregate?group_by=todos.done&agg=sum:id", nil).
+			AssertError(http.StatusBadRequest, "invalid aggregation")
+		projects.Do(http.MethodGet, "/_aggregate?group_by=todos", nil).
+			AssertError(http.StatusBadRequest, "association")
+	})
+}
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"github.com/cdfmlr/crud/controller"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditLog adds a read-only route to the audit log (see package audit)
+// to the base router on relativePath:
+//    GET /relativePath?actor=...&model=...&model_id=...&limit=10&offset=0
+//
+// The audit log records every mutation, so it should be restricted to
+// admins by the base router's middlewares:
+//    admin := r.Group("/admin", middleware.RequireRole(model.Admin))
+//    router.AuditLog(admin, "/audit")
+func AuditLog(base gin.IRouter, relativePath string) gin.IRouter {
+	group := base.Group(relativePath)
+
+	if !gin.IsDebugging() { // GIN_MODE == "release"
+		logger.WithField("relativePath", relativePath).
+			Info("AuditLog: Adding GET route for audit log")
+	}
+
+	group.GET("", controller.AuditListHandler())
+	return group
+}
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"github.com/cdfmlr/crud/controller"
+	"github.com/gin-gonic/gin"
+)
+
+// Backup adds a route to download a backup of the database (see
+// controller.BackupHandler) to the base router on relativePath:
+//    GET /relativePath?format=ndjson|sql|sqlite
+//
+// The backup has all the data, so it must be restricted to admins by the
+// base router's middlewares:
+//    admin := r.Group("/admin", middleware.RequireRole(model.Admin))
+//    router.Backup(admin, "/backup")
+func Backup(base gin.IRouter, relativePath string) gin.IRouter {
+	group := base.Group(relativePath)
+
+	if !gin.IsDebugging() { // GIN_MODE == "release"
+		logger.WithField("relativePath", relativePath).
+			Info("Backup: Adding GET route for database backups")
+	}
+
+	group.GET("", controller.BackupHandler())
+	return group
+}
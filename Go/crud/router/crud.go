@@ -6,6 +6,8 @@ import (
 	"github.com/cdfmlr/crud/orm"
 	"github.com/gin-gonic/gin"
 	"reflect"
+	"strings"
+	"sync"
 )
 
 // Crud add a group of CRUD routes for model T to the base router
@@ -21,15 +23,29 @@ import (
 // adds the following routes:
 //       GET /users/
 //       GET /users/:UserId
-//      POST /users/
-//       PUT /users/:UserId
+//      POST /users/                # ?on_conflict=update|ignore to upsert
+//       PUT /users/:UserId         # create or replace
+//     PATCH /users/:UserId
 //    DELETE /users/:UserId
 // and with options parameters, it's optional to add the following routes:
 //    - GetNested()    =>    GET /users/:UserId/friends
 //    - CreateNested() =>   POST /users/:UserId/friends
 //    - DeleteNested() => DELETE /users/:UserId/friends/:FriendId
+//    - History()      =>    GET /users/:UserId/versions[/:Version]
+//                     =>   POST /users/:UserId/versions/:Version/restore
+//    - Trash()        =>    GET /users/_trash
+//                     =>   POST /users/:UserId/restore
+//                     => DELETE /users/:UserId?hard=true
+//    - Events()       =>    GET /users/_events[/ws]
+//    - Aggregate()    =>    GET /users/_aggregate
+//    - Export()       =>    GET /users/_export
+//    - Import()       =>   POST /users/_import
+//
+// GET requests read from the replicas of the database, if any, unless with
+// the X-Read-Your-Writes header. See controller.ReadYourWrites.
 func Crud[T orm.Model](base gin.IRouter, relativePath string, options ...CrudOption) gin.IRouter {
 	group := base.Group(relativePath)
+	group.Use(controller.ReadYourWrites())
 
 	if !gin.IsDebugging() { // GIN_MODE == "release"
 		logger.WithField("model", getTypeName[T]()).
@@ -60,6 +76,7 @@ type CrudOption func(group *gin.RouterGroup) *gin.RouterGroup
 //       GET /:idParam
 //      POST /
 //       PUT /:idParam
+//     PATCH /:idParam
 //    DELETE /:idParam
 func crud[T orm.Model]() CrudOption {
 	idParam := getIdParam[T]()
@@ -68,7 +85,8 @@ func crud[T orm.Model]() CrudOption {
 		group.GET(fmt.Sprintf("/:%s", idParam), controller.GetByIDHandler[T](idParam))
 
 		group.POST("", controller.CreateHandler[T]())
-		group.PUT(fmt.Sprintf("/:%s", idParam), controller.UpdateHandler[T](idParam))
+		group.PUT(fmt.Sprintf("/:%s", idParam), controller.ReplaceHandler[T](idParam))
+		group.PATCH(fmt.Sprintf("/:%s", idParam), controller.UpdateHandler[T](idParam))
 		group.DELETE(fmt.Sprintf("/:%s", idParam), controller.DeleteHandler[T](idParam))
 
 		return group
@@ -154,11 +172,15 @@ func CrudNested[P orm.Model, T orm.Model](field string) CrudOption {
 }
 
 // getIdParam Model => "ModelID"
+//
+// For models with a composite primary key, the fields are concatenated:
+//    Membership (UserID,ProjectID) => "MembershipUserIDProjectID"
+// and the param is the string form of the orm.CompositeID, e.g.
+//    /memberships/3,7
 func getIdParam[T orm.Model]() string {
 	model := *new(T)
 	modelName := reflect.TypeOf(model).Name()
-	idField, _ := model.Identity()
-	idParam := modelName + idField
+	idParam := modelName + strings.Join(orm.IdentityFields(model), "")
 
 	return idParam
 }
@@ -168,3 +190,156 @@ func getTypeName[T any]() string {
 	model := *new(T)
 	return reflect.TypeOf(model).Name()
 }
+
+// History add routes to the group for the version history of model T,
+// which must be enabled by orm.EnableHistory:
+//       GET /:idParam/versions
+//       GET /:idParam/versions/:Version
+//      POST /:idParam/versions/:Version/restore
+func History[T orm.Model]() CrudOption {
+	idParam := getIdParam[T]()
+	versionParam := "Version"
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				Info("Crud: Adding version history routes for model")
+		}
+
+		versions := fmt.Sprintf("/:%s/versions", idParam)
+		version := fmt.Sprintf("%s/:%s", versions, versionParam)
+
+		group.GET(versions, controller.GetVersionsHandler[T](idParam))
+		group.GET(version, controller.GetVersionHandler[T](idParam, versionParam))
+		group.POST(version+"/restore", controller.RestoreVersionHandler[T](idParam, versionParam))
+		return group
+	}
+}
+
+// Trash add routes to the group for the trash bin of soft deleted model T:
+//       GET /_trash
+//      POST /:idParam/restore
+// and allows permanent deletes
+//    DELETE /:idParam?hard=true
+// for requests that canPurge (e.g. admins only). If canPurge is nil,
+// permanent deletes are never allowed.
+//
+// The model is added to TrashModels.
+func Trash[T orm.Model](canPurge func(c *gin.Context) bool) CrudOption {
+	idParam := getIdParam[T]()
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				Info("Crud: Adding trash bin routes for model")
+		}
+		addTrashModel[T]()
+
+		// must be used before the DELETE /:idParam route is added by crud
+		group.Use(func(c *gin.Context) {
+			if c.Request.Method == "DELETE" && c.Query("hard") == "true" {
+				if canPurge == nil || !canPurge(c) {
+					controller.ResponseError(c, controller.CodeForbidden, controller.ErrHardDeleteForbidden)
+					c.Abort()
+					return
+				}
+				c.Set(controller.HardDeleteKey, true)
+			}
+			c.Next()
+		})
+
+		group.GET("/_trash", controller.GetTrashHandler[T]())
+		group.POST(fmt.Sprintf("/:%s/restore", idParam), controller.RestoreHandler[T](idParam))
+		return group
+	}
+}
+
+// trashModels are the models with the trash bin routes, see Trash.
+var (
+	trashModels   []any
+	trashModelsMu sync.Mutex
+)
+
+func addTrashModel[T orm.Model]() {
+	trashModelsMu.Lock()
+	defer trashModelsMu.Unlock()
+	for _, model := range trashModels {
+		if _, ok := model.(*T); ok {
+			return
+		}
+	}
+	trashModels = append(trashModels, new(T))
+}
+
+// TrashModels returns the models with the trash bin routes added by the
+// Trash option, so far. E.g. to purge them after the retention period, see
+// service.StartTrashPurger.
+func TrashModels() []any {
+	trashModelsMu.Lock()
+	defer trashModelsMu.Unlock()
+	return append([]any(nil), trashModels...)
+}
+
+// Events add routes to the group for the real-time change feed of model T
+// (published by the service layer, see package broker):
+//       GET /_events       (Server-Sent Events)
+//       GET /_events/ws    (WebSocket)
+// authorize filters the events for each subscriber, see
+// controller.EventFilter. If authorize is nil, all events are sent.
+func Events[T orm.Model](authorize controller.EventFilter) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				Info("Crud: Adding change feed routes for model")
+		}
+
+		group.GET("/_events", controller.EventsHandler[T](authorize))
+		group.GET("/_events/ws", controller.WebSocketEventsHandler[T](authorize))
+		return group
+	}
+}
+
+// Aggregate add a route to the group for aggregating model T (e.g. for
+// dashboards), see controller.AggregateHandler:
+//       GET /_aggregate?group_by=done&agg=count
+// Only the given columns can be grouped by or aggregated.
+func Aggregate[T orm.Model](columns ...string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				WithField("columns", columns).
+				Info("Crud: Adding aggregate route for model")
+		}
+
+		group.GET("/_aggregate", controller.AggregateHandler[T](columns...))
+		return group
+	}
+}
+
+// Export add a route to the group for streaming all models T (filtered)
+// as NDJSON or CSV, see controller.ExportHandler:
+//       GET /_export?format=csv
+func Export[T orm.Model]() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				Info("Crud: Adding export route for model")
+		}
+
+		group.GET("/_export", controller.ExportHandler[T]())
+		return group
+	}
+}
+
+// Import add a route to the group for importing models T from a CSV or
+// NDJSON file, see controller.ImportHandler:
+//      POST /_import?key=id&dry_run=true
+func Import[T orm.Model]() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		if !gin.IsDebugging() { // GIN_MODE == "release"
+			logger.WithField("model", getTypeName[T]()).
+				Info("Crud: Adding import route for model")
+		}
+
+		group.POST("/_import", controller.ImportHandler[T]())
+		return group
+	}
+}
@@ -1,3 +1,91 @@
-package router
+package router_test
 
-// TODO: test Crud
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/middleware"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCrud(t *testing.T) {
+	registered := len(orm.RegisteredModels())
+	t.Cleanup(func() {
+		if n := len(orm.RegisteredModels()); n != registered {
+			t.Errorf("%d models registered after the test, want %d", n, registered)
+		}
+	})
+	app := crudtest.New(t,
+		crudtest.WithModels(&model.Todo{}, &model.Project{}),
+		crudtest.WithFixtures(os.DirFS(".."), "fixtures"),
+		crudtest.WithAuth(&model.User{}))
+	isAdmin := func(c *gin.Context) bool { return middleware.HasRole(c, model.Admin) }
+	todos := crudtest.Crud[model.Todo](app, "/todos", router.Trash[model.Todo](isAdmin))
+	projects := crudtest.Crud[model.Project](app, "/projects",
+		router.CrudNested[model.Project, model.Todo]("todos"))
+
+	// authentication
+	todos.Delete(1).AssertError(http.StatusUnauthorized, "Unauthorized")
+	admin := app.User(&model.User{Username: "admin", Email: "admin@example.com", Role: model.Admin})
+	employee := app.User(&model.User{Username: "employee", Email: "employee@example.com", Role: model.Employee})
+	todos, projects = todos.As(employee), projects.As(employee)
+
+	// fixtures
+	walkDog := crudtest.FixtureID[model.Todo](t, "walk_dog")
+	list, res := todos.List("filter_by=title&filter_value=Walk%20the%20dog&total=true")
+	res.AssertStatus(http.StatusOK).AssertTotal(1)
+	if len(list) != 1 || list[0].ID != uint(walkDog.(int64)) {
+		t.Errorf("List(done) = %+v, want walk_dog", list)
+	}
+
+	// create, get, update
+	todo, res := todos.Create(&model.Todo{Title: "Read"})
+	res.AssertStatus(http.StatusOK)
+	todo, res = todos.Update(todo.ID, map[string]any{"done": true})
+	res.AssertStatus(http.StatusOK)
+	if got, _ := todos.Get(todo.ID, ""); got.Title != "Read" || !got.Done {
+		t.Errorf("Get() = %+v after Update", got)
+	}
+	_, res = todos.Update(todo.ID, map[string]any{"id": 42})
+	res.AssertError(http.StatusBadRequest, "id")
+	_, res = todos.Get(404, "")
+	res.AssertStatus(http.StatusUnprocessableEntity)
+
+	// nested
+	home := crudtest.FixtureID[model.Project](t, "home")
+	projectTodos := crudtest.Nested[model.Project, model.Todo](projects, "todos")
+	project, res := projectTodos.Create(home, &model.Todo{Title: "Cook"})
+	res.AssertStatus(http.StatusOK)
+	if len(project.Todos) != 1 || project.Todos[0].ID == 0 {
+		t.Errorf("Create(nested) = %+v, want the new todo", project)
+	}
+	_, res = projectTodos.List(home, "order_by=title&limit=2&total=true")
+	res.AssertStatus(http.StatusOK).AssertTotal(3)
+	projectTodos.Delete(home, walkDog).AssertStatus(http.StatusOK).AssertField("deleted", true)
+	list, _ = projectTodos.List(home, "")
+	if len(list) != 2 {
+		t.Errorf("List(nested) = %+v after Delete, want 2 todos", list)
+	}
+
+	// roles
+	hardDelete := fmt.Sprintf("/%d?hard=true", todo.ID)
+	todos.Do(http.MethodDelete, hardDelete, nil).AssertStatus(http.StatusForbidden)
+	todos.As(admin).Do(http.MethodDelete, hardDelete, nil).AssertStatus(http.StatusOK)
+	_, res = todos.As(admin).List("total=true")
+	res.AssertTotal(3)
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("Todo", crudtest.Conformance[model.Todo]{}.Run)
+	t.Run("Project", crudtest.Conformance[model.Project]{
+		Nested: []crudtest.ConformanceNested[model.Project]{
+			crudtest.NestedConformance[model.Project, model.Todo]("todos", nil),
+		},
+	}.Run)
+}
@@ -0,0 +1,114 @@
+package router_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/router"
+)
+
+func TestETag(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}, &model.Project{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos")
+
+	todo, res := todos.Create(&model.Todo{Title: "Read"})
+	res.AssertStatus(http.StatusOK)
+	created := res.Header.Get("ETag")
+	_, res = todos.Get(todo.ID, "")
+	res.AssertStatus(http.StatusOK).AssertHeader("Vary", "Accept")
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("GET: no ETag")
+	}
+	if etag != created {
+		t.Errorf("ETag of GET = %s, want %s as created", etag, created)
+	}
+	ifNoneMatch := func(etag string) *crudtest.Client[model.Todo] {
+		return todos.WithHeader(http.Header{"If-None-Match": {etag}})
+	}
+	ifMatch := func(etag string) *crudtest.Client[model.Todo] {
+		return todos.WithHeader(http.Header{"If-Match": {etag}})
+	}
+
+	t.Run("304 if none match", func(t *testing.T) {
+		_, res := ifNoneMatch(etag).Get(todo.ID, "")
+		res.AssertStatus(http.StatusNotModified)
+	})
+
+	t.Run("representations", func(t *testing.T) {
+		_, res := ifNoneMatch(etag).Get(todo.ID, "fields=title")
+		res.AssertStatus(http.StatusOK)
+		trimmed := res.Header.Get("ETag")
+		if trimmed == etag {
+			t.Errorf("ETag of fields=title = %s, want other than the full one", trimmed)
+		}
+		_, res = ifNoneMatch(trimmed).Get(todo.ID, "fields=title")
+		res.AssertStatus(http.StatusNotModified)
+		path := fmt.Sprintf("/%d", todo.ID)
+		ifNoneMatch(etag).Do(http.MethodGet, path+"?format=xml", nil).
+			AssertStatus(http.StatusOK)
+		ifNoneMatch(etag).WithHeader(http.Header{"Accept": {"application/xml"}}).
+			Do(http.MethodGet, path, nil).AssertStatus(http.StatusOK)
+	})
+
+	t.Run("412 if not match", func(t *testing.T) {
+		updated, res := ifMatch(etag).Update(todo.ID, map[string]any{"done": true})
+		res.AssertStatus(http.StatusOK)
+		if !updated.Done {
+			t.Errorf("Update(If-Match) = %+v, want done", updated)
+		}
+		current := res.Header.Get("ETag")
+		if current == etag {
+			t.Errorf("ETag = %s after an update, want a new one", current)
+		}
+
+		// a lost update
+		_, res = ifMatch(etag).Update(todo.ID, map[string]any{"title": "Write"})
+		res.AssertStatus(http.StatusPreconditionFailed)
+		ifMatch(etag).Delete(todo.ID).AssertStatus(http.StatusPreconditionFailed)
+
+		// the tag of a shaped representation is not of the version
+		_, res = todos.Get(todo.ID, "fields=title")
+		_, res = ifMatch(res.Header.Get("ETag")).Update(todo.ID, map[string]any{"title": "Write"})
+		res.AssertStatus(http.StatusPreconditionFailed)
+		_, res = ifMatch(current).Update(todo.ID, map[string]any{"title": "Write"})
+		res.AssertStatus(http.StatusOK)
+	})
+
+	t.Run("changes within a millisecond", func(t *testing.T) {
+		_, res := todos.Get(todo.ID, "")
+		before := res.Header.Get("ETag")
+		_, res = todos.Update(todo.ID, map[string]any{"detail": "quickly"})
+		if after := res.Header.Get("ETag"); after == before {
+			t.Errorf("ETag = %s after an update, want a new one", after)
+		}
+	})
+
+	t.Run("preloaded associations", func(t *testing.T) {
+		projects := crudtest.Crud[model.Project](app, "/projects",
+			router.CrudNested[model.Project, model.Todo]("todos"))
+		project, res := projects.Create(&model.Project{Title: "home"})
+		res.AssertStatus(http.StatusOK)
+		projectTodos := crudtest.Nested[model.Project, model.Todo](projects, "todos")
+		project, res = projectTodos.Create(project.ID, &model.Todo{Title: "cook"})
+		res.AssertStatus(http.StatusOK)
+		cook := project.Todos[0]
+
+		path := fmt.Sprintf("/%d?preload=Todos", project.ID)
+		for _, path := range []string{path, "?preload=Todos"} {
+			res := projects.Do(http.MethodGet, path, nil).AssertStatus(http.StatusOK)
+			etag := res.Header.Get("ETag")
+			projects.WithHeader(http.Header{"If-None-Match": {etag}}).
+				Do(http.MethodGet, path, nil).AssertStatus(http.StatusNotModified)
+
+			// the project is unchanged, a todo of it is
+			_, res = todos.Update(cook.ID, map[string]any{"detail": path})
+			res.AssertStatus(http.StatusOK)
+			projects.WithHeader(http.Header{"If-None-Match": {etag}}).
+				Do(http.MethodGet, path, nil).AssertStatus(http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package router_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/router"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestEvents(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}))
+	// secrets are hidden, others redacted
+	authorize := func(c *gin.Context, event *broker.Event) bool {
+		todo, _ := event.Data.(model.Todo)
+		event.Actor = "someone"
+		return todo.Title != "secret"
+	}
+	todos := crudtest.Crud[model.Todo](app, "/todos", router.Events[model.Todo](authorize))
+	server := httptest.NewServer(app.Router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	create := func(title string) {
+		_, res := todos.Create(&model.Todo{Title: title})
+		res.AssertStatus(http.StatusOK)
+	}
+
+	// the last event seen by the first connection
+	var last broker.Event
+
+	t.Run("sse", func(t *testing.T) {
+		stream := openEvents(t, ctx, server.URL+"/todos/_events", "")
+		create("secret")
+		create("read")
+		last = stream.next(t)
+		if last.Type != broker.EventCreated || last.Actor != "someone" || !strings.Contains(fmt.Sprint(last.Data), "read") {
+			t.Errorf("event = %+v, want the authorized creation of read", last)
+		}
+		stream.close()
+	})
+
+	create("write") // missed
+
+	t.Run("sse resumes", func(t *testing.T) {
+		stream := openEvents(t, ctx, server.URL+"/todos/_events", fmt.Sprint(last.ID))
+		defer stream.close()
+		if event := stream.next(t); event.ID <= last.ID || !strings.Contains(fmt.Sprint(event.Data), "write") {
+			t.Errorf("event = %+v, want the missed creation of write", event)
+		}
+	})
+
+	t.Run("websocket resumes", func(t *testing.T) {
+		url := fmt.Sprintf("ws%s/todos/_events/ws?last_event_id=%d", strings.TrimPrefix(server.URL, "http"), last.ID)
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var event broker.Event
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		if event.ID <= last.ID || event.Type != broker.EventCreated {
+			t.Errorf("event = %+v, want the missed creation", event)
+		}
+		create("review")
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		if data, _ := json.Marshal(event.Data); !strings.Contains(string(data), "review") {
+			t.Errorf("event = %+v, want the creation of review", event)
+		}
+	})
+}
+
+// eventStream reads a Server-Sent Events response.
+type eventStream struct {
+	res    *http.Response
+	reader *bufio.Reader
+}
+
+func openEvents(t *testing.T, ctx context.Context, url string, lastEventID string) *eventStream {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	if ct := res.Header.Get("Content-Type"); res.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("GET %s: %d %s", url, res.StatusCode, ct)
+	}
+	return &eventStream{res: res, reader: bufio.NewReader(res.Body)}
+}
+
+// next reads the next event, checking its id and type against the data.
+func (s *eventStream) next(t *testing.T) (event broker.Event) {
+	t.Helper()
+	fields := map[string]string{}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read events: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" && len(fields) > 0 {
+			break
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok && key != "" {
+			fields[key] = value
+		}
+	}
+	if err := json.Unmarshal([]byte(fields["data"]), &event); err != nil {
+		t.Fatalf("decode event %q: %v", fields["data"], err)
+	}
+	if fields["id"] != fmt.Sprint(event.ID) || fields["event"] != string(event.Type) {
+		t.Errorf("event id:%s event:%s, want %d %s", fields["id"], fields["event"], event.ID, event.Type)
+	}
+	return event
+}
+
+func (s *eventStream) close() {
+	s.res.Body.Close()
+}
@@ -0,0 +1,86 @@
+package router_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/router"
+)
+
+func TestExport(t *testing.T) {
+	batchSize := controller.ExportBatchSize
+	controller.ExportBatchSize = 2
+	t.Cleanup(func() { controller.ExportBatchSize = batchSize })
+
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos", router.Export[model.Todo]())
+	for i := 1; i <= 5; i++ {
+		_, res := todos.Create(&model.Todo{Title: fmt.Sprintf("todo %d", i), Done: i%2 == 0})
+		res.AssertStatus(http.StatusOK)
+	}
+
+	lines := func(res *crudtest.Response) []string {
+		t.Helper()
+		res.AssertStatus(http.StatusOK)
+		return strings.Split(strings.TrimSuffix(string(res.Body), "\n"), "\n")
+	}
+
+	t.Run("ndjson in batches", func(t *testing.T) {
+		got := lines(todos.Do(http.MethodGet, "/_export", nil))
+		if len(got) != 5 || !strings.Contains(got[0], `"title":"todo 1"`) || !strings.Contains(got[4], `"title":"todo 5"`) {
+			t.Errorf("export = %q, want 5 todos in order", got)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		res := todos.Do(http.MethodGet, "/_export?format=csv", nil)
+		res.AssertHeader("Content-Disposition", `attachment; filename="todos.csv"`)
+		got := lines(res)
+		if len(got) != 6 || got[0] != "ID,CreatedAt,UpdatedAt,DeletedAt,title,detail,done" || !strings.HasPrefix(got[1], "1,") {
+			t.Errorf("export = %q, want a header and 5 todos", got)
+		}
+	})
+
+	t.Run("filters and fields", func(t *testing.T) {
+		got := lines(todos.Do(http.MethodGet, "/_export?format=csv&filter_by=title&filter_value=todo%203&fields=title", nil))
+		if want := []string{"ID,title", "3,todo 3"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("export = %q, want %q", got, want)
+		}
+		got = lines(todos.Do(http.MethodGet, "/_export?format=csv&filter_by=title&filter_value=nope", nil))
+		if want := []string{"ID,CreatedAt,UpdatedAt,DeletedAt,title,detail,done"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("export of nothing = %q, want the header only", got)
+		}
+	})
+
+	t.Run("stops on disconnect", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder(), disconnect: cancel}
+		req := httptest.NewRequest(http.MethodGet, "/todos/_export", nil).WithContext(ctx)
+		app.Router.ServeHTTP(w, req)
+
+		got := strings.Count(w.Body.String(), "\n")
+		if got != controller.ExportBatchSize {
+			t.Errorf("exported %d lines, want the first batch of %d only", got, controller.ExportBatchSize)
+		}
+	})
+}
+
+// disconnectingRecorder is a ResponseRecorder whose client disconnects
+// after the first write.
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	disconnect func()
+}
+
+func (w *disconnectingRecorder) Write(data []byte) (int, error) {
+	defer w.disconnect()
+	return w.ResponseRecorder.Write(data)
+}
@@ -0,0 +1,102 @@
+package router_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/router"
+)
+
+func TestFields(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}, &model.Project{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos")
+	projects := crudtest.Crud[model.Project](app, "/projects",
+		router.CrudNested[model.Project, model.Todo]("todos"))
+
+	project, res := projects.Create(&model.Project{Title: "home"})
+	res.AssertStatus(http.StatusOK)
+	projectTodos := crudtest.Nested[model.Project, model.Todo](projects, "todos")
+	for _, title := range []string{"cook", "clean"} {
+		_, res := projectTodos.Create(project.ID, &model.Todo{Title: title, Detail: "at home"})
+		res.AssertStatus(http.StatusOK)
+	}
+
+	// keysOf returns the sorted keys of the JSON object(s) of the response
+	// at path: keys of the envelope, and then of the objects, or of the
+	// first one of the arrays.
+	keysOf := func(res *crudtest.Response, path ...string) string {
+		t.Helper()
+		res.AssertStatus(http.StatusOK)
+		raw := res.Envelope()[path[0]]
+		for i := 1; ; i++ {
+			var array []json.RawMessage
+			if json.Unmarshal(raw, &array) == nil {
+				if len(array) == 0 {
+					t.Fatalf("%s is empty: %s", path, res.Body)
+				}
+				raw = array[0]
+			}
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &object); err != nil {
+				t.Fatalf("%s is not an object: %s", path, res.Body)
+			}
+			if i == len(path) {
+				var keys []string
+				for key := range object {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				return fmt.Sprint(keys)
+			}
+			raw = object[path[i]]
+		}
+	}
+
+	tests := []struct {
+		name string
+		res  *crudtest.Response
+		path []string
+		want string
+	}{
+		{"get", todos.Do(http.MethodGet, "/1?fields=title", nil), []string{"Todo"}, "[ID title]"},
+		{"get json keys", todos.Do(http.MethodGet, "/1?fields=title,done", nil), []string{"Todo"}, "[ID done title]"},
+		{"list", todos.Do(http.MethodGet, "?fields=detail", nil), []string{"Todos"}, "[ID detail]"},
+		{"preload", projects.Do(http.MethodGet, "/1?preload=Todos&fields=title&fields[todos]=title", nil), []string{"Project"}, "[ID title todos]"},
+		{"preloaded", projects.Do(http.MethodGet, "/1?preload=Todos&fields=title&fields[todos]=title", nil), []string{"Project", "todos"}, "[ID title]"},
+		{"preloaded untrimmed", projects.Do(http.MethodGet, "/1?preload=Todos&fields=title", nil), []string{"Project", "todos"}, "[CreatedAt DeletedAt ID UpdatedAt detail done title]"},
+		{"list preloaded", projects.Do(http.MethodGet, "?preload=Todos&fields[todos]=done", nil), []string{"Projects", "todos"}, "[ID done]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keysOf(tt.res, tt.path...); got != tt.want {
+				t.Errorf("keys of %v = %s, want %s\n%s", tt.path, got, tt.want, tt.res.Body)
+			}
+		})
+	}
+
+	t.Run("values", func(t *testing.T) {
+		got, res := projects.Get(project.ID, "preload=Todos&fields=title&fields[todos]=title")
+		res.AssertStatus(http.StatusOK)
+		var titles []string
+		for _, todo := range got.Todos {
+			titles = append(titles, todo.Title)
+		}
+		sort.Strings(titles)
+		if got.Title != "home" || !reflect.DeepEqual(titles, []string{"clean", "cook"}) {
+			t.Errorf("Get(fields) = %+v, want the titles", got)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		todos.Do(http.MethodGet, "/1?fields=nope", nil).
+			AssertError(http.StatusBadRequest, "unknown field")
+		projects.Do(http.MethodGet, "/1?fields[nope]=title", nil).
+			AssertError(http.StatusBadRequest, "unknown association")
+	})
+}
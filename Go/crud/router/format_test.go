@@ -0,0 +1,62 @@
+package router_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+)
+
+func TestFormats(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos")
+	for _, title := range []string{"a", "b"} {
+		_, res := todos.Create(&model.Todo{Title: title})
+		res.AssertStatus(http.StatusOK)
+	}
+
+	accept := func(accept string) *crudtest.Client[model.Todo] {
+		return todos.WithHeader(http.Header{"Accept": {accept}})
+	}
+	assertFormat := func(t *testing.T, res *crudtest.Response, contentType string, body string) {
+		t.Helper()
+		res.AssertStatus(http.StatusOK)
+		if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, contentType) {
+			t.Errorf("%s: Content-Type = %q, want %s", res.Body, got, contentType)
+		}
+		if !strings.Contains(res.Header.Get("Vary"), "Accept") {
+			t.Errorf("Vary = %q, want Accept", res.Header.Get("Vary"))
+		}
+		if !strings.Contains(string(res.Body), body) {
+			t.Errorf("body = %s, want %s in it", res.Body, body)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		res         *crudtest.Response
+		contentType string
+		body        string
+	}{
+		{"default", todos.Do(http.MethodGet, "/1", nil), "application/json", `"title":"a"`},
+		{"browser", accept("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8").Do(http.MethodGet, "/1", nil), "application/json", `"title":"a"`},
+		{"unknown", accept("image/png").Do(http.MethodGet, "/1", nil), "application/json", `"title":"a"`},
+		{"json preferred", accept("text/csv;q=0.5, application/json").Do(http.MethodGet, "", nil), "application/json", `"Todos"`},
+		{"json on ties", accept("application/xml, */*").Do(http.MethodGet, "/1", nil), "application/json", `"title":"a"`},
+		{"xml", accept("application/xml").Do(http.MethodGet, "/1", nil), "application/xml", "<Title>a</Title>"},
+		{"xml preferred", accept("application/json;q=0.5, text/xml").Do(http.MethodGet, "/1", nil), "application/xml", "<Title>a</Title>"},
+		{"msgpack", todos.Do(http.MethodGet, "/1?format=msgpack", nil), "application/msgpack", "title"},
+		{"csv", accept("text/csv").Do(http.MethodGet, "?order_by=id", nil), "text/csv", "ID,CreatedAt,UpdatedAt,DeletedAt,title,detail,done\n1,"},
+		{"ndjson", accept("application/x-ndjson").Do(http.MethodGet, "", nil), "application/x-ndjson", `"title":"b"`},
+		{"create", todos.Do(http.MethodPost, "", map[string]any{"title": "c"}), "application/json", `"Todo":{`},
+		{"create xml", accept("application/xml").Do(http.MethodPost, "", map[string]any{"title": "d"}), "application/xml", "<Title>d</Title>"},
+		{"upsert xml", accept("application/xml").Do(http.MethodPost, "?on_conflict=update", map[string]any{"ID": 1, "title": "e"}), "application/xml", "<Title>e</Title>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFormat(t, tt.res, tt.contentType, tt.body)
+		})
+	}
+}
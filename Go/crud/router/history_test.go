@@ -0,0 +1,58 @@
+package router_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+)
+
+// note is a model with its version history enabled.
+type note struct {
+	orm.BasicModel
+	Text string `json:"text"`
+}
+
+// draft is a model without history, routed as if it had.
+type draft struct {
+	orm.BasicModel
+	Text string `json:"text"`
+}
+
+func TestHistory(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&note{}, &draft{}))
+	if err := orm.EnableHistory(&note{}); err != nil {
+		t.Fatalf("EnableHistory() error = %v", err)
+	}
+	notes := crudtest.Crud[note](app, "/notes", router.History[note]())
+	drafts := crudtest.Crud[draft](app, "/drafts", router.History[draft]())
+
+	created, res := notes.Create(&note{Text: "a"})
+	res.AssertStatus(http.StatusOK)
+	_, res = notes.Update(created.ID, map[string]any{"text": "b"})
+	res.AssertStatus(http.StatusOK)
+
+	t.Run("get a version", func(t *testing.T) {
+		res := notes.Do(http.MethodGet, fmt.Sprintf("/%d/versions/1", created.ID), nil).
+			AssertStatus(http.StatusOK)
+		var version orm.Version
+		if res.Decode("Version", &version); version.Data["text"] != "a" {
+			t.Errorf("version 1 = %+v, want a", version)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		notes.Do(http.MethodGet, fmt.Sprintf("/%d/versions/2", created.ID), nil).
+			AssertStatus(http.StatusNotFound)
+	})
+
+	t.Run("history not enabled", func(t *testing.T) {
+		created, res := drafts.Create(&draft{Text: "a"})
+		res.AssertStatus(http.StatusOK)
+		drafts.Do(http.MethodGet, fmt.Sprintf("/%d/versions/1", created.ID), nil).
+			AssertError(http.StatusUnprocessableEntity, orm.ErrHistoryNotEnabled.Error())
+	})
+}
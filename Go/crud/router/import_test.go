@@ -0,0 +1,123 @@
+package router_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/router"
+	"github.com/cdfmlr/crud/service"
+)
+
+func TestImport(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&model.Todo{}))
+	todos := crudtest.Crud[model.Todo](app, "/todos", router.Import[model.Todo]())
+
+	importFile := func(contentType, query, body string) *crudtest.Response {
+		return todos.WithHeader(http.Header{"Content-Type": {contentType}}).
+			Do(http.MethodPost, "/_import"+query, body)
+	}
+	assertReport := func(t *testing.T, res *crudtest.Response, want service.ImportReport) {
+		t.Helper()
+		var got service.ImportReport
+		if !res.Decode("report", &got) {
+			t.Fatalf("no report: %s", res.Body)
+		}
+		if got.DryRun != want.DryRun || got.Created != want.Created || got.Updated != want.Updated || len(got.Errors) != len(want.Errors) {
+			t.Fatalf("report = %+v, want %+v", got, want)
+		}
+		for i := range want.Errors {
+			if got.Errors[i].Line != want.Errors[i].Line || got.Errors[i].Field != want.Errors[i].Field {
+				t.Errorf("report errors[%d] = %+v, want %+v", i, got.Errors[i], want.Errors[i])
+			}
+		}
+	}
+	titles := func() string {
+		list, _ := todos.List("order_by=id")
+		var titles []string
+		for _, todo := range list {
+			title := todo.Title
+			if todo.Done {
+				title += "!"
+			}
+			titles = append(titles, title)
+		}
+		return strings.Join(titles, ",")
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		res := importFile("application/x-ndjson", "?dry_run=true", `{"title":"a"}`+"\n\n"+`{"title":"b","done":true}`+"\n")
+		res.AssertStatus(http.StatusOK)
+		assertReport(t, res, service.ImportReport{DryRun: true, Created: 2})
+		if got := titles(); got != "" {
+			t.Errorf("todos = %q after a dry run, want none", got)
+		}
+	})
+
+	t.Run("column mapping", func(t *testing.T) {
+		res := importFile("text/csv", "?map[Task]=title&map[Notes]=-",
+			"\uFEFFTask,Notes,done\na,note,false\nb,,true\n")
+		res.AssertStatus(http.StatusOK)
+		assertReport(t, res, service.ImportReport{Created: 2})
+		if got := titles(); got != "a,b!" {
+			t.Errorf("todos = %q, want a,b!", got)
+		}
+		list, _ := todos.List("")
+		if list[0].Detail != "" {
+			t.Errorf("detail = %q, want the Notes column ignored", list[0].Detail)
+		}
+	})
+
+	t.Run("key upserts", func(t *testing.T) {
+		res := importFile("text/csv", "?key=title", "title,done\na,true\nc,\n")
+		res.AssertStatus(http.StatusOK)
+		assertReport(t, res, service.ImportReport{Created: 1, Updated: 1})
+		if got := titles(); got != "a!,b!,c" {
+			t.Errorf("todos = %q, want a updated and c created", got)
+		}
+	})
+
+	t.Run("errors by lines", func(t *testing.T) {
+		res := importFile("text/csv", "", "title,done\nd,false\ne,maybe\nf\n")
+		res.AssertError(http.StatusUnprocessableEntity, "nothing imported")
+		assertReport(t, res, service.ImportReport{Created: 1, Errors: []service.ImportError{
+			{Line: 3, Field: "done"},
+			{Line: 4},
+		}})
+		res = importFile("application/x-ndjson", "", `{"title":"d"}`+"\n"+`{"nope":1}`+"\n"+`{`+"\n")
+		res.AssertError(http.StatusUnprocessableEntity, "nothing imported")
+		assertReport(t, res, service.ImportReport{Created: 1, Errors: []service.ImportError{
+			{Line: 2, Field: "nope"},
+			{Line: 3},
+		}})
+		importFile("text/csv", "", "title,nope\nd,1\n").
+			AssertError(http.StatusBadRequest, "unknown field")
+		if got := titles(); got != "a!,b!,c" {
+			t.Errorf("todos = %q after errors, want nothing imported", got)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		maxBytes, maxRows, maxLine := controller.ImportMaxBytes, controller.ImportMaxRows, controller.ImportMaxLineBytes
+		t.Cleanup(func() {
+			controller.ImportMaxBytes, controller.ImportMaxRows, controller.ImportMaxLineBytes = maxBytes, maxRows, maxLine
+		})
+		controller.ImportMaxRows = 2
+		importFile("text/csv", "", "title\nx\ny\nz\n").
+			AssertError(http.StatusRequestEntityTooLarge, "too many rows")
+		importFile("application/x-ndjson", "", `{"title":"x"}`+"\n"+`{"title":"y"}`+"\n"+`{"title":"z"}`+"\n").
+			AssertError(http.StatusRequestEntityTooLarge, "too many rows")
+		controller.ImportMaxLineBytes = 16
+		importFile("application/x-ndjson", "", `{"title":"x"}`+"\n"+`{"title":"a long title"}`+"\n").
+			AssertError(http.StatusRequestEntityTooLarge, "line 2")
+		controller.ImportMaxBytes = 8
+		importFile("text/csv", "", "title\nx\ny\n").
+			AssertError(http.StatusRequestEntityTooLarge, "too large")
+		if got := titles(); got != "a!,b!,c" {
+			t.Errorf("todos = %q after refused files, want nothing imported", got)
+		}
+	})
+}
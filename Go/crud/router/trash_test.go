@@ -0,0 +1,118 @@
+package router_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/middleware"
+	"github.com/cdfmlr/crud/model"
+	"github.com/cdfmlr/crud/outbox"
+	"github.com/cdfmlr/crud/router"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrash(t *testing.T) {
+	app := crudtest.New(t,
+		crudtest.WithModels(&model.Todo{}, &model.Project{}),
+		crudtest.WithAuth(&model.User{}),
+		crudtest.WithEvents())
+	isAdmin := func(c *gin.Context) bool { return middleware.HasRole(c, model.Admin) }
+	todos := crudtest.Crud[model.Todo](app, "/todos", router.Trash[model.Todo](isAdmin))
+	projects := crudtest.Crud[model.Project](app, "/projects",
+		router.CrudNested[model.Project, model.Todo]("todos"))
+	employee := app.User(&model.User{Username: "employee", Email: "employee@example.com", Role: model.Employee})
+	todos, projects = todos.As(employee), projects.As(employee)
+
+	project, res := projects.Create(&model.Project{Title: "home"})
+	res.AssertStatus(http.StatusOK)
+	projectTodos := crudtest.Nested[model.Project, model.Todo](projects, "todos")
+	var ids []uint
+	for _, title := range []string{"cook", "clean"} {
+		linked, res := projectTodos.Create(project.ID, &model.Todo{Title: title})
+		res.AssertStatus(http.StatusOK)
+		ids = append(ids, linked.Todos[0].ID)
+	}
+	cook, clean := ids[0], ids[1]
+
+	trashed := func() []model.Todo {
+		t.Helper()
+		res := todos.Do(http.MethodGet, "/_trash?total=true", nil).AssertStatus(http.StatusOK)
+		var list []model.Todo
+		res.Decode("Todos", &list)
+		res.AssertTotal(int64(len(list)))
+		return list
+	}
+
+	t.Run("list and restore", func(t *testing.T) {
+		todos.Delete(cook).AssertStatus(http.StatusOK)
+		if list := trashed(); len(list) != 1 || list[0].ID != cook {
+			t.Errorf("GET /_trash = %+v, want cook", list)
+		}
+		todos.Do(http.MethodPost, fmt.Sprintf("/%d/restore", cook), nil).AssertStatus(http.StatusOK)
+		if list := trashed(); len(list) != 0 {
+			t.Errorf("GET /_trash = %+v after restore, want none", list)
+		}
+		_, res := todos.Get(cook, "")
+		res.AssertStatus(http.StatusOK)
+	})
+
+	t.Run("hard delete of non-admins", func(t *testing.T) {
+		todos.Do(http.MethodDelete, fmt.Sprintf("/%d?hard=true", cook), nil).
+			AssertStatus(http.StatusForbidden)
+		_, res := todos.Get(cook, "")
+		res.AssertStatus(http.StatusOK)
+	})
+
+	t.Run("purge after retention", func(t *testing.T) {
+		found := false
+		for _, m := range router.TrashModels() {
+			_, isTodo := m.(*model.Todo)
+			found = found || isTodo
+		}
+		if !found {
+			t.Fatalf("TrashModels() = %v, want *model.Todo", router.TrashModels())
+		}
+
+		todos.Delete(cook).AssertStatus(http.StatusOK)
+		todos.Delete(clean).AssertStatus(http.StatusOK)
+		app.DB.Model(&model.Todo{}).Unscoped().Where("id = ?", clean).
+			Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err := service.StartTrashPurger(ctx, time.Hour, 24*time.Hour, router.TrashModels()...)
+		if err != nil {
+			t.Fatalf("StartTrashPurger() error = %v", err)
+		}
+		var entry audit.Entry
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if app.DB.Where("action = ?", audit.ActionPurge).Take(&entry).Error == nil {
+				break
+			}
+		}
+		cancel()
+
+		if entry.ModelID != strconv.Itoa(int(clean)) || entry.Actor != service.TrashPurgerActor {
+			t.Errorf("audit entry of the purge = %+v, want of clean by the purger", entry)
+		}
+		var message outbox.Message
+		if err := app.DB.Where("topic = ?", "TodoPurged").Take(&message).Error; err != nil || message.Key != entry.ModelID {
+			t.Errorf("outbox message of the purge = %+v, %v, want of clean", message, err)
+		}
+		var links int64
+		app.DB.Table("project_todos").Where("todo_id = ?", clean).Count(&links)
+		if links != 0 {
+			t.Errorf("%d project_todos of the purged todo, want none", links)
+		}
+		if list := trashed(); len(list) != 1 || list[0].ID != cook {
+			t.Errorf("GET /_trash = %+v after the purge, want cook only", list)
+		}
+	})
+}
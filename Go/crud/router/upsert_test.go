@@ -0,0 +1,106 @@
+package router_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/orm"
+)
+
+// label is a model with a unique column to upsert on.
+type label struct {
+	orm.BasicModel
+	Name  string `json:"name" gorm:"uniqueIndex"`
+	Color string `json:"color"`
+}
+
+func TestUpsert(t *testing.T) {
+	app := crudtest.New(t, crudtest.WithModels(&label{}))
+	labels := crudtest.Crud[label](app, "/labels")
+
+	upsert := func(query string, l label) (label, *crudtest.Response) {
+		res := labels.Do(http.MethodPost, "?"+query, &l)
+		var got label
+		if res.Code == http.StatusOK {
+			res.Decode("label", &got)
+		}
+		return got, res
+	}
+	count := func() (n int64) {
+		app.DB.Unscoped().Model(&label{}).Count(&n)
+		return n
+	}
+
+	t.Run("on_conflict", func(t *testing.T) {
+		created, res := upsert("on_conflict=update&conflict_columns=name", label{Name: "bug", Color: "red"})
+		res.AssertStatus(http.StatusOK)
+		updated, res := upsert("on_conflict=update&conflict_columns=name", label{Name: "bug", Color: "orange"})
+		res.AssertStatus(http.StatusOK)
+		if updated.ID != created.ID || updated.Color != "orange" || !updated.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("update on conflict = %+v, want %+v in orange", updated, created)
+		}
+		kept, res := upsert("on_conflict=ignore&conflict_columns=name", label{Name: "bug", Color: "green"})
+		res.AssertStatus(http.StatusOK)
+		if kept.ID != created.ID || kept.Color != "orange" {
+			t.Errorf("ignore on conflict = %+v, want the orange one kept", kept)
+		}
+		byID, res := upsert("on_conflict=update", label{BasicModel: orm.BasicModel{ID: created.ID}, Name: "bug", Color: "blue"})
+		res.AssertStatus(http.StatusOK)
+		if byID.ID != created.ID || byID.Color != "blue" {
+			t.Errorf("update on conflict of id = %+v, want it in blue", byID)
+		}
+		if n := count(); n != 1 {
+			t.Errorf("%d labels, want 1", n)
+		}
+
+		_, res = upsert("on_conflict=merge", label{Name: "bug"})
+		res.AssertError(http.StatusBadRequest, "unknown conflict action")
+		_, res = upsert("on_conflict=update&conflict_columns=nope", label{Name: "bug"})
+		res.AssertError(http.StatusBadRequest, "unknown field")
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		deleted, res := upsert("on_conflict=update&conflict_columns=name", label{Name: "wontfix", Color: "gray"})
+		res.AssertStatus(http.StatusOK)
+		labels.Delete(deleted.ID).AssertStatus(http.StatusOK)
+
+		for _, query := range []string{"on_conflict=update&conflict_columns=name", "on_conflict=ignore&conflict_columns=name"} {
+			_, res = upsert(query, label{Name: "wontfix", Color: "white"})
+			res.AssertError(http.StatusConflict, "deleted")
+		}
+		_, res = labels.Get(deleted.ID, "")
+		res.AssertStatus(http.StatusUnprocessableEntity)
+
+		restored, res := upsert("on_conflict=update&conflict_columns=name&restore=true", label{Name: "wontfix", Color: "white"})
+		res.AssertStatus(http.StatusOK)
+		if restored.ID != deleted.ID || restored.Color != "white" {
+			t.Errorf("restore on conflict = %+v, want %+v restored in white", restored, deleted)
+		}
+		if got, res := labels.Get(deleted.ID, ""); res.Code != http.StatusOK || got.Color != "white" {
+			t.Errorf("Get() = %+v after restoring, want it back", got)
+		}
+	})
+
+	t.Run("PUT", func(t *testing.T) {
+		created, res := labels.Replace(100, &label{Name: "docs", Color: "green"})
+		res.AssertStatus(http.StatusOK)
+		if created.ID != 100 || created.Color != "green" {
+			t.Errorf("Replace(new) = %+v, want it created with id 100", created)
+		}
+		replaced, res := labels.Replace(100, &label{Name: "docs"})
+		res.AssertStatus(http.StatusOK)
+		if replaced.Color != "" || !replaced.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("Replace() = %+v, want the color zeroed, the creation kept", replaced)
+		}
+		labels.WithHeader(http.Header{"If-Match": {`"stale"`}}).
+			Do(http.MethodPut, "/100", &label{Name: "docs"}).
+			AssertStatus(http.StatusPreconditionFailed)
+		labels.Do(http.MethodPut, "/100", &label{BasicModel: orm.BasicModel{ID: 101}}).
+			AssertError(http.StatusBadRequest, "id")
+
+		labels.Delete(100).AssertStatus(http.StatusOK)
+		_, res = labels.Replace(100, &label{Name: "docs", Color: "red"})
+		res.AssertError(http.StatusConflict, "deleted")
+	})
+}
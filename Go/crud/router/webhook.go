@@ -0,0 +1,54 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/webhook"
+	"github.com/gin-gonic/gin"
+)
+
+// Webhooks adds routes to manage webhook subscriptions (see package
+// webhook) to the base router on relativePath:
+//       GET /relativePath/
+//       GET /relativePath/:SubscriptionID
+//      POST /relativePath/                  # responds the secret, only once
+//       PUT /relativePath/:SubscriptionID   # keeps the secret
+//     PATCH /relativePath/:SubscriptionID
+//    DELETE /relativePath/:SubscriptionID
+//       GET /relativePath/:SubscriptionID/deliveries?status=failed
+//      POST /relativePath/:SubscriptionID/deliveries/:DeliveryID/replay
+//
+// Webhooks post model changes to arbitrary urls, so they should be
+// restricted to admins by the base router's middlewares:
+//    admin := r.Group("/admin", middleware.RequireRole(model.Admin))
+//    router.Webhooks(admin, "/webhooks")
+func Webhooks(base gin.IRouter, relativePath string, options ...CrudOption) gin.IRouter {
+	idParam := getIdParam[webhook.Subscription]()
+	deliveryParam := "DeliveryID"
+
+	if !gin.IsDebugging() { // GIN_MODE == "release"
+		logger.WithField("relativePath", relativePath).
+			Info("Webhooks: Adding routes for webhooks")
+	}
+
+	// the CRUD routes, but POST: to respond the secret
+	group := base.Group(relativePath)
+	group.Use(controller.ReadYourWrites())
+	group.GET("", controller.GetListHandler[webhook.Subscription]())
+	group.GET(fmt.Sprintf("/:%s", idParam), controller.GetByIDHandler[webhook.Subscription](idParam))
+	group.POST("", controller.WebhookCreateHandler())
+	group.PUT(fmt.Sprintf("/:%s", idParam), controller.ReplaceHandler[webhook.Subscription](idParam))
+	group.PATCH(fmt.Sprintf("/:%s", idParam), controller.UpdateHandler[webhook.Subscription](idParam))
+	group.DELETE(fmt.Sprintf("/:%s", idParam), controller.DeleteHandler[webhook.Subscription](idParam))
+
+	path := fmt.Sprintf("/:%s/deliveries", idParam)
+	group.GET(path, controller.WebhookDeliveriesHandler(idParam))
+	group.POST(fmt.Sprintf("%s/:%s/replay", path, deliveryParam),
+		controller.WebhookReplayHandler(idParam, deliveryParam))
+
+	for _, option := range options {
+		group = option(group)
+	}
+	return group
+}
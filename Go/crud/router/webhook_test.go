@@ -0,0 +1,54 @@
+package router_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cdfmlr/crud/crudtest"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/router"
+	"github.com/cdfmlr/crud/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	if err := orm.UseEncryptionKeys("k1", map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")}); err != nil {
+		t.Fatalf("UseEncryptionKeys() error = %v", err)
+	}
+	app := crudtest.New(t, crudtest.WithModels(&webhook.Subscription{}, &webhook.Delivery{}))
+	router.Webhooks(app.Routes, "/webhooks")
+	webhooks := crudtest.NewClient[webhook.Subscription](app, "/webhooks")
+
+	s, res := webhooks.Create(&webhook.Subscription{URL: "http://example.com/hook", Model: "Todo"})
+	res.AssertStatus(http.StatusOK)
+	var secret string
+	if !res.Decode("secret", &secret) || len(secret) != 64 {
+		t.Fatalf("POST: secret = %q, want the generated one\n%s", secret, res.Body)
+	}
+	stored := func() string {
+		var s webhook.Subscription
+		if err := app.DB.Take(&s).Error; err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		return s.Secret
+	}
+	if got := stored(); got != secret {
+		t.Errorf("stored secret = %q, want %q", got, secret)
+	}
+
+	_, res = webhooks.Get(s.ID, "")
+	res.AssertStatus(http.StatusOK)
+	_, res = webhooks.List("")
+	res.AssertStatus(http.StatusOK)
+	if strings.Contains(string(res.Body), secret) {
+		t.Errorf("GET responds the secret: %s", res.Body)
+	}
+
+	_, res = webhooks.Replace(s.ID, &webhook.Subscription{URL: "http://example.com/other", Model: "Todo"})
+	res.AssertStatus(http.StatusOK)
+	_, res = webhooks.Update(s.ID, map[string]any{"secret": "guessed", "disabled": true})
+	res.AssertStatus(http.StatusOK)
+	if got := stored(); got != secret {
+		t.Errorf("secret = %q after PUT and PATCH, want it kept", got)
+	}
+}
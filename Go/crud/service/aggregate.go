@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// AggregateFunc is an aggregate function of Aggregation.
+type AggregateFunc string
+
+const (
+	AggregateCount AggregateFunc = "count"
+	AggregateSum   AggregateFunc = "sum"
+	AggregateAvg   AggregateFunc = "avg"
+	AggregateMin   AggregateFunc = "min"
+	AggregateMax   AggregateFunc = "max"
+)
+
+// Aggregation is an aggregate function over a column, e.g.
+//    Aggregation{Func: AggregateSum, Column: "price"}  // SUM(price)
+// Column can be empty for AggregateCount: COUNT(*).
+type Aggregation struct {
+	Func   AggregateFunc
+	Column string
+}
+
+// Name of the aggregation in the result: "count" or "<func>_<column>".
+func (a Aggregation) Name() string {
+	if a.Column == "" {
+		return string(a.Func)
+	}
+	return string(a.Func) + "_" + a.Column
+}
+
+// Column types of the Table, derived from the model fields.
+const (
+	ColumnBool   = "bool"
+	ColumnInt    = "int"
+	ColumnFloat  = "float"
+	ColumnString = "string"
+	ColumnTime   = "time"
+)
+
+// TableColumn describes a column of a Table.
+type TableColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // ColumnBool, ColumnInt, ...
+}
+
+// Table is a tabular result: each row is a list of values in the order
+// of the columns.
+type Table struct {
+	Columns []TableColumn `json:"columns"`
+	Rows    [][]any       `json:"rows"`
+}
+
+var (
+	ErrUnknownColumn      = errors.New("unknown column")
+	ErrInvalidAggregation = errors.New("invalid aggregation")
+)
+
+// Aggregate computes the aggregations of models T grouped by the groupBy
+// columns, into a Table of the groupBy columns followed by the
+// aggregations, ordered by the groupBy columns.
+//
+// Columns are the field names or column names of T. Options (e.g. FilterBy,
+// Search) filter the models before aggregating, orders and pagination are
+// ignored.
+//
+// Columns of many2many associations can be grouped by, as
+// "<association>.<column>", through the join table. Then a model is in the
+// group of each of its associated records (or in the group of NULL if it
+// has none), so a count counts the distinct models, and other
+// aggregations than min and max are refused. Other associations are
+// errors of ErrUnknownColumn.
+//
+// Example:
+//     Aggregate[Todo](ctx, []string{"done"}, []Aggregation{{Func: AggregateCount}})
+// means:
+//     SELECT done, COUNT(*) AS count FROM todos GROUP BY done ORDER BY done;
+// and
+//     Aggregate[Project](ctx, []string{"todos.done"}, []Aggregation{{Func: AggregateCount}})
+// means:
+//     SELECT todos.done, COUNT(DISTINCT projects.id) AS count FROM projects
+//     LEFT JOIN project_todos ON project_todos.project_id = projects.id
+//     LEFT JOIN todos ON todos.id = project_todos.todo_id AND todos.deleted_at IS NULL
+//     GROUP BY todos.done ORDER BY todos.done;
+func Aggregate[T any](ctx context.Context, groupBy []string, aggregations []Aggregation, options ...QueryOption) (*Table, error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("groupBy", groupBy)
+	logger.Trace("Aggregate: aggregate models")
+
+	if err := gormOnly(new(T)); err != nil {
+		return nil, err
+	}
+	stmt := &gorm.Statement{DB: orm.DB}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	table := &Table{Rows: [][]any{}}
+	var selects, groups, joins []string
+	joined := map[string]bool{}
+	for _, column := range groupBy {
+		if name, related, ok := strings.Cut(column, "."); ok {
+			rel := orm.LookUpRelationship(stmt.Schema, name)
+			if rel == nil || rel.JoinTable == nil {
+				return nil, fmt.Errorf("%w: %s is not a many2many association", ErrUnknownColumn, name)
+			}
+			field := orm.LookUpField(rel.FieldSchema, related)
+			if field == nil || field.DBName == "" {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+			}
+			if !joined[name] {
+				joined[name] = true
+				joins = append(joins, many2manyJoins(stmt, rel, name)...)
+			}
+			quoted := stmt.Quote(name + "." + field.DBName)
+			selects = append(selects, quoted)
+			groups = append(groups, quoted)
+			table.Columns = append(table.Columns, TableColumn{Name: name + "." + field.DBName, Type: columnType(field)})
+			continue
+		}
+		if orm.LookUpRelationship(stmt.Schema, column) != nil {
+			return nil, fmt.Errorf("%w: %s is an association, group by its columns (%s.<column>) instead", ErrUnknownColumn, column, column)
+		}
+		field := orm.LookUpField(stmt.Schema, column)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+		quoted := stmt.Quote(stmt.Schema.Table + "." + field.DBName)
+		selects = append(selects, quoted)
+		groups = append(groups, quoted)
+		table.Columns = append(table.Columns, TableColumn{Name: field.DBName, Type: columnType(field)})
+	}
+	for _, aggregation := range aggregations {
+		column, typ, err := aggregateColumn(stmt, aggregation, len(joins) > 0)
+		if err != nil {
+			return nil, err
+		}
+		table.Columns = append(table.Columns, TableColumn{Name: aggregation.Name(), Type: typ})
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s",
+			strings.ToUpper(string(aggregation.Func)), column, stmt.Quote(aggregation.Name())))
+	}
+	if len(selects) == 0 {
+		return nil, fmt.Errorf("%w: nothing to aggregate", ErrInvalidAggregation)
+	}
+
+	query := orm.ReadDB(ctx).Model(new(T))
+	for _, option := range options {
+		query = option(query)
+	}
+	// aggregates all the matched models, in the order of groups
+	delete(query.Statement.Clauses, "ORDER BY")
+	delete(query.Statement.Clauses, "LIMIT")
+	if len(joins) > 0 {
+		// filtered before the joins, where the columns are unambiguous
+		query = orm.ReadDB(ctx).Table("(?) AS "+stmt.Quote(stmt.Schema.Table),
+			query.Select(stmt.Quote(stmt.Schema.Table)+".*")).
+			Joins(strings.Join(joins, " "))
+	}
+	query = query.Select(strings.Join(selects, ", "))
+	if len(groups) > 0 {
+		query = query.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		logger.WithError(err).Warn("Aggregate: query failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := make([]any, len(table.Columns))
+		pointers := make([]any, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			logger.WithError(err).Warn("Aggregate: scan failed")
+			return nil, err
+		}
+		for i, column := range table.Columns {
+			values[i] = convertColumnValue(values[i], column.Type)
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
+
+// aggregateColumn returns the SQL argument of the aggregate function and
+// the type of the result. joined for the models joined with many2many
+// associations, see Aggregate.
+func aggregateColumn(stmt *gorm.Statement, aggregation Aggregation, joined bool) (column string, typ string, err error) {
+	if aggregation.Func == AggregateCount && aggregation.Column == "" {
+		if joined && stmt.Schema.PrioritizedPrimaryField != nil {
+			return "DISTINCT " + stmt.Quote(stmt.Schema.Table+"."+stmt.Schema.PrioritizedPrimaryField.DBName), ColumnInt, nil
+		}
+		return "*", ColumnInt, nil
+	}
+	if joined && aggregation.Func != AggregateMin && aggregation.Func != AggregateMax {
+		return "", "", fmt.Errorf("%w: %s(%s) of models grouped by associations", ErrInvalidAggregation, aggregation.Func, aggregation.Column)
+	}
+	field := orm.LookUpField(stmt.Schema, aggregation.Column)
+	if field == nil || field.DBName == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownColumn, aggregation.Column)
+	}
+	column = stmt.Quote(stmt.Schema.Table + "." + field.DBName)
+	typ = columnType(field)
+	numeric := typ == ColumnInt || typ == ColumnFloat
+
+	switch aggregation.Func {
+	case AggregateCount:
+		return column, ColumnInt, nil
+	case AggregateSum:
+		if numeric {
+			return column, typ, nil
+		}
+	case AggregateAvg:
+		if numeric {
+			return column, ColumnFloat, nil
+		}
+	case AggregateMin, AggregateMax:
+		return column, typ, nil
+	}
+	return "", "", fmt.Errorf("%w: %s(%s)", ErrInvalidAggregation, aggregation.Func, aggregation.Column)
+}
+
+// many2manyJoins returns the LEFT JOINs of the join table of the many2many
+// relationship of stmt, and of the associated table aliased as alias, not
+// soft deleted.
+func many2manyJoins(stmt *gorm.Statement, rel *schema.Relationship, alias string) []string {
+	var own, related []string
+	for _, ref := range rel.References {
+		if ref.PrimaryKey == nil {
+			continue
+		}
+		joinColumn := stmt.Quote(rel.JoinTable.Table + "." + ref.ForeignKey.DBName)
+		if ref.OwnPrimaryKey {
+			own = append(own, joinColumn+" = "+stmt.Quote(stmt.Schema.Table+"."+ref.PrimaryKey.DBName))
+		} else {
+			related = append(related, stmt.Quote(alias+"."+ref.PrimaryKey.DBName)+" = "+joinColumn)
+		}
+	}
+	if column, err := softDeleteColumn(reflect.New(rel.FieldSchema.ModelType).Interface()); err == nil {
+		related = append(related, stmt.Quote(alias+"."+column)+" IS NULL")
+	}
+	return []string{
+		fmt.Sprintf("LEFT JOIN %s ON %s", stmt.Quote(rel.JoinTable.Table), strings.Join(own, " AND ")),
+		fmt.Sprintf("LEFT JOIN %s AS %s ON %s", stmt.Quote(rel.FieldSchema.Table), stmt.Quote(alias), strings.Join(related, " AND ")),
+	}
+}
+
+// columnType maps the data type of the field to a column type of Table.
+func columnType(field *schema.Field) string {
+	switch field.DataType {
+	case schema.Bool:
+		return ColumnBool
+	case schema.Int, schema.Uint:
+		return ColumnInt
+	case schema.Float:
+		return ColumnFloat
+	case schema.Time:
+		return ColumnTime
+	}
+	return ColumnString
+}
+
+// convertColumnValue converts a value scanned from the database (whose
+// type varies with the driver, e.g. []byte from mysql, int64 for booleans
+// from sqlite) to the column type.
+func convertColumnValue(value any, typ string) any {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if value == nil {
+		return nil
+	}
+
+	switch typ {
+	case ColumnBool:
+		switch v := value.(type) {
+		case int64:
+			return v != 0
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err == nil {
+				return b
+			}
+		}
+	case ColumnInt:
+		switch v := value.(type) {
+		case float64:
+			return int64(v)
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return int64(f)
+			}
+		}
+	case ColumnFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case ColumnTime:
+		if v, ok := value.(string); ok {
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return value
+}
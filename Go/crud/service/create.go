@@ -2,8 +2,7 @@ package service
 
 import (
 	"context"
-	"github.com/cdfmlr/crud/orm"
-	"gorm.io/gorm"
+	"github.com/cdfmlr/crud/broker"
 )
 
 // Create creates a model in the database.
@@ -61,18 +60,23 @@ func NestInto(parent any, field string) CreateMode {
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create Nested")
 
-		return orm.DB.WithContext(ctx).Session(&gorm.Session{FullSaveAssociations: true}).
-			Model(parent).Association(field).Append(modelToCreate)
+		return repositoryFor(parent).appendAssociation(ctx, parent, field, modelToCreate)
 	}
 }
 
-// IfNotExist creates a model if it does not exist.
+// IfNotExist creates a model. Despite the name, it does not check whether
+// the model exists: it fails on conflicts (e.g. a duplicated primary key).
+// Use Upsert to update, or keep, the existing one instead.
 func IfNotExist() CreateMode {
 	return func(ctx context.Context, modelToCreate any) error {
 		logger.WithContext(ctx).
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create IfNotExist")
 
-		return orm.DB.WithContext(ctx).Create(modelToCreate).Error
+		err := repositoryFor(modelToCreate).create(ctx, modelToCreate)
+		if err == nil {
+			publish(ctx, broker.EventCreated, modelToCreate)
+		}
+		return err
 	}
 }
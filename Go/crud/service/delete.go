@@ -2,15 +2,20 @@ package service
 
 import (
 	"context"
+	"github.com/cdfmlr/crud/broker"
 	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
 )
 
 // Delete a model from database.
 func Delete(ctx context.Context, model any) (rowsAffected int64, err error) {
 	logger.WithContext(ctx).
 		WithField("model", model).Trace("Delete model")
-	result := orm.DB.WithContext(ctx).Delete(model)
-	return result.RowsAffected, result.Error
+	rowsAffected, err = repositoryFor(model).delete(ctx, model)
+	if err == nil {
+		publish(ctx, broker.EventDeleted, model)
+	}
+	return rowsAffected, err
 }
 
 // DeleteByID deletes a model from database by its ID.
@@ -26,17 +31,68 @@ func DeleteByID[T orm.Model](ctx context.Context, id any) (rowsAffected int64, e
 			Warn("DeleteByID: GetByID failed")
 		return 0, err
 	}
-	result := orm.DB.WithContext(ctx).Delete(&model)
-	if result.Error != nil {
+	rowsAffected, err = repositoryFor(&model).delete(ctx, &model)
+	if err != nil {
+		logger.WithContext(ctx).
+			WithError(err).Warn("DeleteByID: failed")
+	} else {
+		publish(ctx, broker.EventDeleted, &model)
+	}
+	return rowsAffected, err
+}
+
+// DeleteByIDIf deletes a model from database by its ID, if the current
+// version of it satisfies the precondition, otherwise ErrPreconditionFailed
+// is returned. See UpdateIf.
+func DeleteByIDIf[T orm.Model](ctx context.Context, id any, precondition func(current *T) bool) (rowsAffected int64, err error) {
+	logger.WithContext(ctx).
+		WithField("id", id).
+		Trace("DeleteByIDIf: Delete model by ID")
+
+	if id == nil {
+		return 0, ErrNilID
+	}
+
+	var deleted *T
+	if usingRepository[T]() {
+		// out of the database, with no locks: check, then delete
+		repo := RepositoryOf[T]()
+		deleted, err = getCurrent(ctx, repo, id)
+		if err == nil && !precondition(deleted) {
+			err = ErrPreconditionFailed
+		}
+		if err == nil {
+			rowsAffected, err = repo.Delete(ctx, deleted)
+		}
+	} else {
+		err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			current, err := lockByID[T](tx, id)
+			if err != nil {
+				return err
+			}
+			if !precondition(current) {
+				return ErrPreconditionFailed
+			}
+			result := tx.Delete(current)
+			if result.Error != nil {
+				return result.Error
+			}
+			rowsAffected, deleted = result.RowsAffected, current
+			return emit(ctx, tx, eventDeleted, current)
+		})
+	}
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("DeleteByID: failed")
+			WithError(err).Warn("DeleteByIDIf: failed")
+	} else {
+		publish(ctx, broker.EventDeleted, deleted)
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
 }
 
 // DeleteNested remove the association between parent and child.
 func DeleteNested[P orm.Model, T any](ctx context.Context, parent *P, field string, child *T) error {
-	err := orm.DB.WithContext(ctx).Model(parent).Association(field).Delete(child)
+	err := repositoryFor(parent).deleteAssociation(ctx, parent, field, child)
 	if err != nil {
 		logger.WithContext(ctx).
 			WithError(err).Warn("DeleteNested: failed")
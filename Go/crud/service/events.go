@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/middleware"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+	"gorm.io/gorm"
+)
+
+// publish publishes a change event of the model (an orm.Model or a
+// pointer to it) to the broker.Default, after a successful mutation.
+//
+// Once the outbox is enabled, the events of the models in the database
+// take the outbox instead (see emit), relayed to the broker by an
+// outbox.BrokerPublisher, so that publish only publishes the events of
+// the models in the repositories set by UseRepository, which have no
+// outbox.
+//
+// The actor is taken from ctx by middleware.EmailKey (a *gin.Context
+// passed as ctx provides the authenticated user).
+func publish(ctx context.Context, eventType broker.EventType, model any) {
+	if _, custom := registeredRepository(model); outbox.Enabled() && !custom {
+		return
+	}
+	name, id, data, ok := describe(model)
+	if !ok {
+		return
+	}
+
+	event := broker.Event{
+		Type:    eventType,
+		Model:   name,
+		ModelID: id,
+		Data:    data,
+	}
+	if actor, ok := ctx.Value(middleware.EmailKey).(string); ok {
+		event.Actor = actor
+	}
+
+	event = broker.Publish(event)
+	logger.WithContext(ctx).
+		WithField("event", event.ID).
+		WithField("type", event.Type).
+		WithField("model", event.Model).
+		Trace("publish: event published")
+}
+
+// Domain event verbs, see emit.
+const (
+	eventCreated  = "Created"
+	eventUpdated  = "Updated"
+	eventDeleted  = "Deleted"
+	eventRestored = "Restored"
+	eventPurged   = "Purged"
+	eventLinked   = "Linked"
+	eventUnlinked = "Unlinked"
+)
+
+// auditActions maps the event verbs to the audited actions.
+var auditActions = map[string]audit.Action{
+	eventCreated:  audit.ActionCreate,
+	eventUpdated:  audit.ActionUpdate,
+	eventDeleted:  audit.ActionDelete,
+	eventRestored: audit.ActionRestore,
+	eventPurged:   audit.ActionPurge,
+	eventLinked:   audit.ActionLink,
+	eventUnlinked: audit.ActionUnlink,
+}
+
+// emit writes the domain event "<Model><verb>" (e.g. TodoCreated) of the
+// model into the outbox with tx, the transaction of the mutation. So the
+// event is kept if and only if the mutation is committed. So is the audit
+// entry of the mutation, see audit.Write.
+//
+// The event is not written if the outbox is not enabled, see outbox.Enable.
+func emit(ctx context.Context, tx *gorm.DB, verb string, model any) error {
+	if err := audit.Write(ctx, tx, auditActions[verb], model); err != nil {
+		return err
+	}
+	if !outbox.Enabled() {
+		return nil
+	}
+	name, id, data, ok := describe(model)
+	if !ok {
+		return nil
+	}
+	return outbox.Write(tx, name+verb, id, data, eventHeaders(ctx))
+}
+
+// emitNested writes the domain event "<Parent><Child><verb>" (e.g.
+// ProjectTodoLinked) of the association between parent and child, and its
+// audit entry, see emit.
+func emitNested(ctx context.Context, tx *gorm.DB, verb string, parent any, field string, child any) error {
+	parentName, parentID, _, ok := describe(parent)
+	if !ok {
+		return nil
+	}
+	childName, childID, data, ok := describe(child)
+	if !ok {
+		return nil
+	}
+	if err := audit.WriteNested(ctx, tx, auditActions[verb], parent, field, childID); err != nil {
+		return err
+	}
+	if !outbox.Enabled() {
+		return nil
+	}
+	return outbox.Write(tx, parentName+childName+verb, parentID, map[string]any{
+		"parent_id": parentID,
+		"field":     field,
+		"child_id":  childID,
+		"child":     data,
+	}, eventHeaders(ctx))
+}
+
+// eventHeaders returns the metadata of the request in ctx for events.
+func eventHeaders(ctx context.Context) map[string]string {
+	headers := map[string]string{}
+	if actor, ok := ctx.Value(middleware.EmailKey).(string); ok && actor != "" {
+		headers["actor"] = actor
+	}
+	if requestID, ok := ctx.Value("request_id").(string); ok && requestID != "" {
+		headers["request_id"] = requestID
+	}
+	return headers
+}
+
+// describe returns the type name, the identity value (if it's an
+// orm.Model) and a copy of the model (or a pointer to it).
+// ok is false if model is not a struct.
+func describe(model any) (name string, id string, data any, ok bool) {
+	v := reflect.ValueOf(model)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", "", nil, false
+	}
+
+	if m, ok := model.(orm.Model); ok {
+		_, value := m.Identity()
+		id = fmt.Sprint(value)
+	}
+	// a copy, the caller may change model later
+	return v.Type().Name(), id, v.Interface(), true
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Fieldset is a resolved sparse fieldset of a model, or of an association
+// of the model, see ResolveFields.
+type Fieldset struct {
+	Path     string   // association path in field names, e.g. "Todos.Tags", "" for the model itself
+	JSONPath []string // association path in JSON keys, e.g. ["todos", "tags"]
+	Columns  []string // columns to Select: the fields and the keys required to preload and ETag
+	Keys     []string // JSON keys of the fields in the serialized model
+}
+
+var (
+	ErrUnknownField       = errors.New("unknown field")
+	ErrUnknownAssociation = errors.New("unknown association")
+)
+
+// ResolveFields resolves the names (field names, JSON keys or column
+// names) of the fields of model, or of its association at path (e.g.
+// "Todos" or "todos.tags", "" for the model itself), into a Fieldset.
+//
+// The primary keys, the foreign keys and the UpdatedAt field are always
+// selected (so associations can be preloaded, and ETags computed), but
+// only the primary keys are added to Keys, besides the given fields.
+func ResolveFields(model any, path string, names []string) (*Fieldset, error) {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldset := &Fieldset{}
+	var relationship *schema.Relationship
+	if path != "" {
+		var relationships []string
+		for _, segment := range strings.Split(path, ".") {
+			relationship = orm.LookUpRelationship(s, segment)
+			if relationship == nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownAssociation, path)
+			}
+			relationships = append(relationships, relationship.Name)
+			fieldset.JSONPath = append(fieldset.JSONPath, orm.JSONKey(relationship.Field))
+			s = relationship.FieldSchema
+		}
+		fieldset.Path = strings.Join(relationships, ".")
+	}
+
+	columns := map[string]bool{}
+	addColumn := func(field *schema.Field) {
+		if field != nil && field.DBName != "" && !columns[field.DBName] {
+			columns[field.DBName] = true
+			fieldset.Columns = append(fieldset.Columns, field.DBName)
+		}
+	}
+
+	for _, field := range s.PrimaryFields {
+		addColumn(field)
+		fieldset.Keys = append(fieldset.Keys, orm.JSONKey(field))
+	}
+	addColumn(s.LookUpField("UpdatedAt"))
+	for _, rel := range s.Relationships.Relations {
+		for _, ref := range rel.References {
+			if ref.ForeignKey != nil && ref.ForeignKey.Schema == s {
+				addColumn(ref.ForeignKey) // belongs to
+			}
+		}
+	}
+	if relationship != nil {
+		for _, ref := range relationship.References {
+			if ref.ForeignKey != nil && ref.ForeignKey.Schema == s {
+				addColumn(ref.ForeignKey) // has one, has many
+			}
+		}
+	}
+
+	for _, name := range names {
+		field := lookUpField(s, name)
+		if field == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+		addColumn(field)
+		if key := orm.JSONKey(field); !contains(fieldset.Keys, key) {
+			fieldset.Keys = append(fieldset.Keys, key)
+		}
+	}
+	return fieldset, nil
+}
+
+// Select is a query option to select only the given columns.
+func Select(columns ...string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Select(columns)
+	}
+}
+
+// lookUpField finds a field by orm.LookUpField, if it's in the JSON of
+// the model, see orm.JSONKey.
+func lookUpField(s *schema.Schema, name string) *schema.Field {
+	if field := orm.LookUpField(s, name); field != nil && orm.JSONKey(field) != "" {
+		return field
+	}
+	return nil
+}
+
+func contains(s []string, e string) bool {
+	for _, x := range s {
+		if x == e {
+			return true
+		}
+	}
+	return false
+}
@@ -36,20 +36,16 @@ func Get[T any](ctx context.Context, dest any, options ...QueryOption) error {
 
 	logger.Trace("Get model into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
-	for _, option := range options {
-		query = option(query)
-	}
-	ret := query.Take(dest)
+	err := RepositoryOf[T]().Get(ctx, dest, options...)
 
-	if ret.Error != nil {
-		logger.WithError(ret.Error).
+	if err != nil {
+		logger.WithError(err).
 			WithField("model", fmt.Sprintf("%T", vT)).
 			WithField("dest", fmt.Sprintf("%T", dest)).
 			Warnf("Get[%T] into %T failed", vT, dest)
 	}
 
-	return ret.Error
+	return err
 }
 
 // GetByID is a shortcut for Get[T](&T, FilterBy("id", id))
@@ -57,6 +53,9 @@ func Get[T any](ctx context.Context, dest any, options ...QueryOption) error {
 // Notice: "id" here is the column (or field) name of the primary key of the
 // model which is indicated by the Identity method of orm.Model.
 // So GetByID only works for models that implement the orm.Model interface.
+//
+// For models with a composite primary key, id is an orm.CompositeID or its
+// string form, e.g. "3,7" (from URLs).
 func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...QueryOption) error {
 	logger.WithContext(ctx).WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("dest", fmt.Sprintf("%T", dest)).
@@ -71,7 +70,12 @@ func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...Quer
 		logger.WithContext(ctx).Warn("GetByID skipped: unknown id field")
 		return ErrNoIdentityField
 	}
-	options = append(options, FilterBy(idField, id))
+	conds, err := orm.IdentityConds(*new(T), id)
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).Warn("GetByID skipped: bad id")
+		return err
+	}
+	options = append(options, Where(conds))
 	return Get[T](ctx, dest, options...)
 }
 
@@ -103,16 +107,12 @@ func GetMany[T any](ctx context.Context, dest any, options ...QueryOption) error
 		WithField("dest", fmt.Sprintf("%T", dest))
 	logger.Trace("GetMany: Get models into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
-	for _, option := range options {
-		query = option(query)
-	}
-	ret := query.Find(dest)
-	if ret.Error != nil {
-		logger.WithError(ret.Error).
+	err := RepositoryOf[T]().GetMany(ctx, dest, options...)
+	if err != nil {
+		logger.WithError(err).
 			Warn("GetMany: Get models into dest failed")
 	}
-	return ret.Error
+	return err
 }
 
 // Count returns the number of models.
@@ -121,16 +121,49 @@ func Count[T any](ctx context.Context, options ...QueryOption) (count int64, err
 		WithField("model", fmt.Sprintf("%T", *new(T)))
 	logger.Trace("Count: Count models")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	count, err = RepositoryOf[T]().Count(ctx, options...)
+	if err != nil {
+		logger.WithError(err).Warn("Count: Count models failed")
+	}
+	return count, err
+}
+
+// FindInBatches queries models T batchSize by batchSize in the order of
+// the primary key, and calls fn with each batch, so that a huge number of
+// models can be processed without loading them all into memory.
+//
+// Options (e.g. FilterBy, Search, Preload) are applied to each batch,
+// except orders and pagination. It stops at the first error returned by
+// fn, or when ctx is done.
+func FindInBatches[T any](ctx context.Context, batchSize int, fn func(batch []*T) error, options ...QueryOption) error {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("batchSize", batchSize)
+	logger.Trace("FindInBatches: Get models in batches")
+
+	if err := gormOnly(new(T)); err != nil {
+		return err
+	}
+	query := orm.ReadDB(ctx).Model(new(T))
 	for _, option := range options {
 		query = option(query)
 	}
-	ret := query.Count(&count)
+	// batches are paginated by the primary key
+	delete(query.Statement.Clauses, "ORDER BY")
+	delete(query.Statement.Clauses, "LIMIT")
+
+	var batch []*T
+	ret := query.FindInBatches(&batch, batchSize, func(tx *gorm.DB, n int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(batch)
+	})
 	if ret.Error != nil {
-		logger.WithError(ret.Error).Warn("Count: Count models failed")
-		err = ret.Error
+		logger.WithError(ret.Error).
+			Warn("FindInBatches: Get models in batches failed")
 	}
-	return count, ret.Error
+	return ret.Error
 }
 
 // GetAssociations find matched associations (model.field) into dest.
@@ -142,7 +175,7 @@ func GetAssociations(ctx context.Context, model any, field string, dest any, opt
 
 	logger.Trace("GetAssociation: Get association into dest")
 
-	err := associationQuery(ctx, model, field, options...).Find(dest)
+	err := repositoryFor(model).getAssociations(ctx, model, field, dest, options...)
 	if err != nil {
 		logger.WithError(err).
 			Warn("GetAssociation: Get association into dest failed")
@@ -157,17 +190,7 @@ func CountAssociations(ctx context.Context, model any, field string, options ...
 		WithField("field", field).
 		Trace("CountAssociations: Count associations")
 
-	count = associationQuery(ctx, model, field, options...).Count()
-	return count, err
-}
-
-// associationQuery builds a gorm association query
-func associationQuery(ctx context.Context, model any, field string, options ...QueryOption) *gorm.Association {
-	query := orm.DB.WithContext(ctx).Model(model)
-	for _, option := range options {
-		query = option(query)
-	}
-	return query.Association(field)
+	return repositoryFor(model).countAssociations(ctx, model, field, options...)
 }
 
 // QueryOption is a function that can be used to construct a query.
@@ -244,6 +267,13 @@ func Where(query any, args ...any) QueryOption {
 	}
 }
 
+// Unscoped includes soft deleted records in the query.
+func Unscoped() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped()
+	}
+}
+
 var (
 	ErrNoIdentityField = errors.New("no identity field found")
 	ErrNilID           = errors.New("id is nil")
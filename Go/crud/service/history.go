@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+// GetVersions returns the previous versions of the model T (by id),
+// oldest first. History must be enabled for T, see orm.EnableHistory.
+//
+// Options like WithPage and OrderBy are applied to the versions query.
+func GetVersions[T orm.Model](ctx context.Context, id any, options ...QueryOption) ([]orm.Version, error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id)
+	logger.Trace("GetVersions")
+
+	query, err := versionQuery[T](ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Order("version")
+	for _, option := range options {
+		query = option(query)
+	}
+
+	var versions []orm.Version
+	if err := query.Find(&versions).Error; err != nil {
+		logger.WithError(err).Warn("GetVersions failed")
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetVersion returns the n-th version of the model T (by id).
+func GetVersion[T orm.Model](ctx context.Context, id any, n int) (*orm.Version, error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id).WithField("version", n)
+	logger.Trace("GetVersion")
+
+	query, err := versionQuery[T](ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var version orm.Version
+	if err := query.Where("version = ?", n).Take(&version).Error; err != nil {
+		logger.WithError(err).Warn("GetVersion failed")
+		return nil, err
+	}
+	return &version, nil
+}
+
+// RestoreVersion restores the model T (by id) to its n-th version,
+// the restored model is written into dest.
+//
+// The restore is an update as well, so the replaced version is kept as
+// a new version. Restoring a version before the deletion of a (soft)
+// deleted record undeletes it.
+func RestoreVersion[T orm.Model](ctx context.Context, id any, n int, dest *T) error {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id).WithField("version", n)
+	logger.Trace("RestoreVersion")
+
+	version, err := GetVersion[T](ctx, id, n)
+	if err != nil {
+		return err
+	}
+
+	conds, err := orm.IdentityConds(*new(T), id)
+	if err != nil {
+		return err
+	}
+	err = orm.DB.WithContext(ctx).Unscoped().Where(conds).Take(dest).Error
+	if err != nil {
+		logger.WithError(err).Warn("RestoreVersion: get current version failed")
+		return err
+	}
+
+	before := *dest
+	// fields missing in the version keep their current values
+	data, err := json.Marshal(version.Data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return err
+	}
+
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Save(dest).Error; err != nil {
+			return err
+		}
+		return emit(audit.WithBefore(ctx, &before), tx, eventRestored, dest)
+	})
+	if err != nil {
+		logger.WithError(err).Warn("RestoreVersion: save failed")
+		return err
+	}
+	publish(ctx, broker.EventUpdated, dest)
+	return nil
+}
+
+// versionQuery builds a query on the versions of model T (by id).
+func versionQuery[T orm.Model](ctx context.Context, id any) (*gorm.DB, error) {
+	if id == nil {
+		return nil, ErrNilID
+	}
+	if err := gormOnly(new(T)); err != nil {
+		return nil, err
+	}
+	if !orm.HistoryEnabled(new(T)) {
+		return nil, orm.ErrHistoryNotEnabled
+	}
+
+	stmt := &gorm.Statement{DB: orm.DB}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	return orm.DB.WithContext(ctx).
+		Table(orm.VersionTable(stmt.Schema.Table)).
+		Where("record_id = ?", fmt.Sprint(id)), nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdfmlr/crud/orm"
+)
+
+type versionedTodo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+func TestRestoreVersion(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := orm.DB.AutoMigrate(&versionedTodo{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	if err := orm.EnableHistory(&versionedTodo{}); err != nil {
+		t.Fatalf("EnableHistory() error = %v", err)
+	}
+	ctx := context.Background()
+
+	todo := versionedTodo{Title: "draft"}
+	if err := Create(ctx, &todo, IfNotExist()); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	todo.Title, todo.Done = "final", true
+	if _, err := Update(ctx, &todo); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	versions, err := GetVersions[versionedTodo](ctx, todo.ID)
+	if err != nil || len(versions) != 1 || versions[0].Data["title"] != "draft" {
+		t.Fatalf("GetVersions() = %+v, %v, want the draft", versions, err)
+	}
+
+	t.Run("restore a version", func(t *testing.T) {
+		var restored versionedTodo
+		if err := RestoreVersion[versionedTodo](ctx, todo.ID, 1, &restored); err != nil {
+			t.Fatalf("RestoreVersion() error = %v", err)
+		}
+		if restored.Title != "draft" || restored.Done {
+			t.Errorf("restored = %+v, want the draft", restored)
+		}
+		// the replaced one is kept as the next version
+		version, err := GetVersion[versionedTodo](ctx, todo.ID, 2)
+		if err != nil || version.Data["title"] != "final" {
+			t.Errorf("GetVersion(2) = %+v, %v, want the final", version, err)
+		}
+	})
+
+	t.Run("restore undeletes", func(t *testing.T) {
+		if _, err := DeleteByID[versionedTodo](ctx, todo.ID); err != nil {
+			t.Fatalf("DeleteByID() error = %v", err)
+		}
+		var restored versionedTodo
+		if err := RestoreVersion[versionedTodo](ctx, todo.ID, 2, &restored); err != nil {
+			t.Fatalf("RestoreVersion() error = %v", err)
+		}
+		var got versionedTodo
+		if err := GetByID[versionedTodo](ctx, todo.ID, &got); err != nil || got.Title != "final" {
+			t.Errorf("GetByID() = %+v, %v, want the final one back", got, err)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		var restored versionedTodo
+		if err := RestoreVersion[versionedTodo](ctx, todo.ID, 42, &restored); err == nil {
+			t.Errorf("RestoreVersion(42) succeeded, want an error")
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ImportRow is a row to Import: the model decoded from a line of the
+// imported file (see DecodeFields), and the fields given in the row.
+type ImportRow[T any] struct {
+	Line   int
+	Model  *T
+	Fields []string // field names, the ones to update for existing models
+}
+
+// Imported is a model created or updated by Import.
+type Imported[T any] struct {
+	Line   int
+	Model  *T
+	Before *T // the existing model updated, nil if created
+}
+
+// ImportError is an error of a row.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportReport reports the result of an Import.
+type ImportReport struct {
+	DryRun  bool          `json:"dry_run"`
+	Created int           `json:"created"` // rows created (or to be created, in a dry run)
+	Updated int           `json:"updated"` // rows updated (or to be updated, in a dry run)
+	Errors  []ImportError `json:"errors"`
+}
+
+// FieldError is an error of a field, see DecodeFields.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+var ErrImportFailed = errors.New("import failed, nothing imported")
+
+// errRollback rolls back the transaction of a dry run.
+var errRollback = errors.New("rollback")
+
+// DecodeFields sets the fields of model (a pointer to a struct) to the
+// values keyed by names (field names, JSON keys or column names), returns
+// the names of the fields set.
+//
+// Strings are parsed into the types of the fields, e.g. "true" for a bool
+// field (as read from CSV). Nil values are skipped. An unknown or
+// unparsable field is returned as a *FieldError.
+func DecodeFields(ctx context.Context, model any, values map[string]any) (fields []string, err error) {
+	modelSchema, err := orm.ParseSchema(model)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(model)
+
+	for name, value := range values {
+		field := lookUpField(modelSchema, name)
+		if field == nil {
+			return fields, &FieldError{Field: name, Err: ErrUnknownField}
+		}
+		if value == nil {
+			continue
+		}
+		if s, ok := value.(string); ok {
+			if value, err = parseFieldString(field, s); err != nil {
+				return fields, &FieldError{Field: name, Err: err}
+			}
+		}
+		if err := field.Set(ctx, rv, value); err != nil {
+			return fields, &FieldError{Field: name, Err: err}
+		}
+		fields = append(fields, field.Name)
+	}
+	return fields, nil
+}
+
+// parseFieldString parses s into the type of the field strictly, where
+// gorm's field.Set would take an invalid bool as false.
+func parseFieldString(field *schema.Field, s string) (any, error) {
+	switch field.DataType {
+	case schema.Bool:
+		return strconv.ParseBool(s)
+	case schema.Int:
+		return strconv.ParseInt(s, 10, 64)
+	case schema.Uint:
+		return strconv.ParseUint(s, 10, 64)
+	case schema.Float:
+		return strconv.ParseFloat(s, 64)
+	}
+	return s, nil // strings, and times parsed by field.Set
+}
+
+// Import creates, or updates, the models of rows in a transaction.
+//
+// If key is not empty (a field name, JSON key or column name), the
+// existing model with the same key value as a row is updated with the
+// fields given in the row, instead of creating a new one.
+//
+// Every row is tried (in a savepoint), and the errors are reported by
+// lines. If any row fails, the transaction is rolled back and
+// ErrImportFailed is returned with the report. So is a dry run, but
+// returns no error if all rows succeed.
+//
+// Events of the imported models are emitted and published, as Create and
+// Update do, only if committed.
+func Import[T any](ctx context.Context, rows []ImportRow[T], key string, dryRun bool) (report *ImportReport, imported []Imported[T], err error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("rows", len(rows)).
+		WithField("key", key).
+		WithField("dryRun", dryRun)
+	logger.Trace("Import: import models")
+
+	if err := gormOnly(new(T)); err != nil {
+		return nil, nil, err
+	}
+	stmt := &gorm.Statement{DB: orm.DB}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, nil, err
+	}
+	var keyField *schema.Field
+	if key != "" {
+		if keyField = lookUpField(stmt.Schema, key); keyField == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownField, key)
+		}
+	}
+
+	report = &ImportReport{DryRun: dryRun, Errors: []ImportError{}}
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			var before *T
+			err := tx.Transaction(func(tx *gorm.DB) error { // a savepoint
+				if keyField != nil {
+					value, zero := keyField.ValueOf(ctx, reflect.ValueOf(row.Model))
+					if !zero {
+						var existing []*T
+						err := tx.Where(map[string]any{keyField.DBName: value}).
+							Limit(1).Find(&existing).Error
+						if err != nil {
+							return err
+						}
+						if len(existing) > 0 {
+							before = existing[0]
+						}
+					}
+				}
+
+				if before == nil {
+					if err := tx.Create(row.Model).Error; err != nil {
+						return err
+					}
+					return emit(ctx, tx, eventCreated, row.Model)
+				}
+				return importUpdate(ctx, tx, stmt.Schema, before, row)
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, ImportError{Line: row.Line, Error: err.Error()})
+				continue
+			}
+
+			if before == nil {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+			imported = append(imported, Imported[T]{Line: row.Line, Model: row.Model, Before: before})
+		}
+
+		if dryRun || len(report.Errors) > 0 {
+			return errRollback
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errRollback) && len(report.Errors) > 0:
+		logger.WithField("errors", len(report.Errors)).
+			Warn("Import: rows failed, rolled back")
+		return report, nil, ErrImportFailed
+	case errors.Is(err, errRollback): // a successful dry run
+		return report, imported, nil
+	case err != nil:
+		logger.WithError(err).Warn("Import: failed")
+		return nil, nil, err
+	}
+
+	for _, i := range imported {
+		if i.Before == nil {
+			publish(ctx, broker.EventCreated, i.Model)
+		} else {
+			publish(ctx, broker.EventUpdated, i.Model)
+		}
+	}
+	return report, imported, nil
+}
+
+// importUpdate updates the existing model (before) with the fields of the
+// row, and reloads the row model.
+func importUpdate[T any](ctx context.Context, tx *gorm.DB, s *schema.Schema, before *T, row ImportRow[T]) error {
+	rv := reflect.ValueOf(row.Model)
+	var fields []string
+	for _, name := range row.Fields {
+		if field := s.LookUpField(name); field == nil || !field.PrimaryKey {
+			fields = append(fields, name)
+		}
+	}
+	for _, field := range s.PrimaryFields {
+		value, _ := field.ValueOf(ctx, reflect.ValueOf(before))
+		if err := field.Set(ctx, rv, value); err != nil {
+			return err
+		}
+	}
+
+	if len(fields) > 0 {
+		if err := tx.Model(row.Model).Select(fields).Updates(row.Model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Take(row.Model).Error; err != nil {
+		return err
+	}
+	return emit(audit.WithBefore(ctx, before), tx, eventUpdated, row.Model)
+}
@@ -0,0 +1,660 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/schema"
+)
+
+// MemoryRepository is a Repository keeping models T in memory, with no
+// database, for tests and prototypes:
+//
+//    service.UseRepository[Todo](service.NewMemoryRepository[Todo]())
+//
+// QueryOptions are run on the models as GORM would run them on a database:
+// FilterBy (and Where with maps or structs), OrderBy, WithPage, Preload
+// (with options), Select and Unscoped are supported. Raw SQL (e.g. Where
+// with a string, Search) fails with ErrUnsupportedQuery.
+//
+// Integer primary keys are auto incremented, timestamps are tracked, and
+// the hooks of models (BeforeCreate, AfterSave...) are called, with a dry
+// run *gorm.DB.
+//
+// Associations are kept with the models. If the associated models use a
+// Repository of their own (see UseRepository), new ones are created in it,
+// and they are read from it by their primary keys (or by the foreign keys,
+// of belongs to associations).
+//
+// There are no transactions nor an outbox: domain events are not written,
+// the services publish the change events to the broker directly instead.
+//
+// Services working on the orm.DB only (Upsert, Replace, the trash...) fail
+// with ErrUnsupportedRepository, see Repository.
+type MemoryRepository[T any] struct {
+	mu     sync.RWMutex
+	models []*T // in the order of creation
+	lastID int64
+}
+
+// NewMemoryRepository returns an empty MemoryRepository.
+func NewMemoryRepository[T any]() *MemoryRepository[T] {
+	return &MemoryRepository[T]{}
+}
+
+func (r *MemoryRepository[T]) Get(ctx context.Context, dest any, options ...QueryOption) error {
+	models, q, err := r.query(ctx, options, true)
+	if err != nil {
+		return err
+	}
+	if len(models) == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	if err := q.load(ctx, models[0]); err != nil {
+		return err
+	}
+	return assignMemory(dest, models[:1])
+}
+
+func (r *MemoryRepository[T]) GetMany(ctx context.Context, dest any, options ...QueryOption) error {
+	models, q, err := r.query(ctx, options, true)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		if err := q.load(ctx, model); err != nil {
+			return err
+		}
+	}
+	return assignMemory(dest, models)
+}
+
+func (r *MemoryRepository[T]) Count(ctx context.Context, options ...QueryOption) (int64, error) {
+	models, _, err := r.query(ctx, options, false)
+	return int64(len(models)), err
+}
+
+// query returns copies of the models matching the options, sorted, and
+// paginated if page. They are not loaded yet, see memoryQuery.load.
+func (r *MemoryRepository[T]) query(ctx context.Context, options []QueryOption, page bool) ([]reflect.Value, *memoryQuery, error) {
+	s, err := memorySchema(new(T))
+	if err != nil {
+		return nil, nil, err
+	}
+	q, err := compileMemoryQuery(s, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.RLock()
+	models := make([]reflect.Value, len(r.models))
+	for i, model := range r.models {
+		models[i] = reflect.ValueOf(model)
+	}
+	matched, err := q.filter(ctx, models)
+	for i := range matched {
+		matched[i] = cloneMemory(matched[i])
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q.sort(ctx, matched)
+	if page {
+		matched = q.page(matched)
+	}
+	return matched, q, nil
+}
+
+func (r *MemoryRepository[T]) Create(ctx context.Context, model *T) error {
+	s, err := memorySchema(model)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(model)
+	if err := memoryHooks(ctx, model, "BeforeSave", "BeforeCreate"); err != nil {
+		return err
+	}
+	if err := memoryTimestamps(ctx, s, v, true); err != nil {
+		return err
+	}
+
+	// the primary key first, as the foreign key of associations
+	r.mu.Lock()
+	err = r.assignID(ctx, s, v)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := saveMemoryAssociations(ctx, s, v); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if r.index(ctx, s, memoryKey(ctx, s, v)) >= 0 {
+		r.mu.Unlock()
+		return gorm.ErrDuplicatedKey
+	}
+	r.models = append(r.models, cloneMemory(v).Interface().(*T))
+	r.mu.Unlock()
+
+	return memoryHooks(ctx, model, "AfterCreate", "AfterSave")
+}
+
+// Update saves all fields of the model, or creates it if it's not found,
+// like gorm.DB.Save. Associations are saved, but never removed.
+func (r *MemoryRepository[T]) Update(ctx context.Context, model *T) (rowsAffected int64, err error) {
+	s, err := memorySchema(model)
+	if err != nil {
+		return 0, err
+	}
+	v := reflect.ValueOf(model)
+	if memoryZeroKey(ctx, s, v) {
+		return 1, r.Create(ctx, model)
+	}
+	if err := memoryHooks(ctx, model, "BeforeSave", "BeforeUpdate"); err != nil {
+		return 0, err
+	}
+	if err := memoryTimestamps(ctx, s, v, false); err != nil {
+		return 0, err
+	}
+	if err := saveMemoryAssociations(ctx, s, v); err != nil {
+		return 0, err
+	}
+
+	saved := cloneMemory(v)
+	r.mu.Lock()
+	if i := r.index(ctx, s, memoryKey(ctx, s, v)); i >= 0 {
+		mergeMemoryAssociations(ctx, s, saved, reflect.ValueOf(r.models[i]))
+		r.models[i] = saved.Interface().(*T)
+	} else {
+		err = r.assignID(ctx, s, v) // keeps lastID after the key
+		r.models = append(r.models, saved.Interface().(*T))
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return 1, memoryHooks(ctx, model, "AfterUpdate", "AfterSave")
+}
+
+func (r *MemoryRepository[T]) Delete(ctx context.Context, model *T) (rowsAffected int64, err error) {
+	s, err := memorySchema(model)
+	if err != nil {
+		return 0, err
+	}
+	v := reflect.ValueOf(model)
+	if memoryZeroKey(ctx, s, v) {
+		return 0, gorm.ErrMissingWhereClause
+	}
+	if err := memoryHooks(ctx, model, "BeforeDelete"); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	i := r.index(ctx, s, memoryKey(ctx, s, v))
+	if i < 0 || memorySoftDeleted(ctx, s, reflect.ValueOf(r.models[i])) {
+		r.mu.Unlock()
+		return 0, nil
+	}
+	if field := memoryDeletedAtField(s); field != nil {
+		deletedAt := gorm.DeletedAt{Time: time.Now(), Valid: true}
+		err = field.Set(ctx, reflect.ValueOf(r.models[i]), deletedAt)
+		if err == nil {
+			err = field.Set(ctx, v, deletedAt)
+		}
+	} else {
+		r.models = append(r.models[:i], r.models[i+1:]...)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return 1, memoryHooks(ctx, model, "AfterDelete")
+}
+
+func (r *MemoryRepository[T]) GetAssociations(ctx context.Context, model *T, field string, dest any, options ...QueryOption) error {
+	children, q, err := r.associations(ctx, model, field, options)
+	if err != nil {
+		return err
+	}
+	q.sort(ctx, children)
+	children = q.page(children)
+	for _, child := range children {
+		if err := q.load(ctx, child); err != nil {
+			return err
+		}
+	}
+	return assignMemory(dest, children)
+}
+
+func (r *MemoryRepository[T]) CountAssociations(ctx context.Context, model *T, field string, options ...QueryOption) (int64, error) {
+	children, _, err := r.associations(ctx, model, field, options)
+	return int64(len(children)), err
+}
+
+// associations returns copies of the associations model.field matching the
+// options.
+func (r *MemoryRepository[T]) associations(ctx context.Context, model *T, field string, options []QueryOption) ([]reflect.Value, *memoryQuery, error) {
+	s, err := memorySchema(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel := orm.LookUpRelationship(s, field)
+	if rel == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownAssociation, field)
+	}
+	q, err := compileMemoryQuery(rel.FieldSchema, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := r.stored(ctx, model)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, q, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	children, err := associatedMemory(ctx, rel, reflect.ValueOf(stored))
+	if err != nil {
+		return nil, nil, err
+	}
+	children, err = q.filter(ctx, children)
+	return children, q, err
+}
+
+// AppendAssociation associates child to model.field: the child is created
+// (or updated) in the Repository of it, if any, and kept with the model.
+func (r *MemoryRepository[T]) AppendAssociation(ctx context.Context, model *T, field string, child any) error {
+	s, err := memorySchema(model)
+	if err != nil {
+		return err
+	}
+	rel := orm.LookUpRelationship(s, field)
+	if rel == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAssociation, field)
+	}
+	c := reflect.ValueOf(child)
+	if c.Kind() != reflect.Ptr {
+		p := reflect.New(c.Type())
+		p.Elem().Set(c)
+		c = p
+	}
+	if c.Elem().Type() != rel.FieldSchema.ModelType {
+		return fmt.Errorf("%w: %T for %s", gorm.ErrInvalidValue, child, field)
+	}
+
+	v := reflect.ValueOf(model)
+	if err := saveMemoryChild(ctx, rel, v, c, true); err != nil {
+		return err
+	}
+	linkMemory(ctx, rel, v, cloneMemory(c))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.index(ctx, s, memoryKey(ctx, s, v))
+	if i < 0 {
+		return gorm.ErrRecordNotFound
+	}
+	linkMemory(ctx, rel, reflect.ValueOf(r.models[i]), cloneMemory(c))
+	return nil
+}
+
+// DeleteAssociation removes child from model.field. The foreign keys of has
+// one or has many children are cleared, as GORM does.
+func (r *MemoryRepository[T]) DeleteAssociation(ctx context.Context, model *T, field string, child any) error {
+	s, err := memorySchema(model)
+	if err != nil {
+		return err
+	}
+	rel := orm.LookUpRelationship(s, field)
+	if rel == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAssociation, field)
+	}
+	v := reflect.ValueOf(model)
+	key := memoryKey(ctx, rel.FieldSchema, reflect.ValueOf(child))
+
+	unlinkMemory(ctx, rel, v, key)
+	r.mu.Lock()
+	if i := r.index(ctx, s, memoryKey(ctx, s, v)); i >= 0 {
+		unlinkMemory(ctx, rel, reflect.ValueOf(r.models[i]), key)
+	}
+	r.mu.Unlock()
+
+	if rel.Type != schema.HasOne && rel.Type != schema.HasMany {
+		return nil
+	}
+	repo, ok := registeredRepository(child)
+	if !ok {
+		return nil
+	}
+	current, err := repo.reload(ctx, child)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey && ref.ForeignKey != nil {
+			fk := ref.ForeignKey.ReflectValueOf(ctx, reflect.ValueOf(current))
+			fk.Set(reflect.Zero(fk.Type()))
+		}
+	}
+	_, err = repo.update(ctx, current)
+	return err
+}
+
+// stored returns a copy of the stored version of the model, with its
+// associations as they are, or gorm.ErrRecordNotFound.
+func (r *MemoryRepository[T]) stored(ctx context.Context, model *T) (*T, error) {
+	s, err := memorySchema(model)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := r.index(ctx, s, memoryKey(ctx, s, reflect.ValueOf(model)))
+	if i < 0 || memorySoftDeleted(ctx, s, reflect.ValueOf(r.models[i])) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return cloneMemory(reflect.ValueOf(r.models[i])).Interface().(*T), nil
+}
+
+// index returns the index of the model of the key in r.models, or -1.
+// It must be called with r.mu locked.
+func (r *MemoryRepository[T]) index(ctx context.Context, s *schema.Schema, key string) int {
+	for i, model := range r.models {
+		if memoryKey(ctx, s, reflect.ValueOf(model)) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// assignID assigns an auto increment primary key to the model, if it has
+// none. It must be called with r.mu locked.
+func (r *MemoryRepository[T]) assignID(ctx context.Context, s *schema.Schema, model reflect.Value) error {
+	field := s.PrioritizedPrimaryField
+	if field == nil || !field.AutoIncrement {
+		return nil
+	}
+	if value, zero := field.ValueOf(ctx, model); !zero {
+		if id, ok := memoryFloat(memoryValue(value)); ok && int64(id) > r.lastID {
+			r.lastID = int64(id)
+		}
+		return nil
+	}
+	r.lastID++
+	return field.Set(ctx, model, r.lastID)
+}
+
+// memoryKey returns the primary key of the model, to look it up.
+func memoryKey(ctx context.Context, s *schema.Schema, model reflect.Value) string {
+	keys := make([]string, len(s.PrimaryFields))
+	for i, field := range s.PrimaryFields {
+		value, _ := field.ValueOf(ctx, model)
+		if f, ok := memoryValue(value).(float64); ok {
+			keys[i] = strconv.FormatFloat(f, 'f', -1, 64)
+		} else {
+			keys[i] = fmt.Sprint(memoryValue(value))
+		}
+	}
+	return strings.Join(keys, orm.CompositeIDSeparator)
+}
+
+// memoryZeroKey reports whether any primary key of the model is zero.
+func memoryZeroKey(ctx context.Context, s *schema.Schema, model reflect.Value) bool {
+	for _, field := range s.PrimaryFields {
+		if _, zero := field.ValueOf(ctx, model); zero {
+			return true
+		}
+	}
+	return len(s.PrimaryFields) == 0
+}
+
+// memoryHooks calls the hooks of the model, like GORM.
+func memoryHooks(ctx context.Context, model any, hooks ...string) error {
+	tx := memorySession().WithContext(ctx)
+	for _, hook := range hooks {
+		var err error
+		switch hook {
+		case "BeforeSave":
+			if m, ok := model.(callbacks.BeforeSaveInterface); ok {
+				err = m.BeforeSave(tx)
+			}
+		case "BeforeCreate":
+			if m, ok := model.(callbacks.BeforeCreateInterface); ok {
+				err = m.BeforeCreate(tx)
+			}
+		case "BeforeUpdate":
+			if m, ok := model.(callbacks.BeforeUpdateInterface); ok {
+				err = m.BeforeUpdate(tx)
+			}
+		case "BeforeDelete":
+			if m, ok := model.(callbacks.BeforeDeleteInterface); ok {
+				err = m.BeforeDelete(tx)
+			}
+		case "AfterSave":
+			if m, ok := model.(callbacks.AfterSaveInterface); ok {
+				err = m.AfterSave(tx)
+			}
+		case "AfterCreate":
+			if m, ok := model.(callbacks.AfterCreateInterface); ok {
+				err = m.AfterCreate(tx)
+			}
+		case "AfterUpdate":
+			if m, ok := model.(callbacks.AfterUpdateInterface); ok {
+				err = m.AfterUpdate(tx)
+			}
+		case "AfterDelete":
+			if m, ok := model.(callbacks.AfterDeleteInterface); ok {
+				err = m.AfterDelete(tx)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryTimestamps sets the autoCreateTime (if zero, on create) and
+// autoUpdateTime fields of the model to now.
+func memoryTimestamps(ctx context.Context, s *schema.Schema, model reflect.Value, create bool) error {
+	now := time.Now()
+	for _, field := range s.Fields {
+		_, zero := field.ValueOf(ctx, model)
+		if (field.AutoCreateTime > 0 && create && zero) || (field.AutoUpdateTime > 0 && (zero || !create)) {
+			if err := field.Set(ctx, model, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveMemoryAssociations saves the associated models of the model into the
+// Repositories of them, see saveMemoryChild.
+func saveMemoryAssociations(ctx context.Context, s *schema.Schema, model reflect.Value) error {
+	for _, rel := range s.Relationships.Relations {
+		for _, child := range memoryChildren(rel.Field.ReflectValueOf(ctx, model)) {
+			if err := saveMemoryChild(ctx, rel, model, child, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveMemoryChild sets the foreign keys between parent and child, and
+// creates the child in the Repository of it (if any), or updates it if
+// full (the FullSaveAssociations of GORM).
+func saveMemoryChild(ctx context.Context, rel *schema.Relationship, parent, child reflect.Value, full bool) error {
+	if rel.Type != schema.BelongsTo {
+		referMemory(ctx, rel, parent, child)
+	}
+	if repo, ok := registeredRepository(child.Interface()); ok {
+		_, err := repo.reload(ctx, child.Interface())
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			err = repo.create(ctx, child.Interface())
+		case err == nil && full:
+			_, err = repo.update(ctx, child.Interface())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if rel.Type == schema.BelongsTo {
+		referMemory(ctx, rel, parent, child)
+	}
+	return nil
+}
+
+// referMemory sets the foreign keys of the relationship between parent and
+// child: of the parent for belongs to, of the child for has one and has
+// many (and none for many2many, of the join table).
+func referMemory(ctx context.Context, rel *schema.Relationship, parent, child reflect.Value) {
+	if rel.Type == schema.Many2Many {
+		return
+	}
+	for _, ref := range rel.References {
+		if ref.ForeignKey == nil {
+			continue
+		}
+		switch {
+		case rel.Type == schema.BelongsTo && ref.PrimaryKey != nil:
+			value, _ := ref.PrimaryKey.ValueOf(ctx, child)
+			_ = ref.ForeignKey.Set(ctx, parent, value)
+		case (rel.Type == schema.HasOne || rel.Type == schema.HasMany) && ref.OwnPrimaryKey:
+			if ref.PrimaryKey != nil {
+				value, _ := ref.PrimaryKey.ValueOf(ctx, parent)
+				_ = ref.ForeignKey.Set(ctx, child, value)
+			} else if ref.PrimaryValue != "" { // polymorphic
+				_ = ref.ForeignKey.Set(ctx, child, ref.PrimaryValue)
+			}
+		}
+	}
+}
+
+// linkMemory associates child to parent.field of the relationship,
+// replacing the one of the same primary key.
+func linkMemory(ctx context.Context, rel *schema.Relationship, parent, child reflect.Value) {
+	key := memoryKey(ctx, rel.FieldSchema, child)
+	field := rel.Field.ReflectValueOf(ctx, parent)
+	var children []reflect.Value
+	for _, c := range memoryChildren(field) {
+		if memoryKey(ctx, rel.FieldSchema, c) != key {
+			children = append(children, c)
+		}
+	}
+	setMemoryChildren(field, append(children, child))
+	if rel.Type == schema.BelongsTo {
+		referMemory(ctx, rel, parent, child)
+	}
+}
+
+// unlinkMemory removes the child of the key from parent.field of the
+// relationship.
+func unlinkMemory(ctx context.Context, rel *schema.Relationship, parent reflect.Value, key string) {
+	field := rel.Field.ReflectValueOf(ctx, parent)
+	var children []reflect.Value
+	removed := false
+	for _, c := range memoryChildren(field) {
+		if memoryKey(ctx, rel.FieldSchema, c) == key {
+			removed = true
+			continue
+		}
+		children = append(children, c)
+	}
+	setMemoryChildren(field, children)
+
+	if rel.Type == schema.BelongsTo && removed {
+		for _, ref := range rel.References {
+			if ref.ForeignKey != nil {
+				fk := ref.ForeignKey.ReflectValueOf(ctx, parent)
+				fk.Set(reflect.Zero(fk.Type()))
+			}
+		}
+	}
+}
+
+// mergeMemoryAssociations adds the associations of the stored version of
+// the model, that are not in the model (to be saved), into it.
+func mergeMemoryAssociations(ctx context.Context, s *schema.Schema, model, stored reflect.Value) {
+	for _, rel := range s.Relationships.Relations {
+		field := rel.Field.ReflectValueOf(ctx, model)
+		children := memoryChildren(field)
+		if field.Kind() != reflect.Slice {
+			if len(children) == 0 {
+				field.Set(rel.Field.ReflectValueOf(ctx, stored))
+			}
+			continue
+		}
+
+		keys := map[string]bool{}
+		for _, child := range children {
+			keys[memoryKey(ctx, rel.FieldSchema, child)] = true
+		}
+		var merged []reflect.Value
+		for _, child := range memoryChildren(rel.Field.ReflectValueOf(ctx, stored)) {
+			if !keys[memoryKey(ctx, rel.FieldSchema, child)] {
+				merged = append(merged, child)
+			}
+		}
+		setMemoryChildren(field, append(merged, children...))
+	}
+}
+
+// associatedMemory returns the models associated to the parent by the
+// relationship, reloaded from their Repositories, see reloadMemoryChildren.
+// Belongs to associations are the ones of the foreign keys.
+func associatedMemory(ctx context.Context, rel *schema.Relationship, parent reflect.Value) ([]reflect.Value, error) {
+	children := memoryChildren(rel.Field.ReflectValueOf(ctx, parent))
+	if rel.Type == schema.BelongsTo {
+		child := reflect.New(rel.FieldSchema.ModelType)
+		for _, ref := range rel.References {
+			if ref.ForeignKey == nil || ref.PrimaryKey == nil {
+				continue
+			}
+			value, _ := ref.ForeignKey.ValueOf(ctx, parent)
+			if memoryValue(value) == nil {
+				return nil, nil
+			}
+			if err := ref.PrimaryKey.Set(ctx, child, memoryValue(value)); err != nil {
+				return nil, err
+			}
+		}
+		if memoryZeroKey(ctx, rel.FieldSchema, child) {
+			return nil, nil
+		}
+
+		if _, ok := registeredRepository(child.Interface()); ok {
+			children = []reflect.Value{child}
+		} else {
+			key := memoryKey(ctx, rel.FieldSchema, child)
+			var matched []reflect.Value
+			for _, c := range children {
+				if memoryKey(ctx, rel.FieldSchema, c) == key {
+					matched = append(matched, c)
+				}
+			}
+			children = matched
+		}
+	}
+	return reloadMemoryChildren(ctx, children)
+}
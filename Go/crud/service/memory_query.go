@@ -0,0 +1,801 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnsupportedQuery = errors.New("query not supported in memory")
+
+// memoryDialector is a gorm.Dialector of no database: dry run sessions of
+// it build the statements of QueryOptions, for MemoryRepository to run
+// them on models in memory.
+type memoryDialector struct{}
+
+func (memoryDialector) Name() string                    { return "memory" }
+func (memoryDialector) Initialize(*gorm.DB) error       { return nil }
+func (memoryDialector) Migrator(*gorm.DB) gorm.Migrator { return nil }
+func (memoryDialector) DataTypeOf(*schema.Field) string { return "" }
+func (memoryDialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+func (memoryDialector) BindVarTo(w clause.Writer, _ *gorm.Statement, _ any) { w.WriteByte('?') }
+func (memoryDialector) Explain(sql string, vars ...any) string              { return sql }
+
+func (memoryDialector) QuoteTo(w clause.Writer, str string) {
+	w.WriteByte('`')
+	w.WriteString(str)
+	w.WriteByte('`')
+}
+
+var (
+	memoryDB     *gorm.DB
+	memoryDBOnce sync.Once
+)
+
+// memorySession returns a dry run session of the memoryDialector.
+func memorySession() *gorm.DB {
+	memoryDBOnce.Do(func() {
+		db, err := gorm.Open(memoryDialector{}, &gorm.Config{
+			DryRun: true,
+			Logger: gormlogger.Discard,
+		})
+		if err != nil { // opens nothing, it never fails
+			panic(err)
+		}
+		memoryDB = db
+	})
+	return memoryDB
+}
+
+// memorySchema parses the schema of the model.
+func memorySchema(model any) (*schema.Schema, error) {
+	return orm.ParseSchema(model)
+}
+
+// memoryQuery is the query of QueryOptions, to run on models (pointers to
+// structs) of a schema in memory.
+type memoryQuery struct {
+	schema   *schema.Schema
+	where    []clause.Expression
+	orders   []memoryOrder
+	limit    int // -1 for no limit
+	offset   int
+	selects  map[string]bool // by field name, nil to select all
+	preloads map[string][]QueryOption
+	unscoped bool
+}
+
+type memoryOrder struct {
+	field *schema.Field
+	desc  bool
+}
+
+// compileMemoryQuery builds the statement of the options on a dry run
+// session, and compiles it into a memoryQuery.
+func compileMemoryQuery(s *schema.Schema, options []QueryOption) (*memoryQuery, error) {
+	tx := memorySession().Model(reflect.New(s.ModelType).Interface())
+	for _, option := range options {
+		tx = option(tx)
+	}
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	stmt := tx.Statement
+	if len(stmt.Joins) > 0 {
+		return nil, fmt.Errorf("%w: JOIN", ErrUnsupportedQuery)
+	}
+
+	q := &memoryQuery{schema: s, limit: -1, unscoped: stmt.Unscoped}
+	for name, c := range stmt.Clauses {
+		switch e := c.Expression.(type) {
+		case nil:
+		case clause.Where:
+			q.where = e.Exprs
+		case clause.OrderBy:
+			if e.Expression != nil {
+				return nil, fmt.Errorf("%w: ORDER BY %v", ErrUnsupportedQuery, e.Expression)
+			}
+			for _, column := range e.Columns {
+				orders, err := q.compileOrder(column)
+				if err != nil {
+					return nil, err
+				}
+				q.orders = append(q.orders, orders...)
+			}
+		case clause.Limit:
+			if e.Limit != nil {
+				q.limit = *e.Limit
+			}
+			q.offset = e.Offset
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedQuery, name)
+		}
+	}
+
+	for _, selects := range stmt.Selects {
+		for _, column := range strings.Split(selects, ",") {
+			column = strings.TrimSpace(column)
+			if column == "*" {
+				q.selects = nil
+				break
+			}
+			field := lookUpMemoryField(s, column)
+			if field == nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+			}
+			if q.selects == nil {
+				q.selects = map[string]bool{}
+			}
+			q.selects[field.Name] = true
+		}
+	}
+
+	q.preloads = map[string][]QueryOption{}
+	for name, args := range stmt.Preloads {
+		var options []QueryOption
+		for i, arg := range args {
+			if f, ok := arg.(func(*gorm.DB) *gorm.DB); ok {
+				options = append(options, f)
+				continue
+			}
+			// inline conditions: Preload("Todos", "done = ?", true)
+			options = append(options, Where(arg, args[i+1:]...))
+			break
+		}
+		if name == clause.Associations {
+			for _, rel := range s.Relationships.Relations {
+				q.preloads[rel.Name] = append(q.preloads[rel.Name], options...)
+			}
+			continue
+		}
+		// nested preloads are preloaded by the associations: A.B => A: Preload(B)
+		first, nested, _ := strings.Cut(name, ".")
+		if nested != "" {
+			options = []QueryOption{Preload(nested, options...)}
+		}
+		rel := orm.LookUpRelationship(s, first)
+		if rel == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAssociation, name)
+		}
+		q.preloads[rel.Name] = append(q.preloads[rel.Name], options...)
+	}
+	return q, nil
+}
+
+// compileOrder compiles an order by column, raw ones being like
+// "age desc, name".
+func (q *memoryQuery) compileOrder(column clause.OrderByColumn) ([]memoryOrder, error) {
+	if !column.Column.Raw {
+		field := lookUpMemoryField(q.schema, column.Column.Name)
+		if field == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column.Column.Name)
+		}
+		return []memoryOrder{{field: field, desc: column.Desc}}, nil
+	}
+
+	var orders []memoryOrder
+	for _, term := range strings.Split(column.Column.Name, ",") {
+		words := strings.Fields(term)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("%w: ORDER BY %s", ErrUnsupportedQuery, column.Column.Name)
+		}
+		field := lookUpMemoryField(q.schema, words[0])
+		if field == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, words[0])
+		}
+		order := memoryOrder{field: field, desc: column.Desc}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				order.desc = true
+			default:
+				return nil, fmt.Errorf("%w: ORDER BY %s", ErrUnsupportedQuery, column.Column.Name)
+			}
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// lookUpMemoryField finds the field of a column (or a field name), which
+// may be quoted, and qualified by the table.
+func lookUpMemoryField(s *schema.Schema, column string) *schema.Field {
+	if i := strings.LastIndexByte(column, '.'); i >= 0 {
+		column = column[i+1:]
+	}
+	return s.LookUpField(strings.Trim(column, "`\" "))
+}
+
+// filter returns the models matching the conditions.
+func (q *memoryQuery) filter(ctx context.Context, models []reflect.Value) ([]reflect.Value, error) {
+	// the first condition is not an OR one, see clause.Where.Build
+	where := append([]clause.Expression(nil), q.where...)
+	for i, expr := range where {
+		if or, ok := expr.(clause.OrConditions); !ok || len(or.Exprs) > 1 {
+			where[0], where[i] = where[i], where[0]
+			break
+		}
+	}
+
+	var matched []reflect.Value
+	for _, model := range models {
+		if !q.unscoped && memorySoftDeleted(ctx, q.schema, model) {
+			continue
+		}
+		ok, err := q.evalAll(ctx, model, where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, model)
+		}
+	}
+	return matched, nil
+}
+
+// sort sorts the models by the orders, stably.
+func (q *memoryQuery) sort(ctx context.Context, models []reflect.Value) {
+	if len(q.orders) == 0 {
+		return
+	}
+	sort.SliceStable(models, func(i, j int) bool {
+		for _, order := range q.orders {
+			a, _ := order.field.ValueOf(ctx, models[i])
+			b, _ := order.field.ValueOf(ctx, models[j])
+			c := compareMemoryOrder(a, b)
+			if c == 0 {
+				continue
+			}
+			if order.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// page paginates the models.
+func (q *memoryQuery) page(models []reflect.Value) []reflect.Value {
+	if q.offset > 0 {
+		if q.offset >= len(models) {
+			return nil
+		}
+		models = models[q.offset:]
+	}
+	if q.limit >= 0 && q.limit < len(models) {
+		models = models[:q.limit]
+	}
+	return models
+}
+
+// load loads the model (a copy of the stored one) as the query asks: the
+// associations are preloaded or cleared, and the fields not selected are
+// cleared.
+func (q *memoryQuery) load(ctx context.Context, model reflect.Value) error {
+	for _, rel := range q.schema.Relationships.Relations {
+		field := rel.Field.ReflectValueOf(ctx, model)
+		options, ok := q.preloads[rel.Name]
+		if !ok {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+
+		children, err := associatedMemory(ctx, rel, model)
+		if err != nil {
+			return err
+		}
+		query, err := compileMemoryQuery(rel.FieldSchema, options)
+		if err != nil {
+			return err
+		}
+		if children, err = query.run(ctx, children, true); err != nil {
+			return err
+		}
+		setMemoryChildren(field, children)
+	}
+
+	if q.selects != nil {
+		for _, field := range q.schema.Fields {
+			if field.DBName != "" && !q.selects[field.Name] {
+				value := field.ReflectValueOf(ctx, model)
+				value.Set(reflect.Zero(value.Type()))
+			}
+		}
+	}
+	return nil
+}
+
+// run runs the query on models (copies of the stored ones): filters, sorts,
+// paginates (if page) and loads them.
+func (q *memoryQuery) run(ctx context.Context, models []reflect.Value, page bool) ([]reflect.Value, error) {
+	models, err := q.filter(ctx, models)
+	if err != nil {
+		return nil, err
+	}
+	q.sort(ctx, models)
+	if page {
+		models = q.page(models)
+	}
+	for _, model := range models {
+		if err := q.load(ctx, model); err != nil {
+			return nil, err
+		}
+	}
+	return models, nil
+}
+
+// memorySoftDeleted reports whether the model is soft deleted, by its
+// gorm.DeletedAt field.
+func memorySoftDeleted(ctx context.Context, s *schema.Schema, model reflect.Value) bool {
+	field := memoryDeletedAtField(s)
+	if field == nil {
+		return false
+	}
+	value, _ := field.ValueOf(ctx, model)
+	deletedAt, _ := value.(gorm.DeletedAt)
+	return deletedAt.Valid
+}
+
+func memoryDeletedAtField(s *schema.Schema) *schema.Field {
+	for _, field := range s.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return field
+		}
+	}
+	return nil
+}
+
+// evalAll evaluates the conditions joined by AND (OR for single
+// OrConditions), with the precedence of SQL: a AND b OR c => (a AND b) OR c.
+func (q *memoryQuery) evalAll(ctx context.Context, model reflect.Value, exprs []clause.Expression) (bool, error) {
+	result, group := false, true
+	for i, expr := range exprs {
+		if or, ok := expr.(clause.OrConditions); ok && len(or.Exprs) == 1 {
+			if i > 0 {
+				result, group = result || group, true
+			}
+			expr = or.Exprs[0]
+		}
+		ok, err := q.eval(ctx, model, expr)
+		if err != nil {
+			return false, err
+		}
+		group = group && ok
+	}
+	return result || group, nil
+}
+
+// eval evaluates the condition expr on the model.
+func (q *memoryQuery) eval(ctx context.Context, model reflect.Value, expr clause.Expression) (bool, error) {
+	row := func(column any) (any, error) {
+		var name string
+		switch column := column.(type) {
+		case string:
+			name = column
+		case clause.Column:
+			name = column.Name
+			if name == clause.PrimaryKey && q.schema.PrioritizedPrimaryField != nil {
+				name = q.schema.PrioritizedPrimaryField.Name
+			}
+		default:
+			return nil, fmt.Errorf("%w: column %v", ErrUnsupportedQuery, column)
+		}
+		field := lookUpMemoryField(q.schema, name)
+		if field == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, name)
+		}
+		value, _ := field.ValueOf(ctx, model)
+		return value, nil
+	}
+	compare := func(column, value any, ok func(c int) bool) (bool, error) {
+		v, err := row(column)
+		if err != nil {
+			return false, err
+		}
+		c, comparable := compareMemoryValues(v, value)
+		return comparable && ok(c), nil
+	}
+
+	switch e := expr.(type) {
+	case clause.Eq:
+		if e.Value == nil {
+			v, err := row(e.Column)
+			return memoryValue(v) == nil, err
+		}
+		return compare(e.Column, e.Value, func(c int) bool { return c == 0 })
+	case clause.Neq:
+		if e.Value == nil {
+			v, err := row(e.Column)
+			return memoryValue(v) != nil, err
+		}
+		return compare(e.Column, e.Value, func(c int) bool { return c != 0 })
+	case clause.Gt:
+		return compare(e.Column, e.Value, func(c int) bool { return c > 0 })
+	case clause.Gte:
+		return compare(e.Column, e.Value, func(c int) bool { return c >= 0 })
+	case clause.Lt:
+		return compare(e.Column, e.Value, func(c int) bool { return c < 0 })
+	case clause.Lte:
+		return compare(e.Column, e.Value, func(c int) bool { return c <= 0 })
+	case clause.IN:
+		v, err := row(e.Column)
+		if err != nil {
+			return false, err
+		}
+		return memoryIn(v, e.Values), nil
+	case clause.AndConditions:
+		return q.evalAll(ctx, model, e.Exprs)
+	case clause.OrConditions:
+		for _, expr := range e.Exprs {
+			if ok, err := q.eval(ctx, model, expr); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	case clause.NotConditions:
+		for _, expr := range e.Exprs {
+			if ok, err := q.eval(ctx, model, expr); ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case clause.Expr, clause.NamedExpr:
+		return false, fmt.Errorf("%w: raw SQL", ErrUnsupportedQuery)
+	default:
+		return false, fmt.Errorf("%w: %T", ErrUnsupportedQuery, expr)
+	}
+}
+
+// memoryChildren returns the associated models (pointers to structs) in the
+// field of an association: a struct, a pointer to it, or a slice of them.
+func memoryChildren(field reflect.Value) []reflect.Value {
+	var children []reflect.Value
+	switch field.Kind() {
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			children = append(children, memoryChildren(field.Index(i))...)
+		}
+	case reflect.Ptr:
+		if !field.IsNil() {
+			children = append(children, field)
+		}
+	case reflect.Struct:
+		if !field.IsZero() {
+			children = append(children, field.Addr())
+		}
+	}
+	return children
+}
+
+// setMemoryChildren sets the field of an association to the children.
+func setMemoryChildren(field reflect.Value, children []reflect.Value) {
+	switch field.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), 0, len(children))
+		for _, child := range children {
+			if field.Type().Elem().Kind() == reflect.Ptr {
+				slice = reflect.Append(slice, child)
+			} else {
+				slice = reflect.Append(slice, child.Elem())
+			}
+		}
+		field.Set(slice)
+	case reflect.Ptr:
+		field.Set(reflect.Zero(field.Type()))
+		if len(children) > 0 {
+			field.Set(children[0])
+		}
+	case reflect.Struct:
+		field.Set(reflect.Zero(field.Type()))
+		if len(children) > 0 {
+			field.Set(children[0].Elem())
+		}
+	}
+}
+
+// reloadMemoryChildren reloads the children from the Repositories of them
+// (see UseRepository), if any, dropping those not found. Others are kept
+// as they are.
+func reloadMemoryChildren(ctx context.Context, children []reflect.Value) ([]reflect.Value, error) {
+	var reloaded []reflect.Value
+	for _, child := range children {
+		repo, ok := registeredRepository(child.Interface())
+		if !ok {
+			reloaded = append(reloaded, child)
+			continue
+		}
+		model, err := repo.reload(ctx, child.Interface())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		reloaded = append(reloaded, reflect.ValueOf(model))
+	}
+	return reloaded, nil
+}
+
+// cloneMemory deep copies the value v. Unexported fields are copied
+// shallowly.
+func cloneMemory(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(cloneMemory(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(cloneMemory(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cloneMemory(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), cloneMemory(iter.Value()))
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// assignMemory sets dest, a pointer to a (view) model or to a slice of
+// them, to the models.
+func assignMemory(dest any, models []reflect.Value) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return gorm.ErrInvalidValue
+	}
+	v = v.Elem()
+
+	switch {
+	case v.Kind() == reflect.Slice:
+		elem := v.Type().Elem()
+		slice := reflect.MakeSlice(v.Type(), 0, len(models))
+		for _, model := range models {
+			if elem.Kind() == reflect.Ptr {
+				p := reflect.New(elem.Elem())
+				copyMemory(p.Elem(), model.Elem())
+				slice = reflect.Append(slice, p)
+			} else {
+				e := reflect.New(elem).Elem()
+				copyMemory(e, model.Elem())
+				slice = reflect.Append(slice, e)
+			}
+		}
+		v.Set(slice)
+	case v.Kind() == reflect.Struct:
+		if len(models) > 0 {
+			copyMemory(v, models[0].Elem())
+		}
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct:
+		if len(models) > 0 {
+			p := reflect.New(v.Type().Elem())
+			copyMemory(p.Elem(), models[0].Elem())
+			v.Set(p)
+		}
+	default:
+		return gorm.ErrInvalidValue
+	}
+	return nil
+}
+
+// copyMemory copies the struct src into dst, a struct of the same type, or
+// of a view of it: with fields of the same names.
+func copyMemory(dst, src reflect.Value) {
+	if dst.Type() == src.Type() {
+		dst.Set(src)
+		return
+	}
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := src.FieldByName(field.Name)
+		switch {
+		case !value.IsValid():
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				copyMemory(dst.Field(i), src)
+			}
+		case value.Type().AssignableTo(field.Type):
+			dst.Field(i).Set(value)
+		case value.Type().ConvertibleTo(field.Type):
+			dst.Field(i).Set(value.Convert(field.Type))
+		}
+	}
+}
+
+// memoryValue returns the value v to compare: pointers are dereferenced,
+// driver.Valuers valued, numbers made float64 and []byte string.
+// It's nil for NULLs.
+func memoryValue(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	if valuer, ok := rv.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil || value == nil {
+			return nil
+		}
+		rv = reflect.ValueOf(value)
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes())
+		}
+	}
+	return rv.Interface()
+}
+
+// compareMemoryValues compares a and b, converting strings to the type of
+// the other, as databases do. It's not ok if any of them is NULL.
+func compareMemoryValues(a, b any) (c int, ok bool) {
+	a, b = memoryValue(a), memoryValue(b)
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if _, ok := a.(string); ok {
+		if _, ok := b.(string); !ok {
+			c, ok := compareMemoryValues(b, a)
+			return -c, ok
+		}
+	}
+
+	switch x := a.(type) {
+	case float64:
+		if y, ok := memoryFloat(b); ok {
+			return compareFloat(x, y), true
+		}
+	case bool:
+		if y, ok := memoryBool(b); ok {
+			return compareFloat(boolFloat(x), boolFloat(y)), true
+		}
+	case time.Time:
+		if y, ok := memoryTime(b); ok {
+			return compareFloat(float64(x.Sub(y)), 0), true
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), true
+}
+
+// compareMemoryOrder compares a and b to sort them, NULLs first.
+func compareMemoryOrder(a, b any) int {
+	a, b = memoryValue(a), memoryValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compareMemoryValues(a, b)
+	return c
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func memoryFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		return boolFloat(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func memoryBool(v any) (bool, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case float64:
+		return v != 0, true
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		return b, err == nil
+	}
+	return false, false
+}
+
+var memoryTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", "2006-01-02"}
+
+func memoryTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range memoryTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// memoryIn reports whether v equals any of the values.
+func memoryIn(v any, values []any) bool {
+	for _, value := range values {
+		if c, ok := compareMemoryValues(v, value); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
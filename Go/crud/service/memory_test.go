@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+func useMemoryRepositories(t *testing.T) {
+	UseRepository[repoOwner](NewMemoryRepository[repoOwner]())
+	UseRepository[repoTodo](NewMemoryRepository[repoTodo]())
+	UseRepository[repoProject](NewMemoryRepository[repoProject]())
+	t.Cleanup(func() {
+		UseRepository[repoOwner](nil)
+		UseRepository[repoTodo](nil)
+		UseRepository[repoProject](nil)
+	})
+}
+
+// TestMemoryRepository tests what the MemoryRepository does not support,
+// the rest is tested by TestRepositories.
+func TestMemoryRepository(t *testing.T) {
+	useMemoryRepositories(t)
+	ctx := context.Background()
+	defer func(db *gorm.DB) { orm.DB = db }(orm.DB)
+	orm.DB = nil // no database at all
+
+	if err := Create(ctx, &repoTodo{Title: "buy milk"}, IfNotExist()); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := Count[repoTodo](ctx, Where("title LIKE ?", "buy%")); !errors.Is(err, ErrUnsupportedQuery) {
+		t.Errorf("Count(raw SQL) error = %v, want ErrUnsupportedQuery", err)
+	}
+
+	// services of the orm.DB only
+	if _, err := Replace(ctx, 1, &repoTodo{Title: "replaced"}, nil); !errors.Is(err, ErrUnsupportedRepository) {
+		t.Errorf("Replace() error = %v, want ErrUnsupportedRepository", err)
+	}
+	if _, err := Upsert(ctx, &repoTodo{Title: "upserted"}, OnConflict{Action: ConflictUpdate}); !errors.Is(err, ErrUnsupportedRepository) {
+		t.Errorf("Upsert() error = %v, want ErrUnsupportedRepository", err)
+	}
+	if err := GetTrashed[repoTodo](ctx, &[]repoTodo{}); !errors.Is(err, ErrUnsupportedRepository) {
+		t.Errorf("GetTrashed() error = %v, want ErrUnsupportedRepository", err)
+	}
+	err := FindInBatches[repoTodo](ctx, 10, func([]*repoTodo) error { return nil })
+	if !errors.Is(err, ErrUnsupportedRepository) {
+		t.Errorf("FindInBatches() error = %v, want ErrUnsupportedRepository", err)
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+// Repository is the storage of models T behind the services: Get, GetByID,
+// GetMany, Count, Create, Update(If), Delete(ByID, ByIDIf) and the services
+// of associations (NestInto, GetAssociations, CountAssociations,
+// DeleteNested) go through the Repository of T, see UseRepository.
+//
+// GormRepository, on the orm.DB, is the default one. MemoryRepository
+// keeps models in memory instead, for tests and prototypes.
+//
+// Options are the QueryOptions of the services (FilterBy, Where, OrderBy,
+// WithPage, Preload, Select, Unscoped...), with the semantics of GORM.
+//
+// Other services (Upsert, Replace, UpdateField, the trash, history,
+// imports, exports, aggregations and searches) work on the orm.DB only:
+// they fail with ErrUnsupportedRepository for models using another
+// Repository.
+type Repository[T any] interface {
+	// Get fetches a single model T matching the options into dest,
+	// or fails with gorm.ErrRecordNotFound.
+	Get(ctx context.Context, dest any, options ...QueryOption) error
+	// GetMany fetches models T matching the options into dest, a
+	// pointer to a slice.
+	GetMany(ctx context.Context, dest any, options ...QueryOption) error
+	// Count counts models T matching the options.
+	Count(ctx context.Context, options ...QueryOption) (int64, error)
+
+	// Create creates the model, and its associations.
+	Create(ctx context.Context, model *T) error
+	// Update saves all fields of the model.
+	Update(ctx context.Context, model *T) (rowsAffected int64, err error)
+	// Delete deletes the model, softly if it has a gorm.DeletedAt field.
+	Delete(ctx context.Context, model *T) (rowsAffected int64, err error)
+
+	// GetAssociations fetches the associations model.field matching the
+	// options into dest.
+	GetAssociations(ctx context.Context, model *T, field string, dest any, options ...QueryOption) error
+	// CountAssociations counts the associations model.field matching
+	// the options.
+	CountAssociations(ctx context.Context, model *T, field string, options ...QueryOption) (int64, error)
+	// AppendAssociation associates child to model.field, creating the
+	// child if it's new.
+	AppendAssociation(ctx context.Context, model *T, field string, child any) error
+	// DeleteAssociation removes the association between model.field and
+	// child (the child itself is kept).
+	DeleteAssociation(ctx context.Context, model *T, field string, child any) error
+}
+
+var ErrUnsupportedRepository = errors.New("not supported by the repository of the model")
+
+// repositories are the Repositories in use, by the types of models.
+var repositories sync.Map // reflect.Type => typedRepository[T]
+
+// UseRepository makes the services of models T use repo.
+// UseRepository[T](nil) goes back to the default GormRepository.
+//
+// Example, to get rid of the database in tests:
+//
+//    service.UseRepository[Todo](service.NewMemoryRepository[Todo]())
+func UseRepository[T any](repo Repository[T]) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if repo == nil {
+		repositories.Delete(t)
+		return
+	}
+	repositories.Store(t, typedRepository[T]{repo})
+}
+
+// RepositoryOf returns the Repository of models T in use, see UseRepository.
+func RepositoryOf[T any]() Repository[T] {
+	if repo, ok := repositories.Load(reflect.TypeOf((*T)(nil)).Elem()); ok {
+		return repo.(typedRepository[T]).Repository
+	}
+	return GormRepository[T]{}
+}
+
+// usingRepository reports whether models T use a Repository other than the
+// default one.
+func usingRepository[T any]() bool {
+	_, ok := repositories.Load(reflect.TypeOf((*T)(nil)).Elem())
+	return ok
+}
+
+// modelRepository is a Repository of the models given as any, for the
+// services that are not generic.
+type modelRepository interface {
+	create(ctx context.Context, model any) error
+	update(ctx context.Context, model any) (int64, error)
+	delete(ctx context.Context, model any) (int64, error)
+	getAssociations(ctx context.Context, model any, field string, dest any, options ...QueryOption) error
+	countAssociations(ctx context.Context, model any, field string, options ...QueryOption) (int64, error)
+	appendAssociation(ctx context.Context, model any, field string, child any) error
+	deleteAssociation(ctx context.Context, model any, field string, child any) error
+}
+
+// customRepository is a modelRepository set by UseRepository.
+type customRepository interface {
+	modelRepository
+	// reload gets the current version of the model, with its associations
+	// as they are stored (if the repository stores them).
+	reload(ctx context.Context, model any) (any, error)
+}
+
+// gormOnly fails with ErrUnsupportedRepository if the model (a T or a *T)
+// uses a Repository other than the default one, for the services working
+// on the orm.DB only.
+func gormOnly(model any) error {
+	if _, ok := registeredRepository(model); ok {
+		return fmt.Errorf("%w: %T", ErrUnsupportedRepository, model)
+	}
+	return nil
+}
+
+// repositoryFor returns the repository of the model (a T or a *T) in use.
+func repositoryFor(model any) modelRepository {
+	if repo, ok := registeredRepository(model); ok {
+		return repo
+	}
+	return gormRepository{}
+}
+
+// registeredRepository returns the repository of the model (a T or a *T),
+// if one is set by UseRepository.
+func registeredRepository(model any) (customRepository, bool) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil, false
+	}
+	repo, ok := repositories.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return repo.(customRepository), true
+}
+
+// typedRepository adapts a Repository[T] to a modelRepository.
+type typedRepository[T any] struct {
+	Repository[T]
+}
+
+// typed returns model (a T or a *T) as a *T.
+func (r typedRepository[T]) typed(model any) *T {
+	if p, ok := model.(*T); ok {
+		return p
+	}
+	v := model.(T)
+	return &v
+}
+
+func (r typedRepository[T]) create(ctx context.Context, model any) error {
+	return r.Create(ctx, r.typed(model))
+}
+
+func (r typedRepository[T]) update(ctx context.Context, model any) (int64, error) {
+	return r.Update(ctx, r.typed(model))
+}
+
+func (r typedRepository[T]) delete(ctx context.Context, model any) (int64, error) {
+	return r.Delete(ctx, r.typed(model))
+}
+
+func (r typedRepository[T]) getAssociations(ctx context.Context, model any, field string, dest any, options ...QueryOption) error {
+	return r.GetAssociations(ctx, r.typed(model), field, dest, options...)
+}
+
+func (r typedRepository[T]) countAssociations(ctx context.Context, model any, field string, options ...QueryOption) (int64, error) {
+	return r.CountAssociations(ctx, r.typed(model), field, options...)
+}
+
+func (r typedRepository[T]) appendAssociation(ctx context.Context, model any, field string, child any) error {
+	return r.AppendAssociation(ctx, r.typed(model), field, child)
+}
+
+func (r typedRepository[T]) deleteAssociation(ctx context.Context, model any, field string, child any) error {
+	return r.DeleteAssociation(ctx, r.typed(model), field, child)
+}
+
+func (r typedRepository[T]) reload(ctx context.Context, model any) (any, error) {
+	if memory, ok := r.Repository.(*MemoryRepository[T]); ok {
+		return memory.stored(ctx, r.typed(model))
+	}
+	m, ok := any(*r.typed(model)).(orm.Model)
+	if !ok {
+		return model, nil
+	}
+	_, id := m.Identity()
+	conds, err := orm.IdentityConds(m, id)
+	if err != nil {
+		return nil, err
+	}
+	var current T
+	err = r.Get(ctx, &current, Where(conds))
+	return &current, err
+}
+
+// GormRepository is the default Repository, of the orm.DB: reads go to
+// orm.ReadDB, and each write is done in a transaction, with the domain
+// events of it written into the outbox (see outbox.Enable).
+type GormRepository[T any] struct{}
+
+func (GormRepository[T]) query(ctx context.Context, options []QueryOption) *gorm.DB {
+	query := orm.ReadDB(ctx).Model(new(T))
+	for _, option := range options {
+		query = option(query)
+	}
+	return query
+}
+
+func (r GormRepository[T]) Get(ctx context.Context, dest any, options ...QueryOption) error {
+	return r.query(ctx, options).Take(dest).Error
+}
+
+func (r GormRepository[T]) GetMany(ctx context.Context, dest any, options ...QueryOption) error {
+	return r.query(ctx, options).Find(dest).Error
+}
+
+func (r GormRepository[T]) Count(ctx context.Context, options ...QueryOption) (count int64, err error) {
+	err = r.query(ctx, options).Count(&count).Error
+	return count, err
+}
+
+func (GormRepository[T]) Create(ctx context.Context, model *T) error {
+	return gormRepository{}.create(ctx, model)
+}
+
+func (GormRepository[T]) Update(ctx context.Context, model *T) (int64, error) {
+	return gormRepository{}.update(ctx, model)
+}
+
+func (GormRepository[T]) Delete(ctx context.Context, model *T) (int64, error) {
+	return gormRepository{}.delete(ctx, model)
+}
+
+func (GormRepository[T]) GetAssociations(ctx context.Context, model *T, field string, dest any, options ...QueryOption) error {
+	return gormRepository{}.getAssociations(ctx, model, field, dest, options...)
+}
+
+func (GormRepository[T]) CountAssociations(ctx context.Context, model *T, field string, options ...QueryOption) (int64, error) {
+	return gormRepository{}.countAssociations(ctx, model, field, options...)
+}
+
+func (GormRepository[T]) AppendAssociation(ctx context.Context, model *T, field string, child any) error {
+	return gormRepository{}.appendAssociation(ctx, model, field, child)
+}
+
+func (GormRepository[T]) DeleteAssociation(ctx context.Context, model *T, field string, child any) error {
+	return gormRepository{}.deleteAssociation(ctx, model, field, child)
+}
+
+// gormRepository is the GormRepository of any model.
+type gormRepository struct{}
+
+func (gormRepository) create(ctx context.Context, model any) error {
+	return orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(model).Error; err != nil {
+			return err
+		}
+		return emit(ctx, tx, eventCreated, model)
+	})
+}
+
+func (gormRepository) update(ctx context.Context, model any) (rowsAffected int64, err error) {
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Save(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+		return emit(ctx, tx, eventUpdated, model)
+	})
+	return rowsAffected, err
+}
+
+func (gormRepository) delete(ctx context.Context, model any) (rowsAffected int64, err error) {
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+		return emit(ctx, tx, eventDeleted, model)
+	})
+	return rowsAffected, err
+}
+
+// association builds a gorm association query
+func (gormRepository) association(ctx context.Context, model any, field string, options ...QueryOption) *gorm.Association {
+	query := orm.ReadDB(ctx).Model(model)
+	for _, option := range options {
+		query = option(query)
+	}
+	return query.Association(field)
+}
+
+func (r gormRepository) getAssociations(ctx context.Context, model any, field string, dest any, options ...QueryOption) error {
+	return r.association(ctx, model, field, options...).Find(dest)
+}
+
+func (r gormRepository) countAssociations(ctx context.Context, model any, field string, options ...QueryOption) (int64, error) {
+	association := r.association(ctx, model, field, options...)
+	return association.Count(), association.Error
+}
+
+func (gormRepository) appendAssociation(ctx context.Context, model any, field string, child any) error {
+	return orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Session(&gorm.Session{FullSaveAssociations: true}).
+			Model(model).Association(field).Append(child)
+		if err != nil {
+			return err
+		}
+		return emitNested(ctx, tx, eventLinked, model, field, child)
+	})
+}
+
+func (gormRepository) deleteAssociation(ctx context.Context, model any, field string, child any) error {
+	return orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(model).Association(field).Delete(child); err != nil {
+			return err
+		}
+		return emitNested(ctx, tx, eventUnlinked, model, field, child)
+	})
+}
+
+// getCurrent gets the current version of the model T by id from repo, for
+// the checks of UpdateIf and DeleteByIDIf out of the database.
+func getCurrent[T orm.Model](ctx context.Context, repo Repository[T], id any) (*T, error) {
+	conds, err := orm.IdentityConds(*new(T), id)
+	if err != nil {
+		return nil, err
+	}
+	var current T
+	err = repo.Get(ctx, &current, Where(conds))
+	return &current, err
+}
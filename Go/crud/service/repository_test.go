@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+type repoOwner struct {
+	orm.BasicModel
+	Name string
+}
+
+type repoTodo struct {
+	orm.BasicModel
+	Title    string
+	Done     bool
+	Priority int
+	OwnerID  *uint
+	Owner    *repoOwner
+}
+
+type repoProject struct {
+	orm.BasicModel
+	Name  string
+	Todos []*repoTodo `gorm:"many2many:repo_project_todos"`
+}
+
+// TestRepositories runs the same tests on the GormRepository and the
+// MemoryRepository.
+func TestRepositories(t *testing.T) {
+	t.Run("Gorm", func(t *testing.T) {
+		defer func(db *gorm.DB) { orm.DB = db }(orm.DB)
+		if _, err := orm.ConnectDB(orm.DBDriverSqlite, filepath.Join(t.TempDir(), "test.db")); err != nil {
+			t.Fatalf("ConnectDB() error = %v", err)
+		}
+		if err := orm.DB.AutoMigrate(&repoOwner{}, &repoTodo{}, &repoProject{}); err != nil {
+			t.Fatalf("AutoMigrate() error = %v", err)
+		}
+		testRepository(t)
+	})
+	t.Run("Memory", func(t *testing.T) {
+		useMemoryRepositories(t)
+		defer func(db *gorm.DB) { orm.DB = db }(orm.DB)
+		orm.DB = nil // no database at all
+		testRepository(t)
+	})
+}
+
+// testRepository runs the services of the Repositories of the models
+// repoOwner, repoTodo and repoProject, which should be empty.
+func testRepository(t *testing.T) {
+	ctx := context.Background()
+
+	owner := repoOwner{Name: "alice"}
+	if err := Create(ctx, &owner, IfNotExist()); err != nil || owner.ID != 1 {
+		t.Fatalf("Create(owner) = %v, ID %d", err, owner.ID)
+	}
+	for i, title := range []string{"buy milk", "walk the dog", "buy eggs", "read"} {
+		todo := repoTodo{Title: title, Priority: i % 3, Done: i%2 == 1}
+		if i < 2 {
+			todo.OwnerID = &owner.ID
+		}
+		if err := Create(ctx, &todo, IfNotExist()); err != nil {
+			t.Fatalf("Create(%s) error = %v", title, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options []QueryOption
+		want    []string
+	}{
+		{"all", nil, []string{"buy milk", "walk the dog", "buy eggs", "read"}},
+		{"FilterBy", []QueryOption{FilterBy("done", true)}, []string{"walk the dog", "read"}},
+		{"FilterBy NULL", []QueryOption{FilterBy("owner_id", nil), FilterBy("priority", 2)}, []string{"buy eggs"}},
+		{"Where map", []QueryOption{Where(map[string]any{"id": []uint{1, 4}})}, []string{"buy milk", "read"}},
+		{"OrderBy", []QueryOption{OrderBy("priority", true), OrderBy("title", false)}, []string{"buy eggs", "walk the dog", "buy milk", "read"}},
+		{"WithPage", []QueryOption{OrderBy("title", false), WithPage(2, 1)}, []string{"buy milk", "read"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var todos []*repoTodo
+			if err := GetMany[repoTodo](ctx, &todos, tt.options...); err != nil {
+				t.Fatalf("GetMany() error = %v", err)
+			}
+			var got []string
+			for _, todo := range todos {
+				got = append(got, todo.Title)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetMany() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("GetMany() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if count, err := Count[repoTodo](ctx, FilterBy("owner_id", owner.ID), WithPage(1, 0)); err != nil || count != 2 {
+		t.Errorf("Count() = %d, %v, want 2", count, err)
+	}
+	// preloads, by the foreign keys, and selects
+	var todo repoTodo
+	if err := GetByID[repoTodo](ctx, 2, &todo, Preload("Owner"), Select("id", "owner_id")); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if todo.Title != "" || todo.Owner == nil || todo.Owner.Name != "alice" {
+		t.Errorf("GetByID(Preload, Select) = %+v", todo)
+	}
+
+	// updates
+	todo = repoTodo{}
+	GetByID[repoTodo](ctx, 1, &todo)
+	todo.Done = true
+	if _, err := UpdateIf(ctx, &todo, func(current *repoTodo) bool { return current.Done }); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("UpdateIf(failed) error = %v", err)
+	}
+	if _, err := UpdateIf(ctx, &todo, func(current *repoTodo) bool { return !current.Done }); err != nil {
+		t.Errorf("UpdateIf() error = %v", err)
+	}
+	if count, _ := Count[repoTodo](ctx, FilterBy("done", true)); count != 3 {
+		t.Errorf("Count(done) = %d after update, want 3", count)
+	}
+
+	// nested models
+	project := repoProject{Name: "home"}
+	Create(ctx, &project, IfNotExist())
+	if err := Create(ctx, &repoTodo{Title: "cook"}, NestInto(&project, "Todos")); err != nil {
+		t.Fatalf("NestInto() error = %v", err)
+	}
+	if err := Create(ctx, &todo, NestInto(&project, "Todos")); err != nil {
+		t.Fatalf("NestInto() error = %v", err)
+	}
+	if count, _ := Count[repoTodo](ctx); count != 5 {
+		t.Errorf("Count() = %d after NestInto, want 5", count)
+	}
+	var nested repoProject
+	GetByID[repoProject](ctx, project.ID, &nested, Preload("Todos", OrderBy("title", false), WithPage(1, 0)))
+	if len(nested.Todos) != 1 || nested.Todos[0].Title != "buy milk" {
+		t.Errorf("Preload(Todos) = %+v", nested.Todos)
+	}
+	if count, err := CountAssociations(ctx, &project, "Todos", FilterBy("done", true)); err != nil || count != 1 {
+		t.Errorf("CountAssociations() = %d, %v, want 1", count, err)
+	}
+
+	// deletes: soft, reflected in the associations
+	if _, err := DeleteByID[repoTodo](ctx, todo.ID); err != nil {
+		t.Fatalf("DeleteByID() error = %v", err)
+	}
+	if err := GetByID[repoTodo](ctx, todo.ID, &repoTodo{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("GetByID(deleted) error = %v", err)
+	}
+	if err := GetByID[repoTodo](ctx, todo.ID, &repoTodo{}, Unscoped()); err != nil {
+		t.Errorf("GetByID(deleted, Unscoped) error = %v", err)
+	}
+	var todos []repoTodo
+	GetAssociations(ctx, &project, "Todos", &todos)
+	if len(todos) != 1 || todos[0].Title != "cook" {
+		t.Errorf("GetAssociations() = %+v after delete", todos)
+	}
+	if err := DeleteNestedByID[repoProject, repoTodo](ctx, project.ID, "Todos", todos[0].ID); err != nil {
+		t.Errorf("DeleteNestedByID() error = %v", err)
+	}
+	if count, _ := CountAssociations(ctx, &project, "Todos"); count != 0 {
+		t.Errorf("CountAssociations() = %d after DeleteNested, want 0", count)
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+// Search is a query option for full-text searching q in the searchable
+// fields of model T, ordering the results by relevance (after any
+// OrderBy applied before it). Search must be enabled for T, see
+// orm.EnableSearch.
+//
+// Example:
+//     GetMany[Todo](&todos, Search[Todo]("buy milk"))
+// means (sqlite):
+//     SELECT todos.* FROM todos
+//         JOIN (SELECT rowid AS crud_search_key, -bm25(todos_search) AS crud_search_score
+//               FROM todos_search WHERE todos_search MATCH '"buy" "milk"') AS crud_search
+//           ON crud_search.crud_search_key = todos.rowid
+//         ORDER BY crud_search.crud_search_score DESC;
+func Search[T any](q string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return orm.Search(tx, new(T), q)
+	}
+}
+
+// SearchSnippets returns the highlighted snippets matching q of the
+// models T with the given ids, keyed by fmt.Sprint(id).
+// See orm.SearchSnippets.
+func SearchSnippets[T any](ctx context.Context, q string, ids []any) (map[string]string, error) {
+	snippets, err := orm.SearchSnippets(ctx, new(T), q, ids)
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("SearchSnippets: failed")
+	}
+	return snippets, err
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoSoftDelete is returned by trash operations on models without a
+// gorm.DeletedAt field (which orm.BasicModel has), whose deletes are permanent.
+var ErrNoSoftDelete = errors.New("model does not support soft delete")
+
+var ErrInvalidPurgeInterval = errors.New("purge interval must be positive")
+
+// TrashPurgerActor is the actor in the audit log of the purges made by
+// StartTrashPurger.
+const TrashPurgerActor = "trash purger"
+
+// GetTrashed gets soft deleted models T into dest, see GetMany.
+func GetTrashed[T any](ctx context.Context, dest any, options ...QueryOption) error {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T)))
+	logger.Trace("GetTrashed: Get trashed models into dest")
+
+	query, err := trashQuery(ctx, new(T))
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		query = option(query)
+	}
+	ret := query.Find(dest)
+	if ret.Error != nil {
+		logger.WithError(ret.Error).Warn("GetTrashed failed")
+	}
+	return ret.Error
+}
+
+// CountTrashed returns the number of soft deleted models T.
+func CountTrashed[T any](ctx context.Context, options ...QueryOption) (count int64, err error) {
+	query, err := trashQuery(ctx, new(T))
+	if err != nil {
+		return 0, err
+	}
+	for _, option := range options {
+		query = option(query)
+	}
+	err = query.Count(&count).Error
+	return count, err
+}
+
+// RestoreByID restores (undeletes) a soft deleted model T by its ID.
+func RestoreByID[T orm.Model](ctx context.Context, id any) (rowsAffected int64, err error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id)
+	logger.Trace("RestoreByID")
+
+	if err := gormOnly(new(T)); err != nil {
+		return 0, err
+	}
+	column, err := softDeleteColumn(new(T))
+	if err != nil {
+		return 0, err
+	}
+
+	var model T
+	if err := getTrashedByID[T](ctx, id, &model); err != nil {
+		logger.WithError(err).Warn("RestoreByID: trashed model not found")
+		return 0, err
+	}
+
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model).Update(column, nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+		return emit(ctx, tx, eventRestored, &model)
+	})
+	if err != nil {
+		logger.WithError(err).Warn("RestoreByID: failed")
+	} else {
+		publish(ctx, broker.EventCreated, &model) // it's back
+	}
+	return rowsAffected, err
+}
+
+// PurgeByID deletes a model T permanently by its ID, whether it has been
+// soft deleted or not, and the rows of the join tables referring to it.
+func PurgeByID[T orm.Model](ctx context.Context, id any) (rowsAffected int64, err error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id)
+	logger.Trace("PurgeByID: Delete model permanently")
+
+	if err := gormOnly(new(T)); err != nil {
+		return 0, err
+	}
+	var model T
+	if err := GetByID[T](ctx, id, &model, Unscoped()); err != nil {
+		logger.WithError(err).Warn("PurgeByID: model not found")
+		return 0, err
+	}
+
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowsAffected, err = purge(ctx, tx, &model)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Warn("PurgeByID: failed")
+	} else {
+		publish(ctx, broker.EventDeleted, &model)
+	}
+	return rowsAffected, err
+}
+
+// PurgeBatchSize is the number of trashed records read at a time by
+// PurgeTrashed.
+var PurgeBatchSize = 100
+
+// PurgeTrashed permanently deletes records of model that were soft deleted
+// before the given time, one by one as PurgeByID does. Models that do not
+// support soft delete are skipped.
+func PurgeTrashed(ctx context.Context, model any, before time.Time) (rowsAffected int64, err error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", model))
+
+	if err := gormOnly(model); err != nil {
+		return 0, err
+	}
+	column, err := softDeleteColumn(model)
+	if errors.Is(err, ErrNoSoftDelete) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	modelType := reflect.TypeOf(model)
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	for {
+		records := reflect.New(reflect.SliceOf(reflect.PtrTo(modelType)))
+		query, err := trashQuery(ctx, model)
+		if err == nil {
+			err = query.Where(fmt.Sprintf("%s < ?", orm.DB.Statement.Quote(column)), before).
+				Limit(PurgeBatchSize).Find(records.Interface()).Error
+		}
+		if err != nil {
+			logger.WithError(err).Warn("PurgeTrashed: failed")
+			return rowsAffected, err
+		}
+		if records.Elem().Len() == 0 {
+			return rowsAffected, nil
+		}
+
+		for i := 0; i < records.Elem().Len(); i++ {
+			record := records.Elem().Index(i).Interface()
+			err := orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				rows, err := purge(ctx, tx, record)
+				rowsAffected += rows
+				return err
+			})
+			if err != nil {
+				logger.WithError(err).Warn("PurgeTrashed: failed")
+				return rowsAffected, err
+			}
+			publish(ctx, broker.EventDeleted, record)
+		}
+	}
+}
+
+// purge deletes the model permanently with tx, and the rows of the join
+// tables referring to it (see deleteJoinRows), and writes its Purged
+// event, see emit.
+func purge(ctx context.Context, tx *gorm.DB, model any) (rowsAffected int64, err error) {
+	if err := deleteJoinRows(ctx, tx, model); err != nil {
+		return 0, err
+	}
+	result := tx.Unscoped().Delete(model)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, emit(ctx, tx, eventPurged, model)
+}
+
+// deleteJoinRows deletes the rows referring to the model from the join
+// tables of the many2many relationships of the registered models, on
+// either side of them, e.g. project_todos of a todo or a project.
+func deleteJoinRows(ctx context.Context, tx *gorm.DB, model any) error {
+	modelSchema, err := orm.ParseSchema(model)
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(model)
+
+	for _, registered := range orm.RegisteredModels() {
+		registeredSchema, err := orm.ParseSchema(registered)
+		if err != nil {
+			return err
+		}
+		for _, rel := range registeredSchema.Relationships.Many2Many {
+			if rel.JoinTable == nil {
+				continue
+			}
+			for _, own := range []bool{true, false} {
+				conds := map[string]any{}
+				for _, ref := range rel.References {
+					if ref.PrimaryKey == nil || ref.OwnPrimaryKey != own ||
+						ref.PrimaryKey.Schema.Table != modelSchema.Table {
+						continue
+					}
+					conds[ref.ForeignKey.DBName], _ = ref.PrimaryKey.ValueOf(ctx, value)
+				}
+				if len(conds) == 0 {
+					continue
+				}
+				if err := tx.Table(rel.JoinTable.Table).Where(conds).Delete(map[string]any{}).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// StartTrashPurger starts a goroutine that purges records of the models
+// trashed longer than retention, every interval, until ctx is done. The
+// purges are audited as made by the actor TrashPurgerActor.
+//
+// A zero or negative interval is refused with ErrInvalidPurgeInterval.
+func StartTrashPurger(ctx context.Context, interval time.Duration, retention time.Duration, models ...any) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidPurgeInterval, interval)
+	}
+	for _, model := range models {
+		if err := gormOnly(model); err != nil {
+			return err
+		}
+	}
+	logger.WithField("interval", interval).
+		WithField("retention", retention).
+		Info("StartTrashPurger: purging trashed records in background")
+
+	ctx = audit.WithSource(ctx, audit.Source{Actor: TrashPurgerActor})
+	purgeAll := func() {
+		before := time.Now().Add(-retention)
+		for _, model := range models {
+			rows, err := PurgeTrashed(ctx, model, before)
+			if err == nil && rows > 0 {
+				logger.WithField("model", fmt.Sprintf("%T", model)).
+					WithField("rowsAffected", rows).
+					Info("TrashPurger: purged trashed records")
+			}
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purgeAll()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// getTrashedByID gets a soft deleted model T by id into dest.
+func getTrashedByID[T orm.Model](ctx context.Context, id any, dest any) error {
+	if id == nil {
+		return ErrNilID
+	}
+	conds, err := orm.IdentityConds(*new(T), id)
+	if err != nil {
+		return err
+	}
+
+	query, err := trashQuery(ctx, new(T))
+	if err != nil {
+		return err
+	}
+	return query.Where(conds).Take(dest).Error
+}
+
+// trashQuery builds a query on soft deleted records of model.
+func trashQuery(ctx context.Context, model any) (*gorm.DB, error) {
+	if err := gormOnly(model); err != nil {
+		return nil, err
+	}
+	column, err := softDeleteColumn(model)
+	if err != nil {
+		return nil, err
+	}
+	return orm.DB.WithContext(ctx).Model(model).Unscoped().
+		Where(fmt.Sprintf("%s IS NOT NULL", orm.DB.Statement.Quote(column))), nil
+}
+
+// softDeleteColumn finds the column of the gorm.DeletedAt field of model.
+func softDeleteColumn(model any) (string, error) {
+	stmt := &gorm.Statement{DB: orm.DB}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	deletedAtType := reflect.TypeOf(gorm.DeletedAt{})
+	for _, field := range stmt.Schema.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			return field.DBName, nil
+		}
+	}
+	return "", ErrNoSoftDelete
+}
+
+// softDeleted reports whether the model (of schema s) is soft deleted, by
+// its gorm.DeletedAt field.
+func softDeleted(ctx context.Context, s *schema.Schema, model any) bool {
+	deletedAtType := reflect.TypeOf(gorm.DeletedAt{})
+	for _, field := range s.Fields {
+		if field.FieldType == deletedAtType {
+			value, _ := field.ValueOf(ctx, reflect.ValueOf(model))
+			deletedAt, _ := value.(gorm.DeletedAt)
+			return deletedAt.Valid
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStartTrashPurger(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Hour} {
+		err := StartTrashPurger(context.Background(), interval, time.Hour)
+		if !errors.Is(err, ErrInvalidPurgeInterval) {
+			t.Errorf("StartTrashPurger(%v) error = %v, want ErrInvalidPurgeInterval", interval, err)
+		}
+	}
+}
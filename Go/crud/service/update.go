@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
 	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Update all fields of an existing model in database.
@@ -18,17 +22,90 @@ func Update(ctx context.Context, model any) (rowsAffected int64, err error) {
 		return 0, ErrNoRecord
 	}
 
-	result := orm.DB.WithContext(ctx).Save(model)
-	if result.Error != nil {
+	rowsAffected, err = repositoryFor(model).update(ctx, model)
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("Update: failed")
+			WithError(err).Warn("Update: failed")
+	} else {
+		publish(ctx, broker.EventUpdated, model)
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
+}
+
+// UpdateIf updates all fields of an existing model in database, if the
+// current version of it in database satisfies the precondition, otherwise
+// ErrPreconditionFailed is returned.
+//
+// The check and the update are done in a transaction, with the current
+// version locked (SELECT ... FOR UPDATE) where the database supports, so
+// that concurrent updates can not sneak in between.
+func UpdateIf[T orm.Model](ctx context.Context, model *T, precondition func(current *T) bool) (rowsAffected int64, err error) {
+	logger.WithContext(ctx).
+		WithField("model", model).Trace("UpdateIf model")
+
+	if model == nil {
+		return 0, ErrNoRecord
+	}
+	_, id := (*model).Identity()
+
+	if usingRepository[T]() {
+		// out of the database, with no locks: check, then update
+		repo := RepositoryOf[T]()
+		var current *T
+		current, err = getCurrent(ctx, repo, id)
+		if err == nil && !precondition(current) {
+			err = ErrPreconditionFailed
+		}
+		if err == nil {
+			rowsAffected, err = repo.Update(ctx, model)
+		}
+	} else {
+		err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			current, err := lockByID[T](tx, id)
+			if err != nil {
+				return err
+			}
+			if !precondition(current) {
+				return ErrPreconditionFailed
+			}
+			result := tx.Save(model)
+			if result.Error != nil {
+				return result.Error
+			}
+			rowsAffected = result.RowsAffected
+			return emit(audit.WithBefore(ctx, current), tx, eventUpdated, model)
+		})
+	}
+	if err != nil {
+		logger.WithContext(ctx).
+			WithError(err).Warn("UpdateIf: failed")
+	} else {
+		publish(ctx, broker.EventUpdated, model)
+	}
+	return rowsAffected, err
+}
+
+// lockByID gets the model T by id in the transaction tx, locking it for
+// update if the database supports.
+func lockByID[T orm.Model](tx *gorm.DB, id any) (*T, error) {
+	idField, _ := (*new(T)).Identity()
+	if idField == "" {
+		return nil, ErrNoIdentityField
+	}
+	conds, err := orm.IdentityConds(*new(T), id)
+	if err != nil {
+		return nil, err
+	}
+	var current T
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(conds).Take(&current).Error
+	return &current, err
 }
 
 var (
-	ErrNoRecord        = errors.New("no record found")
-	ErrMultipleRecords = errors.New("multiple records found")
+	ErrNoRecord           = errors.New("no record found")
+	ErrMultipleRecords    = errors.New("multiple records found")
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
 // UpdateField updates a single fields of an existing model in database.
@@ -39,6 +116,9 @@ func UpdateField[T orm.Model](ctx context.Context, id any, field string, value i
 		WithField("id", id).WithField("field", field).
 		WithField("value", value).Trace("UpdateField")
 
+	if err := gormOnly(new(T)); err != nil {
+		return 0, err
+	}
 	var record T
 	if err := GetByID[T](ctx, id, &record); err != nil {
 		logger.WithContext(ctx).
@@ -46,10 +126,20 @@ func UpdateField[T orm.Model](ctx context.Context, id any, field string, value i
 			Warn("UpdateField: GetByID failed")
 		return 0, err
 	}
-	result := orm.DB.WithContext(ctx).Model(&record).Update(field, value)
-	if result.Error != nil {
+	before := record
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&record).Update(field, value)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+		return emit(audit.WithBefore(ctx, &before), tx, eventUpdated, &record)
+	})
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("UpdateField: failed")
+			WithError(err).Warn("UpdateField: failed")
+	} else {
+		publish(ctx, broker.EventUpdated, &record)
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
 }
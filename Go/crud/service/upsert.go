@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cdfmlr/crud/audit"
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ConflictAction is what Upsert does if the model to create conflicts
+// with an existing one.
+type ConflictAction string
+
+const (
+	ConflictUpdate ConflictAction = "update" // ON CONFLICT DO UPDATE: replace the existing one
+	ConflictIgnore ConflictAction = "ignore" // ON CONFLICT DO NOTHING: keep the existing one
+)
+
+// OnConflict configures Upsert.
+type OnConflict struct {
+	// Columns are the fields (field names, JSON keys or column names)
+	// identifying a model, with a unique index on them.
+	// Primary keys by default.
+	//
+	// MySQL ignores them: conflicts on any unique index are handled
+	// (ON DUPLICATE KEY UPDATE).
+	Columns []string
+	Action  ConflictAction
+	// Restore a soft deleted model conflicting (and replace it, with
+	// ConflictUpdate), instead of failing with ErrConflictDeleted.
+	Restore bool
+}
+
+var (
+	ErrUnknownConflictAction = errors.New("unknown conflict action, use update or ignore")
+	ErrConflictDeleted       = errors.New("conflicts with a deleted model, restore it first")
+)
+
+// Upsert creates the model, or, if it conflicts with an existing one on
+// the columns of onConflict, updates (replaces all the fields except the
+// primary keys and the creation time) or keeps the existing one by the
+// action of onConflict (INSERT ... ON CONFLICT ...).
+//
+// The model is reloaded from the database afterwards, and the existing
+// model (before the update) is returned, nil if created.
+//
+// A soft deleted model still holds its columns (the unique indexes cover
+// deleted rows too), so a conflicting one is neither replaced nor kept
+// silently: ErrConflictDeleted is returned, unless onConflict.Restore.
+//
+// Only models created or updated emit events, as Create and Update do.
+func Upsert[T any](ctx context.Context, model *T, onConflict OnConflict) (before *T, err error) {
+	return upsert(ctx, model, onConflict, nil)
+}
+
+// Replace is PUT: it replaces the model T identified by id with model
+// (whose primary key is set to id), or creates it if not exists.
+//
+// If precondition is not nil, the existing model must exist and satisfy
+// it, otherwise ErrPreconditionFailed is returned, see UpdateIf.
+//
+// A soft deleted model is not replaced: ErrConflictDeleted is returned,
+// it should be restored (see RestoreByID) first.
+func Replace[T orm.Model](ctx context.Context, id any, model *T, precondition func(current *T) bool) (before *T, err error) {
+	if err := gormOnly(model); err != nil {
+		return nil, err
+	}
+	idFields := orm.IdentityFields(*model)
+	if len(idFields) == 0 {
+		return nil, ErrNoIdentityField
+	}
+	values, err := orm.IdentityValues(*model, id)
+	if err != nil {
+		return nil, err
+	}
+	for i, idField := range idFields {
+		if err := setField(ctx, model, idField, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	onConflict := OnConflict{Columns: idFields, Action: ConflictUpdate}
+	return upsert(ctx, model, onConflict, precondition)
+}
+
+// setField sets the field (name, JSON key or column name) of model.
+func setField(ctx context.Context, model any, name string, value any) error {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return err
+	}
+	field := lookUpField(s, name)
+	if field == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownField, name)
+	}
+	return field.Set(ctx, reflect.ValueOf(model), value)
+}
+
+func upsert[T any](ctx context.Context, model *T, onConflict OnConflict, precondition func(current *T) bool) (before *T, err error) {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("onConflict", onConflict)
+	logger.Trace("Upsert model")
+
+	if model == nil {
+		return nil, ErrNoRecord
+	}
+	if err := gormOnly(model); err != nil {
+		return nil, err
+	}
+	if onConflict.Action != ConflictUpdate && onConflict.Action != ConflictIgnore {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownConflictAction, onConflict.Action)
+	}
+	stmt := &gorm.Statement{DB: orm.DB}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+	columns := s.PrimaryFields
+	if len(onConflict.Columns) > 0 {
+		columns = nil
+		for _, name := range onConflict.Columns {
+			field := lookUpField(s, name)
+			if field == nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+			}
+			columns = append(columns, field)
+		}
+	}
+
+	err = orm.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rv := reflect.ValueOf(model)
+		conds, zero := map[string]any{}, false
+		for _, field := range columns {
+			value, isZero := field.ValueOf(ctx, rv)
+			conds[field.DBName] = value
+			zero = zero || isZero
+		}
+
+		if !zero { // may exist: soft deleted ones too
+			var existing []*T
+			err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where(conds).Limit(1).Find(&existing).Error
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				before = existing[0]
+			}
+		}
+		if before != nil && !onConflict.Restore && softDeleted(ctx, s, before) {
+			return ErrConflictDeleted
+		}
+		if precondition != nil && (before == nil || !precondition(before)) {
+			return ErrPreconditionFailed
+		}
+		if before != nil && onConflict.Action == ConflictIgnore {
+			*model = *before
+			return nil
+		}
+
+		if before != nil { // it's the one to update, identified by the primary keys
+			columns, conds = s.PrimaryFields, map[string]any{}
+			for _, field := range columns {
+				value, _ := field.ValueOf(ctx, reflect.ValueOf(before))
+				if err := field.Set(ctx, rv, value); err != nil {
+					return err
+				}
+				conds[field.DBName] = value
+			}
+		}
+		if err := tx.Clauses(conflictClause(s, columns, onConflict.Action)).Create(model).Error; err != nil {
+			return err
+		}
+
+		if !zero { // reload: the creation time, and the id (LastInsertId is not reliable for upserts)
+			var reloaded T
+			if err := tx.Unscoped().Where(conds).Take(&reloaded).Error; err != nil {
+				return err
+			}
+			*model = reloaded
+		}
+
+		if before == nil {
+			return emit(ctx, tx, eventCreated, model)
+		}
+		return emit(audit.WithBefore(ctx, before), tx, eventUpdated, model)
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Upsert: failed")
+		return nil, err
+	}
+
+	switch {
+	case before == nil:
+		publish(ctx, broker.EventCreated, model)
+	case onConflict.Action == ConflictUpdate:
+		publish(ctx, broker.EventUpdated, model)
+	}
+	return before, nil
+}
+
+// conflictClause builds the ON CONFLICT clause on the columns: to update
+// the columns of the model other than the primary keys, the columns, and
+// the creation time; or to do nothing.
+func conflictClause(s *schema.Schema, columns []*schema.Field, action ConflictAction) clause.OnConflict {
+	onConflict := clause.OnConflict{DoNothing: true}
+	for _, field := range columns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+	}
+	if action != ConflictUpdate {
+		return onConflict
+	}
+
+	var updates []string
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey || !field.Creatable || !field.Updatable || field.AutoCreateTime > 0 {
+			continue
+		}
+		conflict := false
+		for _, column := range columns {
+			conflict = conflict || column == field
+		}
+		if !conflict {
+			updates = append(updates, field.DBName)
+		}
+	}
+	if len(updates) > 0 {
+		onConflict.DoNothing = false
+		onConflict.DoUpdates = clause.AssignmentColumns(updates)
+	}
+	return onConflict
+}
@@ -0,0 +1,309 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+)
+
+// Dispatcher enqueues the deliveries of events and posts them.
+type Dispatcher struct {
+	client       *http.Client
+	pollInterval time.Duration
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	batchSize    int
+
+	wake chan struct{}
+}
+
+// DispatcherOption configures a Dispatcher.
+type DispatcherOption func(d *Dispatcher)
+
+// WithHTTPClient sets the client to post deliveries.
+// By default, a client with a 10s timeout is used.
+func WithHTTPClient(client *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetry sets the max attempts of a delivery, and the delay before the
+// first retry (doubled for each further retry, up to maxBackoff).
+// By default, 8 attempts, from 10s up to 1h.
+func WithRetry(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+		d.maxBackoff = maxBackoff
+	}
+}
+
+// WithPollInterval sets how often the outbox is checked for due
+// deliveries (retries and replays). By default, every 5s.
+func WithPollInterval(interval time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.pollInterval = interval
+	}
+}
+
+// NewDispatcher creates a Dispatcher.
+func NewDispatcher(options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pollInterval: 5 * time.Second,
+		maxAttempts:  8,
+		backoff:      10 * time.Second,
+		maxBackoff:   time.Hour,
+		batchSize:    100,
+		wake:         make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// Start starts a goroutine to deliver the due deliveries, until ctx is
+// done. Deliveries are enqueued by Publish, as the Publisher of an
+// outbox.Relay.
+func (d *Dispatcher) Start(ctx context.Context) {
+	logger.WithField("pollInterval", d.pollInterval).
+		WithField("maxAttempts", d.maxAttempts).
+		Info("Dispatcher: delivering webhooks in background")
+
+	go d.deliverLoop(ctx)
+}
+
+// Publish implements outbox.Publisher: it enqueues the deliveries of the
+// domain event msg (e.g. TodoUpdated), as the change event of the model
+// (Todo), see outbox.Message.Event. Other messages (e.g. of associations)
+// are skipped.
+//
+// A message published again (the outbox delivers at least once) is not
+// delivered again.
+func (d *Dispatcher) Publish(ctx context.Context, msg *outbox.Message) error {
+	event, ok := msg.Event()
+	if !ok {
+		return nil
+	}
+	if _, err := d.Enqueue(ctx, event); err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deliverLoop delivers due deliveries every pollInterval, or when woken up.
+func (d *Dispatcher) deliverLoop(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		_, _ = d.DeliverDue(ctx)
+	}
+}
+
+// Enqueue writes a pending delivery of the event into the
+// webhook_deliveries table for each enabled subscription it matches,
+// except the ones delivered the event (by id) already. n is the number of
+// deliveries written.
+func (d *Dispatcher) Enqueue(ctx context.Context, event broker.Event) (n int, err error) {
+	var subscriptions []Subscription
+	err = orm.DB.WithContext(ctx).
+		Where("model = ? AND disabled = ?", event.Model, false).
+		Where("id NOT IN (?)", orm.DB.Model(&Delivery{}).Select("subscription_id").
+			Where("event_id = ? AND replay_of IS NULL", event.ID)).
+		Find(&subscriptions).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("Enqueue: find subscriptions failed")
+		return 0, err
+	}
+	if len(subscriptions) == 0 {
+		return 0, nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	var data map[string]any
+	_ = json.Unmarshal(payload, &struct {
+		Data *map[string]any `json:"data"`
+	}{&data})
+
+	var deliveries []Delivery
+	for _, s := range subscriptions {
+		if !s.matches(event, data) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			SubscriptionID: s.ID,
+			EventID:        event.ID,
+			EventType:      string(event.Type),
+			Payload:        string(payload),
+			Status:         StatusPending,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+	if err := orm.DB.WithContext(ctx).Create(&deliveries).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).
+			WithField("event", event.ID).
+			Error("Enqueue: write deliveries failed")
+		return 0, err
+	}
+	return len(deliveries), nil
+}
+
+// matches reports whether the event (with its data as a JSON map) is
+// subscribed by s.
+func (s *Subscription) matches(event broker.Event, data map[string]any) bool {
+	if len(s.Events) > 0 {
+		found := false
+		for _, t := range s.Events {
+			found = found || t == string(event.Type)
+		}
+		if !found {
+			return false
+		}
+	}
+	for field, want := range s.Match {
+		if got, ok := data[field]; !ok || !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeliverDue posts the pending deliveries that are due, oldest first.
+// delivered is the number of successful ones.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (delivered int, err error) {
+	var due []Delivery
+	err = orm.DB.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+		Order("id").Limit(d.batchSize).Find(&due).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("DeliverDue: find due deliveries failed")
+		return 0, err
+	}
+
+	subscriptions := map[uint]*Subscription{}
+	for i := range due {
+		delivery := &due[i]
+		s, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			s = &Subscription{}
+			if err := orm.DB.WithContext(ctx).Unscoped().Take(s, delivery.SubscriptionID).Error; err != nil {
+				s = nil
+			}
+			subscriptions[delivery.SubscriptionID] = s
+		}
+		if d.deliver(ctx, delivery, s) {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// deliver makes an attempt to post the delivery to subscription s, and
+// saves the result. Deliveries of deleted or disabled subscriptions fail.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery, s *Subscription) bool {
+	logger := logger.WithContext(ctx).
+		WithField("delivery", delivery.ID).
+		WithField("subscription", delivery.SubscriptionID)
+
+	delivery.Attempts++
+	delivery.ResponseStatus = 0
+	err := ErrSubscriptionUnavailable
+	if s != nil && !s.DeletedAt.Valid && !s.Disabled {
+		delivery.ResponseStatus, err = d.post(ctx, s, delivery)
+	}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = StatusSucceeded
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case delivery.Attempts >= d.maxAttempts || errors.Is(err, ErrSubscriptionUnavailable):
+		delivery.Status = StatusFailed
+		delivery.LastError = err.Error()
+		logger.WithError(err).Warn("deliver: failed, giving up")
+	default:
+		delivery.NextAttemptAt = time.Now().Add(d.retryDelay(delivery.Attempts))
+		delivery.LastError = err.Error()
+		logger.WithError(err).
+			WithField("nextAttemptAt", delivery.NextAttemptAt).
+			Info("deliver: failed, will retry")
+	}
+
+	if err := orm.DB.WithContext(ctx).Save(delivery).Error; err != nil {
+		logger.WithError(err).Error("deliver: save delivery failed")
+	}
+	return delivery.Status == StatusSucceeded
+}
+
+// post posts the payload of the delivery to s.URL, errors for non-2xx
+// responses.
+func (d *Dispatcher) post(ctx context.Context, s *Subscription, delivery *Delivery) (status int, err error) {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crud-webhook")
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(s.Secret, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryDelay returns the delay before the next attempt after the given
+// number of failed attempts: backoff * 2^(attempts-1), up to maxBackoff.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,21 @@
+// Package webhook notifies other systems of model changes by HTTP POSTs.
+//
+// A Subscription (managed by admins via router.Webhooks) asks for the
+// change events (broker.Event) of a model, optionally of some event types
+// and matching some field values, e.g. Todos updated with done=true.
+//
+// A Dispatcher is fed with the domain events committed into the outbox
+// (see package outbox) by an outbox.Relay, and writes a Delivery for each
+// matched subscription into the webhook_deliveries table, then posts the
+// pending deliveries asynchronously, retrying failures with exponential
+// backoff. Payloads are signed with the subscription's secret (see Sign
+// and Verify). The deliveries are kept as the delivery log of the
+// subscriptions, and can be replayed.
+//
+// Call Enable() after orm.ConnectDB to migrate the tables, then start a
+// Dispatcher and relay the outbox to it:
+//
+//    dispatcher := webhook.NewDispatcher()
+//    dispatcher.Start(ctx)
+//    outbox.NewRelay(dispatcher).Start(ctx)
+package webhook
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+)
+
+// Deliveries returns the delivery log of the subscription, newest first,
+// optionally of the given status, paginated by limit (no limit if <= 0)
+// and offset. total is the number of all matched deliveries.
+func Deliveries(ctx context.Context, subscriptionID uint, status Status, limit, offset int) (deliveries []Delivery, total int64, err error) {
+	query := orm.DB.WithContext(ctx).Model(&Delivery{}).
+		Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err = query.Count(&total).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).Warn("Deliveries: count failed")
+		return nil, 0, err
+	}
+
+	query = query.Order("id desc")
+	if limit > 0 {
+		query = query.Limit(limit).Offset(offset)
+	}
+	if err = query.Find(&deliveries).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).Warn("Deliveries: find failed")
+	}
+	return deliveries, total, err
+}
+
+// Replay enqueues a new delivery of the payload of a previous delivery
+// (by id) of the subscription, whatever its status is. The replay is
+// posted by the dispatcher in the next poll.
+func Replay(ctx context.Context, subscriptionID uint, deliveryID uint) (*Delivery, error) {
+	var original Delivery
+	err := orm.DB.WithContext(ctx).
+		Where("subscription_id = ?", subscriptionID).
+		Take(&original, deliveryID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	replay := Delivery{
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         StatusPending,
+		NextAttemptAt:  time.Now(),
+		ReplayOf:       &original.ID,
+	}
+	if err := orm.DB.WithContext(ctx).Create(&replay).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).
+			WithField("delivery", deliveryID).
+			Warn("Replay: failed")
+		return nil, err
+	}
+	return &replay, nil
+}
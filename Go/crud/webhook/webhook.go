@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+)
+
+var logger = log.ZoneLogger("crud/webhook")
+
+// Subscription subscribes URL to the change events of Model.
+//
+// The Secret is generated on creation, encrypted at rest (see
+// orm.EncryptedSerializer), and never changed. It's not encoded in JSON,
+// so it's kept out of responses and events: the router.Webhooks responds
+// it only to the creation.
+type Subscription struct {
+	orm.BasicModel
+	URL      string         `json:"url" binding:"required,url"`
+	Model    string         `json:"model" binding:"required" gorm:"index"`   // type name of the model, e.g. "Todo"
+	Events   []string       `json:"events" gorm:"serializer:json"`           // event types, e.g. ["updated"], empty for all
+	Match    map[string]any `json:"match" gorm:"serializer:json"`            // field values the record must have, e.g. {"done": true}
+	Secret   string         `json:"-" gorm:"<-:create;serializer:encrypted"` // to sign payloads, generated on creation
+	Disabled bool           `json:"disabled"`
+}
+
+// TableName of Subscription: webhook_subscriptions
+func (Subscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// BeforeSave validates the URL.
+func (s *Subscription) BeforeSave(tx *gorm.DB) error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// BeforeCreate generates a Secret if it's empty.
+func (s *Subscription) BeforeCreate(tx *gorm.DB) error {
+	if s.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		s.Secret = hex.EncodeToString(secret)
+	}
+	return nil
+}
+
+// Status of a Delivery.
+type Status string
+
+const (
+	StatusPending   Status = "pending"   // to be (re)tried at NextAttemptAt
+	StatusSucceeded Status = "succeeded" // responded 2xx
+	StatusFailed    Status = "failed"    // gave up after max attempts
+)
+
+// Delivery is an event to be (or has been) posted to a subscription.
+type Delivery struct {
+	ID             uint       `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	SubscriptionID uint       `json:"subscription_id" gorm:"index"`
+	EventID        uint64     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"` // the JSON encoded broker.Event posted
+	Status         Status     `json:"status" gorm:"index:idx_webhook_deliveries_due"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index:idx_webhook_deliveries_due"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"last_error,omitempty"`
+	ResponseStatus int        `json:"response_status,omitempty"` // of the last attempt
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	ReplayOf       *uint      `json:"replay_of,omitempty"` // the replayed delivery
+}
+
+// TableName of Delivery: webhook_deliveries
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+var (
+	ErrInvalidURL              = errors.New("webhook url must be an absolute http(s) url")
+	ErrSubscriptionUnavailable = errors.New("webhook subscription is deleted or disabled")
+)
+
+// Enable migrates the webhook tables.
+// It should be called after orm.ConnectDB and orm.UseEncryptionKeys.
+func Enable() error {
+	return orm.RegisterModel(Subscription{}, Delivery{})
+}
+
+// SignatureHeader is the request header of the payload signature:
+//
+//	X-Webhook-Signature: sha256=<hex encoded HMAC-SHA256 of the body>
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign returns the signature of the payload with secret, as in the
+// SignatureHeader.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature (the SignatureHeader of a received
+// request) is valid for the payload (the request body) and secret.
+// Receivers should use it to authenticate deliveries.
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/crud/broker"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/outbox"
+)
+
+func TestDispatcher(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := orm.UseEncryptionKeys("k1", map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")}); err != nil {
+		t.Fatalf("UseEncryptionKeys() error = %v", err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+
+	// the receiver fails the first request, then accepts signed ones
+	var requests, verified int32
+	var secret atomic.Value
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if Verify(secret.Load().(string), body, r.Header.Get(SignatureHeader)) {
+			atomic.AddInt32(&verified, 1)
+		}
+	}))
+	defer receiver.Close()
+
+	s := Subscription{
+		URL:    receiver.URL,
+		Model:  "Todo",
+		Events: []string{"updated"},
+		Match:  map[string]any{"done": true},
+	}
+	if err := orm.DB.Create(&s).Error; err != nil {
+		t.Fatalf("create subscription error = %v", err)
+	}
+	secret.Store(s.Secret)
+
+	type todo struct {
+		ID   uint `json:"id"`
+		Done bool `json:"done"`
+	}
+	d := NewDispatcher(WithRetry(3, 0, 0))
+	ctx := context.Background()
+
+	for _, event := range []broker.Event{
+		{ID: 1, Type: broker.EventUpdated, Model: "Todo", Data: todo{1, false}},
+		{ID: 2, Type: broker.EventCreated, Model: "Todo", Data: todo{2, true}},
+		{ID: 3, Type: broker.EventUpdated, Model: "Project", Data: todo{3, true}},
+		{ID: 4, Type: broker.EventUpdated, Model: "Todo", Data: todo{4, true}}, // the only match
+	} {
+		if _, err := d.Enqueue(ctx, event); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	if n, _ := d.DeliverDue(ctx); n != 0 {
+		t.Errorf("first DeliverDue() = %v, want 0 (receiver unavailable)", n)
+	}
+	time.Sleep(time.Millisecond) // the retry is due now (backoff 0)
+	if n, _ := d.DeliverDue(ctx); n != 1 {
+		t.Errorf("second DeliverDue() = %v, want 1", n)
+	}
+
+	deliveries, total, err := Deliveries(ctx, s.ID, "", 0, 0)
+	if err != nil || total != 1 {
+		t.Fatalf("Deliveries() = %v, %v, %v", deliveries, total, err)
+	}
+	if got := deliveries[0]; got.EventID != 4 || got.Status != StatusSucceeded || got.Attempts != 2 {
+		t.Errorf("delivery = %+v, want event 4 succeeded after 2 attempts", got)
+	}
+
+	replay, err := Replay(ctx, s.ID, deliveries[0].ID)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if n, _ := d.DeliverDue(ctx); n != 1 {
+		t.Errorf("DeliverDue() after replay = %v, want 1", n)
+	}
+	if *replay.ReplayOf != deliveries[0].ID {
+		t.Errorf("replay.ReplayOf = %v, want %v", *replay.ReplayOf, deliveries[0].ID)
+	}
+
+	if got := atomic.LoadInt32(&verified); got != 2 {
+		t.Errorf("verified deliveries = %v, want 2", got)
+	}
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := orm.UseEncryptionKeys("k1", map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")}); err != nil {
+		t.Fatalf("UseEncryptionKeys() error = %v", err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	s := Subscription{URL: "http://example.com/hook", Model: "Todo", Events: []string{"updated"}}
+	if err := orm.DB.Create(&s).Error; err != nil {
+		t.Fatalf("create subscription error = %v", err)
+	}
+
+	t.Run("secret", func(t *testing.T) {
+		var stored string
+		orm.DB.Raw("SELECT secret FROM webhook_subscriptions WHERE id = ?", s.ID).Scan(&stored)
+		if s.Secret == "" || !orm.IsEncrypted(stored) {
+			t.Errorf("secret %q stored as %q, want generated and encrypted", s.Secret, stored)
+		}
+		if j, _ := json.Marshal(s); strings.Contains(string(j), s.Secret) {
+			t.Errorf("JSON of subscription %s has the secret", j)
+		}
+	})
+
+	d := NewDispatcher()
+	ctx := context.Background()
+	for _, msg := range []outbox.Message{
+		{ID: 1, Topic: "TodoCreated", Key: "1", Payload: `{"id":1}`},
+		{ID: 2, Topic: "TodoRestored", Key: "1", Payload: `{"id":1}`}, // an update
+		{ID: 2, Topic: "TodoRestored", Key: "1", Payload: `{"id":1}`}, // published again
+		{ID: 3, Topic: "ProjectTodoLinked", Key: "1", Payload: `{}`},
+	} {
+		if err := d.Publish(ctx, &msg); err != nil {
+			t.Fatalf("Publish(%s) error = %v", msg.Topic, err)
+		}
+	}
+
+	deliveries, _, err := Deliveries(ctx, s.ID, "", 0, 0)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Deliveries() = %+v, %v, want one", deliveries, err)
+	}
+	var event broker.Event
+	if err := json.Unmarshal([]byte(deliveries[0].Payload), &event); err != nil {
+		t.Fatalf("decode payload error = %v", err)
+	}
+	if event.ID != 2 || event.Type != broker.EventUpdated || event.Model != "Todo" || event.ModelID != "1" {
+		t.Errorf("event = %+v, want the update of Todo 1 by message 2", event)
+	}
+}